@@ -0,0 +1,68 @@
+package xmlquery
+
+import "sync"
+
+// NodeAllocator provides the memory of nodes, so that services parsing many
+// documents can recycle it instead of leaving it to the garbage collector.
+// Alloc must return a node set to its zero value. Free is given nodes that
+// are no longer used, already set to their zero value.
+//
+// An allocator is used by ParseWithOptions and ParseFragmentWithOptions,
+// through ParserOptions.Allocator, by NewElementFrom, NewTextFrom,
+// NewCommentFrom and CloneTo for new nodes, and by ReleaseTo for nodes that
+// are no longer needed, whichever allocator they came from.
+type NodeAllocator interface {
+	Alloc() *Node
+	Free(n *Node)
+}
+
+// heapAllocator allocates nodes on the heap and leaves them to the garbage
+// collector.
+type heapAllocator struct{}
+
+func (heapAllocator) Alloc() *Node { return new(Node) }
+func (heapAllocator) Free(*Node)   {}
+
+// ReleaseTo detaches n from its tree and hands it, along with all its
+// descendants, back to a. Neither n nor its descendants may be used
+// afterwards, so any reference to them must be dropped beforehand.
+func (n *Node) ReleaseTo(a NodeAllocator) {
+	removeFromTree(n)
+	var free func(*Node)
+	free = func(n *Node) {
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			free(child)
+			child = next
+		}
+		*n = Node{}
+		a.Free(n)
+	}
+	free(n)
+}
+
+// nodePool is the allocator used with ParserOptions.UseNodePool.
+var nodePool NodeAllocator = &poolAllocator{}
+
+// poolAllocator recycles nodes through a sync.Pool.
+type poolAllocator struct {
+	pool sync.Pool
+}
+
+func (a *poolAllocator) Alloc() *Node {
+	if n, ok := a.pool.Get().(*Node); ok {
+		return n
+	}
+	return new(Node)
+}
+
+func (a *poolAllocator) Free(n *Node) {
+	a.pool.Put(n)
+}
+
+// Release returns n and its descendants to the pool used by parsers with
+// ParserOptions.UseNodePool, typically once done with a whole document. As
+// with ReleaseTo, none of the released nodes may be used afterwards.
+func (n *Node) Release() {
+	n.ReleaseTo(nodePool)
+}
@@ -0,0 +1,123 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+type freeList struct {
+	nodes        []*Node
+	allocs, hits int
+}
+
+func (l *freeList) Alloc() *Node {
+	l.allocs++
+	if len(l.nodes) == 0 {
+		return new(Node)
+	}
+	l.hits++
+	n := l.nodes[len(l.nodes)-1]
+	l.nodes = l.nodes[:len(l.nodes)-1]
+	return n
+}
+
+func (l *freeList) Free(n *Node) {
+	l.nodes = append(l.nodes, n)
+}
+
+func TestNodeAllocator(t *testing.T) {
+	s := `<?xml version="1.0"?><a x="1"><b>text</b><!-- c --></a>`
+	l := &freeList{}
+	opts := ParserOptions{Allocator: l}
+	doc, err := ParseWithOptions(strings.NewReader(s), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.allocs != 6 || l.hits != 0 {
+		t.Fatalf("expected 6 allocations from an empty list, but got %d (%d reused)", l.allocs, l.hits)
+	}
+	if got := doc.OutputXML(false); got != s {
+		t.Fatalf("\nexpected: %s\ngot:      %s", s, got)
+	}
+
+	b := FindOne(doc, "//b")
+	b.ReleaseTo(l)
+	if len(l.nodes) != 2 || FindOne(doc, "//b") != nil {
+		t.Fatalf("expected <b> and its text to be released, but got %d nodes", len(l.nodes))
+	}
+	doc.ReleaseTo(l)
+	if len(l.nodes) != 6 {
+		t.Fatalf("expected 6 free nodes, but got %d", len(l.nodes))
+	}
+	for _, n := range l.nodes {
+		if n.Parent != nil || n.FirstChild != nil || n.Data != "" || n.Attr != nil {
+			t.Fatalf("released node %v was not reset", n)
+		}
+	}
+
+	doc, err = ParseWithOptions(strings.NewReader(s), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.hits != 6 {
+		t.Fatalf("expected 6 reused nodes, but got %d", l.hits)
+	}
+	if got := doc.OutputXML(false); got != s {
+		t.Fatalf("\nexpected: %s\ngot:      %s", s, got)
+	}
+}
+
+func TestNodeAllocatorBuilders(t *testing.T) {
+	l := &freeList{}
+	elem := NewElementFrom(l, "x:a")
+	elem.AppendText("hi")
+	elem.AddChild(NewTextFrom(l, "!"))
+	elem.AddChild(NewCommentFrom(l, "c"))
+	if l.allocs != 3 || elem.Prefix != "x" || elem.Data != "a" {
+		t.Fatalf("expected 3 allocations, got %d", l.allocs)
+	}
+
+	doc := loadXML(`<r><a><b/>text</a></r>`)
+	c := FindOne(doc, "//a").CloneTo(l, true)
+	if l.allocs != 6 || c.OutputXML(true) != "<a><b/>text</a>" {
+		t.Fatalf("expected 3 more allocations for the clone, got %d", l.allocs-3)
+	}
+
+	nodes, err := ParseFragmentWithOptions(strings.NewReader(`<c/>more`), nil, ParserOptions{Allocator: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Data != "c" || nodes[1].Data != "more" {
+		t.Fatalf("unexpected fragment %v", nodes)
+	}
+	// The document, declaration and wrapper of the fragment are given back.
+	if len(l.nodes) != 3 {
+		t.Fatalf("expected 3 released nodes, got %d", len(l.nodes))
+	}
+}
+
+func TestUseNodePool(t *testing.T) {
+	// A sync.Pool may drop what it is given, so the pool is replaced by a
+	// list to see the nodes go through it.
+	l := &freeList{}
+	defer func(pool NodeAllocator) { nodePool = pool }(nodePool)
+	nodePool = l
+
+	s := `<?xml version="1.0"?><a x="1"><b>text</b><!-- c --></a>`
+	for i := 0; i < 3; i++ {
+		doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{UseNodePool: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.OutputXML(false); got != s {
+			t.Fatalf("\nexpected: %s\ngot:      %s", s, got)
+		}
+		doc.Release()
+		if len(l.nodes) != 6 {
+			t.Fatalf("expected 6 nodes in the pool, but got %d", len(l.nodes))
+		}
+	}
+	if l.allocs != 18 || l.hits != 12 {
+		t.Fatalf("expected the nodes of the last two documents to be reused, but got %d of %d", l.hits, l.allocs)
+	}
+}
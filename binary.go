@@ -0,0 +1,264 @@
+package xmlquery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// binaryMagic starts every tree written by EncodeBinary, the last byte being
+// the version of the format.
+const binaryMagic = "xmlq\x01"
+
+const (
+	binaryCDATA = 1 << iota
+	binaryMaskText
+)
+
+// EncodeBinary writes the subtree rooted at n to w in a compact binary
+// format, which DecodeBinary reads back much faster than the XML can be
+// parsed, for caching parsed documents on disk. Everything but Info is
+// kept, including namespace URIs, positions and sensitive data marks; Info
+// is left out since it may hold values of any type.
+//
+// The format may change between versions of this package, so the cache
+// must be rebuilt when DecodeBinary reports a version mismatch.
+func (n *Node) EncodeBinary(w io.Writer) error {
+	e := &binaryEncoder{w: bufio.NewWriter(w), strs: make(map[string]uint64), marks: sensitiveMarks(n)}
+	e.w.WriteString(binaryMagic)
+	e.node(n)
+	return e.w.Flush()
+}
+
+type binaryEncoder struct {
+	w     *bufio.Writer
+	strs  map[string]uint64      // ids of the strings already written
+	marks map[*Node]*sensitivity // the sensitive elements of the tree
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (e *binaryEncoder) uint(v uint64) {
+	e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+// Strings are written once and referred to by id afterwards: as 0 followed
+// by their length and bytes the first time, as their id plus one then.
+func (e *binaryEncoder) string(s string) {
+	if id, ok := e.strs[s]; ok {
+		e.uint(id + 1)
+		return
+	}
+	e.strs[s] = uint64(len(e.strs))
+	e.uint(0)
+	e.uint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *binaryEncoder) node(n *Node) {
+	var flags uint64
+	if n.CDATA {
+		flags |= binaryCDATA
+	}
+	marks := e.marks[n]
+	if marks != nil && marks.text {
+		flags |= binaryMaskText
+	}
+	e.uint(uint64(n.Type))
+	e.uint(flags)
+	e.string(n.Data)
+	e.string(n.Prefix)
+	e.string(n.NamespaceURI)
+	e.string(n.Inst)
+	e.string(n.DetectedEncoding())
+	e.uint(uint64(n.Line))
+	e.uint(uint64(n.Column))
+	e.uint(uint64(n.level))
+
+	e.uint(uint64(len(n.Attr)))
+	for _, attr := range n.Attr {
+		e.string(attr.Name.Space)
+		e.string(attr.Name.Local)
+		e.string(attr.Value)
+	}
+	e.uint(uint64(len(n.attrURIs)))
+	for _, uri := range n.attrURIs {
+		e.string(uri)
+	}
+	var maskAttrs []string
+	if marks != nil {
+		maskAttrs = marks.attrs
+	}
+	e.uint(uint64(len(maskAttrs)))
+	for _, name := range maskAttrs {
+		e.string(name)
+	}
+
+	children := 0
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		children++
+	}
+	e.uint(uint64(children))
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		e.node(child)
+	}
+}
+
+// DecodeBinary reads a tree written by EncodeBinary from r.
+func DecodeBinary(r io.Reader) (*Node, error) {
+	d := &binaryDecoder{r: bufio.NewReader(r)}
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil {
+		return nil, d.wrap(err)
+	}
+	if string(magic[:len(magic)-1]) != binaryMagic[:len(binaryMagic)-1] {
+		return nil, errors.New("xmlquery: not a binary encoded tree")
+	}
+	if v := magic[len(magic)-1]; v != binaryMagic[len(binaryMagic)-1] {
+		return nil, fmt.Errorf("xmlquery: unsupported binary format version %d", v)
+	}
+	n := d.node()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.marks) > 0 {
+		addRootInfo(n).sensitive = d.marks
+		atomic.StoreInt32(&sensitiveMarked, 1)
+	}
+	return n, nil
+}
+
+type binaryDecoder struct {
+	r     *bufio.Reader
+	strs  []string
+	marks map[*Node]*sensitivity // the sensitive elements read so far
+	err   error                  // the first error met, after which reads return zero values
+}
+
+func (d *binaryDecoder) wrap(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("xmlquery: decoding binary tree: %w", err)
+}
+
+func (d *binaryDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = d.wrap(err)
+	}
+}
+
+func (d *binaryDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *binaryDecoder) int() int {
+	v := d.uint()
+	if v > 1<<31-1 {
+		d.fail(fmt.Errorf("value %d out of range", v))
+		return 0
+	}
+	return int(v)
+}
+
+func (d *binaryDecoder) string() string {
+	ref := d.uint()
+	if d.err != nil {
+		return ""
+	}
+	if ref > 0 {
+		if ref > uint64(len(d.strs)) {
+			d.fail(fmt.Errorf("invalid string reference %d", ref))
+			return ""
+		}
+		return d.strs[ref-1]
+	}
+	size := d.int()
+	if d.err != nil {
+		return ""
+	}
+	// Read through a limited reader rather than allocating size bytes up
+	// front, so a corrupt length cannot exhaust memory.
+	buf, err := io.ReadAll(io.LimitReader(d.r, int64(size)))
+	if err == nil && len(buf) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		d.fail(err)
+		return ""
+	}
+	s := string(buf)
+	d.strs = append(d.strs, s)
+	return s
+}
+
+// Returns the marks of n, adding them if needed.
+func (d *binaryDecoder) mark(n *Node) *sensitivity {
+	if d.marks == nil {
+		d.marks = make(map[*Node]*sensitivity)
+	}
+	return markOf(d.marks, n)
+}
+
+func (d *binaryDecoder) node() *Node {
+	t := d.uint()
+	if t > uint64(DoctypeNode) {
+		d.fail(fmt.Errorf("invalid node type %d", t))
+		return nil
+	}
+	n := &Node{Type: NodeType(t)}
+	flags := d.uint()
+	n.CDATA = flags&binaryCDATA != 0
+	if flags&binaryMaskText != 0 {
+		d.mark(n).text = true
+	}
+	n.Data = d.string()
+	n.Prefix = d.string()
+	n.NamespaceURI = d.string()
+	n.Inst = d.string()
+	if enc := d.string(); enc != "" {
+		n.doc = &docInfo{encoding: enc}
+	}
+	n.Line = d.int()
+	n.Column = d.int()
+	n.level = d.int()
+
+	for i, count := 0, d.int(); i < count && d.err == nil; i++ {
+		space, local := d.string(), d.string()
+		n.Attr = append(n.Attr, xml.Attr{Name: xml.Name{Space: space, Local: local}, Value: d.string()})
+	}
+	for i, count := 0, d.int(); i < count && d.err == nil; i++ {
+		n.attrURIs = append(n.attrURIs, d.string())
+	}
+	for i, count := 0, d.int(); i < count && d.err == nil; i++ {
+		marks := d.mark(n)
+		marks.attrs = append(marks.attrs, d.string())
+	}
+
+	children := d.int()
+	for i := 0; i < children && d.err == nil; i++ {
+		child := d.node()
+		if child == nil {
+			break
+		}
+		child.Parent = n
+		if n.FirstChild == nil {
+			n.FirstChild = child
+		} else {
+			n.LastChild.NextSibling = child
+			child.PrevSibling = n.LastChild
+		}
+		n.LastChild = child
+	}
+	return n
+}
@@ -0,0 +1,87 @@
+package xmlquery
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeBinary(t *testing.T) {
+	s := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE doc>
+<?pi some data?>
+<doc xmlns="urn:d" xmlns:p="urn:p">
+  <!-- comment -->
+  <p:item p:id="1" user="me" password="secret"><![CDATA[<raw>]]></p:item>
+  <item>text &amp; more</item>
+</doc>`
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.MarkSensitive("//@password"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := doc.EncodeBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeBinary(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(doc, got, CompareOptions{}) {
+		t.Errorf("expected equal trees\nexpected: %s\ngot:      %s", doc.OutputXML(true), got.OutputXML(true))
+	}
+	if doc.OutputXML(true) != got.OutputXML(true) {
+		t.Errorf("\nexpected: %s\ngot:      %s", doc.OutputXML(true), got.OutputXML(true))
+	}
+	checkTreeInvariants(t, got)
+
+	item := FindOne(got, "//p:item")
+	if item.NamespaceURI != "urn:p" || item.AttrNamespaceURI(0) != "urn:p" {
+		t.Errorf("expected namespace URIs to be kept, got %q and %q", item.NamespaceURI, item.AttrNamespaceURI(0))
+	}
+	if item.Line != 6 || item.Column != 3 {
+		t.Errorf("expected position 6:3, got %d:%d", item.Line, item.Column)
+	}
+	if !item.FirstChild.CDATA {
+		t.Error("expected the CDATA flag to be kept")
+	}
+	if !strings.Contains(got.Dump(), `password="***"`) {
+		t.Errorf("expected the password to stay masked, got %s", got.Dump())
+	}
+	if got.DetectedEncoding() != doc.DetectedEncoding() {
+		t.Errorf("expected encoding %q, got %q", doc.DetectedEncoding(), got.DetectedEncoding())
+	}
+}
+
+func TestDecodeBinaryErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := loadXML(`<a x="1"><b>text</b></a>`).EncodeBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	tests := map[string][]byte{
+		"empty":      nil,
+		"not a tree": []byte("<a/>"),
+		"version":    append([]byte("xmlq\x09"), data[5:]...),
+		"truncated":  data[:len(data)-3],
+		"node type":  append([]byte("xmlq\x01\x7f"), data[6:]...),
+		"child type": corruptChildType(data),
+	}
+	for name, input := range tests {
+		if _, err := DecodeBinary(bytes.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// Returns data with the type of the element a, below the root, changed to
+// an unknown one.
+func corruptChildType(data []byte) []byte {
+	corrupt := append([]byte(nil), data...)
+	i := bytes.Index(corrupt, []byte("\x00\x01a"))
+	corrupt[i-2] = 0x7f
+	return corrupt
+}
@@ -0,0 +1,159 @@
+package xmlquery
+
+import "strings"
+
+// NewElement returns a new element, detached from any tree. The name may
+// have a prefix, as in "soap:Envelope"; the prefix is resolved against the
+// xmlns declarations in scope once the element is added to a tree.
+func NewElement(name string) *Node {
+	return NewElementFrom(heapAllocator{}, name)
+}
+
+// NewElementFrom is like NewElement, with the node provided by a.
+func NewElementFrom(a NodeAllocator, name string) *Node {
+	n := newNode(a, ElementNode, name, 0)
+	if i := strings.Index(name, ":"); i > 0 {
+		n.Prefix, n.Data = name[:i], name[i+1:]
+	}
+	return n
+}
+
+// NewText returns a new text node, detached from any tree.
+func NewText(data string) *Node {
+	return NewTextFrom(heapAllocator{}, data)
+}
+
+// NewTextFrom is like NewText, with the node provided by a.
+func NewTextFrom(a NodeAllocator, data string) *Node {
+	return newNode(a, TextNode, data, 0)
+}
+
+// NewComment returns a new comment node, detached from any tree.
+func NewComment(data string) *Node {
+	return NewCommentFrom(heapAllocator{}, data)
+}
+
+// NewCommentFrom is like NewComment, with the node provided by a.
+func NewCommentFrom(a NodeAllocator, data string) *Node {
+	return newNode(a, CommentNode, data, 0)
+}
+
+// AppendElement adds a new element with the given name as the last child of
+// n and returns it, so that documents can be built in a chain:
+//
+//	root := xmlquery.NewElement("root")
+//	root.AppendElement("child").WithAttr("id", "1").AppendText("hi")
+func (n *Node) AppendElement(name string) *Node {
+	elem := NewElement(name)
+	n.appendNode(elem)
+	return elem
+}
+
+// AppendText adds a text node as the last child of n and returns n.
+func (n *Node) AppendText(data string) *Node {
+	n.appendNode(NewText(data))
+	return n
+}
+
+// AppendComment adds a comment as the last child of n and returns n.
+func (n *Node) AppendComment(data string) *Node {
+	n.appendNode(NewComment(data))
+	return n
+}
+
+// WithAttr sets an attribute of n, like SetAttr, and returns n for chaining.
+func (n *Node) WithAttr(key, val string) *Node {
+	n.SetAttr(key, val)
+	return n
+}
+
+// Adds child as the last child of n, at the level below it.
+func (n *Node) appendNode(child *Node) {
+	addChild(n, child)
+	setLevel(child, n.level+1)
+}
+
+// SetInnerText replaces the children of n with a single text node holding
+// text, or with nothing if text is empty.
+func (n *Node) SetInnerText(text string) {
+	n.removeChildren()
+	if text != "" {
+		n.appendNode(NewText(text))
+	}
+}
+
+// SetInnerXML parses s as an XML fragment, with the namespaces in scope at n
+// bound, and replaces the children of n with the resulting nodes. The
+// children are left untouched if s is not well-formed.
+func (n *Node) SetInnerXML(s string) error {
+	nodes, err := ParseFragment(strings.NewReader(s), n)
+	if err != nil {
+		return err
+	}
+	n.removeChildren()
+	for _, child := range nodes {
+		addChild(n, child)
+	}
+	return nil
+}
+
+// Rename changes the local name of the element n, keeping its prefix,
+// namespace, attributes and children.
+func (n *Node) Rename(local string) {
+	n.Data = local
+	dropIndex(n)
+}
+
+// RenameNS changes the name of the element n to local, in the namespace
+// namespaceURI written with prefix, keeping its attributes and children. If
+// prefix is not bound to namespaceURI in scope at n, it is declared on n,
+// and the children of n that use the prefix are given a declaration of its
+// former binding so that they keep their namespace. The prefix is ignored
+// if namespaceURI is empty, as names in no namespace have none.
+func (n *Node) RenameNS(namespaceURI, prefix, local string) {
+	if namespaceURI == "" {
+		prefix = ""
+	}
+	if old := lookupNamespaceURI(n, prefix); old != namespaceURI {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode && !declaresPrefix(child, prefix) && usesPrefix(child, prefix) {
+				if prefix == "" {
+					child.SetAttr("xmlns", old)
+				} else if old != "" {
+					child.SetAttr("xmlns:"+prefix, old)
+				}
+			}
+		}
+		n.DeclareNamespace(prefix, namespaceURI)
+	}
+	n.Prefix, n.Data, n.NamespaceURI = prefix, local, namespaceURI
+	dropIndex(n)
+}
+
+// Returns true if prefix is used by the names of the element n or its
+// descendants, up to those redeclaring it.
+func usesPrefix(n *Node, prefix string) bool {
+	if n.Prefix == prefix {
+		return true
+	}
+	for _, attr := range n.Attr {
+		if prefix != "" && attr.Name.Space == prefix {
+			return true
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode && !declaresPrefix(child, prefix) && usesPrefix(child, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Detaches all the children of n.
+func (n *Node) removeChildren() {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		removeFromTree(child)
+		child = next
+	}
+}
@@ -0,0 +1,107 @@
+package xmlquery
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	root := NewElement("root").WithAttr("xmlns:x", "urn:x")
+	root.AppendComment("generated")
+	root.AppendElement("child").WithAttr("id", "1").AppendText("hi")
+	item := root.AppendElement("x:item")
+	item.AppendElement("name").AppendText("a & b")
+	item.AddChild(NewText("tail"))
+
+	expected := `<root xmlns:x="urn:x"><!--generated--><child id="1">hi</child><x:item><name>a &amp; b</name>tail</x:item></root>`
+	if got := root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if n := FindOne(root, "//x:item/name"); n == nil || n.Parent != item || n.InnerText() != "a & b" {
+		t.Errorf("unexpected tree: %v", n)
+	}
+	if got := elementNamespaceURI(item); got != "urn:x" {
+		t.Errorf("expected urn:x, got %q", got)
+	}
+	if got := item.LastChild.PrevSibling.Data; got != "name" {
+		t.Errorf("expected name, got %q", got)
+	}
+}
+
+func TestSetInnerText(t *testing.T) {
+	doc := loadXML(`<root><a>old <b>bold</b> text</a></root>`)
+	a := FindOne(doc, "//a")
+	a.SetInnerText("new & <improved>")
+	if expected, got := `<a>new &amp; &lt;improved&gt;</a>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	a.SetInnerText("")
+	if expected, got := `<a/>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+}
+
+func TestSetInnerXML(t *testing.T) {
+	doc := loadXML(`<root xmlns:x="urn:x"><a>old</a></root>`)
+	a := FindOne(doc, "//a")
+	if err := a.SetInnerXML(`text <x:b id="1">bold</x:b><c/>tail`); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := `<a xmlns:x="urn:x">text <x:b id="1">bold</x:b><c/>tail</a>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if b := FindOne(doc, "//x:b"); b == nil || b.NamespaceURI != "urn:x" {
+		t.Errorf("expected x:b in urn:x, got %v", b)
+	}
+	checkTreeInvariants(t, doc)
+
+	if err := a.SetInnerXML(`<unclosed>`); err == nil {
+		t.Error("expected an error for a malformed fragment")
+	}
+	if err := a.SetInnerXML(`<y:b/>`); err == nil {
+		t.Error("expected an error for an unbound prefix")
+	}
+	if err := a.SetInnerXML(`x</xmlquery-fragment><xmlquery-fragment>y`); err == nil {
+		t.Error("expected an error for a fragment closing its wrapper")
+	}
+	if got := a.FirstChild.Data; got != "text " {
+		t.Errorf("children should be kept on error, got %q", got)
+	}
+}
+
+func TestRename(t *testing.T) {
+	doc := loadXML(`<r xmlns:x="urn:x"><x:a id="1"><b/><x:c/></x:a></r>`)
+	a := FindOne(doc, "//x:a")
+	a.Rename("item")
+	if expected, got := `<x:item xmlns:x="urn:x" id="1"><b/><x:c/></x:item>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if a.NamespaceURI != "urn:x" || FindOne(doc, "//x:item") != a {
+		t.Error("Rename changed the namespace of the element")
+	}
+
+	a.RenameNS("urn:x", "x", "entry")
+	if expected, got := `<x:entry xmlns:x="urn:x" id="1"><b/><x:c/></x:entry>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	a.RenameNS("urn:d", "", "entry")
+	if expected, got := `<entry xmlns:x="urn:x" id="1" xmlns="urn:d"><b xmlns=""/><x:c/></entry>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if b := FindOne(doc, "//b"); b.NamespaceURI != "" || a.NamespaceURI != "urn:d" {
+		t.Errorf("unexpected namespaces %q and %q", a.NamespaceURI, b.NamespaceURI)
+	}
+
+	a.RenameNS("urn:y", "x", "entry")
+	if expected, got := `<x:entry id="1" xmlns="urn:d" xmlns:x="urn:y"><b xmlns=""/><x:c xmlns:x="urn:x"/></x:entry>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if c := FindOne(doc, "//x:entry/*[2]"); c.NamespaceURI != "urn:x" {
+		t.Errorf("expected x:c to stay in urn:x, got %q", c.NamespaceURI)
+	}
+
+	reparsed := loadXML(doc.OutputXML(false))
+	if !Equal(reparsed, doc, CompareOptions{}) {
+		t.Error("the renamed tree does not parse back to the same tree")
+	}
+	checkTreeInvariants(t, doc)
+}
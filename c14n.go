@@ -0,0 +1,243 @@
+package xmlquery
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// CanonicalOptions selects the variant of canonical XML written by
+// OutputCanonical.
+type CanonicalOptions struct {
+	// Exclusive selects Exclusive XML Canonicalization 1.0, which only
+	// declares the namespaces an element visibly uses, instead of Canonical
+	// XML 1.0, which declares every namespace in scope.
+	Exclusive bool
+	// InclusivePrefixes lists the prefixes whose namespaces are declared
+	// following the rules of Canonical XML 1.0 even with Exclusive set, as in
+	// the InclusiveNamespaces PrefixList of XML Signature. The default
+	// namespace is written "#default".
+	InclusivePrefixes []string
+	// WithComments keeps comments, which are removed by default.
+	WithComments bool
+}
+
+var (
+	c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+// OutputCanonical returns the canonical form of n, as defined by Canonical
+// XML 1.0 (https://www.w3.org/TR/xml-c14n) or, with opts.Exclusive,
+// Exclusive XML Canonicalization 1.0 (https://www.w3.org/TR/xml-exc-c14n),
+// for instance to compute the digest of an XML signature. The canonical
+// form of an element is that of the document subset made of the element and
+// its descendants, so namespaces and, with Canonical XML, xml:* attributes
+// inherited from its ancestors are written on it.
+//
+// The declaration and DOCTYPE of documents are left out, empty elements are
+// written with an end tag, attributes are sorted and CDATA sections are
+// written as escaped text.
+func (n *Node) OutputCanonical(opts CanonicalOptions) string {
+	var buf strings.Builder
+	c := &canonicalizer{w: &buf, opts: opts}
+	switch n.Type {
+	case DocumentNode:
+		afterRoot := false
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Type {
+			case ElementNode, ProcInstNode, CommentNode:
+				if child.Type == CommentNode && !opts.WithComments {
+					continue
+				}
+				if afterRoot {
+					buf.WriteByte('\n')
+				}
+				c.write(child, nil)
+				if child.Type == ElementNode {
+					afterRoot = true
+				} else if !afterRoot {
+					buf.WriteByte('\n')
+				}
+			}
+		}
+	default:
+		c.write(n, nil)
+	}
+	return buf.String()
+}
+
+type canonicalizer struct {
+	w    io.StringWriter
+	opts CanonicalOptions
+}
+
+// Returns the namespaces in scope at n, by prefix, the default namespace
+// having an empty prefix.
+func inScopeNamespaces(n *Node) map[string]string {
+	ns := make(map[string]string)
+	for ; n != nil; n = n.Parent {
+		if n.Type != ElementNode {
+			continue
+		}
+		for _, attr := range n.Attr {
+			prefix, ok := "", false
+			if attr.Name.Space == "xmlns" {
+				prefix, ok = attr.Name.Local, true
+			} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+				ok = true
+			}
+			if _, seen := ns[prefix]; ok && !seen {
+				ns[prefix] = attr.Value
+			}
+		}
+	}
+	return ns
+}
+
+// Writes n. rendered holds the namespace declarations in effect in the
+// output at the parent of n, and is nil for the apex of the output.
+func (c *canonicalizer) write(n *Node, rendered map[string]string) {
+	switch n.Type {
+	case TextNode:
+		c.w.WriteString(c14nTextEscaper.Replace(n.Data))
+		return
+	case CommentNode:
+		if c.opts.WithComments {
+			c.w.WriteString("<!--" + n.Data + "-->")
+		}
+		return
+	case ProcInstNode:
+		if n.Inst == "" {
+			c.w.WriteString("<?" + n.Data + "?>")
+		} else {
+			c.w.WriteString("<?" + n.Data + " " + n.Inst + "?>")
+		}
+		return
+	case ElementNode:
+	default:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			c.write(child, rendered)
+		}
+		return
+	}
+
+	apex := rendered == nil
+	if apex {
+		rendered = map[string]string{"": ""}
+	}
+	inScope := inScopeNamespaces(n)
+	if _, ok := inScope[""]; !ok {
+		inScope[""] = ""
+	}
+
+	// Namespace declarations
+	var candidates []string
+	if c.opts.Exclusive {
+		candidates = append(candidates, n.Prefix)
+		for _, attr := range n.Attr {
+			if attr.Name.Space != "" && attr.Name.Space != "xmlns" && attr.Name.Space != "xml" {
+				candidates = append(candidates, attr.Name.Space)
+			}
+		}
+		for _, prefix := range c.opts.InclusivePrefixes {
+			if prefix == "#default" {
+				prefix = ""
+			}
+			if _, ok := inScope[prefix]; ok {
+				candidates = append(candidates, prefix)
+			}
+		}
+	} else {
+		for prefix := range inScope {
+			candidates = append(candidates, prefix)
+		}
+	}
+	sort.Strings(candidates)
+	var decls []string
+	childRendered := rendered
+	for i, prefix := range candidates {
+		if i > 0 && candidates[i-1] == prefix {
+			continue
+		}
+		uri := inScope[prefix]
+		if prefix == "xml" || rendered[prefix] == uri {
+			continue
+		}
+		if _, ok := rendered[prefix]; !ok && uri == "" {
+			continue
+		}
+		if len(decls) == 0 {
+			childRendered = make(map[string]string, len(rendered)+1)
+			for k, v := range rendered {
+				childRendered[k] = v
+			}
+		}
+		childRendered[prefix] = uri
+		if prefix == "" {
+			decls = append(decls, ` xmlns="`+c14nAttrEscaper.Replace(uri)+`"`)
+		} else {
+			decls = append(decls, ` xmlns:`+prefix+`="`+c14nAttrEscaper.Replace(uri)+`"`)
+		}
+	}
+
+	// Attributes, sorted by namespace URI then local name
+	type canonicalAttr struct {
+		uri, local, name, value string
+	}
+	var attrs []canonicalAttr
+	seenXML := make(map[string]bool)
+	for i, attr := range n.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		uri := ""
+		if attr.Name.Space != "" {
+			uri = n.AttrNamespaceURI(i)
+			if uri == "" {
+				uri = inScope[attr.Name.Space]
+			}
+		}
+		if attr.Name.Space == "xml" {
+			seenXML[attr.Name.Local] = true
+		}
+		attrs = append(attrs, canonicalAttr{uri, attr.Name.Local, xml_name2string(attr.Name), attr.Value})
+	}
+	if apex && !c.opts.Exclusive {
+		// Canonical XML copies the xml:* attributes in scope to the apex.
+		for p := n.Parent; p != nil; p = p.Parent {
+			if p.Type != ElementNode {
+				continue
+			}
+			for _, attr := range p.Attr {
+				if attr.Name.Space == "xml" && !seenXML[attr.Name.Local] {
+					seenXML[attr.Name.Local] = true
+					attrs = append(attrs, canonicalAttr{xmlNamespaceURI, attr.Name.Local, xml_name2string(attr.Name), attr.Value})
+				}
+			}
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].uri != attrs[j].uri {
+			return attrs[i].uri < attrs[j].uri
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	name := n.Data
+	if n.Prefix != "" {
+		name = n.Prefix + ":" + n.Data
+	}
+	c.w.WriteString("<" + name)
+	for _, decl := range decls {
+		c.w.WriteString(decl)
+	}
+	for _, attr := range attrs {
+		c.w.WriteString(" " + attr.name + `="` + c14nAttrEscaper.Replace(attr.value) + `"`)
+	}
+	c.w.WriteString(">")
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.write(child, childRendered)
+	}
+	c.w.WriteString("</" + name + ">")
+}
@@ -0,0 +1,73 @@
+package xmlquery
+
+import (
+	"testing"
+)
+
+func TestOutputCanonical(t *testing.T) {
+	// From section 3.3 of Canonical XML 1.0.
+	s := `<?xml version="1.0"?>
+<!-- before -->
+<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e4   name="elem4"   id="elem4"   ></e4>
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="" xmlns:a="http://www.w3.org">
+            <e9 xmlns="" xmlns:a="http://www.ietf.org"/>
+         </e8>
+      </e7>
+   </e6>
+   <t a="tab	&amp; &quot;quote&quot; >">&lt;![CDATA[x]]&gt; <![CDATA[a < b]]></t>
+</doc>
+<?pi-after?>`
+	expected := `<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e4 id="elem4" name="elem4"></e4>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6 xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="">
+            <e9 xmlns:a="http://www.ietf.org"></e9>
+         </e8>
+      </e7>
+   </e6>
+   <t a="tab&#x9;&amp; &quot;quote&quot; >">&lt;![CDATA[x]]&gt; a &lt; b</t>
+</doc>
+<?pi-after?>`
+	doc := loadXML(s)
+	if got := doc.OutputCanonical(CanonicalOptions{}); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	expected = "<!-- before -->\n" + expected
+	if got := doc.OutputCanonical(CanonicalOptions{WithComments: true}); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestOutputCanonicalSubtree(t *testing.T) {
+	// From section 2.2 of Exclusive XML Canonicalization 1.0.
+	doc := loadXML(`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org" xml:space="preserve"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`)
+	elem2 := FindOne(doc, "//n1:elem2")
+	tests := []struct {
+		opts     CanonicalOptions
+		expected string
+	}{
+		{CanonicalOptions{}, `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en" xml:space="preserve"><n3:stuff></n3:stuff></n1:elem2>`},
+		{CanonicalOptions{Exclusive: true}, `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`},
+		{CanonicalOptions{Exclusive: true, InclusivePrefixes: []string{"n0", "#default", "nope"}}, `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`},
+	}
+	for _, test := range tests {
+		if got := elem2.OutputCanonical(test.opts); got != test.expected {
+			t.Errorf("%+v:\nexpected: %s\ngot:      %s", test.opts, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrorList is a list of errors reported together.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// An ErrorCollector performs extractions relative to a node and records
+// their errors instead of returning them, so a whole sequence of lookups can
+// be written without checking each one and the failures reported at once:
+//
+//	errs := doc.Errs()
+//	name := errs.Text("//customer/name")
+//	total := errs.Float("//order/total")
+//	if err := errs.Err(); err != nil {
+//		return err
+//	}
+//
+// Failed extractions return the zero value of their type.
+type ErrorCollector struct {
+	node *Node
+	errs ErrorList
+}
+
+// Errs returns a new ErrorCollector that evaluates expressions relative to n.
+func (n *Node) Errs() *ErrorCollector {
+	return &ErrorCollector{node: n}
+}
+
+// Add records err, unless it is nil.
+func (c *ErrorCollector) Add(err error) {
+	if err != nil {
+		c.errs = append(c.errs, err)
+	}
+}
+
+func (c *ErrorCollector) addf(expr, format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Errorf("xmlquery: %s: %s", expr, fmt.Sprintf(format, args...)))
+}
+
+// Err returns an ErrorList with every recorded error, or nil if there are none.
+func (c *ErrorCollector) Err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return append(ErrorList(nil), c.errs...)
+}
+
+// QueryAll returns the nodes matched by expr, recording an error if expr is invalid.
+func (c *ErrorCollector) QueryAll(expr string) []*Node {
+	list, err := QueryAll(c.node, expr)
+	if err != nil {
+		c.addf(expr, "%v", err)
+	}
+	return list
+}
+
+// Query returns the first node matched by expr, recording an error if expr
+// is invalid or matches nothing.
+func (c *ErrorCollector) Query(expr string) *Node {
+	list, err := QueryAll(c.node, expr)
+	if err != nil {
+		c.addf(expr, "%v", err)
+		return nil
+	}
+	if len(list) == 0 {
+		c.addf(expr, "no node matched")
+		return nil
+	}
+	return list[0]
+}
+
+// Text returns the inner text of the first node matched by expr, with
+// leading and trailing whitespace removed.
+func (c *ErrorCollector) Text(expr string) string {
+	if n := c.Query(expr); n != nil {
+		return strings.TrimSpace(n.InnerText())
+	}
+	return ""
+}
+
+// Int returns the text of the first node matched by expr parsed as an integer.
+func (c *ErrorCollector) Int(expr string) int {
+	n := c.Query(expr)
+	if n == nil {
+		return 0
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(n.InnerText()))
+	if err != nil {
+		c.addf(expr, "%v", err)
+	}
+	return i
+}
+
+// Float returns the text of the first node matched by expr parsed as a float.
+func (c *ErrorCollector) Float(expr string) float64 {
+	n := c.Query(expr)
+	if n == nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(n.InnerText()), 64)
+	if err != nil {
+		c.addf(expr, "%v", err)
+	}
+	return f
+}
+
+// Bool returns the text of the first node matched by expr parsed as a boolean.
+func (c *ErrorCollector) Bool(expr string) bool {
+	n := c.Query(expr)
+	if n == nil {
+		return false
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(n.InnerText()))
+	if err != nil {
+		c.addf(expr, "%v", err)
+	}
+	return b
+}
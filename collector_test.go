@@ -0,0 +1,58 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMustParse(t *testing.T) {
+	doc := MustParse(strings.NewReader(`<a><b/></a>`))
+	if doc.MustQuery("//b") == nil {
+		t.Fatal("missing <b>")
+	}
+	if doc.MustQuery("//c") != nil {
+		t.Fatal("unexpected <c>")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustParse did not panic on a malformed document")
+		}
+	}()
+	MustParse(strings.NewReader(`<a><b></a>`))
+}
+
+func TestMustQueryPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustQuery did not panic on an invalid expression")
+		}
+	}()
+	loadXML(`<a/>`).MustQuery("//[")
+}
+
+func TestErrorCollector(t *testing.T) {
+	doc := loadXML(`<order><id>42</id><total>9.5</total><paid>true</paid><name> Ann </name><qty>x</qty></order>`)
+	errs := doc.Errs()
+	if errs.Int("//id") != 42 || errs.Float("//total") != 9.5 || !errs.Bool("//paid") || errs.Text("//name") != "Ann" {
+		t.Fatal("unexpected values")
+	}
+	if len(errs.QueryAll("//order/*")) != 5 {
+		t.Fatal("expected 5 children")
+	}
+	if err := errs.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	errs.Int("//qty")
+	errs.Text("//missing")
+	errs.QueryAll("//[")
+	errs.Text("//qty[. > 1]")
+	err := errs.Err()
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 4 {
+		t.Fatalf("expected 4 errors, but got %v", err)
+	}
+	if !strings.Contains(err.Error(), "//missing: no node matched") {
+		t.Fatalf("unexpected error message: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+)
+
+// CompactCopy returns a deep copy of the subtree rooted at n in which all
+// nodes live in a single contiguous slice, all attributes share one backing
+// array and equal strings are interned. Such copies are cheaper to traverse
+// and to hand off to worker goroutines than trees built node by node.
+//
+// The copy is meant to be read-only: it may be queried concurrently as long
+// as nobody mutates it, and since every node shares the same allocation, the
+// whole copy stays in memory while any one of its nodes is referenced.
+func (n *Node) CompactCopy() *Node {
+	nodes, attrs := 0, 0
+	var count func(*Node)
+	count = func(n *Node) {
+		nodes++
+		attrs += len(n.Attr)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			count(child)
+		}
+	}
+	count(n)
+
+	var (
+		arena    = make([]Node, 0, nodes)
+		attrPool = make([]xml.Attr, 0, attrs)
+		strs     = make(map[string]string)
+	)
+	intern := func(s string) string {
+		if v, ok := strs[s]; ok {
+			return v
+		}
+		strs[s] = s
+		return s
+	}
+
+	var copyNode func(src, parent *Node) *Node
+	copyNode = func(src, parent *Node) *Node {
+		arena = append(arena, Node{
+			Parent:       parent,
+			Type:         src.Type,
+			Data:         intern(src.Data),
+			Prefix:       intern(src.Prefix),
+			NamespaceURI: intern(src.NamespaceURI),
+			Info:         src.Info,
+			Inst:         intern(src.Inst),
+			CDATA:        src.CDATA,
+			Line:         src.Line,
+			Column:       src.Column,
+			level:        src.level,
+		})
+		dst := &arena[len(arena)-1]
+		if len(src.Attr) > 0 {
+			start := len(attrPool)
+			for _, attr := range src.Attr {
+				attrPool = append(attrPool, xml.Attr{
+					Name:  xml.Name{Space: intern(attr.Name.Space), Local: intern(attr.Name.Local)},
+					Value: intern(attr.Value),
+				})
+			}
+			// Cap the slice so appending to it never overwrites a sibling's attributes.
+			dst.Attr = attrPool[start:len(attrPool):len(attrPool)]
+		}
+		if len(src.attrURIs) > 0 {
+			dst.attrURIs = make([]string, len(src.attrURIs))
+			for i, uri := range src.attrURIs {
+				dst.attrURIs[i] = intern(uri)
+			}
+		}
+		for child := src.FirstChild; child != nil; child = child.NextSibling {
+			c := copyNode(child, dst)
+			if dst.FirstChild == nil {
+				dst.FirstChild = c
+			} else {
+				dst.LastChild.NextSibling = c
+				c.PrevSibling = dst.LastChild
+			}
+			dst.LastChild = c
+		}
+		return dst
+	}
+	c := copyNode(n, nil)
+	if enc := n.DetectedEncoding(); enc != "" {
+		c.setEncoding(enc)
+	}
+	copyMarks(n, c, sensitiveMarks(n), true)
+	return c
+}
@@ -0,0 +1,35 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompactCopy(t *testing.T) {
+	s := `<?xml version="1.0"?><catalog><book id="1"><title>A</title></book><book id="2"><title>B</title></book></catalog>`
+	doc := loadXML(s)
+	cp := doc.CompactCopy()
+	if got, expected := cp.OutputXML(false), doc.OutputXML(false); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	books := FindOne(cp, "//catalog").SelectElements("book")
+	if len(books) != 2 {
+		t.Fatalf("expected 2 books, but got %d", len(books))
+	}
+	if books[0].Data != books[1].Data || books[1].Parent.Data != "catalog" {
+		t.Fatal("copied nodes are not linked correctly")
+	}
+
+	// The copy must not share anything mutable with the original.
+	books[0].SetAttr("id", "changed")
+	books[0].Attr = append(books[0].Attr, books[0].Attr[0])
+	if FindOne(doc, "//book[@id='changed']") != nil {
+		t.Fatal("CompactCopy shares attributes with the original")
+	}
+	if books[1].SelectAttr("id") != "2" {
+		t.Fatal("appending attributes overwrote a sibling")
+	}
+	if !strings.Contains(doc.OutputXML(false), `<book id="1">`) {
+		t.Fatal("original tree was modified")
+	}
+}
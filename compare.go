@@ -0,0 +1,115 @@
+package xmlquery
+
+import (
+	"sort"
+	"strings"
+)
+
+// CompareOptions controls what Equal considers significant. The zero value
+// compares everything.
+type CompareOptions struct {
+	// IgnoreComments leaves comments out of the comparison.
+	IgnoreComments bool
+	// IgnorePrefixes compares element and attribute names by namespace URI
+	// rather than by prefix, and leaves namespace declarations out.
+	IgnorePrefixes bool
+	// IgnoreWhitespace leaves out the text nodes made only of whitespace,
+	// such as the indentation between elements.
+	IgnoreWhitespace bool
+	// IgnoreAttrOrder compares attributes as sets rather than lists.
+	IgnoreAttrOrder bool
+}
+
+// Equal reports whether the trees rooted at a and b are structurally equal:
+// same node types, names, attributes and text, and children that are equal
+// in turn, as directed by opts. Text written as CDATA equals the same text
+// written with character references.
+func Equal(a, b *Node, opts CompareOptions) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || a.Data != b.Data {
+		return false
+	}
+	switch a.Type {
+	case ElementNode:
+		if opts.IgnorePrefixes {
+			if elementNamespaceURI(a) != elementNamespaceURI(b) {
+				return false
+			}
+		} else if a.Prefix != b.Prefix {
+			return false
+		}
+		if !equalAttrs(a, b, opts) {
+			return false
+		}
+	case DeclarationNode:
+		if !equalAttrs(a, b, opts) {
+			return false
+		}
+	case ProcInstNode:
+		if a.Inst != b.Inst {
+			return false
+		}
+	}
+
+	ca, cb := a.FirstChild, b.FirstChild
+	for {
+		ca, cb = opts.skip(ca), opts.skip(cb)
+		if ca == nil || cb == nil {
+			return ca == cb
+		}
+		if !Equal(ca, cb, opts) {
+			return false
+		}
+		ca, cb = ca.NextSibling, cb.NextSibling
+	}
+}
+
+// Returns n or the first of its following siblings that is not ignored.
+func (opts CompareOptions) skip(n *Node) *Node {
+	for ; n != nil; n = n.NextSibling {
+		switch {
+		case n.Type == CommentNode && opts.IgnoreComments:
+		case n.Type == TextNode && opts.IgnoreWhitespace && strings.TrimSpace(n.Data) == "":
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+func equalAttrs(a, b *Node, opts CompareOptions) bool {
+	ka, kb := compareAttrKeys(a, opts), compareAttrKeys(b, opts)
+	if len(ka) != len(kb) {
+		return false
+	}
+	if opts.IgnoreAttrOrder {
+		sort.Strings(ka)
+		sort.Strings(kb)
+	}
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the attributes of n as "name=value" strings, names being qualified
+// by their namespace URI rather than prefix if opts.IgnorePrefixes is set.
+func compareAttrKeys(n *Node, opts CompareOptions) []string {
+	keys := make([]string, 0, len(n.Attr))
+	for i, attr := range n.Attr {
+		name := xml_name2string(attr.Name)
+		if opts.IgnorePrefixes {
+			uri := n.AttrNamespaceURI(i)
+			if uri == xmlnsNamespaceURI {
+				continue
+			}
+			name = "{" + uri + "}" + attr.Name.Local
+		}
+		keys = append(keys, name+"="+attr.Value)
+	}
+	return keys
+}
@@ -0,0 +1,42 @@
+package xmlquery
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		opts     CompareOptions
+		expected bool
+	}{
+		{`<a x="1"><b>t</b></a>`, `<a x="1"><b>t</b></a>`, CompareOptions{}, true},
+		{`<a x="1"><b>t</b></a>`, `<a x="2"><b>t</b></a>`, CompareOptions{}, false},
+		{`<a x="1"><b>t</b></a>`, `<a x="1"><b>u</b></a>`, CompareOptions{}, false},
+		{`<a x="1"><b>t</b></a>`, `<a x="1"><c>t</c></a>`, CompareOptions{}, false},
+		{`<a><b/></a>`, `<a><b/><b/></a>`, CompareOptions{}, false},
+		{`<a><![CDATA[<t>]]></a>`, `<a>&lt;t&gt;</a>`, CompareOptions{}, true},
+
+		{`<a><!--c--><b/></a>`, `<a><b/></a>`, CompareOptions{}, false},
+		{`<a><!--c--><b/></a>`, `<a><b/><!--d--></a>`, CompareOptions{IgnoreComments: true}, true},
+
+		{"<a>\n  <b/>\n</a>", `<a><b/></a>`, CompareOptions{}, false},
+		{"<a>\n  <b/>\n</a>", `<a><b/></a>`, CompareOptions{IgnoreWhitespace: true}, true},
+		{"<a> t </a>", `<a>t</a>`, CompareOptions{IgnoreWhitespace: true}, false},
+
+		{`<a x="1" y="2"/>`, `<a y="2" x="1"/>`, CompareOptions{}, false},
+		{`<a x="1" y="2"/>`, `<a y="2" x="1"/>`, CompareOptions{IgnoreAttrOrder: true}, true},
+
+		{`<p:a xmlns:p="urn:x" p:id="1"/>`, `<q:a xmlns:q="urn:x" q:id="1"/>`, CompareOptions{}, false},
+		{`<p:a xmlns:p="urn:x" p:id="1"/>`, `<q:a xmlns:q="urn:x" q:id="1"/>`, CompareOptions{IgnorePrefixes: true}, true},
+		{`<p:a xmlns:p="urn:x"/>`, `<a xmlns="urn:x"/>`, CompareOptions{IgnorePrefixes: true}, true},
+		{`<p:a xmlns:p="urn:x"/>`, `<p:a xmlns:p="urn:y"/>`, CompareOptions{IgnorePrefixes: true}, false},
+	}
+	for _, test := range tests {
+		a, b := FindOne(loadXML(test.a), "/*"), FindOne(loadXML(test.b), "/*")
+		if got := Equal(a, b, test.opts); got != test.expected {
+			t.Errorf("%s vs %s with %+v: expected %v", test.a, test.b, test.opts, test.expected)
+		}
+	}
+	if !Equal(nil, nil, CompareOptions{}) || Equal(loadXML(`<a/>`), nil, CompareOptions{}) {
+		t.Error("unexpected result for nil nodes")
+	}
+}
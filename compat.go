@@ -0,0 +1,52 @@
+package xmlquery
+
+import (
+	"github.com/gjvnq/xpath"
+)
+
+// The functions in this file mirror the API of github.com/antchfx/xmlquery,
+// from which this package was forked, so that code written for it only needs
+// its import paths changed. Find, FindOne, FindEach, FindEachWithBreak,
+// Query, QueryAll, CreateXPathNavigator and Node.SelectAttr already share
+// their names and signatures.
+
+// QuerySelector returns the first node matched by the compiled expression
+// selector, or nil.
+func QuerySelector(top *Node, selector *xpath.Expr) *Node {
+	t := selector.Select(CreateXPathNavigator(top))
+	if t.MoveNext() {
+		return getCurrentNode(t)
+	}
+	return nil
+}
+
+// QuerySelectorAll returns all the nodes matched by the compiled expression
+// selector.
+func QuerySelectorAll(top *Node, selector *xpath.Expr) []*Node {
+	return iteratorNodes(selector.Select(CreateXPathNavigator(top)))
+}
+
+// AddAttr adds an attribute to n, with the key optionally prefixed.
+func AddAttr(n *Node, key, val string) {
+	addAttr(n, key, val)
+}
+
+// AddChild adds n as the last child of parent.
+func AddChild(parent, n *Node) {
+	parent.AddChild(n)
+}
+
+// AddSibling adds n as the last sibling of sibling.
+func AddSibling(sibling, n *Node) {
+	n.AddSibling(sibling)
+}
+
+// RemoveFromTree removes n, along with its descendants, from its tree.
+func RemoveFromTree(n *Node) {
+	n.Detach()
+}
+
+// RemoveAttr removes the attribute with the given name, like DelAttr.
+func (n *Node) RemoveAttr(key string) {
+	n.DelAttr(key)
+}
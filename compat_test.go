@@ -0,0 +1,36 @@
+package xmlquery
+
+import (
+	"testing"
+
+	"github.com/gjvnq/xpath"
+)
+
+func TestCompatAPI(t *testing.T) {
+	doc := loadXML(`<r><a id="1"/><a id="2"/></r>`)
+	expr := xpath.MustCompile("//a")
+	if n := QuerySelector(doc, expr); n == nil || n.SelectAttr("id") != "1" {
+		t.Fatalf("unexpected first node %v", n)
+	}
+	if nodes := QuerySelectorAll(doc, expr); len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, but got %d", len(nodes))
+	}
+	if n := QuerySelector(doc, xpath.MustCompile("//b")); n != nil {
+		t.Fatalf("expected no node, but got %v", n)
+	}
+
+	r := FindOne(doc, "/r")
+	b := &Node{Type: ElementNode, Data: "b"}
+	AddChild(r, b)
+	AddAttr(b, "x:k", "v")
+	c := &Node{Type: ElementNode, Data: "c"}
+	AddSibling(b, c)
+	if got, expected := r.OutputXML(true), `<r><a id="1"/><a id="2"/><b x:k="v"/><c/></r>`; got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	b.RemoveAttr("x:k")
+	RemoveFromTree(FindOne(doc, "//a"))
+	if got, expected := r.OutputXML(true), `<r><a id="2"/><b/><c/></r>`; got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
@@ -0,0 +1,214 @@
+package xmlquery
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RowLayout describes how FromRows maps a result set to elements.
+type RowLayout struct {
+	// Root is the name of the element wrapping all rows, "rows" by default.
+	Root string
+	// Row is the name of the element created for every row, "row" by default.
+	Row string
+	// Attrs lists the columns written as attributes of the row element
+	// instead of child elements.
+	Attrs []string
+	// OmitNull skips NULL columns instead of writing them as empty elements.
+	// NULL columns listed in Attrs are always skipped.
+	OmitNull bool
+}
+
+// FromRows reads every row of rows and returns an element tree with one
+// child of the root per row and, inside it, one element per column named
+// after the column. Column names that are not valid XML names have their
+// invalid characters replaced with underscores. It does not close rows.
+func FromRows(rows *sql.Rows, layout RowLayout) (*Node, error) {
+	if layout.Root == "" {
+		layout.Root = "rows"
+	}
+	if layout.Row == "" {
+		layout.Row = "row"
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	asAttr := make([]bool, len(columns))
+	for i, col := range columns {
+		for _, attr := range layout.Attrs {
+			if attr == col {
+				asAttr[i] = true
+			}
+		}
+		columns[i] = sanitizeName(col)
+	}
+
+	root := &Node{Type: ElementNode, Data: layout.Root}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := &Node{Type: ElementNode, Data: layout.Row, level: 1}
+		addChild(root, row)
+		for i, val := range values {
+			if val == nil && (asAttr[i] || layout.OmitNull) {
+				continue
+			}
+			text := ""
+			if val != nil {
+				text = formatValue(val)
+			}
+			if asAttr[i] {
+				addAttr(row, columns[i], text)
+				continue
+			}
+			col := &Node{Type: ElementNode, Data: columns[i], level: 2}
+			addChild(row, col)
+			if text != "" {
+				addChild(col, &Node{Type: TextNode, Data: text, level: 3})
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// FromMap builds a document from a map. Every key becomes an element,
+// named after the key, whose content is given by the value:
+//
+//   - maps become child elements, following the same rules;
+//   - slices and arrays repeat the element once per item;
+//   - nil produces an empty element;
+//   - anything else is formatted as text (times use RFC 3339).
+//
+// Inside a map, keys starting with "@" become attributes of the enclosing
+// element and the "#text" key its text content. Keys are processed in
+// lexical order so the result is deterministic.
+func FromMap(m map[string]interface{}) (*Node, error) {
+	return fromMapOptions(m, MapOptions{})
+}
+
+func fromMapOptions(m map[string]interface{}, opts MapOptions) (*Node, error) {
+	doc := &Node{Type: DocumentNode}
+	if err := fromMap(doc, reflect.ValueOf(m), opts.withDefaults()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func fromMap(parent *Node, m reflect.Value, opts MapOptions) error {
+	keys := make([]string, 0, m.Len())
+	for _, k := range m.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val := m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+		switch {
+		case strings.HasPrefix(key, opts.AttrPrefix):
+			if parent.Type != ElementNode {
+				return fmt.Errorf("xmlquery: attribute %s has no enclosing element", key)
+			}
+			addAttr(parent, key[len(opts.AttrPrefix):], formatValue(val.Interface()))
+		case key == opts.TextKey:
+			if parent.Type != ElementNode {
+				return fmt.Errorf("xmlquery: %s has no enclosing element", key)
+			}
+			addChild(parent, &Node{Type: TextNode, Data: formatValue(val.Interface()), level: parent.level + 1})
+		default:
+			if err := fromValue(parent, sanitizeName(key), val, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fromValue(parent *Node, name string, v reflect.Value, opts MapOptions) error {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			addChild(parent, &Node{Type: ElementNode, Data: name, level: parent.level + 1})
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break // []byte is text
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := fromValue(parent, name, v.Index(i), opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	elem := &Node{Type: ElementNode, Data: name, level: parent.level + 1}
+	addChild(parent, elem)
+	if v.Kind() == reflect.Map {
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("xmlquery: cannot convert %s to elements", v.Type())
+		}
+		return fromMap(elem, v, opts)
+	}
+	if text := formatValue(v.Interface()); text != "" {
+		addChild(elem, &Node{Type: TextNode, Data: text, level: elem.level + 1})
+	}
+	return nil
+}
+
+// Formats a scalar value as element or attribute content.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// Replaces the characters that are not allowed in XML names with underscores.
+func sanitizeName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	var buf strings.Builder
+	for i, c := range s {
+		valid := unicode.IsLetter(c) || c == '_' || c == ':'
+		if i > 0 {
+			valid = valid || unicode.IsDigit(c) || c == '-' || c == '.'
+		}
+		if valid {
+			buf.WriteRune(c)
+		} else {
+			buf.WriteByte('_')
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,107 @@
+package xmlquery
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+)
+
+// A minimal database/sql driver returning a fixed result set.
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeStmt struct{}
+type fakeRows struct{ i int }
+
+var fakeData = [][]driver.Value{
+	{int64(1), "Go", nil},
+	{int64(2), "XML & co", 12.5},
+}
+
+func (fakeDriver) Open(string) (driver.Conn, error)         { return fakeConn{}, nil }
+func (fakeConn) Prepare(string) (driver.Stmt, error)        { return fakeStmt{}, nil }
+func (fakeConn) Close() error                               { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                  { return nil, driver.ErrSkip }
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return 0 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+func (*fakeRows) Columns() []string                         { return []string{"id", "title", "unit price"} }
+func (*fakeRows) Close() error                              { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(fakeData) {
+		return io.EOF
+	}
+	copy(dest, fakeData[r.i])
+	r.i++
+	return nil
+}
+
+func init() {
+	sql.Register("xmlquery-fake", fakeDriver{})
+}
+
+func TestFromRows(t *testing.T) {
+	db, err := sql.Open("xmlquery-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := FromRows(rows, RowLayout{Root: "books", Row: "book", Attrs: []string{"id"}})
+	rows.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<books><book id="1"><title>Go</title><unit_price/></book><book id="2"><title>XML &amp; co</title><unit_price>12.5</unit_price></book></books>`
+	if got := root.OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	rows, err = db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err = FromRows(rows, RowLayout{OmitNull: true})
+	rows.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `<rows><row><id>1</id><title>Go</title></row><row><id>2</id><title>XML &amp; co</title><unit_price>12.5</unit_price></row></rows>`
+	if got := root.OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	doc, err := FromMap(map[string]interface{}{
+		"order": map[string]interface{}{
+			"@id":      7,
+			"customer": "Ann",
+			"items": map[string]interface{}{
+				"item": []interface{}{
+					map[string]interface{}{"@sku": "a", "#text": "pen"},
+					"paper",
+				},
+			},
+			"note":   nil,
+			"placed": time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+			"tags":   []string{"x", "y"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<order id="7"><customer>Ann</customer><items><item sku="a">pen</item><item>paper</item></items><note/><placed>2019-03-01T00:00:00Z</placed><tags>x</tags><tags>y</tags></order>`
+	if got := doc.OutputXML(false); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if _, err := FromMap(map[string]interface{}{"@id": 1}); err == nil {
+		t.Fatal("expected an error for a top-level attribute")
+	}
+}
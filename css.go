@@ -0,0 +1,409 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// QueryCSS returns the descendants of n matched by a CSS selector, in
+// document order. The following subset of CSS 3 is supported:
+//
+//	*, name, prefix|name       type selectors
+//	.class, #id                class and id attributes
+//	[a] [a=v] [a~=v] [a|=v]    attribute selectors, with quoted or bare
+//	[a^=v] [a$=v] [a*=v]       values
+//	:first-child, :last-child, :only-child, :nth-child(an+b),
+//	:nth-last-child(an+b)      structural pseudo-classes
+//	A B, A > B, A + B, A ~ B   combinators
+//	A, B                       selector lists
+//
+// Names are compared case-sensitively, as in XML.
+func QueryCSS(n *Node, selector string) ([]*Node, error) {
+	sels, err := parseCSS(selector)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*Node
+	var walk func(*Node)
+	walk = func(p *Node) {
+		for child := p.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != ElementNode {
+				continue
+			}
+			for _, sel := range sels {
+				if sel.matchAt(child, len(sel.compounds)-1) {
+					nodes = append(nodes, child)
+					break
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(n)
+	return nodes, nil
+}
+
+// cssSelector is a complex selector: compound selectors separated by
+// combinators, combinators[i] being between compounds[i] and compounds[i+1].
+type cssSelector struct {
+	compounds   []cssCompound
+	combinators []byte // ' ', '>', '+' or '~'
+}
+
+// cssCompound is a sequence of simple selectors that must all match.
+type cssCompound struct {
+	conds []func(*Node) bool
+}
+
+func (c cssCompound) match(n *Node) bool {
+	for _, cond := range c.conds {
+		if !cond(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if n matches the compound selector i and the ones before it,
+// in the relations given by the combinators.
+func (s *cssSelector) matchAt(n *Node, i int) bool {
+	if !s.compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	switch s.combinators[i-1] {
+	case ' ':
+		for p := n.Parent; p != nil && p.Type == ElementNode; p = p.Parent {
+			if s.matchAt(p, i-1) {
+				return true
+			}
+		}
+	case '>':
+		if p := n.Parent; p != nil && p.Type == ElementNode {
+			return s.matchAt(p, i-1)
+		}
+	case '+':
+		if p := prevElement(n); p != nil {
+			return s.matchAt(p, i-1)
+		}
+	case '~':
+		for p := prevElement(n); p != nil; p = prevElement(p) {
+			if s.matchAt(p, i-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func prevElement(n *Node) *Node {
+	for n = n.PrevSibling; n != nil; n = n.PrevSibling {
+		if n.Type == ElementNode {
+			return n
+		}
+	}
+	return nil
+}
+
+func nextElement(n *Node) *Node {
+	for n = n.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == ElementNode {
+			return n
+		}
+	}
+	return nil
+}
+
+// cssParser parses a selector list.
+type cssParser struct {
+	src string
+	pos int
+}
+
+func parseCSS(selector string) ([]*cssSelector, error) {
+	p := &cssParser{src: selector}
+	var sels []*cssSelector
+	for {
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, fmt.Errorf("xmlquery: invalid CSS selector %q: %v", selector, err)
+		}
+		sels = append(sels, sel)
+		if p.pos == len(p.src) {
+			return sels, nil
+		}
+		p.pos++ // ','
+	}
+}
+
+func (p *cssParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n\f", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *cssParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// Parses a complex selector, up to a ',' or the end of the input.
+func (p *cssParser) parseSelector() (*cssSelector, error) {
+	sel := &cssSelector{}
+	p.skipSpace()
+	for {
+		c, err := p.parseCompound()
+		if err != nil {
+			return nil, err
+		}
+		sel.compounds = append(sel.compounds, c)
+
+		space := p.skipSpace()
+		switch ch := p.peek(); {
+		case ch == 0 || ch == ',':
+			return sel, nil
+		case ch == '>' || ch == '+' || ch == '~':
+			p.pos++
+			p.skipSpace()
+			sel.combinators = append(sel.combinators, ch)
+		case space:
+			sel.combinators = append(sel.combinators, ' ')
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", ch, p.pos)
+		}
+	}
+}
+
+func isCSSNameChar(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func (p *cssParser) parseName() string {
+	start := p.pos
+	for p.pos < len(p.src) && isCSSNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *cssParser) parseCompound() (cssCompound, error) {
+	var c cssCompound
+	start := p.pos
+	// Type selector.
+	if p.peek() == '*' {
+		p.pos++
+	} else if name := p.parseName(); name != "" {
+		prefix := ""
+		if p.peek() == '|' && p.pos+1 < len(p.src) && p.src[p.pos+1] != '=' {
+			p.pos++
+			prefix = name
+			if p.peek() == '*' {
+				p.pos++
+				name = ""
+			} else if name = p.parseName(); name == "" {
+				return c, fmt.Errorf("missing name after %q", prefix+"|")
+			}
+			c.conds = append(c.conds, func(n *Node) bool { return n.Prefix == prefix })
+		}
+		if name != "" {
+			c.conds = append(c.conds, func(n *Node) bool { return n.Data == name })
+		}
+	}
+	for {
+		switch p.peek() {
+		case '.':
+			p.pos++
+			class := p.parseName()
+			if class == "" {
+				return c, fmt.Errorf("missing class name at offset %d", p.pos)
+			}
+			c.conds = append(c.conds, func(n *Node) bool {
+				return matchAttr(n, "class", "~=", class)
+			})
+		case '#':
+			p.pos++
+			id := p.parseName()
+			if id == "" {
+				return c, fmt.Errorf("missing id at offset %d", p.pos)
+			}
+			c.conds = append(c.conds, func(n *Node) bool {
+				return matchAttr(n, "id", "=", id)
+			})
+		case '[':
+			cond, err := p.parseAttr()
+			if err != nil {
+				return c, err
+			}
+			c.conds = append(c.conds, cond)
+		case ':':
+			cond, err := p.parsePseudo()
+			if err != nil {
+				return c, err
+			}
+			c.conds = append(c.conds, cond)
+		default:
+			if p.pos == start {
+				return c, fmt.Errorf("missing selector at offset %d", p.pos)
+			}
+			return c, nil
+		}
+	}
+}
+
+// Parses an attribute selector, starting at '['.
+func (p *cssParser) parseAttr() (func(*Node) bool, error) {
+	p.pos++
+	p.skipSpace()
+	name := p.parseName()
+	if p.peek() == '|' && p.pos+1 < len(p.src) && p.src[p.pos+1] != '=' {
+		p.pos++
+		name += ":" + p.parseName()
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing attribute name at offset %d", p.pos)
+	}
+	p.skipSpace()
+	if p.peek() == ']' {
+		p.pos++
+		return func(n *Node) bool {
+			_, ok := n.GetAttr(name)
+			return ok
+		}, nil
+	}
+	op := ""
+	for _, o := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.src[p.pos:], o) {
+			op = o
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("invalid attribute selector at offset %d", p.pos)
+	}
+	p.pos += len(op)
+	p.skipSpace()
+	var value string
+	if q := p.peek(); q == '"' || q == '\'' {
+		end := strings.IndexByte(p.src[p.pos+1:], q)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string at offset %d", p.pos)
+		}
+		value = p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else {
+		value = p.parseName()
+	}
+	p.skipSpace()
+	if p.peek() != ']' {
+		return nil, fmt.Errorf("missing ] at offset %d", p.pos)
+	}
+	p.pos++
+	return func(n *Node) bool { return matchAttr(n, name, op, value) }, nil
+}
+
+func matchAttr(n *Node, name, op, value string) bool {
+	v, ok := n.GetAttr(name)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "=":
+		return v == value
+	case "~=":
+		for _, field := range strings.Fields(v) {
+			if field == value {
+				return true
+			}
+		}
+		return false
+	case "|=":
+		return v == value || strings.HasPrefix(v, value+"-")
+	case "^=":
+		return value != "" && strings.HasPrefix(v, value)
+	case "$=":
+		return value != "" && strings.HasSuffix(v, value)
+	case "*=":
+		return value != "" && strings.Contains(v, value)
+	}
+	return false
+}
+
+// Parses a pseudo-class, starting at ':'.
+func (p *cssParser) parsePseudo() (func(*Node) bool, error) {
+	p.pos++
+	name := p.parseName()
+	switch name {
+	case "first-child":
+		return func(n *Node) bool { return prevElement(n) == nil }, nil
+	case "last-child":
+		return func(n *Node) bool { return nextElement(n) == nil }, nil
+	case "only-child":
+		return func(n *Node) bool { return prevElement(n) == nil && nextElement(n) == nil }, nil
+	case "nth-child", "nth-last-child":
+		if p.peek() != '(' {
+			return nil, fmt.Errorf("missing argument of :%s", name)
+		}
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		a, b, err := parseNth(p.src[p.pos+1 : p.pos+end])
+		if err != nil {
+			return nil, err
+		}
+		p.pos += end + 1
+		sibling := prevElement
+		if name == "nth-last-child" {
+			sibling = nextElement
+		}
+		return func(n *Node) bool {
+			i := 1
+			for s := sibling(n); s != nil; s = sibling(s) {
+				i++
+			}
+			if a == 0 {
+				return i == b
+			}
+			return (i-b)/a >= 0 && (i-b)%a == 0
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported pseudo-class :%s", name)
+}
+
+// Parses the an+b argument of :nth-child.
+func parseNth(s string) (a, b int, err error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	switch s {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	}
+	i := strings.IndexByte(s, 'n')
+	if i < 0 {
+		b, err = strconv.Atoi(s)
+		return 0, b, err
+	}
+	switch s[:i] {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		if a, err = strconv.Atoi(s[:i]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if rest := s[i+1:]; rest != "" {
+		if b, err = strconv.Atoi(rest); err != nil {
+			return 0, 0, err
+		}
+	}
+	return a, b, nil
+}
@@ -0,0 +1,55 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryCSS(t *testing.T) {
+	doc := loadXML(`<html xmlns:x="urn:x"><body>
+<div class="item main" id="first"><a href="https://a.example">a</a><span><a href="http://b.example">b</a></span></div>
+<div class="item"><a href="https://c.example" lang="en-US">c</a></div>
+<p>p1</p><p>p2</p><p>p3</p><p>p4</p>
+<x:note>n</x:note>
+</body></html>`)
+	tests := []struct {
+		selector, expected string
+	}{
+		{"div.item > a[href^='https']", "a,c"},
+		{"div.item a", "a,b,c"},
+		{"div.main.item a", "a,b"},
+		{"#first span a", "b"},
+		{"a[href$=example]", "a,b,c"},
+		{`a[href*="//c."]`, "c"},
+		{"a[lang|=en]", "c"},
+		{"[lang]", "c"},
+		{"div + p", "p1"},
+		{"div ~ p:nth-child(odd)", "p1,p3"},
+		{"p:nth-child(2n+4)", "p2,p4"},
+		{"p:nth-last-child(-n+2)", "p4"},
+		{"body > :first-child > a", "a"},
+		{"p:last-child, x|note", "n"},
+		{"span > a:only-child, p:nth-child(4)", "b,p2"},
+		{"x|*", "n"},
+		{"note", "n"},
+	}
+	for _, test := range tests {
+		nodes, err := QueryCSS(doc, test.selector)
+		if err != nil {
+			t.Errorf("%s: %v", test.selector, err)
+			continue
+		}
+		if got := strings.Join(NodeList(nodes).Texts(), ","); got != test.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", test.selector, test.expected, got)
+		}
+	}
+}
+
+func TestQueryCSSErrors(t *testing.T) {
+	doc := loadXML(`<a/>`)
+	for _, selector := range []string{"", "a,", "a >", "[", "a[href", "a[href=='x']", "a:hover", "a:nth-child(x)", "a!"} {
+		if _, err := QueryCSS(doc, selector); err == nil {
+			t.Errorf("%q: expected an error", selector)
+		}
+	}
+}
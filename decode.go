@@ -0,0 +1,176 @@
+package xmlquery
+
+import (
+	"encoding"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	nodeType            = reflect.TypeOf((*Node)(nil))
+	xmlUnmarshalerType  = reflect.TypeOf((*xml.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// QueryAs evaluates expr against n and decodes every matched node into a T.
+//
+// Elements are decoded into structs (and types implementing xml.Unmarshaler)
+// with encoding/xml, so the usual `xml:"..."` tags apply. Text, attribute and
+// element nodes are decoded into strings, booleans, numbers and types
+// implementing encoding.TextUnmarshaler from their inner text, with
+// surrounding whitespace trimmed for non-string types. T may also be *Node,
+// in which case the matched nodes are returned as is.
+func QueryAs[T any](n *Node, expr string) (list []T, err error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(expr, &err)
+	t := exp.Select(CreateXPathNavigator(n))
+	for t.MoveNext() {
+		var v T
+		if err := decodeNode(getCurrentNode(t), reflect.ValueOf(&v).Elem()); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// FindAs evaluates expr against n and converts every matched node with
+// convert, stopping at the first error. It is a lighter alternative to
+// QueryAs for values that are not decoded by reflection, and works with
+// converters such as AsString and AsInt:
+//
+//	prices, err := xmlquery.FindAs(doc, "//item/price", xmlquery.AsFloat)
+func FindAs[T any](n *Node, expr string, convert func(*Node) (T, error)) ([]T, error) {
+	nodes, err := QueryAll(n, expr)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]T, 0, len(nodes))
+	for _, node := range nodes {
+		v, err := convert(node)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// AsString returns the inner text of n, for FindAs.
+func AsString(n *Node) (string, error) {
+	return n.InnerText(), nil
+}
+
+// AsInt parses the inner text of n, surrounding whitespace trimmed, as a
+// decimal integer, for FindAs.
+func AsInt(n *Node) (int, error) {
+	var i int
+	err := decodeText(n.InnerText(), reflect.ValueOf(&i).Elem())
+	return i, err
+}
+
+// AsFloat parses the inner text of n, surrounding whitespace trimmed, as a
+// floating-point number, for FindAs.
+func AsFloat(n *Node) (float64, error) {
+	var f float64
+	err := decodeText(n.InnerText(), reflect.ValueOf(&f).Elem())
+	return f, err
+}
+
+// AsBool parses the inner text of n, surrounding whitespace trimmed, as a
+// boolean accepted by strconv.ParseBool, for FindAs.
+func AsBool(n *Node) (bool, error) {
+	var b bool
+	err := decodeText(n.InnerText(), reflect.ValueOf(&b).Elem())
+	return b, err
+}
+
+// AsTime returns a converter for FindAs parsing the inner text of nodes,
+// surrounding whitespace trimmed, as a time in the given layout.
+func AsTime(layout string) func(*Node) (time.Time, error) {
+	return func(n *Node) (time.Time, error) {
+		text := strings.TrimSpace(n.InnerText())
+		t, err := time.Parse(layout, text)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("xmlquery: cannot decode %q into time.Time: %v", text, err)
+		}
+		return t, nil
+	}
+}
+
+// decodeNode stores the value of n into v, which must be settable.
+func decodeNode(n *Node, v reflect.Value) error {
+	if v.Type() == nodeType {
+		v.Set(reflect.ValueOf(n))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeNode(n, v.Elem())
+	}
+
+	ptr := v.Addr()
+	if n.Type == ElementNode {
+		if ptr.Type().Implements(xmlUnmarshalerType) ||
+			(v.Kind() == reflect.Struct && !ptr.Type().Implements(textUnmarshalerType)) {
+			return xml.Unmarshal([]byte(n.OutputXML(true)), ptr.Interface())
+		}
+	}
+	return decodeText(n.InnerText(), v)
+}
+
+// decodeText parses text into v, which must be settable and either implement
+// encoding.TextUnmarshaler or be of a basic kind.
+func decodeText(text string, v reflect.Value) error {
+	if v.Kind() != reflect.String {
+		text = strings.TrimSpace(text)
+	}
+	if ptr := v.Addr(); ptr.Type().Implements(textUnmarshalerType) {
+		return ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("xmlquery: cannot decode %q into %s: %v", text, v.Type(), err)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("xmlquery: cannot decode %q into %s: %v", text, v.Type(), err)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("xmlquery: cannot decode %q into %s: %v", text, v.Type(), err)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("xmlquery: cannot decode %q into %s: %v", text, v.Type(), err)
+		}
+		v.SetFloat(f)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("xmlquery: cannot decode into %s", v.Type())
+		}
+		v.Set(reflect.ValueOf(text))
+	default:
+		return fmt.Errorf("xmlquery: cannot decode into %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package xmlquery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryAs(t *testing.T) {
+	doc := loadXML(`<catalog>
+	<book id="1"><title>Go</title><price> 10 </price><published>2019-03-01T00:00:00Z</published></book>
+	<book id="2"><title>XML</title><price>12</price><published>2018-01-01T00:00:00Z</published></book>
+</catalog>`)
+
+	type Book struct {
+		ID    string `xml:"id,attr"`
+		Title string `xml:"title"`
+	}
+	books, err := QueryAs[Book](doc, "//book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 || books[1].ID != "2" || books[1].Title != "XML" {
+		t.Fatalf("unexpected books: %+v", books)
+	}
+
+	prices, err := QueryAs[int](doc, "//price")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 2 || prices[0] != 10 || prices[1] != 12 {
+		t.Fatalf("unexpected prices: %v", prices)
+	}
+
+	ids, err := QueryAs[string](doc, "//book/@id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "1" {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	dates, err := QueryAs[time.Time](doc, "//published")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dates[0].Year() != 2019 {
+		t.Fatalf("unexpected date: %v", dates[0])
+	}
+
+	nodes, err := QueryAs[*Node](doc, "//title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].InnerText() != "Go" {
+		t.Fatalf("unexpected nodes: %v", nodes)
+	}
+}
+
+func TestQueryAsErrors(t *testing.T) {
+	doc := loadXML(`<a><b>x</b></a>`)
+	if _, err := QueryAs[int](doc, "//b"); err == nil {
+		t.Fatal("expected an error when decoding a non-number")
+	}
+	if _, err := QueryAs[string](doc, "//["); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+}
+
+func TestFindAs(t *testing.T) {
+	doc := loadXML(`<list>
+	<item href="/a" count=" 3 " price="1.5" ok="true" on="2020-01-02">A</item>
+	<item href="/b" count="4" price="2" ok="false" on="2021-03-04">B</item>
+</list>`)
+	hrefs, err := FindAs(doc, "//item/@href", AsString)
+	if err != nil || len(hrefs) != 2 || hrefs[0] != "/a" || hrefs[1] != "/b" {
+		t.Errorf("unexpected hrefs %v, %v", hrefs, err)
+	}
+	counts, err := FindAs(doc, "//item/@count", AsInt)
+	if err != nil || len(counts) != 2 || counts[0]+counts[1] != 7 {
+		t.Errorf("unexpected counts %v, %v", counts, err)
+	}
+	prices, err := FindAs(doc, "//item/@price", AsFloat)
+	if err != nil || len(prices) != 2 || prices[0]+prices[1] != 3.5 {
+		t.Errorf("unexpected prices %v, %v", prices, err)
+	}
+	oks, err := FindAs(doc, "//item/@ok", AsBool)
+	if err != nil || len(oks) != 2 || !oks[0] || oks[1] {
+		t.Errorf("unexpected booleans %v, %v", oks, err)
+	}
+	dates, err := FindAs(doc, "//item/@on", AsTime("2006-01-02"))
+	if err != nil || len(dates) != 2 || dates[1].Year() != 2021 {
+		t.Errorf("unexpected dates %v, %v", dates, err)
+	}
+	lengths, err := FindAs(doc, "//item", func(n *Node) (int, error) {
+		return len(n.InnerText()), nil
+	})
+	if err != nil || len(lengths) != 2 || lengths[0] != 1 {
+		t.Errorf("unexpected lengths %v, %v", lengths, err)
+	}
+	if none, err := FindAs(doc, "//missing", AsInt); err != nil || len(none) != 0 {
+		t.Errorf("expected no values, got %v, %v", none, err)
+	}
+
+	if _, err := FindAs(doc, "//item", AsInt); err == nil {
+		t.Error("expected an error converting text to int")
+	}
+	if _, err := FindAs(doc, "//item[", AsString); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+}
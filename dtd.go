@@ -0,0 +1,326 @@
+package xmlquery
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultEntityExpansionRatio is the MaxExpansionRatio applied to documents
+// declaring entities in their DTD when none is set. It bounds both the
+// replacement text of each entity, relative to the size of the DOCTYPE, and
+// the text that references to entities expand to, relative to the input
+// read, so that "billion laughs" documents cannot exhaust memory.
+const defaultEntityExpansionRatio = 100
+
+var predefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// Entities returns the general entities declared by the internal subset of
+// a DoctypeNode, mapping their names to their replacement text as written in
+// the declaration. External and parameter entities are left out. It returns
+// nil for other nodes.
+func (n *Node) Entities() map[string]string {
+	if n.Type != DoctypeNode {
+		return nil
+	}
+	entities, _ := parseDoctypeEntities(n.Data)
+	return entities
+}
+
+// Returns the internal general entities declared by the internal subset of
+// a DOCTYPE directive, unexpanded. Only the first declaration of an entity
+// counts.
+func parseDoctypeEntities(directive string) (map[string]string, error) {
+	var entities map[string]string
+	err := forEachDecl(directive, func(decl string) error {
+		if !strings.HasPrefix(decl, "<!ENTITY") {
+			return nil
+		}
+		name, value, _, ok := parseEntityDecl(decl[len("<!ENTITY"):])
+		if !ok {
+			return fmt.Errorf("xmlquery: malformed entity declaration in DTD")
+		}
+		if name != "" {
+			if entities == nil {
+				entities = make(map[string]string)
+			}
+			if _, dup := entities[name]; !dup {
+				entities[name] = value
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// Calls fn for every markup declaration of the internal subset of a DOCTYPE
+// directive, such as "<!ENTITY a 'b'>", skipping comments and parameter
+// entity references.
+func forEachDecl(directive string, fn func(decl string) error) error {
+	start := strings.IndexByte(directive, '[')
+	if start < 0 {
+		return nil
+	}
+	s := directive[start+1:]
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		switch {
+		case s == "" || s[0] == ']':
+			return nil
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				return fmt.Errorf("xmlquery: unterminated comment in DTD")
+			}
+			s = s[end+3:]
+			continue
+		}
+		end := skipMarkupDecl(s)
+		if end < 0 {
+			return fmt.Errorf("xmlquery: unterminated declaration in DTD")
+		}
+		if s[0] == '<' {
+			if err := fn(s[:end]); err != nil {
+				return err
+			}
+		}
+		s = s[end:]
+	}
+}
+
+// Returns the names of the attributes declared with the ID type by the
+// internal subset of a DOCTYPE directive, by element name.
+func parseDoctypeIDAttrs(directive string) map[string][]string {
+	var ids map[string][]string
+	forEachDecl(directive, func(decl string) error {
+		if !strings.HasPrefix(decl, "<!ATTLIST") {
+			return nil
+		}
+		fields := dtdFields(strings.TrimSuffix(decl[len("<!ATTLIST"):], ">"))
+		if len(fields) == 0 {
+			return nil
+		}
+		elem := fields[0]
+		for i := 1; i+1 < len(fields); {
+			name, typ := fields[i], fields[i+1]
+			if typ == "ID" {
+				if ids == nil {
+					ids = make(map[string][]string)
+				}
+				ids[elem] = append(ids[elem], name)
+			}
+			i += 2
+			if typ == "NOTATION" {
+				i++ // the list of notations
+			}
+			if i < len(fields) && fields[i] == "#FIXED" {
+				i++
+			}
+			i++ // the default
+		}
+		return nil
+	})
+	return ids
+}
+
+// Splits a declaration into fields separated by whitespace, keeping quoted
+// strings and parenthesized groups whole.
+func dtdFields(s string) []string {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return fields
+		}
+		end := len(s)
+		switch s[0] {
+		case '"', '\'':
+			if i := strings.IndexByte(s[1:], s[0]); i >= 0 {
+				end = i + 2
+			}
+		case '(':
+			if i := strings.IndexByte(s, ')'); i >= 0 {
+				end = i + 1
+			}
+		default:
+			if i := strings.IndexAny(s, " \t\r\n"); i >= 0 {
+				end = i
+			}
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
+
+// Parses the rest of an entity declaration, after "<!ENTITY". The returned
+// name is empty for parameter and external entities, which are skipped.
+func parseEntityDecl(s string) (name, value, rest string, ok bool) {
+	fields := 0
+	parameter, external := false, false
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return "", "", "", false
+		}
+		switch c := s[0]; {
+		case c == '>':
+			if fields < 2 || parameter || external {
+				return "", "", s[1:], fields >= 2
+			}
+			return name, value, s[1:], true
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[1:], c)
+			if end < 0 {
+				return "", "", "", false
+			}
+			if fields == 1 && !external {
+				value = s[1 : end+1]
+			}
+			fields++
+			s = s[end+2:]
+		case c == '%' && fields == 0:
+			parameter = true
+			s = s[1:]
+		default:
+			end := strings.IndexAny(s, " \t\r\n>\"'")
+			if end < 0 {
+				return "", "", "", false
+			}
+			switch word := s[:end]; {
+			case fields == 0:
+				name = word
+			case word == "SYSTEM" || word == "PUBLIC" || word == "NDATA":
+				external = true
+			}
+			fields++
+			s = s[end:]
+		}
+	}
+}
+
+// Returns the length of the markup declaration or comment at the start of
+// s, or of the parameter entity reference there, or -1 if it is unterminated.
+func skipMarkupDecl(s string) int {
+	if s[0] == '%' {
+		if end := strings.IndexByte(s, ';'); end >= 0 {
+			return end + 1
+		}
+		return -1
+	}
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// Expands the references to characters and entities in the replacement texts
+// of entities, looking up undeclared names in known. An error is returned if
+// an entity refers to itself, to an unknown entity, or if its replacement
+// text grows beyond limit bytes.
+func expandEntities(declared, known map[string]string, limit int64, ratio float64, input int) (map[string]string, error) {
+	expanded := make(map[string]string, len(declared))
+	visiting := make(map[string]bool)
+	var expand func(name string) (string, error)
+	expand = func(name string) (string, error) {
+		if v, ok := expanded[name]; ok {
+			return v, nil
+		}
+		if visiting[name] {
+			return "", fmt.Errorf("xmlquery: entity %q refers to itself", name)
+		}
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		var buf bytes.Buffer
+		s := declared[name]
+		for {
+			i := strings.IndexByte(s, '&')
+			if i < 0 {
+				buf.WriteString(s)
+				break
+			}
+			buf.WriteString(s[:i])
+			end := strings.IndexByte(s[i:], ';')
+			if end < 0 {
+				return "", fmt.Errorf("xmlquery: unterminated reference in entity %q", name)
+			}
+			ref := s[i+1 : i+end]
+			s = s[i+end+1:]
+			switch {
+			case strings.HasPrefix(ref, "#"):
+				r, err := parseCharRef(ref[1:])
+				if err != nil {
+					return "", fmt.Errorf("xmlquery: invalid character reference &%s; in entity %q", ref, name)
+				}
+				buf.WriteRune(r)
+			case hasKey(predefinedEntities, ref):
+				buf.WriteString(predefinedEntities[ref])
+			case hasKey(declared, ref):
+				v, err := expand(ref)
+				if err != nil {
+					return "", err
+				}
+				buf.WriteString(v)
+			case hasKey(known, ref):
+				buf.WriteString(known[ref])
+			default:
+				return "", fmt.Errorf("xmlquery: entity %q refers to undeclared entity %q", name, ref)
+			}
+			if int64(buf.Len()) > limit {
+				return "", &ExpansionError{Input: int64(input), Expanded: int64(buf.Len()), Limit: ratio}
+			}
+		}
+		expanded[name] = buf.String()
+		return expanded[name], nil
+	}
+	for name := range declared {
+		if _, err := expand(name); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func hasKey(m map[string]string, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// Parses the number of a character reference, after "&#".
+func parseCharRef(s string) (rune, error) {
+	var n uint64
+	var err error
+	if strings.HasPrefix(s, "x") {
+		n, err = strconv.ParseUint(s[1:], 16, 32)
+	} else {
+		n, err = strconv.ParseUint(s, 10, 32)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if r := rune(n); utf8.ValidRune(r) {
+		return r, nil
+	}
+	return 0, fmt.Errorf("xmlquery: invalid character &#%s;", s)
+}
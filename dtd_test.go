@@ -0,0 +1,117 @@
+package xmlquery
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDoctypeEntities(t *testing.T) {
+	s := `<?xml version="1.0"?>
+<!DOCTYPE note [
+  <!-- entities -->
+  <!ELEMENT note (#PCDATA)>
+  <!ATTLIST note lang CDATA "en">
+  <!ENTITY company "ACME &amp; Co">
+  <!ENTITY copy "&#169; &company;">
+  <!ENTITY % param "ignored">
+  <!ENTITY ext SYSTEM "http://example.com/ext.xml">
+  <!ENTITY company "second declaration">
+]>
+<note from="&company;">&copy; 2024</note>`
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := FindOne(doc, "/note")
+	if got, expected := note.InnerText(), "© ACME & Co 2024"; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got, expected := note.SelectAttr("from"), "ACME & Co"; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	var doctype *Node
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == DoctypeNode {
+			doctype = n
+		}
+	}
+	if doctype == nil {
+		t.Fatal("missing DoctypeNode")
+	}
+	if !strings.HasPrefix(doctype.Data, "DOCTYPE note [") || doctype.Line != 2 {
+		t.Errorf("unexpected doctype %q at line %d", doctype.Data, doctype.Line)
+	}
+	entities := doctype.Entities()
+	if len(entities) != 2 || entities["company"] != "ACME &amp; Co" || entities["copy"] != "&#169; &company;" {
+		t.Errorf("unexpected entities: %v", entities)
+	}
+	if !strings.Contains(doc.OutputXML(false), "<!DOCTYPE note [") {
+		t.Errorf("doctype missing from output: %s", doc.OutputXML(false))
+	}
+}
+
+func TestDoctypeOptionsEntityPrecedence(t *testing.T) {
+	s := `<!DOCTYPE a [<!ENTITY x "dtd">]><a>&x;</a>`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{Entity: map[string]string{"x": "option"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FindOne(doc, "/a").InnerText(); got != "option" {
+		t.Errorf("expected option, got %q", got)
+	}
+}
+
+func TestDoctypeEntityErrors(t *testing.T) {
+	laughs := `<!DOCTYPE lolz [
+<!ENTITY lol "lol">
+<!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+<!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+<!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">
+<!ENTITY lol5 "&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;">
+<!ENTITY lol6 "&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;">
+<!ENTITY lol7 "&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;">
+]><lolz>&lol7;</lolz>`
+	if _, err := Parse(strings.NewReader(laughs)); err == nil {
+		t.Error("expected an error for exponential entity expansion")
+	} else if _, ok := err.(*ExpansionError); !ok {
+		t.Errorf("expected an *ExpansionError, got %v", err)
+	}
+
+	// Every entity is within the limit, but the text referencing them is not.
+	wide := `<!DOCTYPE lolz [
+<!ENTITY lol "lol">
+<!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+<!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+<!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">
+]><lolz>` + strings.Repeat("&lol4;", 5000) + `</lolz>`
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Parse(strings.NewReader(wide))
+	runtime.ReadMemStats(&after)
+	if _, ok := err.(*ExpansionError); !ok {
+		t.Errorf("expected an *ExpansionError for many references, got %v", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 10<<20 {
+		t.Errorf("%d bytes allocated before the expansion was stopped", n)
+	}
+
+	many := `<!DOCTYPE a [<!ENTITY x "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx">]><a>` +
+		strings.Repeat("&x;", 1000) + `</a>`
+	if _, err := ParseWithOptions(strings.NewReader(many), ParserOptions{MaxEntityExpansions: 100}); err == nil {
+		t.Error("expected an error for too many entity references")
+	}
+
+	for _, s := range []string{
+		`<!DOCTYPE a [<!ENTITY x "&y;"><!ENTITY y "&x;">]><a>&x;</a>`,
+		`<!DOCTYPE a [<!ENTITY x "&unknown;">]><a>&x;</a>`,
+		`<!DOCTYPE a [<!ENTITY x "value>]><a/>`,
+	} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
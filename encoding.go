@@ -0,0 +1,410 @@
+package xmlquery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+var encodingDeclRegexp = regexp.MustCompile(`^\s*<\?xml\s[^>]*?encoding\s*=\s*["']([A-Za-z][A-Za-z0-9._-]*)["']`)
+
+// detectEncoding sniffs the encoding of the document read from r, looking
+// first for a byte order mark, then for the byte pattern of UTF-16 text
+// without one (see Appendix F of the XML specification), and finally for the
+// encoding pseudo-attribute of the XML declaration. It returns a reader
+// with the byte order mark removed and UTF-16 input already transcoded to
+// UTF-8, along with the lowercase name of the detected encoding.
+func detectEncoding(r io.Reader) (io.Reader, string, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(1024)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", err
+	}
+
+	enc := ""
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		br.Discard(3)
+		return br, "utf-8", nil
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		br.Discard(2)
+		enc = "utf-16be"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		br.Discard(2)
+		enc = "utf-16le"
+	case len(head) >= 2 && head[0] == 0 && head[1] != 0:
+		// The first character of a document is ASCII, so in UTF-16 one of
+		// its two bytes is zero.
+		enc = "utf-16be"
+	case len(head) >= 2 && head[0] != 0 && head[1] == 0:
+		enc = "utf-16le"
+	}
+	if enc != "" {
+		utf8, err := charset.NewReaderLabel(enc, br)
+		if err != nil {
+			return nil, "", err
+		}
+		return utf8, enc, nil
+	}
+
+	if m := encodingDeclRegexp.FindSubmatch(head); m != nil {
+		return br, strings.ToLower(string(m[1])), nil
+	}
+	return br, "utf-8", nil
+}
+
+// newDecoder returns a decoder for the document read from r and the name of
+// its detected encoding. Documents declaring an encoding other than UTF-8 are
+// converted by charsetReader, or by charset.NewReaderLabel if it is nil.
+func newDecoder(r io.Reader, charsetReader func(label string, input io.Reader) (io.Reader, error)) (*xml.Decoder, string, error) {
+	decoder, _, enc, err := newRecordingDecoder(r, charsetReader)
+	return decoder, enc, err
+}
+
+// newRecordingDecoder is like newDecoder but also returns the recorder of
+// the bytes read by the decoder, which is off until started.
+func newRecordingDecoder(r io.Reader, charsetReader func(label string, input io.Reader) (io.Reader, error)) (*xml.Decoder, *inputRecorder, string, error) {
+	if charsetReader == nil {
+		charsetReader = charset.NewReaderLabel
+	}
+	r, enc, err := detectEncoding(r)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	rec := &inputRecorder{r: bufio.NewReader(r), line: 1}
+	decoder := xml.NewDecoder(rec)
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// input is rec itself, which must keep sitting between the decoder
+		// and whatever reader comes next.
+		next := io.Reader(rec.r)
+		if !strings.HasPrefix(enc, "utf-16") {
+			// UTF-16 was already transcoded by detectEncoding, despite what
+			// the declaration says.
+			next, err = charsetReader(label, rec.r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		rec.r = bufio.NewReader(next)
+		return rec, nil
+	}
+	return decoder, rec, enc, nil
+}
+
+// An inputRecorder is the reader of an xml.Decoder keeping, once started,
+// the bytes read since a given input offset, so that the way a token
+// was written can be looked at. It also keeps track of line numbers.
+type inputRecorder struct {
+	r         *bufio.Reader
+	recording bool
+	buf       []byte
+	base      int64 // input offset of buf[0]
+	offset    int64 // input offset of the next byte
+
+	// If positive, the maximum number of bytes read since the start offset.
+	maxToken   int64
+	tokenStart int64
+
+	// The line of the next byte and the offset it starts at, and the same
+	// before the last byte was read, since the decoder may have put it back.
+	line, prevLine           int
+	lineStart, prevLineStart int64
+	noLines                  bool // lines are not tracked, pos returns 0
+
+	// Once set by limitExpansion, the lengths of the replacement text of the
+	// entities by name, and the state of the reference being read.
+	entities map[string]int
+	ratio    float64
+	expanded int64  // bytes of replacement text referenced so far
+	ref      []byte // the name read so far, after '&'
+	inRef    bool
+	maxRef   int // the length of the longest entity name
+}
+
+func (rec *inputRecorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	rec.record(p[:n])
+	return n, err
+}
+
+func (rec *inputRecorder) ReadByte() (byte, error) {
+	if rec.maxToken > 0 && rec.offset-rec.tokenStart > rec.maxToken {
+		return 0, &LimitError{Option: "MaxTokenSize", Limit: int(rec.maxToken)}
+	}
+	b, err := rec.r.ReadByte()
+	if err == nil {
+		if !rec.noLines {
+			rec.prevLine, rec.prevLineStart = rec.line, rec.lineStart
+		}
+		rec.record([]byte{b})
+		if b == '\n' && !rec.noLines {
+			rec.line++
+			rec.lineStart = rec.offset
+		}
+		if rec.entities != nil {
+			if err := rec.countRef(b); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return b, err
+}
+
+// limitExpansion makes reading fail with an *ExpansionError once the
+// replacement text of the references to entities read so far is more than
+// ratio times larger than the input. Unlike the check of the tokens
+// returned by the decoder, this stops a text or an attribute value with
+// many references to a large entity before the decoder expands them.
+func (rec *inputRecorder) limitExpansion(entities map[string]string, ratio float64) {
+	rec.entities = make(map[string]int, len(entities))
+	rec.maxRef = 0
+	for name, value := range entities {
+		rec.entities[name] = len(value)
+		if len(name) > rec.maxRef {
+			rec.maxRef = len(name)
+		}
+	}
+	rec.ratio = ratio
+}
+
+// Follows the entity reference that b may be part of, counting its
+// replacement text once it ends. References are counted wherever they are,
+// comments and CDATA sections included, which only overestimates.
+func (rec *inputRecorder) countRef(b byte) error {
+	switch {
+	case b == '&':
+		rec.inRef, rec.ref = true, rec.ref[:0]
+	case !rec.inRef:
+	case b == ';':
+		rec.inRef = false
+		rec.expanded += int64(rec.entities[string(rec.ref)])
+		if float64(rec.expanded) > rec.ratio*float64(rec.offset) {
+			return &ExpansionError{Input: rec.offset, Expanded: rec.expanded, Limit: rec.ratio}
+		}
+	case len(rec.ref) < rec.maxRef:
+		rec.ref = append(rec.ref, b)
+	default:
+		rec.inRef = false
+	}
+	return nil
+}
+
+// pos returns the line and the column, in bytes, of the byte at offset,
+// which must be the next byte or the last one read.
+func (rec *inputRecorder) pos(offset int64) (line, column int) {
+	if rec.noLines {
+		return 0, 0
+	}
+	switch offset {
+	case rec.offset:
+		return rec.line, int(offset-rec.lineStart) + 1
+	case rec.offset - 1:
+		return rec.prevLine, int(offset-rec.prevLineStart) + 1
+	}
+	return 0, 0
+}
+
+func (rec *inputRecorder) record(p []byte) {
+	if rec.recording {
+		rec.buf = append(rec.buf, p...)
+	}
+	rec.offset += int64(len(p))
+}
+
+// start discards the bytes recorded before offset and records from then on.
+func (rec *inputRecorder) start(offset int64) {
+	rec.tokenStart = offset
+	if !rec.recording {
+		rec.recording = true
+		rec.base = rec.offset
+	}
+	if i := offset - rec.base; i > 0 && i <= int64(len(rec.buf)) {
+		rec.buf = append(rec.buf[:0], rec.buf[i:]...)
+		rec.base = offset
+	}
+}
+
+// skipToTag discards the input from offset on up to the next '<', which must
+// be recorded if already read, and returns the offset of the '<'. It returns
+// false at the end of the input.
+func (rec *inputRecorder) skipToTag(offset int64) (int64, bool) {
+	if i := bytes.IndexByte(rec.from(offset), '<'); i >= 0 {
+		return offset + int64(i), true
+	}
+	for {
+		b, err := rec.ReadByte()
+		if err != nil {
+			return 0, false
+		}
+		if b == '<' {
+			return rec.offset - 1, true
+		}
+	}
+}
+
+// A resumeReader reads pending bytes, then the rest of the input of a
+// recorder, one byte at a time so that the recorder tracks positions.
+type resumeReader struct {
+	pending []byte
+	rec     *inputRecorder
+}
+
+func (r *resumeReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = b
+	return 1, nil
+}
+
+func (r *resumeReader) ReadByte() (byte, error) {
+	if len(r.pending) > 0 {
+		b := r.pending[0]
+		r.pending = r.pending[1:]
+		return b, nil
+	}
+	return r.rec.ReadByte()
+}
+
+// from returns the bytes recorded from offset on.
+func (rec *inputRecorder) from(offset int64) []byte {
+	i := offset - rec.base
+	if i < 0 || i > int64(len(rec.buf)) {
+		return nil
+	}
+	return rec.buf[i:]
+}
+
+// encodingWriter transcodes UTF-8 text to the encoding of its encoder.
+type encodingWriter struct {
+	io.Writer // the writer of the encoder
+}
+
+// Flushes the encoder, if it buffers its output.
+func (w *encodingWriter) Close() error {
+	if c, ok := w.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// lookupOutputEncoding returns the encoding named label and its canonical
+// name. Labels are those of the WHATWG Encoding Standard, except that
+// ISO-8859-1 and US-ASCII, which it takes for windows-1252 as browsers do,
+// are the encodings of their names.
+func lookupOutputEncoding(label string) (encoding.Encoding, string, error) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "iso-8859-1", "iso8859-1", "iso88591", "iso_8859-1", "iso_8859-1:1987", "l1", "latin1", "cp819", "ibm819", "csisolatin1", "iso-ir-100":
+		return charmap.ISO8859_1, "iso-8859-1", nil
+	case "us-ascii", "ascii", "ansi_x3.4-1968", "iso646-us", "us", "cp367", "ibm367", "csascii", "iso-ir-6":
+		return asciiEncoding{}, "us-ascii", nil
+	}
+	enc, name := charset.Lookup(label)
+	if enc == nil {
+		return nil, "", fmt.Errorf("xmlquery: unsupported output encoding %q", label)
+	}
+	return enc, name, nil
+}
+
+// newEncodingWriter returns a writer converting UTF-8 text to enc, named
+// name, before writing it to w, with characters the encoding cannot
+// represent replaced by character references. UTF-16 output starts with a
+// byte order mark, as the XML specification requires. The writer must be
+// closed to flush it.
+func newEncodingWriter(w io.Writer, enc encoding.Encoding, name string) (io.WriteCloser, error) {
+	ew := &encodingWriter{Writer: encoding.HTMLEscapeUnsupported(enc.NewEncoder()).Writer(w)}
+	if strings.HasPrefix(name, "utf-16") {
+		if _, err := io.WriteString(ew, "\uFEFF"); err != nil {
+			return nil, err
+		}
+	}
+	return ew, nil
+}
+
+// asciiEncoding is US-ASCII, which x/text does not provide.
+type asciiEncoding struct{}
+
+// Input in US-ASCII is also ISO-8859-1.
+func (asciiEncoding) NewDecoder() *encoding.Decoder { return charmap.ISO8859_1.NewDecoder() }
+
+func (asciiEncoding) NewEncoder() *encoding.Encoder {
+	return &encoding.Encoder{Transformer: asciiEncoder{}}
+}
+
+type asciiEncoder struct{ transform.NopResetter }
+
+func (asciiEncoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		c := src[nSrc]
+		if c >= utf8.RuneSelf {
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			return nDst, nSrc, errNotASCII{}
+		}
+		if nDst >= len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		dst[nDst] = c
+		nDst++
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+// errNotASCII reports a character outside US-ASCII. Its Replacement
+// method marks it as an error that HTMLEscapeUnsupported handles.
+type errNotASCII struct{}
+
+func (errNotASCII) Error() string     { return "xmlquery: character not in US-ASCII" }
+func (errNotASCII) Replacement() byte { return '?' }
+
+// Returns attrs, the pseudo-attributes of an XML declaration, with the
+// encoding set to enc.
+func withEncodingAttr(attrs []xml.Attr, enc string) []xml.Attr {
+	out := make([]xml.Attr, 0, len(attrs)+1)
+	found := false
+	for _, attr := range attrs {
+		if attr.Name.Space == "" && attr.Name.Local == "standalone" && !found {
+			out = append(out, xml.Attr{Name: xml.Name{Local: "encoding"}, Value: enc})
+			found = true
+		}
+		if attr.Name.Space == "" && attr.Name.Local == "encoding" {
+			if !found {
+				attr.Value = enc
+				out = append(out, attr)
+				found = true
+			}
+			continue
+		}
+		out = append(out, attr)
+	}
+	if !found {
+		out = append(out, xml.Attr{Name: xml.Name{Local: "encoding"}, Value: enc})
+	}
+	return out
+}
+
+// Reports whether the document doc has an XML declaration.
+func hasDeclaration(doc *Node) bool {
+	for c := doc.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == DeclarationNode && c.Data == "xml" {
+			return true
+		}
+	}
+	return false
+}
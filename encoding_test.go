@@ -0,0 +1,127 @@
+package xmlquery
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func utf16Bytes(s string, bigEndian, bom bool) []byte {
+	var buf bytes.Buffer
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	for _, u := range units {
+		if bigEndian {
+			buf.Write([]byte{byte(u >> 8), byte(u)})
+		} else {
+			buf.Write([]byte{byte(u), byte(u >> 8)})
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDetectEncoding(t *testing.T) {
+	decl := `<?xml version="1.0" encoding="UTF-16"?><a>héllo ☃</a>`
+	cases := []struct {
+		name     string
+		input    []byte
+		expected string
+		text     string
+	}{
+		{"utf-8", []byte(`<a>héllo ☃</a>`), "utf-8", "héllo ☃"},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, `<?xml version="1.0"?><a>héllo ☃</a>`...), "utf-8", "héllo ☃"},
+		{"utf-16le bom", utf16Bytes(decl, false, true), "utf-16le", "héllo ☃"},
+		{"utf-16be bom", utf16Bytes(decl, true, true), "utf-16be", "héllo ☃"},
+		{"utf-16le", utf16Bytes(decl, false, false), "utf-16le", "héllo ☃"},
+		{"utf-16be", utf16Bytes("\n"+decl, true, false), "utf-16be", "héllo ☃"},
+		{"latin1", []byte("<?xml version='1.0' encoding='ISO-8859-1'?><a>h\xe9llo</a>"), "iso-8859-1", "héllo"},
+	}
+	for _, c := range cases {
+		doc, err := Parse(bytes.NewReader(c.input))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if doc.DetectedEncoding() != c.expected {
+			t.Fatalf("%s: expected encoding %s, but got %s", c.name, c.expected, doc.DetectedEncoding())
+		}
+		if got := doc.At("a").Value(); got != c.text {
+			t.Fatalf("%s: expected %q, but got %q", c.name, c.text, got)
+		}
+	}
+}
+
+func TestOutputEncoding(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><a>é€</a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := doc.OutputXMLWithOptions(&buf, false, OutputOptions{Encoding: "ISO-8859-1"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\" standalone=\"yes\"?><a>\xe9&#8364;</a>"
+	if buf.String() != expected {
+		t.Errorf("\nexpected: %q\ngot:      %q", expected, buf.String())
+	}
+
+	// The output parses back to the same text.
+	back, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := back.SelectElement("a").InnerText(); text != "é€" {
+		t.Errorf("expected é€ after parsing, got %q", text)
+	}
+
+	buf.Reset()
+	if err := doc.SelectElement("a").OutputXMLWithOptions(&buf, true, OutputOptions{Encoding: "UTF-16BE"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := utf16Bytes("<a>é€</a>", true, true); !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("\nexpected: %x\ngot:      %x", expected, buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := doc.SelectElement("a").OutputXMLWithOptions(&buf, true, OutputOptions{Encoding: "US-ASCII"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<a>&#233;&#8364;</a>"; buf.String() != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, buf.String())
+	}
+
+	if err := doc.OutputXMLWithOptions(&buf, false, OutputOptions{Encoding: "no-such-encoding"}); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+
+	// References are not decoded in comments, PIs and CDATA sections.
+	for _, s := range []string{`<a><!-- € --></a>`, `<a><?pi €?></a>`, `<a><![CDATA[€]]></a>`} {
+		doc := loadXML(s)
+		if err := doc.OutputXMLWithOptions(&buf, false, OutputOptions{Encoding: "ISO-8859-1"}); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+	doc = loadXML(`<a><!-- é --><![CDATA[é]]></a>`)
+	buf.Reset()
+	if err := doc.SelectElement("a").OutputXMLWithOptions(&buf, true, OutputOptions{Encoding: "latin1"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<a><!-- \xe9 --><![CDATA[\xe9]]></a>"; buf.String() != expected {
+		t.Errorf("\nexpected: %q\ngot:      %q", expected, buf.String())
+	}
+}
+
+func TestOutputEncodingAddsDeclaration(t *testing.T) {
+	doc := &Node{Type: DocumentNode}
+	doc.AddChild(&Node{Type: ElementNode, Data: "a"})
+	var buf bytes.Buffer
+	if err := doc.OutputXMLWithOptions(&buf, false, OutputOptions{Encoding: "windows-1252"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="windows-1252"?><a/>`
+	if buf.String() != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, buf.String())
+	}
+}
@@ -0,0 +1,88 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// EventHandler receives the events of ParseEvents. Element and attribute
+// names have their namespace resolved: Space is the namespace URI, not the
+// prefix. Namespace declarations are passed along with the other attributes,
+// with "xmlns" as Space, or as Local for the default namespace. An error
+// returned by a method stops the parsing and is returned by ParseEvents.
+type EventHandler interface {
+	StartElement(name xml.Name, attrs []xml.Attr) error
+	EndElement(name xml.Name) error
+	Text(text string) error
+	Comment(text string) error
+}
+
+// EventHandlerFuncs is an EventHandler calling its fields, any of which may
+// be nil to ignore the corresponding events.
+type EventHandlerFuncs struct {
+	OnStartElement func(name xml.Name, attrs []xml.Attr) error
+	OnEndElement   func(name xml.Name) error
+	OnText         func(text string) error
+	OnComment      func(text string) error
+}
+
+func (h EventHandlerFuncs) StartElement(name xml.Name, attrs []xml.Attr) error {
+	if h.OnStartElement == nil {
+		return nil
+	}
+	return h.OnStartElement(name, attrs)
+}
+
+func (h EventHandlerFuncs) EndElement(name xml.Name) error {
+	if h.OnEndElement == nil {
+		return nil
+	}
+	return h.OnEndElement(name)
+}
+
+func (h EventHandlerFuncs) Text(text string) error {
+	if h.OnText == nil {
+		return nil
+	}
+	return h.OnText(text)
+}
+
+func (h EventHandlerFuncs) Comment(text string) error {
+	if h.OnComment == nil {
+		return nil
+	}
+	return h.OnComment(text)
+}
+
+// ParseEvents reads the XML document from r and reports its elements, text
+// and comments to handler as they are read, without building a tree, so
+// documents of any size can be processed in constant memory. Encodings are
+// detected as by Parse.
+func ParseEvents(r io.Reader, handler EventHandler) error {
+	decoder, _, err := newDecoder(r, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			err = handler.StartElement(tok.Name, tok.Attr)
+		case xml.EndElement:
+			err = handler.EndElement(tok.Name)
+		case xml.CharData:
+			err = handler.Text(string(tok))
+		case xml.Comment:
+			err = handler.Comment(string(tok))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
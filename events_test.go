@@ -0,0 +1,77 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type recordingHandler struct {
+	events []string
+}
+
+func (h *recordingHandler) StartElement(name xml.Name, attrs []xml.Attr) error {
+	s := "start " + name.Space + " " + name.Local
+	for _, attr := range attrs {
+		s += " " + attr.Name.Space + ":" + attr.Name.Local + "=" + attr.Value
+	}
+	h.events = append(h.events, s)
+	return nil
+}
+
+func (h *recordingHandler) EndElement(name xml.Name) error {
+	h.events = append(h.events, "end "+name.Local)
+	return nil
+}
+
+func (h *recordingHandler) Text(text string) error {
+	h.events = append(h.events, "text "+text)
+	return nil
+}
+
+func (h *recordingHandler) Comment(text string) error {
+	h.events = append(h.events, "comment "+text)
+	return nil
+}
+
+func TestParseEvents(t *testing.T) {
+	s := `<?xml version="1.0"?><a:root xmlns:a="urn:a"><!--c--><a:item a:id="1">x</a:item></a:root>`
+	h := &recordingHandler{}
+	if err := ParseEvents(strings.NewReader(s), h); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"start urn:a root xmlns:a=urn:a",
+		"comment c",
+		"start urn:a item urn:a:id=1",
+		"text x",
+		"end item",
+		"end root",
+	}
+	if strings.Join(h.events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("\nexpected: %q\ngot:      %q", expected, h.events)
+	}
+}
+
+func TestParseEventsStop(t *testing.T) {
+	errStop := errors.New("stop")
+	count := 0
+	err := ParseEvents(strings.NewReader(`<a><b/><b/><b/></a>`), EventHandlerFuncs{
+		OnStartElement: func(name xml.Name, attrs []xml.Attr) error {
+			if count++; name.Local == "b" {
+				return errStop
+			}
+			return nil
+		},
+	})
+	if err != errStop {
+		t.Errorf("expected the error of the handler, got %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 elements to be started, got %d", count)
+	}
+	if err := ParseEvents(strings.NewReader(`<a><b></a>`), EventHandlerFuncs{}); err == nil {
+		t.Error("expected an error for a malformed document")
+	}
+}
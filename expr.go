@@ -0,0 +1,185 @@
+package xmlquery
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/gjvnq/xpath"
+)
+
+// DefaultExprCacheSize is the number of compiled expressions kept by the
+// package-level cache unless changed with SetExprCacheSize.
+const DefaultExprCacheSize = 1024
+
+// Expr is a compiled XPath expression. It is safe for concurrent use and
+// can be evaluated any number of times with FindExpr and FindOneExpr,
+// saving the cost of parsing the expression on every call.
+type Expr struct {
+	exp *cachedExpr
+}
+
+// cachedExpr is a compiled expression shared through the cache. Select
+// works on a copy of the query of the expression, but Evaluate runs on
+// the query itself, so concurrent evaluations are serialized.
+type cachedExpr struct {
+	*xpath.Expr
+	mu    sync.Mutex
+	src   string      // the expression as given, before binding calls
+	calls []*funcCall // the calls to registered functions, see RegisterFunc
+}
+
+// Select is like xpath.Expr.Select, with root set up to evaluate the calls
+// of e to registered functions.
+func (e *cachedExpr) Select(root *NodeNavigator) *xpath.NodeIterator {
+	root.calls = e.calls
+	return e.Expr.Select(root)
+}
+
+// Evaluate is like Select for xpath.Expr.Evaluate, but safe for concurrent
+// use.
+func (e *cachedExpr) Evaluate(root *NodeNavigator) interface{} {
+	root.calls = e.calls
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.Expr.Evaluate(root)
+}
+
+// String returns the source of the expression.
+func (e *cachedExpr) String() string {
+	return e.src
+}
+
+// Compile parses an XPath expression. Compiled expressions are kept in a
+// package-level LRU cache, so compiling the same string again is cheap.
+// The same cache is used by every function of this package that accepts
+// an expression as a string, such as Find and Query.
+func Compile(expr string) (*Expr, error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{exp: exp}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid.
+func MustCompile(expr string) *Expr {
+	e, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.exp.String()
+}
+
+// FindExpr is like Find but takes a compiled expression.
+func FindExpr(top *Node, e *Expr) []*Node {
+	return selectNodes(top, e.exp)
+}
+
+// FindOneExpr is like FindOne but takes a compiled expression.
+func FindOneExpr(top *Node, e *Expr) *Node {
+	t := e.exp.Select(CreateXPathNavigator(top))
+	if t.MoveNext() {
+		return getCurrentNode(t)
+	}
+	return nil
+}
+
+func selectNodes(top *Node, exp *cachedExpr) []*Node {
+	return iteratorNodes(exp.Select(CreateXPathNavigator(top)))
+}
+
+// Returns the nodes selected by t, in document order.
+func iteratorNodes(t *xpath.NodeIterator) []*Node {
+	var elems []*Node
+	for t.MoveNext() {
+		elems = append(elems, getCurrentNode(t))
+	}
+	// The engine returns the nodes of the operands of a union one operand
+	// after the other.
+	for i := 1; i < len(elems); i++ {
+		if compareDocumentOrder(elems[i-1], elems[i]) > 0 {
+			sortDocumentOrder(elems)
+			break
+		}
+	}
+	return elems
+}
+
+type exprCacheEntry struct {
+	src string
+	exp *cachedExpr
+}
+
+var exprCache = struct {
+	sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}{
+	size:    DefaultExprCacheSize,
+	order:   list.New(),
+	entries: make(map[string]*list.Element),
+}
+
+// SetExprCacheSize changes the number of compiled expressions kept by the
+// package-level cache, evicting the least recently used ones if needed.
+// A size of zero disables caching.
+func SetExprCacheSize(size int) {
+	exprCache.Lock()
+	defer exprCache.Unlock()
+	exprCache.size = size
+	evictExprs()
+}
+
+// Drops every expression from the cache.
+func purgeExprs() {
+	exprCache.Lock()
+	defer exprCache.Unlock()
+	exprCache.order.Init()
+	exprCache.entries = make(map[string]*list.Element)
+}
+
+// Must be called with exprCache locked.
+func evictExprs() {
+	for exprCache.order.Len() > exprCache.size {
+		last := exprCache.order.Back()
+		exprCache.order.Remove(last)
+		delete(exprCache.entries, last.Value.(*exprCacheEntry).src)
+	}
+}
+
+// compile returns the compiled form of expr, from the cache if possible.
+func compile(expr string) (*cachedExpr, error) {
+	exprCache.Lock()
+	if elem, ok := exprCache.entries[expr]; ok {
+		exprCache.order.MoveToFront(elem)
+		exprCache.Unlock()
+		return elem.Value.(*exprCacheEntry).exp, nil
+	}
+	exprCache.Unlock()
+
+	// Compile without holding the lock; a concurrent compilation of the same
+	// string is harmless.
+	exp, err := compileExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	exprCache.Lock()
+	defer exprCache.Unlock()
+	if exprCache.size > 0 {
+		if elem, ok := exprCache.entries[expr]; ok {
+			// Share the expression compiled concurrently, whose evaluations
+			// are serialized with those of this one.
+			return elem.Value.(*exprCacheEntry).exp, nil
+		}
+		exprCache.entries[expr] = exprCache.order.PushFront(&exprCacheEntry{src: expr, exp: exp})
+		evictExprs()
+	}
+	return exp, nil
+}
@@ -0,0 +1,70 @@
+package xmlquery
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	e, err := Compile("//book[genre='Fantasy']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.String() != "//book[genre='Fantasy']" {
+		t.Fatalf("unexpected expression source %q", e.String())
+	}
+	if list := FindExpr(doc, e); len(list) != 2 {
+		t.Fatalf("expected 2 books, but got %d", len(list))
+	}
+	if n := FindOneExpr(doc, e); n == nil || n.SelectAttr("id") != "bk102" {
+		t.Fatal("expected bk102")
+	}
+	if n := FindOneExpr(doc, MustCompile("//magazine")); n != nil {
+		t.Fatal("//magazine should match nothing")
+	}
+	if _, err := Compile("//book["); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+}
+
+func TestExprCache(t *testing.T) {
+	defer SetExprCacheSize(DefaultExprCacheSize)
+	SetExprCacheSize(2)
+	a1, _ := compile("//a")
+	b1, _ := compile("//b")
+	if a2, _ := compile("//a"); a2 != a1 {
+		t.Fatal("//a was not cached")
+	}
+	compile("//c") // evicts //b, the least recently used
+	if b2, _ := compile("//b"); b2 == b1 {
+		t.Fatal("//b was not evicted")
+	}
+	if exprCache.order.Len() != 2 {
+		t.Fatalf("expected 2 cached expressions, but got %d", exprCache.order.Len())
+	}
+	SetExprCacheSize(0)
+	if exprCache.order.Len() != 0 {
+		t.Fatal("cache was not emptied")
+	}
+	if c1, _ := compile("//c"); c1 == nil {
+		t.Fatal("compile failed without a cache")
+	}
+}
+
+func TestEvaluateConcurrently(t *testing.T) {
+	doc := loadXML(`<r><n>1</n><n>2</n><n>3</n></r>`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if v, err := Evaluate(doc, "sum(//n)"); err != nil || v != float64(6) {
+					t.Errorf("expected 6, got %v (%v)", v, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,146 @@
+package xmlquery
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Extract populates the struct pointed to by dest from the subtree rooted
+// at n, using the XPath expressions found in the `xpath:"..."` tags of its
+// fields. Expressions are evaluated relative to n.
+//
+// Fields of basic types and types implementing encoding.TextUnmarshaler
+// receive the value of the first matched node, or of the expression itself
+// when it evaluates to a number, string or boolean (e.g. "count(item)").
+// Slice fields receive one element per matched node. Struct fields (and
+// slices of structs) whose type has xpath tags of its own are extracted
+// recursively, with the matched node as the new context; other structs are
+// decoded with encoding/xml as in QueryAs. Fields are left untouched when
+// their expression matches nothing. Untagged embedded structs are extracted
+// against n.
+//
+// A field may also carry an `xsd:"..."` tag naming a type registered with
+// RegisterValueDecoder, in which case its value is decoded with that decoder
+// and the result stored into the field (or into each slice element).
+func Extract(n *Node, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("xmlquery: Extract requires a non-nil pointer to a struct")
+	}
+	return extractStruct(n, v.Elem())
+}
+
+// Returns true if t is a struct with at least one xpath tag, which Extract
+// should populate field by field rather than decode as a whole.
+func hasXPathTags(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if _, ok := f.Tag.Lookup("xpath"); ok {
+			return true
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && hasXPathTags(f.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func extractStruct(n *Node, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		expr, ok := f.Tag.Lookup("xpath")
+		if !ok {
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				if err := extractStruct(n, v.Field(i)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if expr == "" || expr == "-" {
+			continue
+		}
+		if err := extractField(n, expr, f.Tag.Get("xsd"), v.Field(i)); err != nil {
+			return fmt.Errorf("xmlquery: field %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractField(n *Node, expr, xsdType string, v reflect.Value) error {
+	res, err := Evaluate(n, expr)
+	if err != nil {
+		return err
+	}
+	var nodes []*Node
+	switch res := res.(type) {
+	case []*Node:
+		nodes = res
+	case float64:
+		return extractText(strconv.FormatFloat(res, 'f', -1, 64), xsdType, v)
+	case string:
+		return extractText(res, xsdType, v)
+	case bool:
+		return extractText(strconv.FormatBool(res), xsdType, v)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		list := reflect.MakeSlice(v.Type(), len(nodes), len(nodes))
+		for i, node := range nodes {
+			if err := extractValue(node, xsdType, list.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+		return nil
+	}
+	return extractValue(nodes[0], xsdType, v)
+}
+
+// Stores the result of a scalar expression into v.
+func extractText(text, xsdType string, v reflect.Value) error {
+	if xsdType != "" {
+		return decodeTyped(text, xsdType, indirect(v))
+	}
+	return decodeText(text, indirect(v))
+}
+
+// Stores node into v, recursing into structs that have xpath tags.
+func extractValue(n *Node, xsdType string, v reflect.Value) error {
+	if xsdType != "" {
+		return decodeTyped(n.InnerText(), xsdType, indirect(v))
+	}
+	if v.Kind() == reflect.Ptr && v.Type() != nodeType {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return extractValue(n, xsdType, v.Elem())
+	}
+	if hasXPathTags(v.Type()) {
+		return extractStruct(n, v)
+	}
+	return decodeNode(n, v)
+}
+
+// Allocates nil pointers until reaching a non-pointer value.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,70 @@
+package xmlquery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	doc := loadXML(`<catalog name="shop">
+	<book id="1"><title>Go</title><price>10.5</price><tag>a</tag><tag>b</tag><author><name>Ann</name></author></book>
+	<book id="2"><title>XML</title><price>12</price><published>2018-01-01T00:00:00Z</published></book>
+</catalog>`)
+
+	type Author struct {
+		Name string `xpath:"name"`
+	}
+	type Book struct {
+		ID        int        `xpath:"@id"`
+		Title     string     `xpath:"title"`
+		Price     float64    `xpath:"price"`
+		Tags      []string   `xpath:"tag"`
+		Author    *Author    `xpath:"author"`
+		Published *time.Time `xpath:"published"`
+	}
+	type Meta struct {
+		Name string `xpath:"/catalog/@name"`
+	}
+	var catalog struct {
+		Meta
+		Count   int    `xpath:"count(//book)"`
+		Books   []Book `xpath:"//book"`
+		First   *Node  `xpath:"//book[1]"`
+		Missing string `xpath:"//missing"`
+		Ignored string
+	}
+	catalog.Missing = "untouched"
+	if err := Extract(doc, &catalog); err != nil {
+		t.Fatal(err)
+	}
+	if catalog.Name != "shop" || catalog.Count != 2 || len(catalog.Books) != 2 {
+		t.Fatalf("unexpected catalog: %+v", catalog)
+	}
+	b := catalog.Books[0]
+	if b.ID != 1 || b.Title != "Go" || b.Price != 10.5 || len(b.Tags) != 2 || b.Tags[1] != "b" {
+		t.Fatalf("unexpected first book: %+v", b)
+	}
+	if b.Author == nil || b.Author.Name != "Ann" || b.Published != nil {
+		t.Fatalf("unexpected first book: %+v", b)
+	}
+	b = catalog.Books[1]
+	if b.Author != nil || b.Published == nil || b.Published.Year() != 2018 {
+		t.Fatalf("unexpected second book: %+v", b)
+	}
+	if catalog.First.SelectAttr("id") != "1" || catalog.Missing != "untouched" {
+		t.Fatalf("unexpected catalog: %+v", catalog)
+	}
+}
+
+func TestExtractErrors(t *testing.T) {
+	doc := loadXML(`<a><b>x</b></a>`)
+	var v struct {
+		B int `xpath:"//b"`
+	}
+	if err := Extract(doc, &v); err == nil {
+		t.Fatal("expected an error when decoding a non-number")
+	}
+	if err := Extract(doc, v); err == nil {
+		t.Fatal("expected an error for a non-pointer destination")
+	}
+}
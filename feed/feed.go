@@ -0,0 +1,249 @@
+/*
+Package feed reads RSS 2.0 and Atom feeds from parsed documents. The usual
+fields are exposed with their types, such as dates as time.Time, while the
+elements they come from stay available for extensions.
+*/
+package feed
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+// Namespace URIs of the elements read from feeds.
+const (
+	NamespaceAtom    = "http://www.w3.org/2005/Atom"
+	NamespaceContent = "http://purl.org/rss/1.0/modules/content/"
+	NamespaceDC      = "http://purl.org/dc/elements/1.1/"
+)
+
+// Format is the format of a feed.
+type Format int
+
+const (
+	RSS  Format = iota // RSS 2.0
+	Atom               // Atom 1.0
+)
+
+func (f Format) String() string {
+	if f == Atom {
+		return "Atom"
+	}
+	return "RSS"
+}
+
+// Feed is an RSS channel or an Atom feed.
+type Feed struct {
+	Format      Format
+	Title       string
+	Description string // the RSS description or the Atom subtitle
+	Links       []Link
+	// Updated is the date of the last change of the feed, or the zero time
+	// if it is missing or cannot be parsed.
+	Updated time.Time
+	Items   []*Item
+	// Node is the channel or feed element, for access to extensions.
+	Node *xmlquery.Node
+}
+
+// Item is an RSS item or an Atom entry.
+type Item struct {
+	Title   string
+	ID      string // the RSS guid or the Atom id
+	Summary string // the RSS description or the Atom summary
+	Content string // the RSS content:encoded or the Atom content
+	Authors []string
+	Links   []Link
+	// Published and Updated are the zero time when missing or if they
+	// cannot be parsed. RSS only has Published.
+	Published, Updated time.Time
+	// Node is the item or entry element, for access to extensions.
+	Node *xmlquery.Node
+}
+
+// Link is a link of a feed or item. RSS links have an empty Rel, except for
+// enclosures, which have the "enclosure" Rel.
+type Link struct {
+	Href, Rel, Type string
+}
+
+// Link returns the address of the first link of f that is not an enclosure
+// or the link to the feed itself, which is normally its web page.
+func (f *Feed) Link() string {
+	return mainLink(f.Links)
+}
+
+// Link returns the address of the first link of i that is not an
+// enclosure, which is normally the address of the item.
+func (i *Item) Link() string {
+	return mainLink(i.Links)
+}
+
+func mainLink(links []Link) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// Parse reads the RSS 2.0 or Atom feed of doc, which may be a document or
+// its root element. It returns an error if doc is neither.
+func Parse(doc *xmlquery.Node) (*Feed, error) {
+	root := doc
+	if root.Type == xmlquery.DocumentNode {
+		root = child(doc, "", "")
+	}
+	switch {
+	case root == nil:
+	case root.Data == "rss" && root.NamespaceURI == "":
+		if channel := child(root, "", "channel"); channel != nil {
+			return parseRSS(channel), nil
+		}
+	case root.Data == "feed" && root.NamespaceURI == NamespaceAtom:
+		return parseAtom(root), nil
+	}
+	return nil, errors.New("feed: not an RSS 2.0 or Atom feed")
+}
+
+func parseRSS(channel *xmlquery.Node) *Feed {
+	f := &Feed{
+		Format:      RSS,
+		Title:       text(channel, "", "title"),
+		Description: text(channel, "", "description"),
+		Links:       rssLinks(channel),
+		Node:        channel,
+	}
+	if f.Updated = parseDate(text(channel, "", "lastBuildDate")); f.Updated.IsZero() {
+		f.Updated = parseDate(text(channel, "", "pubDate"))
+	}
+	for _, n := range children(channel, "", "item") {
+		item := &Item{
+			Title:     text(n, "", "title"),
+			ID:        text(n, "", "guid"),
+			Summary:   text(n, "", "description"),
+			Content:   text(n, NamespaceContent, "encoded"),
+			Links:     rssLinks(n),
+			Published: parseDate(text(n, "", "pubDate")),
+			Node:      n,
+		}
+		for _, a := range append(children(n, "", "author"), children(n, NamespaceDC, "creator")...) {
+			item.Authors = append(item.Authors, strings.TrimSpace(a.InnerText()))
+		}
+		f.Items = append(f.Items, item)
+	}
+	return f
+}
+
+// Returns the links of an RSS channel or item: the link element, the
+// enclosures and the Atom links that are often added to RSS.
+func rssLinks(n *xmlquery.Node) []Link {
+	var links []Link
+	if href := text(n, "", "link"); href != "" {
+		links = append(links, Link{Href: href})
+	}
+	for _, e := range children(n, "", "enclosure") {
+		links = append(links, Link{Href: e.SelectAttr("url"), Rel: "enclosure", Type: e.SelectAttr("type")})
+	}
+	return append(links, atomLinks(n)...)
+}
+
+func parseAtom(feed *xmlquery.Node) *Feed {
+	f := &Feed{
+		Format:      Atom,
+		Title:       text(feed, NamespaceAtom, "title"),
+		Description: text(feed, NamespaceAtom, "subtitle"),
+		Links:       atomLinks(feed),
+		Updated:     parseDate(text(feed, NamespaceAtom, "updated")),
+		Node:        feed,
+	}
+	for _, n := range children(feed, NamespaceAtom, "entry") {
+		item := &Item{
+			Title:     text(n, NamespaceAtom, "title"),
+			ID:        text(n, NamespaceAtom, "id"),
+			Summary:   text(n, NamespaceAtom, "summary"),
+			Content:   text(n, NamespaceAtom, "content"),
+			Links:     atomLinks(n),
+			Published: parseDate(text(n, NamespaceAtom, "published")),
+			Updated:   parseDate(text(n, NamespaceAtom, "updated")),
+			Node:      n,
+		}
+		for _, a := range children(n, NamespaceAtom, "author") {
+			item.Authors = append(item.Authors, text(a, NamespaceAtom, "name"))
+		}
+		f.Items = append(f.Items, item)
+	}
+	return f
+}
+
+func atomLinks(n *xmlquery.Node) []Link {
+	var links []Link
+	for _, l := range children(n, NamespaceAtom, "link") {
+		link := Link{Href: l.SelectAttr("href"), Rel: l.SelectAttr("rel"), Type: l.SelectAttr("type")}
+		if link.Rel == "" && n.NamespaceURI == NamespaceAtom {
+			link.Rel = "alternate" // the default in Atom
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// The layouts of the dates found in feeds: RFC 822 as used by RSS, with
+// its common variations, and RFC 3339 as used by Atom.
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+// Returns the date written in s, or the zero time if it cannot be parsed.
+func parseDate(s string) time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Returns the trimmed text of the first child of n with the given name and
+// namespace URI, or "" if there is none.
+func text(n *xmlquery.Node, uri, name string) string {
+	if c := child(n, uri, name); c != nil {
+		return strings.TrimSpace(c.InnerText())
+	}
+	return ""
+}
+
+// Returns the first child element of n with the given name and namespace
+// URI, or the first child element if name is empty.
+func child(n *xmlquery.Node, uri, name string) *xmlquery.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == xmlquery.ElementNode && (name == "" || c.Data == name && c.NamespaceURI == uri) {
+			return c
+		}
+	}
+	return nil
+}
+
+func children(n *xmlquery.Node, uri, name string) []*xmlquery.Node {
+	var list []*xmlquery.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == xmlquery.ElementNode && c.Data == name && c.NamespaceURI == uri {
+			list = append(list, c)
+		}
+	}
+	return list
+}
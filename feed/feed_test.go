@@ -0,0 +1,131 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+func parse(t *testing.T, s string) *Feed {
+	doc, err := xmlquery.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestParseRSS(t *testing.T) {
+	f := parse(t, `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+  <title>News</title>
+  <link>https://example.com/</link>
+  <description>All the news</description>
+  <atom:link href="https://example.com/rss" rel="self" type="application/rss+xml"/>
+  <lastBuildDate>Tue, 10 Jun 2003 09:41:01 GMT</lastBuildDate>
+  <item>
+    <title>First</title>
+    <link>https://example.com/1</link>
+    <guid>id-1</guid>
+    <description>Summary</description>
+    <content:encoded><![CDATA[<p>Full</p>]]></content:encoded>
+    <dc:creator>Ann</dc:creator>
+    <pubDate>Tue, 3 Jun 2003 09:39:21 +0200</pubDate>
+    <enclosure url="https://example.com/1.mp3" type="audio/mpeg" length="1"/>
+    <media:thumbnail url="https://example.com/1.jpg"/>
+  </item>
+  <item><title>Second</title><pubDate>not a date</pubDate></item>
+</channel>
+</rss>`)
+	if f.Format != RSS || f.Title != "News" || f.Description != "All the news" || f.Link() != "https://example.com/" {
+		t.Errorf("unexpected feed %+v", f)
+	}
+	if len(f.Links) != 2 || f.Links[1].Rel != "self" {
+		t.Errorf("unexpected links %+v", f.Links)
+	}
+	if !f.Updated.Equal(time.Date(2003, 6, 10, 9, 41, 1, 0, time.UTC)) {
+		t.Errorf("unexpected update date %v", f.Updated)
+	}
+	if len(f.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(f.Items))
+	}
+	item := f.Items[0]
+	if item.Title != "First" || item.ID != "id-1" || item.Summary != "Summary" || item.Content != "<p>Full</p>" || item.Link() != "https://example.com/1" {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if len(item.Authors) != 1 || item.Authors[0] != "Ann" {
+		t.Errorf("unexpected authors %v", item.Authors)
+	}
+	if !item.Published.Equal(time.Date(2003, 6, 3, 7, 39, 21, 0, time.UTC)) {
+		t.Errorf("unexpected publication date %v", item.Published)
+	}
+	if len(item.Links) != 2 || item.Links[1] != (Link{Href: "https://example.com/1.mp3", Rel: "enclosure", Type: "audio/mpeg"}) {
+		t.Errorf("unexpected links %+v", item.Links)
+	}
+	if thumb := xmlquery.FindOne(item.Node, "media:thumbnail/@url"); thumb == nil || thumb.InnerText() != "https://example.com/1.jpg" {
+		t.Errorf("expected the extension to be reachable, got %v", thumb)
+	}
+	if !f.Items[1].Published.IsZero() {
+		t.Errorf("expected no date, got %v", f.Items[1].Published)
+	}
+}
+
+func TestParseAtom(t *testing.T) {
+	f := parse(t, `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example Feed</title>
+  <subtitle>A subtitle.</subtitle>
+  <link href="http://example.org/feed/" rel="self"/>
+  <link href="http://example.org/"/>
+  <updated>2003-12-13T18:30:02Z</updated>
+  <entry>
+    <title type="html">Atom &lt;b&gt;Powered&lt;/b&gt;</title>
+    <link href="http://example.org/2003/12/13/atom03"/>
+    <link rel="enclosure" href="http://example.org/a.mp3" type="audio/mpeg"/>
+    <id>urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</id>
+    <published>2003-12-13T08:29:29-04:00</published>
+    <updated>2003-12-13T18:30:02Z</updated>
+    <summary>Some text.</summary>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml">Full <b>text</b></div></content>
+    <author><name>John Doe</name></author>
+  </entry>
+</feed>`)
+	if f.Format != Atom || f.Title != "Example Feed" || f.Description != "A subtitle." || f.Link() != "http://example.org/" {
+		t.Errorf("unexpected feed %+v", f)
+	}
+	if !f.Updated.Equal(time.Date(2003, 12, 13, 18, 30, 2, 0, time.UTC)) {
+		t.Errorf("unexpected update date %v", f.Updated)
+	}
+	if len(f.Items) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(f.Items))
+	}
+	item := f.Items[0]
+	if item.Title != "Atom <b>Powered</b>" || item.ID != "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a" ||
+		item.Summary != "Some text." || item.Content != "Full text" || item.Link() != "http://example.org/2003/12/13/atom03" {
+		t.Errorf("unexpected entry %+v", item)
+	}
+	if len(item.Authors) != 1 || item.Authors[0] != "John Doe" {
+		t.Errorf("unexpected authors %v", item.Authors)
+	}
+	if !item.Published.Equal(time.Date(2003, 12, 13, 12, 29, 29, 0, time.UTC)) {
+		t.Errorf("unexpected publication date %v", item.Published)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{`<html/>`, `<rss version="2.0"/>`, `<feed/>`} {
+		doc, err := xmlquery.Parse(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(doc); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+)
+
+// FlatDoc is a read-only, structure-of-arrays representation of a tree.
+// Nodes are stored in document order and addressed by their index; instead
+// of five pointers per node it keeps a handful of integers, names are stored
+// once in a shared table and all character data lives in a single buffer.
+// It is meant for workloads that hold many large documents in memory and
+// only need to walk them, and is traversed with a Cursor.
+type FlatDoc struct {
+	types   []NodeType
+	parents []int32 // index of the parent node, -1 for the root
+	ends    []int32 // index one past the last descendant
+	names   []int32 // name id of elements and declarations, -1 otherwise
+	prefix  []int32 // name id of the element prefix
+	spaces  []int32 // name id of the element namespace URI
+	spans   []int32 // start and end offsets in text of the node data (instruction of processing instructions), at 2*i and 2*i+1
+
+	attrs      []int32 // index of the first attribute of node i, up to attrs[i+1]
+	attrSpace  []int32
+	attrLocal  []int32
+	attrValues []int32 // start and end offsets in text of attribute a, at 2*a and 2*a+1
+
+	strs []string
+	text []byte
+}
+
+// NewFlatDoc converts the subtree rooted at n into a FlatDoc.
+func NewFlatDoc(n *Node) *FlatDoc {
+	d := &FlatDoc{}
+	ids := make(map[string]int32)
+	nameID := func(s string) int32 {
+		if id, ok := ids[s]; ok {
+			return id
+		}
+		id := int32(len(d.strs))
+		d.strs = append(d.strs, s)
+		ids[s] = id
+		return id
+	}
+	var add func(n *Node, parent int32)
+	add = func(n *Node, parent int32) {
+		i := int32(len(d.types))
+		d.types = append(d.types, n.Type)
+		d.parents = append(d.parents, parent)
+		d.ends = append(d.ends, 0)
+		d.attrs = append(d.attrs, int32(len(d.attrLocal)))
+		start := int32(len(d.text))
+		switch n.Type {
+		case ElementNode, DeclarationNode:
+			d.names = append(d.names, nameID(n.Data))
+		case ProcInstNode:
+			// The target is a name, which leaves the span for the instruction.
+			d.names = append(d.names, nameID(n.Data))
+			d.text = append(d.text, n.Inst...)
+		default:
+			d.names = append(d.names, -1)
+			d.text = append(d.text, n.Data...)
+		}
+		d.spans = append(d.spans, start, int32(len(d.text)))
+		d.prefix = append(d.prefix, nameID(n.Prefix))
+		d.spaces = append(d.spaces, nameID(n.NamespaceURI))
+		for _, attr := range n.Attr {
+			d.attrSpace = append(d.attrSpace, nameID(attr.Name.Space))
+			d.attrLocal = append(d.attrLocal, nameID(attr.Name.Local))
+			from := int32(len(d.text))
+			d.text = append(d.text, attr.Value...)
+			d.attrValues = append(d.attrValues, from, int32(len(d.text)))
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			add(child, i)
+		}
+		d.ends[i] = int32(len(d.types))
+	}
+	add(n, -1)
+	// Sentinel so the attributes of the last node can be found like any other.
+	d.attrs = append(d.attrs, int32(len(d.attrLocal)))
+	return d
+}
+
+// Len returns the number of nodes in the document.
+func (d *FlatDoc) Len() int {
+	return len(d.types)
+}
+
+// Cursor returns a cursor positioned on the root node.
+func (d *FlatDoc) Cursor() *Cursor {
+	return &Cursor{doc: d}
+}
+
+func (d *FlatDoc) data(i int32) string {
+	if d.names[i] >= 0 {
+		return d.strs[d.names[i]]
+	}
+	return string(d.text[d.spans[2*i]:d.spans[2*i+1]])
+}
+
+func (d *FlatDoc) attr(i int32) []xml.Attr {
+	from, to := d.attrs[i], d.attrs[i+1]
+	if from == to {
+		return nil
+	}
+	attrs := make([]xml.Attr, 0, to-from)
+	for a := from; a < to; a++ {
+		attrs = append(attrs, xml.Attr{
+			Name:  xml.Name{Space: d.strs[d.attrSpace[a]], Local: d.strs[d.attrLocal[a]]},
+			Value: d.attrValue(a),
+		})
+	}
+	return attrs
+}
+
+func (d *FlatDoc) attrValue(a int32) string {
+	return string(d.text[d.attrValues[2*a]:d.attrValues[2*a+1]])
+}
+
+// Node converts the FlatDoc back into a pointer-based tree and returns its root.
+func (d *FlatDoc) Node() *Node {
+	nodes := make([]*Node, len(d.types))
+	for i := range d.types {
+		n := &Node{
+			Type:         d.types[i],
+			Data:         d.data(int32(i)),
+			Prefix:       d.strs[d.prefix[i]],
+			NamespaceURI: d.strs[d.spaces[i]],
+			Attr:         d.attr(int32(i)),
+		}
+		if n.Type == ProcInstNode {
+			n.Inst = string(d.text[d.spans[2*i]:d.spans[2*i+1]])
+		}
+		nodes[i] = n
+		if p := d.parents[i]; p >= 0 {
+			n.level = nodes[p].level + 1
+			addChild(nodes[p], n)
+		}
+	}
+	return nodes[0]
+}
+
+// A Cursor walks a FlatDoc without materializing any Node.
+type Cursor struct {
+	doc *FlatDoc
+	pos int32
+}
+
+// Index returns the document-order index of the current node.
+func (c *Cursor) Index() int {
+	return int(c.pos)
+}
+
+// Type returns the type of the current node.
+func (c *Cursor) Type() NodeType {
+	return c.doc.types[c.pos]
+}
+
+// Data returns the tag name of elements and the content of other nodes, like Node.Data.
+func (c *Cursor) Data() string {
+	return c.doc.data(c.pos)
+}
+
+// Prefix returns the namespace prefix of the current element.
+func (c *Cursor) Prefix() string {
+	return c.doc.strs[c.doc.prefix[c.pos]]
+}
+
+// NamespaceURI returns the namespace URI of the current element.
+func (c *Cursor) NamespaceURI() string {
+	return c.doc.strs[c.doc.spaces[c.pos]]
+}
+
+// Attr returns a copy of the attributes of the current node.
+func (c *Cursor) Attr() []xml.Attr {
+	return c.doc.attr(c.pos)
+}
+
+// GetAttr works like Node.GetAttr but does not allocate the attribute list.
+func (c *Cursor) GetAttr(key string) (string, bool) {
+	d := c.doc
+	for a := d.attrs[c.pos]; a < d.attrs[c.pos+1]; a++ {
+		name := xml.Name{Space: d.strs[d.attrSpace[a]], Local: d.strs[d.attrLocal[a]]}
+		if xml_name2string(name) == key {
+			return d.attrValue(a), true
+		}
+	}
+	return "", false
+}
+
+// Copy returns an independent cursor at the same position.
+func (c *Cursor) Copy() *Cursor {
+	cp := *c
+	return &cp
+}
+
+// MoveToParent moves to the parent node, returning false at the root.
+func (c *Cursor) MoveToParent() bool {
+	if p := c.doc.parents[c.pos]; p >= 0 {
+		c.pos = p
+		return true
+	}
+	return false
+}
+
+// MoveToFirstChild moves to the first child, returning false if there is none.
+func (c *Cursor) MoveToFirstChild() bool {
+	if next := c.pos + 1; next < c.doc.ends[c.pos] {
+		c.pos = next
+		return true
+	}
+	return false
+}
+
+// MoveToNextSibling moves to the next sibling, returning false if there is none.
+func (c *Cursor) MoveToNextSibling() bool {
+	p := c.doc.parents[c.pos]
+	if p < 0 {
+		return false
+	}
+	if next := c.doc.ends[c.pos]; next < c.doc.ends[p] {
+		c.pos = next
+		return true
+	}
+	return false
+}
+
+// MoveToPrevSibling moves to the previous sibling, returning false if there is none.
+func (c *Cursor) MoveToPrevSibling() bool {
+	p := c.doc.parents[c.pos]
+	if p < 0 || p+1 == c.pos {
+		return false
+	}
+	for i := p + 1; ; i = c.doc.ends[i] {
+		if c.doc.ends[i] == c.pos {
+			c.pos = i
+			return true
+		}
+	}
+}
+
+// Next moves to the following node in document order, returning false at the end.
+func (c *Cursor) Next() bool {
+	if int(c.pos)+1 < len(c.doc.types) {
+		c.pos++
+		return true
+	}
+	return false
+}
+
+// SkipChildren moves to the node following the current subtree in document
+// order, returning false if there is none.
+func (c *Cursor) SkipChildren() bool {
+	if end := c.doc.ends[c.pos]; int(end) < len(c.doc.types) {
+		c.pos = end
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package xmlquery
+
+import (
+	"testing"
+)
+
+func TestFlatDocRoundTrip(t *testing.T) {
+	s := `<?xml version="1.0"?><catalog xmlns:x="urn:x"><book id="1" x:lang="en">A<!--c--><x:title>T</x:title></book>tail<book id="2"/></catalog>`
+	doc := loadXML(s)
+	flat := NewFlatDoc(doc)
+	if got, expected := flat.Node().OutputXML(false), doc.OutputXML(false); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if flat.Len() != 10 {
+		t.Fatalf("expected 10 nodes, but got %d", flat.Len())
+	}
+}
+
+func TestFlatDocCursor(t *testing.T) {
+	doc := loadXML(`<catalog><book id="1">A<title>T</title></book>tail<book id="2"/></catalog>`)
+	c := NewFlatDoc(doc).Cursor()
+	if c.Type() != DocumentNode {
+		t.Fatal("cursor does not start at the root")
+	}
+	c.MoveToFirstChild() // <?xml?>
+	if !c.MoveToNextSibling() || c.Data() != "catalog" {
+		t.Fatalf("expected catalog, but got %q", c.Data())
+	}
+	if c.MoveToNextSibling() {
+		t.Fatal("catalog should not have a next sibling")
+	}
+	c.MoveToFirstChild()
+	if v, _ := c.GetAttr("id"); v != "1" {
+		t.Fatalf("expected id 1, but got %q", v)
+	}
+	book := c.Copy()
+	c.MoveToNextSibling()
+	if c.Type() != TextNode || c.Data() != "tail" {
+		t.Fatalf("expected text node \"tail\", but got %q", c.Data())
+	}
+	c.MoveToNextSibling()
+	if c.Attr()[0].Value != "2" || c.MoveToFirstChild() {
+		t.Fatal("second book is wrong")
+	}
+	c.MoveToPrevSibling()
+	c.MoveToPrevSibling()
+	if c.Index() != book.Index() || c.MoveToPrevSibling() {
+		t.Fatal("MoveToPrevSibling did not return to the first book")
+	}
+	if !c.SkipChildren() || c.Data() != "tail" {
+		t.Fatal("SkipChildren did not skip the first book")
+	}
+	c.MoveToParent()
+	if c.Data() != "catalog" {
+		t.Fatalf("expected catalog, but got %q", c.Data())
+	}
+	n := 0
+	for c := NewFlatDoc(doc).Cursor(); c.Next(); {
+		n++
+	}
+	if n != 8 {
+		t.Fatalf("expected 8 nodes after the root, but got %d", n)
+	}
+}
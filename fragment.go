@@ -0,0 +1,80 @@
+package xmlquery
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// fragmentElement is the name of the element wrapping fragments while they
+// are parsed.
+const fragmentElement = "xmlquery-fragment"
+
+// ParseFragment parses the XML fragment read from r, such as the content of
+// an element or of an external entity: it may have any number of top-level
+// elements, text, comments and processing instructions, but no XML or
+// document type declaration. Prefixes are resolved with the namespaces in
+// scope at context, which may be nil. The top-level nodes are returned
+// detached, ready to be inserted as children of context.
+func ParseFragment(r io.Reader, context *Node) ([]*Node, error) {
+	return ParseFragmentWithOptions(r, context, ParserOptions{})
+}
+
+// ParseFragmentWithOptions is like ParseFragment, with the given options,
+// such as an Allocator or limits. The positions of the nodes are always
+// discarded, as they would be off on the first line.
+func ParseFragmentWithOptions(r io.Reader, context *Node, opts ParserOptions) ([]*Node, error) {
+	var start strings.Builder
+	start.WriteString("<" + fragmentElement)
+	if context != nil {
+		ns := inScopeNamespaces(context)
+		prefixes := make([]string, 0, len(ns))
+		for prefix := range ns {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		for _, prefix := range prefixes {
+			name := "xmlns"
+			if prefix != "" {
+				name += ":" + prefix
+			}
+			start.WriteString(" " + name + `="` + escapeAttrValue(ns[prefix], '"') + `"`)
+		}
+	}
+	start.WriteString(">")
+	opts.DiscardPositions = true
+	doc, err := parse(io.MultiReader(strings.NewReader(start.String()), r, strings.NewReader("</"+fragmentElement+">")), opts)
+	if err != nil {
+		return nil, err
+	}
+	// The fragment may close the wrapper itself and open another one.
+	var wrapper *Node
+	for child := doc.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode {
+			if wrapper != nil {
+				return nil, errors.New("xmlquery: fragment has an unbalanced end tag")
+			}
+			wrapper = child
+		}
+	}
+	var nodes []*Node
+	for child := wrapper.FirstChild; child != nil; {
+		if child.Type == DeclarationNode || child.Type == DoctypeNode {
+			return nil, errors.New("xmlquery: fragment has an XML or document type declaration")
+		}
+		next := child.NextSibling
+		removeFromTree(child)
+		nodes = append(nodes, child)
+		child = next
+	}
+	level := 0
+	if context != nil {
+		level = context.level + 1
+	}
+	for _, n := range nodes {
+		setLevel(n, level)
+	}
+	doc.ReleaseTo(opts.allocator())
+	return nodes, nil
+}
@@ -0,0 +1,66 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFragment(t *testing.T) {
+	nodes, err := ParseFragment(strings.NewReader(`<a/>text<!--c--><b id="1"><c/></b>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range nodes {
+		if n.Parent != nil || n.PrevSibling != nil || n.NextSibling != nil {
+			t.Errorf("%v should be detached", n)
+		}
+		got = append(got, n.OutputXML(true))
+	}
+	if expected := `<a/>|text|<!--c-->|<b id="1"><c/></b>`; strings.Join(got, "|") != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, strings.Join(got, "|"))
+	}
+
+	nodes, err = ParseFragment(strings.NewReader("just text"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Type != TextNode || nodes[0].Data != "just text" {
+		t.Errorf("unexpected nodes: %v", nodes)
+	}
+}
+
+func TestParseFragmentContext(t *testing.T) {
+	doc := loadXML(`<root xmlns="urn:default" xmlns:x="urn:x"><list><item/></list></root>`)
+	list := FindOne(doc, "//list")
+	nodes, err := ParseFragment(strings.NewReader(`<x:item a="1"/><item/>`), list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes[0].NamespaceURI != "urn:x" || nodes[1].NamespaceURI != "urn:default" {
+		t.Errorf("unexpected namespaces %q and %q", nodes[0].NamespaceURI, nodes[1].NamespaceURI)
+	}
+	for _, n := range nodes {
+		list.InsertBefore(n, nil)
+	}
+	if expected, got := `<list xmlns="urn:default" xmlns:x="urn:x"><item/><x:item a="1"/><item/></list>`, list.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	if _, err := ParseFragment(strings.NewReader(`<y:item/>`), list); err == nil {
+		t.Error("expected an error for an unbound prefix")
+	}
+	for _, s := range []string{
+		`x</xmlquery-fragment><xmlquery-fragment>y`,
+		`</xmlquery-fragment><xmlquery-fragment>`,
+		`</xmlquery-fragment><!--c--><xmlquery-fragment>`,
+		`</xmlquery-fragment><?xml version="1.0"?><xmlquery-fragment>`,
+		`<?xml version="1.0"?><a/>`,
+		`<!DOCTYPE a><a/>`,
+	} {
+		if nodes, err := ParseFragment(strings.NewReader(s), nil); err == nil {
+			t.Errorf("%s: expected an error, got %d nodes", s, len(nodes))
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package xmlquery
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	xmlNameType       = reflect.TypeOf(xml.Name{})
+	xmlAttrType       = reflect.TypeOf(xml.Attr{})
+	xmlMarshalerType  = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// FromStruct builds a detached element from v, a struct or a pointer to
+// one, following the rules of encoding/xml for `xml:"..."` tags, so that
+// typed values can be added to a tree without encoding and parsing them:
+// the name of the element comes from the XMLName field or the type, and
+// fields become child elements, attributes (",attr"), text (",chardata" and
+// ",cdata"), comments (",comment") or raw XML (",innerxml"), with
+// ",omitempty" and "a>b" paths supported. Names with a namespace, as in
+// `xml:"urn:x item"`, are declared with xmlns attributes.
+//
+// Fields of type *Node receive a deep copy of the node, so hand-built
+// subtrees can be mixed with structs. Values implementing xml.Marshaler are
+// encoded with encoding/xml and parsed back, as their output cannot be
+// known otherwise.
+func FromStruct(v interface{}) (*Node, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("xmlquery: FromStruct of a nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("xmlquery: FromStruct requires a struct, got %v", rv.Kind())
+	}
+	parent := &Node{Type: DocumentNode}
+	if err := buildElement(parent, xml.Name{}, rv, ""); err != nil {
+		return nil, err
+	}
+	n := parent.FirstChild
+	removeFromTree(n)
+	setLevel(n, 0)
+	return n, nil
+}
+
+// fieldInfo is the meaning of an `xml:"..."` tag.
+type fieldInfo struct {
+	name      xml.Name
+	parents   []string
+	kind      string // "attr", "chardata", "cdata", "innerxml", "comment" or "" for elements
+	omitEmpty bool
+}
+
+func parseFieldTag(f reflect.StructField) fieldInfo {
+	var info fieldInfo
+	tag := f.Tag.Get("xml")
+	name := tag
+	if i := strings.Index(tag, ","); i >= 0 {
+		name = tag[:i]
+		for _, flag := range strings.Split(tag[i+1:], ",") {
+			switch flag {
+			case "omitempty":
+				info.omitEmpty = true
+			case "attr", "chardata", "cdata", "innerxml", "comment":
+				info.kind = flag
+			}
+		}
+	}
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		info.name.Space, name = name[:i], name[i+1:]
+	}
+	if strings.Contains(name, ">") {
+		info.parents = strings.Split(name, ">")
+		name = info.parents[len(info.parents)-1]
+		info.parents = info.parents[:len(info.parents)-1]
+	}
+	info.name.Local = name
+	return info
+}
+
+// Returns the name of the element for v given by its XMLName field, if any.
+func xmlNameOf(v reflect.Value) (xml.Name, bool) {
+	f, ok := v.Type().FieldByName("XMLName")
+	if !ok || f.Type != xmlNameType {
+		return xml.Name{}, false
+	}
+	if name := v.FieldByIndex(f.Index).Interface().(xml.Name); name.Local != "" {
+		return name, true
+	}
+	if info := parseFieldTag(f); info.name.Local != "" {
+		return info.name, true
+	}
+	return xml.Name{}, false
+}
+
+// Appends to parent the element for v, named name unless v names itself.
+// defaultNS is the default namespace in scope at parent.
+func buildElement(parent *Node, name xml.Name, v reflect.Value, defaultNS string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type() == nodeType {
+			clone := v.Interface().(*Node).Clone(true)
+			addChild(parent, clone)
+			setLevel(clone, parent.level+1)
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if own, ok := xmlNameOf(v); ok && (name.Local == "" || own.Space != "" && name.Space == "") {
+			if name.Local == "" {
+				name = own
+			} else {
+				name.Space = own.Space
+			}
+		}
+	}
+	if name.Local == "" {
+		name.Local = v.Type().Name()
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(xmlMarshalerType) || v.Type().Implements(xmlMarshalerType) {
+		return buildMarshaled(parent, name, v)
+	}
+
+	elem := &Node{Type: ElementNode, Data: name.Local}
+	parent.appendNode(elem)
+	if name.Space == "" {
+		// As with encoding/xml, unqualified names stay in the default namespace.
+		name.Space = defaultNS
+	} else if name.Space != defaultNS {
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: name.Space})
+		defaultNS = name.Space
+	}
+	elem.NamespaceURI = name.Space
+	if v.Kind() == reflect.Struct && !implementsText(v) {
+		return buildFields(elem, v, defaultNS)
+	}
+	text, err := textOf(v)
+	if err != nil {
+		return err
+	}
+	if text != "" {
+		elem.appendNode(NewText(text))
+	}
+	return nil
+}
+
+// Adds the fields of the struct v to elem.
+func buildFields(elem *Node, v reflect.Value, defaultNS string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if (f.PkgPath != "" && !f.Anonymous) || f.Name == "XMLName" || f.Tag.Get("xml") == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		info := parseFieldTag(f)
+		if f.Anonymous && f.Tag.Get("xml") == "" {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := buildFields(elem, fv, defaultNS); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if info.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if info.name.Local == "" {
+			info.name.Local = f.Name
+		}
+		if err := buildField(elem, info, fv, defaultNS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildField(elem *Node, info fieldInfo, v reflect.Value, defaultNS string) error {
+	switch info.kind {
+	case "attr":
+		return buildAttr(elem, info.name, v)
+	case "chardata", "cdata":
+		text, err := textOf(v)
+		if err != nil || text == "" {
+			return err
+		}
+		node := NewText(text)
+		node.CDATA = info.kind == "cdata"
+		elem.appendNode(node)
+		return nil
+	case "comment":
+		text, err := textOf(v)
+		if err != nil || text == "" {
+			return err
+		}
+		elem.appendNode(NewComment(text))
+		return nil
+	case "innerxml":
+		text, err := textOf(v)
+		if err != nil || text == "" {
+			return err
+		}
+		nodes, err := ParseFragment(strings.NewReader(text), elem)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			elem.appendNode(n)
+			setLevel(n, elem.level+1)
+		}
+		return nil
+	}
+
+	parent := elem
+	for _, name := range info.parents {
+		if last := parent.LastChild; last != nil && last.Type == ElementNode && last.Data == name && last.NamespaceURI == defaultNS {
+			parent = last
+			continue
+		}
+		parent = parent.AppendElement(name)
+		parent.NamespaceURI = defaultNS
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			if err := buildElement(parent, info.name, v.Index(i), defaultNS); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return buildElement(parent, info.name, v, defaultNS)
+}
+
+func buildAttr(elem *Node, name xml.Name, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == xmlAttrType {
+		attr := v.Interface().(xml.Attr)
+		name, v = attr.Name, reflect.ValueOf(attr.Value)
+	}
+	value, err := textOf(v)
+	if err != nil {
+		return err
+	}
+	switch name.Space {
+	case "":
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: name.Local}, Value: value})
+		return nil
+	case xmlNamespaceURI:
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Space: "xml", Local: name.Local}, Value: value})
+		return nil
+	}
+	prefix := lookupPrefix(elem, name.Space)
+	if prefix == "" {
+		for i := 1; ; i++ {
+			prefix = "ns" + strconv.Itoa(i)
+			if lookupNamespaceURI(elem, prefix) == "" {
+				break
+			}
+		}
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: name.Space})
+	}
+	elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Space: prefix, Local: name.Local}, Value: value})
+	return nil
+}
+
+// Appends to parent the nodes written by the xml.Marshaler v.
+func buildMarshaled(parent *Node, name xml.Name, v reflect.Value) error {
+	val := v.Interface()
+	if v.CanAddr() {
+		// Keeps the methods with a pointer receiver.
+		val = v.Addr().Interface()
+	}
+	var buf bytes.Buffer
+	if err := xml.NewEncoder(&buf).EncodeElement(val, xml.StartElement{Name: name}); err != nil {
+		return err
+	}
+	nodes, err := ParseFragment(&buf, parent)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		addChild(parent, n)
+		setLevel(n, parent.level+1)
+	}
+	return nil
+}
+
+func implementsText(v reflect.Value) bool {
+	return v.Type().Implements(textMarshalerType) || v.CanAddr() && v.Addr().Type().Implements(textMarshalerType)
+}
+
+// Returns the text for v, which must be of a basic kind, a byte slice or
+// implement encoding.TextMarshaler.
+func textOf(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if implementsText(v) {
+		m, ok := v.Interface().(encoding.TextMarshaler)
+		if !ok {
+			m = v.Addr().Interface().(encoding.TextMarshaler)
+		}
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("xmlquery: FromStruct cannot convert %s to text", v.Type())
+}
+
+// Reports whether v is empty for the omitempty option, as in encoding/xml.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string `xml:"city"`
+	Zip  string `xml:"zip,attr,omitempty"`
+}
+
+type testMeta struct {
+	Version int `xml:"version,attr"`
+}
+
+type testPerson struct {
+	XMLName xml.Name `xml:"urn:people person"`
+	testMeta
+	ID       int          `xml:"id,attr"`
+	Lang     string       `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Ref      string       `xml:"urn:refs ref,attr,omitempty"`
+	Name     string       `xml:"name"`
+	Emails   []string     `xml:"contact>email"`
+	Phone    string       `xml:"contact>phone,omitempty"`
+	Address  *testAddress `xml:"address"`
+	Missing  *testAddress `xml:"missing"`
+	Born     time.Time    `xml:"born"`
+	Note     string       `xml:",comment"`
+	Bio      string       `xml:",cdata"`
+	Extra    *Node        `xml:"extra"`
+	Skipped  string       `xml:"-"`
+	private  string
+	Duration time.Duration `xml:"duration,omitempty"`
+}
+
+func TestFromStruct(t *testing.T) {
+	extra := NewElement("custom").WithAttr("k", "v").AppendText("hand-built")
+	p := &testPerson{
+		testMeta: testMeta{Version: 2},
+		ID:       7,
+		Lang:     "en",
+		Ref:      "r1",
+		Name:     "Ann & Bob",
+		Emails:   []string{"a@example.com", "b@example.com"},
+		Address:  &testAddress{City: "Paris", Zip: "75001"},
+		Born:     time.Date(1990, 1, 2, 3, 4, 5, 0, time.UTC),
+		Note:     " note ",
+		Bio:      "<b>bold</b>",
+		Extra:    extra,
+		Skipped:  "no",
+		private:  "no",
+	}
+	n, err := FromStruct(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<person xmlns="urn:people" version="2" id="7" xml:lang="en" xmlns:ns1="urn:refs" ns1:ref="r1">` +
+		`<name>Ann &amp; Bob</name><contact><email>a@example.com</email><email>b@example.com</email></contact>` +
+		`<address zip="75001"><city>Paris</city></address><born>1990-01-02T03:04:05Z</born>` +
+		`<!-- note --><![CDATA[<b>bold</b>]]><custom k="v">hand-built</custom></person>`
+	if got := n.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if n.Parent != nil || n.NamespaceURI != "urn:people" || FindOne(n, "//address").NamespaceURI != "urn:people" {
+		t.Error("unexpected parent or namespaces")
+	}
+	if extra.Parent != nil {
+		t.Error("the *Node field should be copied, not moved")
+	}
+	checkTreeInvariants(t, n)
+
+	// The result is what encoding/xml would produce, once parsed.
+	encoded, err := xml.Marshal(testAddress{City: "Oslo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = FromStruct(testAddress{City: "Oslo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.OutputXML(true); got != string(encoded) {
+		t.Errorf("\nexpected: %s\ngot:      %s", encoded, got)
+	}
+}
+
+type testMarshaler struct{}
+
+func (testMarshaler) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement("custom", start)
+}
+
+type testPtrMarshaler struct {
+	A string
+}
+
+func (*testPtrMarshaler) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement("custom", start)
+}
+
+func TestFromStructErrors(t *testing.T) {
+	if _, err := FromStruct(nil); err == nil {
+		t.Error("expected an error for nil")
+	}
+	if _, err := FromStruct((*testAddress)(nil)); err == nil {
+		t.Error("expected an error for a nil pointer")
+	}
+	if _, err := FromStruct(42); err == nil {
+		t.Error("expected an error for a non-struct value")
+	}
+	type bad struct {
+		M map[string]int `xml:"m"`
+	}
+	if _, err := FromStruct(bad{M: map[string]int{"a": 1}}); err == nil || !strings.Contains(err.Error(), "map[string]int") {
+		t.Errorf("expected an error for a map field, got %v", err)
+	}
+
+	type wrapper struct {
+		XMLName xml.Name      `xml:"w"`
+		M       testMarshaler `xml:"m"`
+	}
+	n, err := FromStruct(wrapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := `<w><m>custom</m></w>`, n.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	// Methods with a pointer receiver are used for addressable fields, as
+	// by encoding/xml.
+	type ptrWrapper struct {
+		XMLName xml.Name         `xml:"w"`
+		M       testPtrMarshaler `xml:"m"`
+	}
+	v := &ptrWrapper{M: testPtrMarshaler{A: "x"}}
+	n, err = FromStruct(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := xml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := `<w><m>custom</m></w>`, n.OutputXML(true); got != expected || got != string(encoded) {
+		t.Errorf("\nexpected: %s\ngot:      %s\nencoding/xml: %s", expected, got, encoded)
+	}
+}
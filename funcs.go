@@ -0,0 +1,384 @@
+package xmlquery
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gjvnq/xpath"
+)
+
+// funcs holds the functions registered with RegisterFunc, by name. The map
+// is replaced rather than changed, so that it can be read without the lock
+// once obtained.
+var funcs struct {
+	sync.Mutex
+	m map[string]*registeredFunc
+}
+
+// registeredFunc is a Go function callable from XPath expressions.
+type registeredFunc struct {
+	name string
+	fn   reflect.Value
+}
+
+// funcCall is a call to a registered function in a compiled expression.
+// The xpath engine has no way to call Go functions, so the call is compiled
+// as a reference to an attribute of the context node that only exists for
+// the navigators evaluating the expression, whose value is computed by
+// calling the function with the arguments evaluated from the context node.
+type funcCall struct {
+	fn   *registeredFunc
+	args []*cachedExpr
+}
+
+// The prefix of the names of the attributes standing for function calls,
+// numbered by the position of the call in the expression.
+const funcAttrPrefix = "xmlquery-func-"
+
+var (
+	nodeListType = reflect.TypeOf([]*Node(nil))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterFunc makes fn callable from XPath expressions under name, which
+// must have a prefix, as in my:matches, so as not to clash with the
+// functions of XPath:
+//
+//	RegisterFunc("my:matches", func(s, pattern string) bool {
+//		return regexp.MustCompile(pattern).MatchString(s)
+//	})
+//	items := Find(doc, "//item[my:matches(@code, '^[A-Z]{3}$')]")
+//
+// The parameters of fn may be strings, float64s and bools, to which the
+// arguments are converted as by the XPath functions string, number and
+// boolean, or a *Node or a []*Node, given the first node or all the nodes
+// of an argument that must be a node set. fn must return a string, a
+// float64 or a bool, optionally followed by an error, which makes the
+// evaluation of the expression fail. Arguments are evaluated with the
+// context node of the call, but not its position: position() and last()
+// cannot be used in them.
+//
+// Registering a function again under the same name replaces it, and
+// empties the cache of compiled expressions. Functions may be called by
+// several goroutines at once. RegisterFunc panics if name has no prefix or
+// fn is not a function as described above.
+func RegisterFunc(name string, fn interface{}) {
+	if i := strings.IndexByte(name, ':'); i <= 0 || i == len(name)-1 || !isFuncName(name[:i]) || !isFuncName(name[i+1:]) {
+		panic(fmt.Sprintf("xmlquery: function name %q must be a name with a prefix", name))
+	}
+	v := reflect.ValueOf(fn)
+	if err := checkFuncType(v); err != nil {
+		panic(fmt.Sprintf("xmlquery: function %s %v", name, err))
+	}
+
+	funcs.Lock()
+	m := make(map[string]*registeredFunc, len(funcs.m)+1)
+	for k, f := range funcs.m {
+		m[k] = f
+	}
+	m[name] = &registeredFunc{name: name, fn: v}
+	funcs.m = m
+	funcs.Unlock()
+	// Cached expressions may refer to the function replaced.
+	purgeExprs()
+}
+
+func checkFuncType(v reflect.Value) error {
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("is a %v, not a function", v.Type())
+	}
+	t := v.Type()
+	if t.IsVariadic() {
+		return fmt.Errorf("cannot be variadic")
+	}
+	for i := 0; i < t.NumIn(); i++ {
+		switch in := t.In(i); in {
+		case reflect.TypeOf(""), reflect.TypeOf(float64(0)), reflect.TypeOf(false), nodeType, nodeListType:
+		default:
+			return fmt.Errorf("has a parameter of unsupported type %v", in)
+		}
+	}
+	if t.NumOut() == 0 || t.NumOut() > 2 || t.NumOut() == 2 && t.Out(1) != errorType {
+		return fmt.Errorf("must return a value, optionally followed by an error")
+	}
+	switch out := t.Out(0); out {
+	case reflect.TypeOf(""), reflect.TypeOf(float64(0)), reflect.TypeOf(false):
+	default:
+		return fmt.Errorf("returns an unsupported type %v", out)
+	}
+	return nil
+}
+
+func registeredFuncs() map[string]*registeredFunc {
+	funcs.Lock()
+	defer funcs.Unlock()
+	return funcs.m
+}
+
+func isFuncNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isFuncNameChar(c byte) bool {
+	return isFuncNameStart(c) || c == '-' || c == '.' || c >= '0' && c <= '9'
+}
+
+func isFuncName(s string) bool {
+	if s == "" || !isFuncNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isFuncNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileExpr compiles expr, with its calls to registered functions turned
+// into references to the attributes standing for them.
+func compileExpr(expr string) (*cachedExpr, error) {
+	src, calls, err := bindFuncs(expr)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := xpath.Compile(src)
+	if err != nil {
+		if len(calls) > 0 {
+			// The error would quote the expression as rewritten.
+			return nil, fmt.Errorf("xmlquery: %q: %v", expr, err)
+		}
+		return nil, err
+	}
+	return &cachedExpr{Expr: compiled, src: expr, calls: calls}, nil
+}
+
+var wildcardAttrs = regexp.MustCompile(`^(?:@\s*|attribute\s*::\s*)(?:\*|node\s*\(\s*\))`)
+
+// Returns expr with the calls to registered functions in it replaced by
+// references to the attributes standing for them, along with the calls.
+func bindFuncs(expr string) (string, []*funcCall, error) {
+	registered := registeredFuncs()
+	if len(registered) == 0 || !strings.Contains(expr, ":") {
+		return expr, nil, nil
+	}
+	var (
+		buf   strings.Builder
+		calls []*funcCall
+		hide  []int // where wildcard attribute tests end in buf
+	)
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		if c == '@' || c == 'a' && startsName(expr, i) {
+			if m := wildcardAttrs.FindString(expr[i:]); m != "" {
+				buf.WriteString(m)
+				hide = append(hide, buf.Len())
+				i += len(m)
+				continue
+			}
+		}
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				// Let the compiler report the unterminated literal.
+				buf.WriteString(expr[i:])
+				i = len(expr)
+				continue
+			}
+			buf.WriteString(expr[i : i+end+2])
+			i += end + 2
+		case isFuncNameStart(c) && startsName(expr, i):
+			j := i + 1
+			for j < len(expr) && isFuncNameChar(expr[j]) {
+				j++
+			}
+			if j+1 < len(expr) && expr[j] == ':' && isFuncNameStart(expr[j+1]) {
+				for j++; j < len(expr) && isFuncNameChar(expr[j]); j++ {
+				}
+			}
+			open := j
+			for open < len(expr) && isSpace(expr[open]) {
+				open++
+			}
+			fn := registered[expr[i:j]]
+			if fn == nil || open == len(expr) || expr[open] != '(' {
+				buf.WriteString(expr[i:j])
+				i = j
+				continue
+			}
+			args, end, err := splitFuncArgs(expr, open+1)
+			if err == nil {
+				var call *funcCall
+				if call, err = compileFuncCall(fn, args); err == nil {
+					buf.WriteString(funcCallRef(fn, len(calls)))
+					calls = append(calls, call)
+				}
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("xmlquery: call to %s in %q: %v", fn.name, expr, err)
+			}
+			i = end
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	if len(calls) == 0 {
+		return expr, nil, nil
+	}
+
+	// Wildcard attribute tests, as in @* and count(@*), are given a
+	// predicate leaving out the attributes standing for the calls. It tests
+	// their names with the self axis, since name() without argument is not
+	// evaluated from the context node by the xpath engine.
+	tests := make([]string, len(calls))
+	for i := range calls {
+		tests[i] = "self::" + funcAttrPrefix + strconv.Itoa(i)
+	}
+	pred := "[not(" + strings.Join(tests, " or ") + ")]"
+	src := buf.String()
+	var out strings.Builder
+	from := 0
+	for _, at := range hide {
+		out.WriteString(src[from:at])
+		out.WriteString(pred)
+		from = at
+	}
+	out.WriteString(src[from:])
+	return out.String(), calls, nil
+}
+
+// Reports whether a name can start at expr[i], rather than continue the
+// name of a variable or the local part of a prefixed name.
+func startsName(expr string, i int) bool {
+	return i == 0 || !isFuncNameChar(expr[i-1]) && expr[i-1] != ':' && expr[i-1] != '$'
+}
+
+// Returns the reference to the attribute standing for the i-th call of an
+// expression, a call to fn, converted to the type fn returns.
+func funcCallRef(fn *registeredFunc, i int) string {
+	attr := "@" + funcAttrPrefix + strconv.Itoa(i)
+	switch fn.fn.Type().Out(0).Kind() {
+	case reflect.Bool:
+		return "(" + attr + " = 'true')"
+	case reflect.Float64:
+		return "number(" + attr + ")"
+	}
+	return "string(" + attr + ")"
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Returns the arguments of the call whose opening parenthesis is right
+// before expr[start], and the position past its closing parenthesis.
+func splitFuncArgs(expr string, start int) ([]string, int, error) {
+	var args []string
+	depth := 0
+	from := start
+	for i := start; i < len(expr); i++ {
+		switch c := expr[i]; c {
+		case '\'', '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, 0, fmt.Errorf("unterminated literal")
+			}
+			i += end + 1
+		case '(', '[':
+			depth++
+		case ']':
+			depth--
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if arg := strings.TrimSpace(expr[from:i]); arg != "" || len(args) > 0 {
+				args = append(args, arg)
+			}
+			return args, i + 1, nil
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(expr[from:i]))
+				from = i + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("missing closing parenthesis")
+}
+
+// Compiles the arguments of a call to fn, converted to the types of its
+// parameters.
+func compileFuncCall(fn *registeredFunc, args []string) (*funcCall, error) {
+	t := fn.fn.Type()
+	if len(args) != t.NumIn() {
+		return nil, fmt.Errorf("%d arguments given, %d expected", len(args), t.NumIn())
+	}
+	call := &funcCall{fn: fn, args: make([]*cachedExpr, len(args))}
+	for i, arg := range args {
+		if arg == "" {
+			return nil, fmt.Errorf("argument %d is empty", i+1)
+		}
+		switch t.In(i).Kind() {
+		case reflect.String:
+			arg = "string(" + arg + ")"
+		case reflect.Float64:
+			arg = "number(" + arg + ")"
+		case reflect.Bool:
+			arg = "boolean(" + arg + ")"
+		}
+		exp, err := compileExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		call.args[i] = exp
+	}
+	return call, nil
+}
+
+// Calls the function with its arguments evaluated from the context node
+// ctx, and returns the result as the value of an attribute. Errors of the
+// function are raised as panics, which the query functions turn into
+// errors.
+func (c *funcCall) value(ctx *NodeNavigator) string {
+	t := c.fn.fn.Type()
+	in := make([]reflect.Value, len(c.args))
+	for i, arg := range c.args {
+		nav := &NodeNavigator{root: ctx.root, curr: ctx.curr, attr: ctx.attr, prefixes: ctx.prefixes}
+		v := arg.Evaluate(nav)
+		switch t.In(i) {
+		case nodeType, nodeListType:
+			it, ok := v.(*xpath.NodeIterator)
+			if !ok {
+				panic(fmt.Errorf("argument %d of %s is not a node set", i+1, c.fn.name))
+			}
+			nodes := iteratorNodes(it)
+			if t.In(i) == nodeListType {
+				in[i] = reflect.ValueOf(nodes)
+			} else if len(nodes) > 0 {
+				in[i] = reflect.ValueOf(nodes[0])
+			} else {
+				in[i] = reflect.Zero(nodeType)
+			}
+		default:
+			in[i] = reflect.ValueOf(v)
+		}
+	}
+	out := c.fn.fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		panic(fmt.Errorf("%s: %v", c.fn.name, out[1].Interface()))
+	}
+	switch v := out[0].Interface().(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return out[0].String()
+}
@@ -0,0 +1,111 @@
+package xmlquery
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterFunc("test:matches", func(s, pattern string) bool {
+		return regexp.MustCompile(pattern).MatchString(s)
+	})
+	RegisterFunc("test:upper", strings.ToUpper)
+	RegisterFunc("test:double", func(f float64) float64 { return 2 * f })
+	RegisterFunc("test:names", func(nodes []*Node) string {
+		var names []string
+		for _, n := range nodes {
+			names = append(names, n.Data)
+		}
+		return strings.Join(names, ",")
+	})
+	RegisterFunc("test:first", func(n *Node) (string, error) {
+		if n == nil {
+			return "", errors.New("no node")
+		}
+		return n.Data, nil
+	})
+}
+
+func TestRegisterFunc(t *testing.T) {
+	doc := loadXML(`<items><item code="ABC" qty="2">one</item><item code="abc" qty="3">two</item><item code="XYZ" qty="5"><sub/>three</item></items>`)
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{`//item[test:matches(@code, '^[A-Z]{3}$')]`, []string{"one", "three"}},
+		{`//item[not(test:matches(@code, "^[A-Z]"))]`, []string{"two"}},
+		{`//item[test:upper(@code) = 'ABC']`, []string{"one", "two"}},
+		{`//item[test:double(@qty) > 6]`, []string{"three"}},
+		{`//item[test:double(test:double(@qty)) = 12]`, []string{"two"}},
+		{`//item[test:names(*) = 'sub']`, []string{"three"}},
+		{`//item[count(@*) = 2][test:matches(@code, 'a')]`, []string{"two"}},
+		{`//item[@*[. = 'XYZ']][test:upper(.) = 'THREE']`, []string{"three"}},
+	}
+	for _, test := range tests {
+		nodes, err := QueryAll(doc, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		var got []string
+		for _, n := range nodes {
+			got = append(got, n.InnerText())
+		}
+		if strings.Join(got, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s: expected %v, got %v", test.expr, test.expected, got)
+		}
+	}
+
+	if attrs := Find(doc, `//item[test:matches(@code, 'X')]/@*`); len(attrs) != 2 {
+		t.Errorf("expected the 2 attributes of the item, got %v", attrs)
+	}
+	if v, err := Evaluate(doc, `test:upper(string(//item[2]/@code))`); err != nil || v != "ABC" {
+		t.Errorf("expected ABC, got %v, %v", v, err)
+	}
+	if v, err := Evaluate(doc, `test:first(//item[3]/*)`); err != nil || v != "sub" {
+		t.Errorf("expected sub, got %v, %v", v, err)
+	}
+	if e := MustCompile(`//item[test:double(@qty) = 4]`); e.String() != `//item[test:double(@qty) = 4]` {
+		t.Errorf("unexpected source %q", e.String())
+	}
+}
+
+func TestRegisterFuncErrors(t *testing.T) {
+	doc := loadXML(`<items><item code="ABC"/></items>`)
+	for _, expr := range []string{
+		`//item[test:upper(@code, 'x')]`,
+		`//item[test:upper()]`,
+		`//item[test:upper(@code]`,
+		`//item[test:unknown(@code)]`,
+	} {
+		if _, err := QueryAll(doc, expr); err == nil {
+			t.Errorf("%s: expected a compile error", expr)
+		}
+	}
+	_, err := QueryAll(doc, `//item[test:first(missing) = 'x']`)
+	if err == nil || !strings.Contains(err.Error(), "no node") {
+		t.Errorf("expected the error of the function, got %v", err)
+	}
+	if _, err := QueryAll(doc, `//item[test:names('x') = '']`); err == nil {
+		t.Error("expected an error for a string passed as a node set")
+	}
+
+	for name, fn := range map[string]interface{}{
+		"nameonly":   strings.ToUpper,
+		"test:bad":   "not a function",
+		"test:args":  func(i int) bool { return true },
+		"test:out":   func() {},
+		"test:error": func() (string, string) { return "", "" },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			RegisterFunc(name, fn)
+		}()
+	}
+}
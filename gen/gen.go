@@ -0,0 +1,211 @@
+/*
+Package gen generates random XML documents following a summary of their
+structure, for load testing code that processes documents and for seeding
+fuzzers. The summary can be written by hand or inferred from sample
+documents.
+*/
+package gen
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+// Schema summarizes the structure of a family of documents.
+type Schema struct {
+	// Root is the name of the root element.
+	Root string
+	// Elements describes the elements by name.
+	Elements map[string]*Element
+}
+
+// Element describes the content of an element.
+type Element struct {
+	// Attrs lists the attributes of the element, with sample values.
+	Attrs map[string][]string
+	// Children lists the names of the child elements, in order.
+	Children []string
+	// MaxOccurs gives, by child name, how many times a child may be
+	// repeated; it is 1 for children not listed.
+	MaxOccurs map[string]int
+	// Text lists sample texts of the element. Elements without samples
+	// have no text.
+	Text []string
+}
+
+// Options controls the size of the generated documents.
+type Options struct {
+	// MaxDepth limits the nesting of elements, 10 by default.
+	MaxDepth int
+	// MaxNodes limits the number of elements, 1000 by default.
+	MaxNodes int
+	// Repeat multiplies the MaxOccurs of every child, making documents
+	// larger.
+	Repeat int
+}
+
+// Infer returns the schema summarizing the given documents. All documents
+// are expected to have the same root element, the first one is used.
+func Infer(docs ...*xmlquery.Node) *Schema {
+	s := &Schema{Elements: make(map[string]*Element)}
+	var visit func(n *xmlquery.Node)
+	visit = func(n *xmlquery.Node) {
+		name := qname(n)
+		e := s.element(name)
+		counts := make(map[string]int)
+		for _, attr := range n.Attr {
+			key := attr.Name.Local
+			if attr.Name.Space != "" {
+				key = attr.Name.Space + ":" + key
+			}
+			e.Attrs[key] = addSample(e.Attrs[key], attr.Value)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Type {
+			case xmlquery.ElementNode:
+				childName := qname(child)
+				if counts[childName] == 0 && !contains(e.Children, childName) {
+					e.Children = append(e.Children, childName)
+				}
+				counts[childName]++
+				visit(child)
+			case xmlquery.TextNode:
+				if !child.IsEmpty() {
+					e.Text = addSample(e.Text, child.Data)
+				}
+			}
+		}
+		for childName, count := range counts {
+			if count > e.MaxOccurs[childName] {
+				e.MaxOccurs[childName] = count
+			}
+		}
+	}
+	for _, doc := range docs {
+		for n := doc.FirstChild; n != nil; n = n.NextSibling {
+			if n.Type == xmlquery.ElementNode {
+				if s.Root == "" {
+					s.Root = qname(n)
+				}
+				visit(n)
+			}
+		}
+		if doc.Type == xmlquery.ElementNode {
+			if s.Root == "" {
+				s.Root = qname(doc)
+			}
+			visit(doc)
+		}
+	}
+	return s
+}
+
+func (s *Schema) element(name string) *Element {
+	e, ok := s.Elements[name]
+	if !ok {
+		e = &Element{Attrs: make(map[string][]string), MaxOccurs: make(map[string]int)}
+		s.Elements[name] = e
+	}
+	return e
+}
+
+// The number of distinct samples kept per attribute or text.
+const maxSamples = 16
+
+func addSample(samples []string, s string) []string {
+	if len(samples) >= maxSamples || contains(samples, s) {
+		return samples
+	}
+	return append(samples, s)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func qname(n *xmlquery.Node) string {
+	if n.Prefix != "" {
+		return n.Prefix + ":" + n.Data
+	}
+	return n.Data
+}
+
+// Generate returns a random document following s. Every child element and
+// attribute is included at random, repeated children up to their MaxOccurs,
+// and text and attribute values are picked among the samples, or made up
+// when there are none. The same source of randomness produces the same
+// document.
+func (s *Schema) Generate(r *rand.Rand, opts Options) *xmlquery.Node {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = 10
+	}
+	if opts.MaxNodes <= 0 {
+		opts.MaxNodes = 1000
+	}
+	if opts.Repeat <= 0 {
+		opts.Repeat = 1
+	}
+	g := &generator{s: s, r: r, opts: opts}
+	doc := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	doc.AddChild(g.element(s.Root, 1))
+	return doc
+}
+
+type generator struct {
+	s     *Schema
+	r     *rand.Rand
+	opts  Options
+	nodes int
+}
+
+func (g *generator) element(name string, depth int) *xmlquery.Node {
+	g.nodes++
+	n := &xmlquery.Node{Type: xmlquery.ElementNode, Data: name}
+	e := g.s.Elements[name]
+	if e == nil {
+		return n
+	}
+
+	names := make([]string, 0, len(e.Attrs))
+	for attr := range e.Attrs {
+		names = append(names, attr)
+	}
+	sort.Strings(names) // map order would make the output differ between runs
+	for _, attr := range names {
+		if g.r.Intn(4) > 0 {
+			n.SetAttr(attr, g.pick(e.Attrs[attr]))
+		}
+	}
+
+	if len(e.Text) > 0 {
+		n.AddChild(&xmlquery.Node{Type: xmlquery.TextNode, Data: g.pick(e.Text)})
+	}
+	if depth >= g.opts.MaxDepth {
+		return n
+	}
+	for _, child := range e.Children {
+		max := e.MaxOccurs[child]
+		if max < 1 {
+			max = 1
+		}
+		for count := g.r.Intn(max*g.opts.Repeat + 1); count > 0 && g.nodes < g.opts.MaxNodes; count-- {
+			n.AddChild(g.element(child, depth+1))
+		}
+	}
+	return n
+}
+
+func (g *generator) pick(samples []string) string {
+	if len(samples) == 0 {
+		return "v" + strconv.Itoa(g.r.Intn(1000))
+	}
+	return samples[g.r.Intn(len(samples))]
+}
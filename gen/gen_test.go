@@ -0,0 +1,65 @@
+package gen
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+func TestInfer(t *testing.T) {
+	doc, err := xmlquery.Parse(strings.NewReader(`<catalog><book id="1"><title>A</title><author>X</author><author>Y</author></book><book id="2"><title>B</title></book></catalog>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Infer(doc)
+	if s.Root != "catalog" {
+		t.Fatalf("expected root catalog, but got %s", s.Root)
+	}
+	book := s.Elements["book"]
+	if strings.Join(book.Children, ",") != "title,author" || book.MaxOccurs["author"] != 2 || book.MaxOccurs["title"] != 1 {
+		t.Fatalf("unexpected book %+v", book)
+	}
+	if strings.Join(book.Attrs["id"], ",") != "1,2" {
+		t.Fatalf("unexpected id samples %v", book.Attrs["id"])
+	}
+	if strings.Join(s.Elements["title"].Text, ",") != "A,B" {
+		t.Fatalf("unexpected title samples %v", s.Elements["title"].Text)
+	}
+	if s.Elements["catalog"].MaxOccurs["book"] != 2 {
+		t.Fatal("expected up to 2 books")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s := &Schema{
+		Root: "list",
+		Elements: map[string]*Element{
+			"list": {Children: []string{"item"}, MaxOccurs: map[string]int{"item": 4}},
+			"item": {Attrs: map[string][]string{"id": nil}, Children: []string{"list"}, Text: []string{"x", "y"}},
+		},
+	}
+	opts := Options{MaxDepth: 6, MaxNodes: 200, Repeat: 2}
+	doc := s.Generate(rand.New(rand.NewSource(1)), opts)
+	out := doc.OutputXML(false)
+	if out != s.Generate(rand.New(rand.NewSource(1)), opts).OutputXML(false) {
+		t.Fatal("the same seed should produce the same document")
+	}
+	reparsed, err := xmlquery.Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("generated document is not well-formed: %v", err)
+	}
+	if root := xmlquery.FindOne(reparsed, "/list"); root == nil {
+		t.Fatal("missing root element")
+	}
+	if n := len(xmlquery.Find(reparsed, "//*")); n > opts.MaxNodes {
+		t.Fatalf("expected at most %d elements, but got %d", opts.MaxNodes, n)
+	}
+	if n := len(xmlquery.Find(reparsed, "/list/item/list")); n == 0 {
+		t.Fatal("expected nested lists")
+	}
+	if n := len(xmlquery.Find(reparsed, "/*/*/*/*/*/*/*")); n != 0 {
+		t.Fatalf("expected no element deeper than %d, but got %d", opts.MaxDepth, n)
+	}
+}
@@ -1,10 +1,11 @@
 module github.com/gjvnq/xmlquery
 
-go 1.12
+go 1.18
 
 require (
 	github.com/gjvnq/xpath v0.0.0-20190321230035-73e5f591b991
 	golang.org/x/crypto v0.0.0-20190320223903-b7391e95e576 // indirect
 	golang.org/x/net v0.0.0-20190320064053-1272bf9dcd53
 	golang.org/x/sys v0.0.0-20190321052220-f7bb7a8bee54 // indirect
+	golang.org/x/text v0.3.0
 )
@@ -0,0 +1,183 @@
+package xmlquery
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Namespace URIs of the foreign elements that may appear in HTML.
+var htmlNamespaceURIs = map[string]string{
+	"svg":  "http://www.w3.org/2000/svg",
+	"math": "http://www.w3.org/1998/Math/MathML",
+}
+
+// ParseHTML parses the HTML document read from r with the forgiving parser
+// of golang.org/x/net/html, which accepts any input as browsers do, and
+// returns it as a tree of Nodes that can be queried, changed and written as
+// XML like any other. The encoding is detected from the byte order mark and
+// the meta elements of the document, as browsers do, and returned by the
+// DetectedEncoding method of the returned node.
+//
+// Element and attribute names are lowercase, without prefixes, so that
+// queries such as //div[@class='title'] work as expected; SVG and MathML
+// elements have the NamespaceURI of their vocabulary.
+func ParseHTML(r io.Reader) (*Node, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(1024)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	_, enc, _ := charset.DetermineEncoding(head, "")
+	utf8, err := charset.NewReaderLabel(enc, br)
+	if err != nil {
+		return nil, err
+	}
+	root, err := html.Parse(utf8)
+	if err != nil {
+		return nil, err
+	}
+	doc := convertHTMLNode(root, 0)
+	doc.setEncoding(enc)
+	return doc, nil
+}
+
+// FromHTMLNode returns a copy of the tree rooted at h, as built by
+// golang.org/x/net/html and used by htmlquery, as a detached tree of Nodes.
+// Names are converted as by ParseHTML. It returns nil if h is an error
+// node.
+func FromHTMLNode(h *html.Node) *Node {
+	return convertHTMLNode(h, 0)
+}
+
+// ToHTMLNode returns a copy of the tree rooted at n as a tree of
+// golang.org/x/net/html nodes, for use with htmlquery or html.Render. SVG
+// and MathML elements get the namespace of their vocabulary and other
+// elements keep their prefix in their name. Since HTML has no processing
+// instructions, they become comments, as the HTML parser reads them, and
+// XML declarations are dropped; ToHTMLNode returns nil if n is one.
+func ToHTMLNode(n *Node) *html.Node {
+	h := &html.Node{Data: n.Data}
+	switch n.Type {
+	case DocumentNode:
+		h.Type = html.DocumentNode
+	case ElementNode:
+		h.Type = html.ElementNode
+		for ns, uri := range htmlNamespaceURIs {
+			if n.NamespaceURI == uri {
+				h.Namespace = ns
+			}
+		}
+		if h.Namespace == "" {
+			h.Data = qualifiedName(n)
+			h.DataAtom = atom.Lookup([]byte(h.Data))
+		}
+		for _, attr := range n.Attr {
+			h.Attr = append(h.Attr, html.Attribute{Namespace: attr.Name.Space, Key: attr.Name.Local, Val: attr.Value})
+		}
+	case TextNode:
+		h.Type = html.TextNode
+	case CommentNode:
+		h.Type = html.CommentNode
+	case ProcInstNode:
+		h.Type = html.CommentNode
+		h.Data = "?" + n.Data
+		if n.Inst != "" {
+			h.Data += " " + n.Inst
+		}
+		h.Data += "?"
+	case DoctypeNode:
+		h.Type = html.DoctypeNode
+		h.Data = ""
+		decl := n.Data
+		if i := strings.IndexByte(decl, '['); i >= 0 {
+			decl = decl[:i] // the internal subset has no HTML equivalent
+		}
+		fields := dtdFields(decl)
+		if len(fields) > 1 {
+			h.Data = strings.ToLower(fields[1])
+		}
+		if len(fields) > 2 {
+			switch strings.ToUpper(fields[2]) {
+			case "PUBLIC":
+				h.Attr = appendDoctypeIDs(h.Attr, fields[3:], "public", "system")
+			case "SYSTEM":
+				h.Attr = appendDoctypeIDs(h.Attr, fields[3:], "system")
+			}
+		}
+	default:
+		return nil
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if c := ToHTMLNode(child); c != nil {
+			h.AppendChild(c)
+		}
+	}
+	return h
+}
+
+// Appends the quoted identifiers of a doctype to attrs as the attributes
+// with the given keys, which is how the HTML parser stores them.
+func appendDoctypeIDs(attrs []html.Attribute, ids []string, keys ...string) []html.Attribute {
+	for i, key := range keys {
+		if i >= len(ids) || len(ids[i]) < 2 {
+			break
+		}
+		attrs = append(attrs, html.Attribute{Key: key, Val: ids[i][1 : len(ids[i])-1]})
+	}
+	return attrs
+}
+
+// Returns a copy of the HTML tree rooted at h as a tree of Nodes, with h at
+// the given level. Error nodes are dropped, and so nil is returned for them.
+func convertHTMLNode(h *html.Node, level int) *Node {
+	n := &Node{Data: h.Data, level: level}
+	switch h.Type {
+	case html.DocumentNode:
+		n.Type = DocumentNode
+	case html.ElementNode:
+		n.Type = ElementNode
+		n.NamespaceURI = htmlNamespaceURIs[h.Namespace]
+		for _, attr := range h.Attr {
+			n.Attr = append(n.Attr, xml.Attr{Name: xml.Name{Space: attr.Namespace, Local: attr.Key}, Value: attr.Val})
+		}
+	case html.TextNode:
+		n.Type = TextNode
+	case html.CommentNode:
+		n.Type = CommentNode
+	case html.DoctypeNode:
+		n.Type = DoctypeNode
+		n.Data = "DOCTYPE " + h.Data
+		var public, system string
+		for _, attr := range h.Attr {
+			switch attr.Key {
+			case "public":
+				public = attr.Val
+			case "system":
+				system = attr.Val
+			}
+		}
+		switch {
+		case public != "":
+			n.Data += ` PUBLIC "` + public + `"`
+			if system != "" {
+				n.Data += ` "` + system + `"`
+			}
+		case system != "":
+			n.Data += ` SYSTEM "` + system + `"`
+		}
+	default:
+		return nil
+	}
+	for child := h.FirstChild; child != nil; child = child.NextSibling {
+		if c := convertHTMLNode(child, level+1); c != nil {
+			addChild(n, c)
+		}
+	}
+	return n
+}
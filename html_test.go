@@ -0,0 +1,82 @@
+package xmlquery
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseHTML(t *testing.T) {
+	s := `<!DOCTYPE html>
+<html><head><title>Page</title></head>
+<body>
+<!-- nav -->
+<div class=title>Hello<br>world</div>
+<p>one<p>two &amp; <b>three
+<svg><circle r="1"/></svg>
+</body></html>`
+	doc, err := ParseHTML(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != DocumentNode || doc.FirstChild.Type != DoctypeNode || doc.FirstChild.Data != "DOCTYPE html" {
+		t.Errorf("expected a document starting with its doctype, got %v", doc.FirstChild)
+	}
+	if n := FindOne(doc, "//title"); n == nil || n.InnerText() != "Page" {
+		t.Errorf("expected the title, got %v", n)
+	}
+	if n := FindOne(doc, "//div[@class='title']"); n == nil || n.OutputXML(true) != `<div class="title">Hello<br/>world</div>` {
+		t.Errorf("unexpected div %v", n)
+	}
+	var texts []string
+	for _, p := range Find(doc, "//p") {
+		texts = append(texts, strings.TrimSpace(p.InnerText()))
+	}
+	if got := strings.Join(texts, "|"); !strings.HasPrefix(got, "one|two & three") {
+		t.Errorf("expected the unclosed paragraphs to be fixed, got %q", got)
+	}
+	if n := FindOne(doc, "//circle"); n == nil || n.NamespaceURI != "http://www.w3.org/2000/svg" {
+		t.Errorf("expected an SVG circle, got %v", n)
+	}
+	if n := FindOne(doc, "//comment()"); n == nil || n.Data != " nav " {
+		t.Errorf("expected the comment, got %v", n)
+	}
+	checkTreeInvariants(t, doc)
+}
+
+func TestHTMLNodeConversion(t *testing.T) {
+	s := `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">` +
+		`<html><head><title>T</title></head><body><!--c--><p class="x">a &lt; b<br></p>` +
+		`<svg viewBox="0 0 1 1"><a xlink:href="#x"></a></svg></body></html>`
+	h, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected bytes.Buffer
+	html.Render(&expected, h)
+
+	doc := FromHTMLNode(h)
+	checkTreeInvariants(t, doc)
+	if n := FindOne(doc, "//p[@class='x']"); n == nil || n.InnerText() != "a < b" {
+		t.Errorf("unexpected paragraph %v", n)
+	}
+
+	var got bytes.Buffer
+	html.Render(&got, ToHTMLNode(doc))
+	if got.String() != expected.String() {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected.String(), got.String())
+	}
+
+	// Subtrees from XML documents can be rendered as HTML.
+	x := loadXML(`<div xmlns:x="urn:x"><?php echo 1?><x:y a="1">t</x:y><br/></div>`)
+	got.Reset()
+	html.Render(&got, ToHTMLNode(FindOne(x, "/div")))
+	if s := `<div xmlns:x="urn:x"><!--?php echo 1?--><x:y a="1">t</x:y><br/></div>`; got.String() != s {
+		t.Errorf("\nexpected: %s\ngot:      %s", s, got.String())
+	}
+	if ToHTMLNode(x.FirstChild) != nil {
+		t.Error("expected the XML declaration to be dropped")
+	}
+}
@@ -0,0 +1,248 @@
+package xmlquery
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// indexesBuilt is set once any tree has been indexed. Until then, changes to
+// trees, such as those made while parsing, do not look for an index to drop.
+var indexesBuilt int32
+
+// docIndex holds the lookup tables of a tree, built from its root.
+type docIndex struct {
+	ids   map[string]*Node   // elements by ID
+	tags  map[string][]*Node // elements by local name, set by BuildIndex
+	attrs map[string][]*Node // elements by attribute local name and value, set by BuildIndex
+}
+
+// Returns the root of the tree n belongs to.
+func treeRoot(n *Node) *Node {
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return n
+}
+
+// GetElementByID returns the element of the tree of n whose ID is id, or nil
+// if there is none. IDs are the values of xml:id attributes and of the
+// attributes declared with the ID type by the DTD of the document. If
+// several elements share an ID, the first one in document order is
+// returned.
+//
+// Lookups go through an index of the tree, built on the first call and
+// dropped by the methods changing the tree, such as InsertBefore and
+// SetAttr.
+func (n *Node) GetElementByID(id string) *Node {
+	root := treeRoot(n)
+	docMu.Lock()
+	defer docMu.Unlock()
+	if root.doc == nil || root.doc.index == nil || root.doc.index.ids == nil {
+		buildIDIndex(root)
+	}
+	elem := root.doc.index.ids[id]
+	if elem != nil && (treeRoot(elem) != root || !hasID(elem, id, idAttrs(root))) {
+		// Stale entry.
+		buildIDIndex(root)
+		elem = root.doc.index.ids[id]
+	}
+	return elem
+}
+
+// BuildIndex indexes the elements of the tree of n by name and by attribute
+// value. Once built, QueryAll, Query, Find and FindOne answer expressions of
+// the forms below from the index rather than by walking the tree:
+//
+//	//name
+//	//name[@attr='value']
+//	//*[@attr='value']
+//
+// Names may have a prefix. The index is meant for documents that no longer
+// change: the methods changing the tree, such as InsertBefore and SetAttr,
+// drop it, and BuildIndex must be called again to restore it.
+func (n *Node) BuildIndex() {
+	root := treeRoot(n)
+	tags := make(map[string][]*Node)
+	attrs := make(map[string][]*Node)
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == ElementNode {
+			tags[n.Data] = append(tags[n.Data], n)
+			for _, attr := range n.Attr {
+				key := attr.Name.Local + "\x00" + attr.Value
+				if list := attrs[key]; len(list) == 0 || list[len(list)-1] != n {
+					attrs[key] = append(list, n)
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	docMu.Lock()
+	defer docMu.Unlock()
+	if root.doc == nil {
+		root.doc = &docInfo{}
+	}
+	if root.doc.index == nil {
+		root.doc.index = &docIndex{}
+	}
+	root.doc.index.tags, root.doc.index.attrs = tags, attrs
+	atomic.StoreInt32(&indexesBuilt, 1)
+}
+
+var (
+	indexedNameExpr = regexp.MustCompile(`^//(\*|[\pL_][\pL\pN_.-]*(?::[\pL_][\pL\pN_.-]*)?)$`)
+	indexedAttrExpr = regexp.MustCompile(`^//(\*|[\pL_][\pL\pN_.-]*(?::[\pL_][\pL\pN_.-]*)?)\[@([\pL_][\pL\pN_.-]*(?::[\pL_][\pL\pN_.-]*)?)\s*=\s*(?:'([^']*)'|"([^"]*)")\]$`)
+)
+
+// Returns the nodes selected by expr from the index of the tree of top, if
+// it has one built by BuildIndex and expr is one of the forms it answers.
+func indexedQuery(top *Node, expr string) ([]*Node, bool) {
+	var elem, attr, value string
+	if m := indexedNameExpr.FindStringSubmatch(expr); m != nil && m[1] != "*" {
+		elem = m[1]
+	} else if m := indexedAttrExpr.FindStringSubmatch(expr); m != nil {
+		elem, attr, value = m[1], m[2], m[3]+m[4]
+	} else {
+		return nil, false
+	}
+	root := treeRoot(top)
+	docMu.Lock()
+	defer docMu.Unlock()
+	if root.doc == nil || root.doc.index == nil || root.doc.index.tags == nil {
+		return nil, false
+	}
+
+	var candidates []*Node
+	attrPrefix := ""
+	if attr != "" {
+		if i := strings.IndexByte(attr, ':'); i > 0 {
+			attrPrefix, attr = attr[:i], attr[i+1:]
+		}
+		candidates = root.doc.index.attrs[attr+"\x00"+value]
+	} else {
+		name := elem
+		if i := strings.IndexByte(name, ':'); i > 0 {
+			name = name[i+1:]
+		}
+		candidates = root.doc.index.tags[name]
+	}
+	var nodes []*Node
+	for _, n := range candidates {
+		// As in XPath, a name without prefix only matches names without
+		// prefix.
+		if elem != "*" && qualifiedName(n) != elem {
+			continue
+		}
+		if attr != "" && !hasAttrValue(n, attrPrefix, attr, value) {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, true
+}
+
+// Returns true if n has an attribute with the given prefix, local name and
+// value.
+func hasAttrValue(n *Node, prefix, local, value string) bool {
+	for _, a := range n.Attr {
+		if a.Name.Local == local && a.Value == value && a.Name.Space == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidateIndex drops the indexes of the tree of n: the ID index is
+// rebuilt on the next call to GetElementByID, while the indexes of
+// BuildIndex are only rebuilt by calling it again. The methods changing a
+// tree call it themselves; it must be called after changing the fields of
+// the nodes of an indexed tree directly.
+func (n *Node) InvalidateIndex() {
+	root := treeRoot(n)
+	docMu.Lock()
+	if root.doc != nil {
+		root.doc.index = nil
+	}
+	docMu.Unlock()
+}
+
+// dropIndex is InvalidateIndex for the helpers changing trees, which only
+// pay for finding the root of the tree of n once indexes are in use.
+func dropIndex(n *Node) {
+	if atomic.LoadInt32(&indexesBuilt) == 0 {
+		return
+	}
+	n.InvalidateIndex()
+}
+
+// Must be called with docMu locked.
+func buildIDIndex(root *Node) {
+	if root.doc == nil {
+		root.doc = &docInfo{}
+	}
+	if root.doc.index == nil {
+		root.doc.index = &docIndex{}
+	}
+	ids := make(map[string]*Node)
+	attrs := idAttrs(root)
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == ElementNode {
+			for _, attr := range n.Attr {
+				if isIDAttr(n, attr.Name.Space, attr.Name.Local, attrs) {
+					if _, dup := ids[attr.Value]; !dup {
+						ids[attr.Value] = n
+					}
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+	root.doc.index.ids = ids
+	atomic.StoreInt32(&indexesBuilt, 1)
+}
+
+// Returns the attributes declared with the ID type by the DTD of the
+// document rooted at root, by element name.
+func idAttrs(root *Node) map[string][]string {
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == DoctypeNode {
+			return parseDoctypeIDAttrs(child.Data)
+		}
+	}
+	return nil
+}
+
+func isIDAttr(n *Node, space, local string, attrs map[string][]string) bool {
+	if space == "xml" && local == "id" {
+		return true
+	}
+	name := local
+	if space != "" {
+		name = space + ":" + local
+	}
+	for _, attr := range attrs[qualifiedName(n)] {
+		if attr == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if n has an ID attribute with the value id.
+func hasID(n *Node, id string, attrs map[string][]string) bool {
+	for _, attr := range n.Attr {
+		if attr.Value == id && isIDAttr(n, attr.Name.Space, attr.Name.Local, attrs) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetElementByID(t *testing.T) {
+	s := `<!DOCTYPE doc [
+  <!ATTLIST item key ID #REQUIRED lang CDATA "en">
+  <!ATTLIST note ref IDREF #IMPLIED code ID #IMPLIED>
+]>
+<doc><item key="a">1</item><item key="b">2</item><note code="c" ref="a">3</note><other xml:id="d">4</other><other key="e">5</other><item key="a">dup</item></doc>`
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, expected := range map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"} {
+		if n := doc.GetElementByID(id); n == nil || n.InnerText() != expected {
+			t.Errorf("%s: expected %s, got %v", id, expected, n)
+		}
+	}
+	for _, id := range []string{"e", "en", "x"} {
+		if n := doc.GetElementByID(id); n != nil {
+			t.Errorf("%s: expected nothing, got %v", id, n)
+		}
+	}
+
+	// Lookups from any node of the tree use the same index.
+	b := FindOne(doc, "//item[2]")
+	if n := b.GetElementByID("d"); n == nil || n.InnerText() != "4" {
+		t.Errorf("expected the element with xml:id d, got %v", n)
+	}
+
+	// Changes to the tree drop the index.
+	b.Detach()
+	if n := doc.GetElementByID("b"); n != nil {
+		t.Errorf("expected the removed element to be gone, got %v", n)
+	}
+	FindOne(doc, "/doc").InsertBefore(NewElement("new").WithAttr("xml:id", "f"), nil)
+	if n := doc.GetElementByID("f"); n == nil || n.Data != "new" {
+		t.Errorf("expected the new element, got %v", n)
+	}
+	FindOne(doc, "//new").SetAttr("xml:id", "g")
+	if n := doc.GetElementByID("g"); n == nil || n.Data != "new" {
+		t.Errorf("expected the renumbered element, got %v", n)
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	doc := loadXML(`<doc xmlns:p="urn:p"><item id="1" kind="a">1</item><group><item id="2" kind="b">2</item><p:item id="3" kind="a">3</p:item></group><other kind="a" p:kind="b">4</other></doc>`)
+	exprs := []string{
+		"//item",
+		"//p:item",
+		"//group",
+		"//missing",
+		"//item[@kind='a']",
+		`//item[@kind = "b"]`,
+		"//*[@kind='a']",
+		"//*[@p:kind='b']",
+		"//*[@xmlns:p='urn:p']",
+	}
+	expected := make(map[string][]*Node)
+	for _, expr := range exprs {
+		expected[expr] = Find(doc, expr)
+	}
+
+	doc.BuildIndex()
+	for _, expr := range exprs {
+		if _, ok := indexedQuery(doc, expr); !ok {
+			t.Errorf("%s: expected an indexed query", expr)
+		}
+		got := Find(FindOne(doc, "//group"), expr)
+		if strings.Join(NodeList(got).Texts(), ",") != strings.Join(NodeList(expected[expr]).Texts(), ",") {
+			t.Errorf("%s:\nexpected: %v\ngot:      %v", expr, NodeList(expected[expr]).Texts(), NodeList(got).Texts())
+		}
+	}
+	if n := FindOne(doc, "//item[@id='2']"); n == nil || n.InnerText() != "2" {
+		t.Errorf("expected the second item, got %v", n)
+	}
+	for _, expr := range []string{"//*", "//item[1]", "/doc/item", "//item/@id"} {
+		if _, ok := indexedQuery(doc, expr); ok {
+			t.Errorf("%s: expected the query not to use the index", expr)
+		}
+	}
+
+	// Changes to the tree drop the index.
+	mutations := []func(){
+		func() { FindOne(doc, "/doc").AddChild(NewElement("item")) },
+		func() { FindOne(doc, "/doc/item[2]").Detach() },
+		func() { FindOne(doc, "//group").InsertBefore(NewElement("item"), nil) },
+		func() { FindOne(doc, "//item[@id='1']").SetAttr("kind", "b") },
+		func() { FindOne(doc, "//other").Rename("item") },
+	}
+	for i, mutate := range mutations {
+		doc.BuildIndex()
+		mutate()
+		if _, ok := indexedQuery(doc, "//item"); ok {
+			t.Errorf("mutation %d: expected the index to be dropped", i)
+		}
+	}
+	if n := len(Find(doc, "//item")); n != 4 {
+		t.Errorf("expected 4 items, got %d", n)
+	}
+	if n := len(Find(doc, "//item[@kind='b']")); n != 2 {
+		t.Errorf("expected 2 items of kind b, got %d", n)
+	}
+}
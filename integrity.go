@@ -0,0 +1,134 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IntegrityProblem is a broken link or a wrong level found in a tree by
+// CheckIntegrity.
+type IntegrityProblem struct {
+	Node *Node // the node whose links or level are wrong
+	Msg  string
+}
+
+func (p IntegrityProblem) String() string {
+	return describeNode(p.Node) + ": " + p.Msg
+}
+
+// IntegrityError is returned by CheckIntegrity for a tree with problems.
+type IntegrityError struct {
+	Problems []IntegrityProblem
+}
+
+func (e *IntegrityError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.String()
+	}
+	return "xmlquery: broken tree: " + strings.Join(msgs, "; ")
+}
+
+// CheckIntegrity verifies that the subtree of n is consistent, as it is
+// after parsing and the mutation methods of Node, and returns an
+// *IntegrityError listing the problems found otherwise: children whose
+// Parent, PrevSibling or NextSibling links disagree with FirstChild and
+// LastChild, cycles, documents and attributes linked as children, and
+// levels that are not one more than the level of the parent. Trees edited
+// by setting the link fields directly are the usual suspects.
+//
+// If repairLevels is true, wrong levels are fixed rather than reported;
+// links are never changed.
+func (n *Node) CheckIntegrity(repairLevels bool) error {
+	c := &integrityChecker{repair: repairLevels, seen: make(map[*Node]bool)}
+	if n.Parent != nil && n.level != n.Parent.level+1 {
+		c.level(n, n.Parent.level+1)
+	}
+	c.check(n)
+	if len(c.problems) > 0 {
+		return &IntegrityError{Problems: c.problems}
+	}
+	return nil
+}
+
+type integrityChecker struct {
+	repair   bool
+	seen     map[*Node]bool
+	problems []IntegrityProblem
+}
+
+func (c *integrityChecker) report(n *Node, format string, args ...interface{}) {
+	c.problems = append(c.problems, IntegrityProblem{Node: n, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Repairs or reports the level of n, which should be level.
+func (c *integrityChecker) level(n *Node, level int) {
+	if c.repair {
+		n.level = level
+		return
+	}
+	c.report(n, "at level %d instead of %d", n.level, level)
+}
+
+func (c *integrityChecker) check(n *Node) {
+	c.seen[n] = true
+	if (n.FirstChild == nil) != (n.LastChild == nil) {
+		c.report(n, "FirstChild and LastChild disagree")
+	}
+	if n.FirstChild != nil && (n.Type == TextNode || n.Type == CommentNode) {
+		c.report(n, "has children")
+	}
+	var prev *Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if c.seen[child] {
+			c.report(n, "cycle through %s", describeNode(child))
+			return
+		}
+		if child.Parent != n {
+			c.report(child, "Parent is not %s", describeNode(n))
+		}
+		if child.PrevSibling != prev {
+			c.report(child, "PrevSibling is not the previous child")
+		}
+		switch child.Type {
+		case DocumentNode:
+			c.report(child, "document linked as a child")
+		case AttributeNode:
+			c.report(child, "attribute linked as a child")
+		}
+		if child.level != n.level+1 {
+			c.level(child, n.level+1)
+		}
+		c.check(child)
+		prev = child
+	}
+	if prev != n.LastChild {
+		c.report(n, "LastChild is not the last child")
+	}
+}
+
+// Returns a short description of n for messages, without following its
+// links, which may be broken.
+func describeNode(n *Node) string {
+	switch n.Type {
+	case DocumentNode:
+		return "document"
+	case ElementNode:
+		return "element " + qualifiedName(n)
+	case AttributeNode:
+		return "attribute " + qualifiedName(n)
+	case TextNode:
+		text := n.Data
+		if len(text) > 20 {
+			text = text[:20] + "..."
+		}
+		return fmt.Sprintf("text %q", text)
+	case CommentNode:
+		return "comment"
+	case ProcInstNode:
+		return "processing instruction " + n.Data
+	case DeclarationNode:
+		return "declaration"
+	}
+	return "DOCTYPE"
+}
@@ -0,0 +1,122 @@
+package xmlquery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	doc := loadXML(`<root><a><a1/>text</a><b/><c/></root>`)
+	if err := doc.CheckIntegrity(false); err != nil {
+		t.Fatal(err)
+	}
+	root := doc.SelectElement("root")
+	a, b, c := root.SelectElement("a"), root.SelectElement("b"), root.SelectElement("c")
+
+	// Links set by hand.
+	b.PrevSibling = nil
+	c.Parent = a
+	root.LastChild = b
+	err := doc.CheckIntegrity(false)
+	var ierr *IntegrityError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("expected an *IntegrityError, got %v", err)
+	}
+	var got []string
+	for _, p := range ierr.Problems {
+		got = append(got, p.String())
+	}
+	expected := []string{
+		"element b: PrevSibling is not the previous child",
+		"element c: Parent is not element root",
+		"element root: LastChild is not the last child",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("\nexpected: %s\ngot:      %s", strings.Join(expected, "\n          "), strings.Join(got, "\n          "))
+	}
+	if !strings.HasPrefix(err.Error(), "xmlquery: broken tree: element b: ") {
+		t.Errorf("unexpected message %q", err)
+	}
+
+	// Cycles are reported rather than followed forever.
+	doc = loadXML(`<root><a/><b/></root>`)
+	root = doc.SelectElement("root")
+	root.LastChild.NextSibling = root.FirstChild
+	if err := doc.CheckIntegrity(false); err == nil || !strings.Contains(err.Error(), "cycle through element a") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// Levels are reported, or repaired.
+	doc = loadXML(`<root><a><a1/></a></root>`)
+	a = FindOne(doc, "//a")
+	a.level = 5
+	err = doc.CheckIntegrity(false)
+	if err == nil || !errors.As(err, &ierr) || len(ierr.Problems) != 2 || ierr.Problems[0].Node != a || ierr.Problems[0].Msg != "at level 5 instead of 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := doc.CheckIntegrity(true); err != nil {
+		t.Fatal(err)
+	}
+	checkTreeInvariants(t, doc)
+	if err := FindOne(doc, "//a1").CheckIntegrity(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLevelAfterMutations(t *testing.T) {
+	doc := loadXML(`<root><a><a1/></a><b/></root>`)
+	root := doc.SelectElement("root")
+	a, b := root.SelectElement("a"), root.SelectElement("b")
+
+	// Subtrees moved with any of the mutation functions are at the right
+	// level afterwards.
+	c := NewElement("c")
+	c.AddChild(NewElement("c1"))
+	b.AddChild(c)
+	a1 := FindOne(doc, "//a1")
+	a1.Detach()
+	if a1.level != 0 || a1.Level() != 0 {
+		t.Errorf("detached node at level %d", a1.level)
+	}
+	a1.AddSibling(c.FirstChild)
+	AddChild(a, NewElement("a2"))
+	AddSibling(a, NewElement("d"))
+	x := NewElement("x")
+	x.AppendElement("x1")
+	root.Wrap(x)
+	b.Unwrap()
+	if err := doc.CheckIntegrity(false); err != nil {
+		t.Fatal(err)
+	}
+	checkTreeInvariants(t, doc)
+	for n, level := range map[*Node]int{doc: 0, x: 1, root: 2, a: 3, c: 3, a1: 4, FindOne(doc, "//x1"): 2} {
+		if n.Level() != level || n.level != level {
+			t.Errorf("%s: expected level %d, got %d (%d)", n.Data, level, n.Level(), n.level)
+		}
+	}
+
+	// Parsing into the mutated tree relies on levels.
+	if err := c.SetInnerXML(`<e><f/></e>text`); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := ParseFragment(strings.NewReader(`<g/>`), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AddChild(nodes[0])
+	if err := doc.CheckIntegrity(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := FindOne(doc, "//f").Level(); got != 5 {
+		t.Errorf("expected level 5, got %d", got)
+	}
+	expected := `<x><x1/><root><a><a2/><g/></a><c><e><f/></e>text</c><d/></root></x>`
+	if got := x.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	RemoveFromTree(c)
+	if err := c.CheckIntegrity(false); err != nil || c.level != 0 {
+		t.Errorf("unexpected level %d, %v", c.level, err)
+	}
+}
@@ -0,0 +1,123 @@
+package xmlquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MapOptions sets the conventions used to represent elements as maps, by
+// ToMap and ToJSON, and to read them back, by FromJSON.
+type MapOptions struct {
+	// AttrPrefix is prepended to the names of attributes, "@" by default.
+	AttrPrefix string
+	// TextKey is the key of the text of elements that also have
+	// attributes or children, "#text" by default.
+	TextKey string
+	// AlwaysArray represents child elements as arrays even when they are
+	// not repeated, so that consumers see the same shape whatever the
+	// number of occurrences.
+	AlwaysArray bool
+}
+
+func (opts MapOptions) withDefaults() MapOptions {
+	if opts.AttrPrefix == "" {
+		opts.AttrPrefix = "@"
+	}
+	if opts.TextKey == "" {
+		opts.TextKey = "#text"
+	}
+	return opts
+}
+
+// ToMap converts n into a map with a single key, the name of n, or of the
+// root element if n is a document. Elements are represented as follows:
+//
+//   - an empty element is nil;
+//   - an element with only text is a string;
+//   - any other element is a map holding its attributes, its text, made of
+//     its text nodes that are not only whitespace, and its child elements,
+//     as a slice of their values if there are several with the same name.
+//
+// Comments and processing instructions are left out. This is the inverse
+// of FromMap, except that all values are strings.
+func (n *Node) ToMap(opts MapOptions) map[string]interface{} {
+	opts = opts.withDefaults()
+	if n.Type == DocumentNode {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode {
+				n = child
+				break
+			}
+		}
+	}
+	return map[string]interface{}{qualifiedName(n): toMapValue(n, opts)}
+}
+
+func qualifiedName(n *Node) string {
+	if n.Prefix != "" {
+		return n.Prefix + ":" + n.Data
+	}
+	return n.Data
+}
+
+func toMapValue(n *Node, opts MapOptions) interface{} {
+	m := make(map[string]interface{})
+	for _, attr := range n.Attr {
+		m[opts.AttrPrefix+xml_name2string(attr.Name)] = attr.Value
+	}
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case TextNode:
+			if !child.IsEmpty() {
+				text.WriteString(child.Data)
+			}
+		case ElementNode:
+			name := qualifiedName(child)
+			v := toMapValue(child, opts)
+			switch prev := m[name].(type) {
+			case []interface{}:
+				m[name] = append(prev, v)
+			case nil:
+				if _, ok := m[name]; ok {
+					m[name] = []interface{}{prev, v}
+				} else if opts.AlwaysArray {
+					m[name] = []interface{}{v}
+				} else {
+					m[name] = v
+				}
+			default:
+				m[name] = []interface{}{prev, v}
+			}
+		}
+	}
+	if len(m) == 0 {
+		if text.Len() == 0 {
+			return nil
+		}
+		return text.String()
+	}
+	if text.Len() > 0 {
+		m[opts.TextKey] = text.String()
+	}
+	return m
+}
+
+// ToJSON returns the JSON encoding of ToMap(opts).
+func (n *Node) ToJSON(opts MapOptions) ([]byte, error) {
+	return json.Marshal(n.ToMap(opts))
+}
+
+// FromJSON builds a document from a JSON object, following the rules of
+// FromMap with the conventions of opts.
+func FromJSON(data []byte, opts MapOptions) (*Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep numbers as written
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("xmlquery: invalid JSON: %v", err)
+	}
+	return fromMapOptions(m, opts)
+}
@@ -0,0 +1,62 @@
+package xmlquery
+
+import (
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?>
+<order id="7">
+	<item sku="a">pen</item>
+	<item sku="b">ink</item>
+	<note>fragile</note>
+	<gift/>
+	<!-- comment -->
+</order>`)
+	b, err := doc.ToJSON(MapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"order":{"@id":"7","gift":null,"item":[{"#text":"pen","@sku":"a"},{"#text":"ink","@sku":"b"}],"note":"fragile"}}`
+	if got := string(b); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	b, err = FindOne(doc, "//order").ToJSON(MapOptions{AttrPrefix: "-", TextKey: "_", AlwaysArray: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"order":{"-id":"7","gift":[null],"item":[{"-sku":"a","_":"pen"},{"-sku":"b","_":"ink"}],"note":["fragile"]}}`
+	if got := string(b); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	opts := MapOptions{AttrPrefix: "-", TextKey: "_"}
+	doc, err := FromJSON([]byte(`{"order":{"-id":7,"item":[{"-sku":"a","_":"pen"},{"_":"ink"}],"gift":null,"qty":1.50}}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<order id="7"><gift/><item sku="a">pen</item><item>ink</item><qty>1.50</qty></order>`
+	if got := doc.OutputXML(false); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	// Round trip
+	b, err := doc.ToJSON(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := FromJSON(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.OutputXML(false); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	if _, err := FromJSON([]byte(`[1]`), opts); err == nil {
+		t.Fatal("expected an error for a JSON array")
+	}
+}
@@ -0,0 +1,179 @@
+package xmlquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ToJSONML returns the subtree rooted at n following the JsonML convention
+// (http://www.jsonml.org): an element is an array holding its name, an
+// optional object with its attributes and then its children, and text is a
+// string. Names keep their prefix, as in ["atom:link",{"href":"/"}].
+//
+// Comments and processing instructions have no JsonML representation and
+// are left out. A document is represented by its root element.
+func (n *Node) ToJSONML() []byte {
+	var buf bytes.Buffer
+	if n.Type == DocumentNode {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode {
+				n = child
+				break
+			}
+		}
+	}
+	writeJSONML(&buf, n)
+	return buf.Bytes()
+}
+
+func writeJSONML(buf *bytes.Buffer, n *Node) {
+	switch n.Type {
+	case TextNode:
+		writeJSONString(buf, n.Data)
+		return
+	case ElementNode:
+	default:
+		buf.WriteString("null")
+		return
+	}
+	buf.WriteByte('[')
+	name := n.Data
+	if n.Prefix != "" {
+		name = n.Prefix + ":" + n.Data
+	}
+	writeJSONString(buf, name)
+	if len(n.Attr) > 0 {
+		buf.WriteString(",{")
+		for i, attr := range n.Attr {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, xml_name2string(attr.Name))
+			buf.WriteByte(':')
+			writeJSONString(buf, attr.Value)
+		}
+		buf.WriteByte('}')
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != ElementNode && child.Type != TextNode {
+			continue
+		}
+		buf.WriteByte(',')
+		writeJSONML(buf, child)
+	}
+	buf.WriteByte(']')
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)               // never fails for strings
+	buf.Truncate(buf.Len() - 1) // drop the newline added by Encode
+}
+
+// ParseJSONML builds a document from an element in the JsonML convention,
+// as written by ToJSONML. Attribute values that are numbers, booleans or
+// null are converted to text. Namespace prefixes are resolved with the xmlns
+// attributes of the elements.
+func ParseJSONML(data []byte) (*Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("xmlquery: invalid JsonML: %v", err)
+	}
+	if tok != json.Delim('[') {
+		return nil, errors.New("xmlquery: invalid JsonML: the root must be an element array")
+	}
+	doc := &Node{Type: DocumentNode}
+	if err := readJSONMLElement(dec, doc); err != nil {
+		return nil, fmt.Errorf("xmlquery: invalid JsonML: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("xmlquery: invalid JsonML: data after the root element")
+	}
+	return doc, nil
+}
+
+// Reads the rest of an element array, whose opening bracket was consumed,
+// and adds the element to parent.
+func readJSONMLElement(dec *json.Decoder, parent *Node) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	name, ok := tok.(string)
+	if !ok || name == "" {
+		return errors.New("element arrays must start with a name")
+	}
+	elem := &Node{Type: ElementNode, Data: name, level: parent.level + 1}
+	if i := strings.Index(name, ":"); i > 0 {
+		elem.Prefix, elem.Data = name[:i], name[i+1:]
+	}
+	addChild(parent, elem)
+
+	first := true
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			switch tok {
+			case ']':
+				elem.NamespaceURI = lookupNamespaceURI(elem, elem.Prefix)
+				return nil
+			case '[':
+				if err := readJSONMLElement(dec, elem); err != nil {
+					return err
+				}
+			case '{':
+				if !first {
+					return fmt.Errorf("attributes of <%s> must follow its name", name)
+				}
+				if err := readJSONMLAttrs(dec, elem); err != nil {
+					return err
+				}
+			}
+		case string:
+			addChild(elem, &Node{Type: TextNode, Data: tok, level: elem.level + 1})
+		default:
+			return fmt.Errorf("unexpected %v in <%s>", tok, name)
+		}
+		first = false
+	}
+}
+
+// Reads the rest of an attribute object, whose opening brace was consumed.
+func readJSONMLAttrs(dec *json.Decoder, elem *Node) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string) // object keys are always strings
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		switch v := tok.(type) {
+		case string:
+			addAttr(elem, key, v)
+		case json.Number:
+			addAttr(elem, key, v.String())
+		case bool:
+			addAttr(elem, key, fmt.Sprint(v))
+		case nil:
+			addAttr(elem, key, "")
+		default:
+			return fmt.Errorf("attribute %s of <%s> must be a scalar", key, elem.Data)
+		}
+	}
+	_, err := dec.Token() // closing brace
+	return err
+}
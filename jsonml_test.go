@@ -0,0 +1,37 @@
+package xmlquery
+
+import (
+	"testing"
+)
+
+func TestToJSONML(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?><ul xmlns:x="urn:x" class="list"><!-- c --><li x:id="1">a &amp; "b"</li><li/></ul>`)
+	expected := `["ul",{"xmlns:x":"urn:x","class":"list"},["li",{"x:id":"1"},"a & \"b\""],["li"]]`
+	if got := string(doc.ToJSONML()); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestParseJSONML(t *testing.T) {
+	doc, err := ParseJSONML([]byte(`["atom:feed", {"xmlns:atom": "urn:atom", "n": 3, "ok": true},
+		"title ", ["atom:link", {"href": "/"}], ["br"]]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<atom:feed xmlns:atom="urn:atom" n="3" ok="true">title <atom:link href="/"/><br/></atom:feed>`
+	if got := doc.OutputXML(false); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if link := FindOne(doc, "//atom:link"); link.NamespaceURI != "urn:atom" || link.Parent.Data != "feed" {
+		t.Fatalf("unexpected link %v in namespace %q", link, link.NamespaceURI)
+	}
+	if got := string(doc.ToJSONML()); got != `["atom:feed",{"xmlns:atom":"urn:atom","n":"3","ok":"true"},"title ",["atom:link",{"href":"/"}],["br"]]` {
+		t.Fatalf("unexpected round trip %s", got)
+	}
+
+	for _, s := range []string{`"text"`, `[]`, `[1]`, `["a", "b", {"c": "d"}]`, `["a", {"b": []}]`, `["a"] ["b"]`, `["a"`} {
+		if _, err := ParseJSONML([]byte(s)); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package xmlquery
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// LoadURLOptions controls how LoadURLWithContext fetches a document.
+type LoadURLOptions struct {
+	// Client sends the request. If nil, a client with the default transport
+	// is used, configured with TLSConfig if set.
+	Client *http.Client
+	// TLSConfig is used for the connection when Client is nil.
+	TLSConfig *tls.Config
+	// Header is added to the request, e.g. for an Authorization header.
+	Header http.Header
+	// Parser is used to parse the response body.
+	Parser ParserOptions
+}
+
+// LoadURLWithContext loads the XML document from the specified URL, like
+// LoadURL, but the request is bound to ctx and configured by opts. Responses
+// with a status other than 2xx are reported as errors. Bodies with a gzip
+// Content-Encoding are decompressed, and a charset given by the Content-Type
+// header takes precedence over the encoding declared by the document.
+// Parsing also stops once ctx is done.
+func LoadURLWithContext(ctx context.Context, url string, opts LoadURLOptions) (*Node, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range opts.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+		if opts.TLSConfig != nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = opts.TLSConfig
+			client = &http.Client{Transport: transport}
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("xmlquery: %s: unexpected status %s", url, resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if !resp.Uncompressed && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	popts := opts.Parser
+	detected := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && params["charset"] != "" {
+		convert := popts.CharsetReader
+		if convert == nil {
+			convert = charset.NewReaderLabel
+		}
+		if body, err = convert(params["charset"], body); err != nil {
+			return nil, err
+		}
+		// The body is UTF-8 now, whatever the document declares.
+		popts.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+		detected = strings.ToLower(params["charset"])
+	}
+	doc, err := parseContext(ctx, body, popts)
+	if err == nil && detected != "" {
+		doc.setEncoding(detected)
+	}
+	return doc, err
+}
@@ -0,0 +1,79 @@
+package xmlquery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadURLWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<root><item>a</item></root>`))
+	}))
+	defer server.Close()
+
+	if _, err := LoadURLWithContext(context.Background(), server.URL, LoadURLOptions{}); err == nil {
+		t.Error("expected an error for an unauthorized request")
+	}
+	doc, err := LoadURLWithContext(context.Background(), server.URL, LoadURLOptions{
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := FindOne(doc, "//item"); n == nil || n.InnerText() != "a" {
+		t.Errorf("unexpected document: %s", doc.OutputXML(true))
+	}
+}
+
+func TestLoadURLWithContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := LoadURLWithContext(ctx, server.URL, LoadURLOptions{Client: server.Client()}); err == nil {
+		t.Error("expected an error when the context expires")
+	}
+}
+
+func TestLoadURLWithContextGzipCharset(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	// The declaration is wrong, the Content-Type header wins.
+	gz.Write([]byte("<?xml version=\"1.0\" encoding=\"utf-8\"?><root>caf\xe9</root>"))
+	gz.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=ISO-8859-1")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	doc, err := LoadURLWithContext(context.Background(), server.URL, LoadURLOptions{
+		Header: http.Header{"Accept-Encoding": {"gzip"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FindOne(doc, "/root").InnerText(); got != "café" {
+		t.Errorf("expected café, got %q", got)
+	}
+	if doc.DetectedEncoding() != "iso-8859-1" {
+		t.Errorf("expected encoding iso-8859-1, got %q", doc.DetectedEncoding())
+	}
+}
@@ -0,0 +1,147 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// Languages that write decimals with a comma, keyed by base language.
+var decimalCommaLanguages = map[string]bool{
+	"af": true, "az": true, "be": true, "bg": true, "bs": true, "ca": true,
+	"cs": true, "da": true, "de": true, "el": true, "es": true, "et": true,
+	"eu": true, "fi": true, "fo": true, "fr": true, "gl": true, "hr": true,
+	"hu": true, "hy": true, "id": true, "is": true, "it": true, "ka": true,
+	"kk": true, "ky": true, "lt": true, "lv": true, "mk": true, "mn": true,
+	"nb": true, "nl": true, "nn": true, "no": true, "pl": true, "pt": true,
+	"ro": true, "ru": true, "sk": true, "sl": true, "sq": true, "sr": true,
+	"sv": true, "tr": true, "uk": true, "uz": true, "vi": true,
+}
+
+// Regions that use a decimal point even though their language usually does not.
+var decimalPointRegions = map[string]bool{
+	"CH": true, "LI": true, "MX": true,
+}
+
+// DecimalSeparator returns the character used to separate the integer and
+// fractional parts of numbers written in the given language.
+func DecimalSeparator(tag language.Tag) rune {
+	base, _ := tag.Base()
+	region, conf := tag.Region()
+	if conf == language.Exact && decimalPointRegions[region.String()] {
+		return '.'
+	}
+	if decimalCommaLanguages[base.String()] {
+		return ','
+	}
+	return '.'
+}
+
+// NormalizeNumber converts a number written following the conventions of
+// the given language (e.g. "1.234,56" in German or "1 234,56" in French)
+// into the form accepted by strconv.ParseFloat ("1234.56"). Surrounding
+// whitespace, grouping separators (periods, commas, apostrophes and all
+// kinds of spaces) and a leading plus sign are removed, and the Unicode
+// minus sign is replaced by an ASCII hyphen.
+//
+// Grouping separators are only accepted between groups of three digits of
+// the integer part, so that a number written with the decimal separator of
+// another language, such as "1,5" in English, is an error rather than 15.
+func NormalizeNumber(s string, tag language.Tag) (string, error) {
+	dec := DecimalSeparator(tag)
+	var buf strings.Builder
+	var (
+		inInt   = true  // within the integer part
+		grouped = false // a grouping separator was seen
+		digits  = 0     // digits of the integer part since the last separator
+	)
+	// Checks the last group of the integer part once it ends.
+	endInt := func() bool {
+		inInt = false
+		return !grouped || digits == 3
+	}
+	for i, c := range strings.TrimSpace(s) {
+		switch {
+		case c == dec && inInt:
+			if !endInt() {
+				return "", fmt.Errorf("xmlquery: misplaced grouping separator in %q", s)
+			}
+			buf.WriteByte('.')
+		case c == '-' || c == '−':
+			buf.WriteByte('-')
+		case c == '+' && i == 0:
+		case c == '.' || c == ',' || c == '\'' || c == '’' || unicode.IsSpace(c):
+			// grouping separator
+			if !inInt || digits == 0 || digits > 3 || grouped && digits != 3 {
+				return "", fmt.Errorf("xmlquery: misplaced grouping separator in %q", s)
+			}
+			grouped, digits = true, 0
+		default:
+			if inInt && c >= '0' && c <= '9' {
+				digits++
+			} else if inInt && !endInt() {
+				return "", fmt.Errorf("xmlquery: misplaced grouping separator in %q", s)
+			}
+			buf.WriteRune(c)
+		}
+	}
+	if inInt && !endInt() {
+		return "", fmt.Errorf("xmlquery: misplaced grouping separator in %q", s)
+	}
+	return buf.String(), nil
+}
+
+// ParseFloatLocale parses a number written following the conventions of
+// the given language. See NormalizeNumber.
+func ParseFloatLocale(s string, tag language.Tag) (float64, error) {
+	norm, err := NormalizeNumber(s, tag)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(norm, 64)
+	if err != nil {
+		return 0, fmt.Errorf("xmlquery: invalid number %q for language %s", s, tag)
+	}
+	return f, nil
+}
+
+// FormatFloatLocale formats f with prec decimals following the conventions
+// of the given language, grouping thousands with a period where the
+// decimal separator is a comma and with a comma otherwise.
+func FormatFloatLocale(f float64, prec int, tag language.Tag) string {
+	dec, group := ".", ","
+	if DecimalSeparator(tag) == ',' {
+		dec, group = ",", "."
+	}
+	s := strconv.FormatFloat(f, 'f', prec, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	var buf strings.Builder
+	buf.WriteString(sign)
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			buf.WriteString(group)
+		}
+		buf.WriteRune(c)
+	}
+	if fracPart != "" {
+		buf.WriteString(dec)
+		buf.WriteString(fracPart)
+	}
+	return buf.String()
+}
+
+// FloatValueLocale parses the inner text of n as a number written following
+// the conventions of the given language.
+func (n *Node) FloatValueLocale(tag language.Tag) (float64, error) {
+	return ParseFloatLocale(n.InnerText(), tag)
+}
@@ -0,0 +1,77 @@
+package xmlquery
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestFloatValueLocale(t *testing.T) {
+	doc := loadXML(`<prices><de> 1.234,56 </de><fr>1 234,5</fr><en>1,234.56</en><ch>1'234.5</ch><neg>−3,5</neg></prices>`)
+	cases := []struct {
+		name     string
+		tag      language.Tag
+		expected float64
+	}{
+		{"de", language.German, 1234.56},
+		{"fr", language.French, 1234.5},
+		{"en", language.English, 1234.56},
+		{"ch", language.Make("de-CH"), 1234.5},
+		{"neg", language.German, -3.5},
+	}
+	for _, c := range cases {
+		got, err := FindOne(doc, "//"+c.name).FloatValueLocale(c.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.expected {
+			t.Fatalf("%s: expected %v, but got %v", c.name, c.expected, got)
+		}
+	}
+	if _, err := ParseFloatLocale("abc", language.German); err == nil {
+		t.Fatal("expected an error for an invalid number")
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	cases := []struct {
+		s        string
+		tag      language.Tag
+		expected string // empty for an error
+	}{
+		{"1,234,567.5", language.English, "1234567.5"},
+		{"+1.5e3", language.English, "1.5e3"},
+		{".5", language.English, ".5"},
+		{"12 345", language.French, "12345"},
+		{"1.234", language.German, "1234"},
+		{"1,5", language.English, ""},
+		{"12.5", language.German, ""},
+		{"1,23,456", language.English, ""},
+		{"1234,567", language.English, ""},
+		{",123", language.English, ""},
+		{"1,234.5,6", language.English, ""},
+		{"1.2.3", language.English, ""},
+	}
+	for _, c := range cases {
+		got, err := NormalizeNumber(c.s, c.tag)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.s, got)
+			}
+		} else if err != nil || got != c.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", c.s, c.expected, got, err)
+		}
+	}
+}
+
+func TestFormatFloatLocale(t *testing.T) {
+	if got := FormatFloatLocale(1234567.891, 2, language.German); got != "1.234.567,89" {
+		t.Fatalf("expected 1.234.567,89, but got %s", got)
+	}
+	if got := FormatFloatLocale(-1234.5, 1, language.English); got != "-1,234.5" {
+		t.Fatalf("expected -1,234.5, but got %s", got)
+	}
+	if got := FormatFloatLocale(12, 0, language.French); got != "12" {
+		t.Fatalf("expected 12, but got %s", got)
+	}
+}
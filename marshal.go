@@ -0,0 +1,197 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"strconv"
+)
+
+// MarshalXML implements xml.Marshaler, so that a Node can be a field of a
+// struct encoded by encoding/xml, such as the body of an envelope:
+//
+//	type Envelope struct {
+//		XMLName xml.Name      `xml:"Envelope"`
+//		Body    *xmlquery.Node `xml:"Body"`
+//	}
+//
+// An element is written under its own name, regardless of the name given
+// by the field, and a document as its children. Namespaces used by n but
+// declared by its ancestors are declared on n. XML declarations are left
+// out, since the encoder writes its own, and CDATA sections are written as
+// escaped text.
+func (n *Node) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if n.Type == DocumentNode {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if err := marshalNode(e, child, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return marshalNode(e, n, inheritedDecls(n))
+}
+
+// Writes n with the encoder, adding the namespace declarations in decls to
+// it if it is an element.
+func marshalNode(e *xml.Encoder, n *Node, decls []xml.Attr) error {
+	switch n.Type {
+	case ElementNode:
+		start := xml.StartElement{Name: xml.Name{Local: qualifiedName(n)}}
+		for _, attr := range n.Attr {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: xml_name2string(attr.Name)}, Value: attr.Value})
+		}
+		start.Attr = append(start.Attr, decls...)
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if err := marshalNode(e, child, nil); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case TextNode:
+		return e.EncodeToken(xml.CharData(n.Data))
+	case CommentNode:
+		return e.EncodeToken(xml.Comment(n.Data))
+	case ProcInstNode:
+		return e.EncodeToken(xml.ProcInst{Target: n.Data, Inst: []byte(n.Inst)})
+	case DoctypeNode:
+		return e.EncodeToken(xml.Directive(n.Data))
+	}
+	return nil
+}
+
+// Returns the declarations of the namespaces used in the subtree of n that
+// are declared by the ancestors of n rather than by n.
+func inheritedDecls(n *Node) []xml.Attr {
+	if n.Type != ElementNode || n.Parent == nil {
+		return nil
+	}
+	inherited := inScopeNamespaces(n.Parent)
+	own := make(map[string]bool) // prefixes declared by n
+	for _, attr := range n.Attr {
+		if attr.Name.Space == "xmlns" {
+			own[attr.Name.Local] = true
+		} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			own[""] = true
+		}
+	}
+	used := make(map[string]bool)
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type != ElementNode {
+			return
+		}
+		used[n.Prefix] = true
+		for _, attr := range n.Attr {
+			if attr.Name.Space != "" && attr.Name.Space != "xmlns" {
+				used[attr.Name.Space] = true
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	var decls []xml.Attr
+	// Declare in the order of the ancestors' declarations, to keep the
+	// output stable.
+	for p := n.Parent; p != nil; p = p.Parent {
+		for _, attr := range p.Attr {
+			prefix := ""
+			if attr.Name.Space == "xmlns" {
+				prefix = attr.Name.Local
+			} else if attr.Name.Space != "" || attr.Name.Local != "xmlns" {
+				continue
+			}
+			if own[prefix] || !used[prefix] || inherited[prefix] != attr.Value || attr.Value == "" {
+				continue
+			}
+			name := "xmlns"
+			if prefix != "" {
+				name += ":" + prefix
+			}
+			decls = append(decls, xml.Attr{Name: xml.Name{Local: name}, Value: attr.Value})
+			own[prefix] = true
+		}
+	}
+	return decls
+}
+
+// UnmarshalXML implements xml.Unmarshaler, so that a Node can be a field of
+// a struct decoded by encoding/xml: the element matched by the field, along
+// with its content, is decoded into n. Since the decoder does not report
+// the prefixes declared outside of the element, namespaces bound there get
+// prefixes of the form nsN, declared on the element that uses them.
+func (n *Node) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	space2prefix := newNamespaceTable()
+	elem, err := newElementNode(heapAllocator{}, space2prefix, bindUnknownSpaces(space2prefix, start), 0)
+	if err != nil {
+		return err
+	}
+	*n = *elem
+	for parent := n; ; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := newElementNode(heapAllocator{}, space2prefix, bindUnknownSpaces(space2prefix, tok), parent.level+1)
+			if err != nil {
+				return err
+			}
+			addChild(parent, child)
+			parent = child
+		case xml.EndElement:
+			if parent == n {
+				return nil
+			}
+			parent = parent.Parent
+		case xml.CharData:
+			addChild(parent, &Node{Type: TextNode, Data: string(tok), level: parent.level + 1})
+		case xml.Comment:
+			addChild(parent, &Node{Type: CommentNode, Data: string(tok), level: parent.level + 1})
+		case xml.ProcInst:
+			addChild(parent, &Node{Type: ProcInstNode, Data: tok.Target, Inst: string(tok.Inst), level: parent.level + 1})
+		}
+	}
+}
+
+// Returns tok with declarations added for the namespace URIs it uses that
+// are unknown to space2prefix, with generated prefixes.
+func bindUnknownSpaces(space2prefix map[string]string, tok xml.StartElement) xml.StartElement {
+	declared := make(map[string]bool)
+	for _, attr := range tok.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			declared[attr.Value] = true
+		}
+	}
+	bind := func(uri string) {
+		if uri == "" || uri == "xmlns" || declared[uri] {
+			return
+		}
+		if _, ok := space2prefix[uri]; ok {
+			return
+		}
+		prefix := ""
+		for i := 0; prefix == ""; i++ {
+			prefix = "ns" + strconv.Itoa(i)
+			for _, p := range space2prefix {
+				if p == prefix {
+					prefix = ""
+					break
+				}
+			}
+		}
+		tok.Attr = append(tok.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: uri})
+		declared[uri] = true
+	}
+	tok = tok.Copy()
+	bind(tok.Name.Space)
+	for _, attr := range tok.Attr {
+		bind(attr.Name.Space)
+	}
+	return tok
+}
@@ -0,0 +1,58 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+type testEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	ID      string   `xml:"id,attr"`
+	Body    *Node    `xml:"Body"`
+}
+
+func TestMarshalXML(t *testing.T) {
+	doc := loadXML(`<root xmlns:p="urn:p" xmlns:q="urn:q"><Body><p:item p:id="1">a &amp; b<!--c--><?pi x?></p:item><item/></Body></root>`)
+	env := testEnvelope{ID: "e1", Body: FindOne(doc, "//Body")}
+	data, err := xml.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<Envelope id="e1"><Body xmlns:p="urn:p"><p:item p:id="1">a &amp; b<!--c--><?pi x?></p:item><item></item></Body></Envelope>`
+	if string(data) != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, data)
+	}
+
+	var decoded testEnvelope
+	if err := xml.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != "e1" || decoded.Body == nil {
+		t.Fatalf("unexpected envelope %+v", decoded)
+	}
+	// The declaration of p is moved to Body.
+	if !Equal(decoded.Body, env.Body, CompareOptions{IgnorePrefixes: true}) {
+		t.Errorf("\nexpected: %s\ngot:      %s", env.Body.OutputXML(true), decoded.Body.OutputXML(true))
+	}
+	checkTreeInvariants(t, decoded.Body)
+	if n := FindOne(decoded.Body, "//p:item/@p:id"); n == nil || n.InnerText() != "1" {
+		t.Errorf("expected the prefixed attribute, got %v", n)
+	}
+}
+
+func TestUnmarshalXMLOuterNamespaces(t *testing.T) {
+	s := `<Envelope xmlns:s="urn:s" xmlns="urn:d"><s:Body><item/></s:Body></Envelope>`
+	var env struct {
+		Body *Node `xml:"urn:s Body"`
+	}
+	if err := xml.Unmarshal([]byte(s), &env); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<ns0:Body xmlns:ns0="urn:s"><ns1:item xmlns:ns1="urn:d"/></ns0:Body>`
+	if got := env.Body.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if env.Body.NamespaceURI != "urn:s" || env.Body.FirstChild.NamespaceURI != "urn:d" {
+		t.Errorf("unexpected namespace URIs %q and %q", env.Body.NamespaceURI, env.Body.FirstChild.NamespaceURI)
+	}
+}
@@ -0,0 +1,279 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"sort"
+	"strconv"
+)
+
+// GetAttrNS returns the value of the attribute of n with the given namespace
+// URI and local name, whatever prefix the document used for it. An empty
+// namespaceURI selects unprefixed attributes.
+func (n *Node) GetAttrNS(namespaceURI, local string) (string, bool) {
+	if i := n.attrIndexNS(namespaceURI, local); i >= 0 {
+		return n.Attr[i].Value, true
+	}
+	return "", false
+}
+
+// SetAttrNS sets the attribute of n with the given namespace URI and local
+// name. If the attribute does not exist yet, it is added with a prefix bound
+// to namespaceURI in scope at n; if there is none, a new prefix is declared
+// on n. Like SetAttr, it returns true if the attribute existed and false if
+// it was added.
+func (n *Node) SetAttrNS(namespaceURI, local, val string) bool {
+	if i := n.attrIndexNS(namespaceURI, local); i >= 0 {
+		n.Attr[i].Value = val
+		dropIndex(n)
+		return true
+	}
+	if namespaceURI == "" {
+		addAttr(n, local, val)
+		return false
+	}
+	prefix := lookupPrefix(n, namespaceURI)
+	if prefix == "" {
+		for i := 0; ; i++ {
+			prefix = "ns" + strconv.Itoa(i)
+			if lookupNamespaceURI(n, prefix) == "" {
+				break
+			}
+		}
+		addAttr(n, "xmlns:"+prefix, namespaceURI)
+	}
+	addAttr(n, prefix+":"+local, val)
+	return false
+}
+
+// Returns the index of the attribute of n with the given namespace URI and
+// local name, or -1.
+func (n *Node) attrIndexNS(namespaceURI, local string) int {
+	for i, attr := range n.Attr {
+		if attr.Name.Local == local && n.AttrNamespaceURI(i) == namespaceURI {
+			return i
+		}
+	}
+	return -1
+}
+
+// LookupNamespaceURI returns the namespace URI that prefix is bound to in
+// scope at n, by the xmlns declarations of n and its ancestors, or an empty
+// string if it is not bound. An empty prefix stands for the default
+// namespace.
+func (n *Node) LookupNamespaceURI(prefix string) string {
+	return lookupNamespaceURI(n, prefix)
+}
+
+// LookupPrefix returns a prefix bound to namespaceURI in scope at n. A
+// non-empty prefix is preferred; an empty one is returned if namespaceURI
+// is only the default namespace. It returns false if the namespace has no
+// prefix in scope.
+func (n *Node) LookupPrefix(namespaceURI string) (string, bool) {
+	if prefix := lookupPrefix(n, namespaceURI); prefix != "" {
+		return prefix, true
+	}
+	if namespaceURI != "" && lookupNamespaceURI(n, "") == namespaceURI {
+		return "", true
+	}
+	return "", false
+}
+
+// DeclareNamespace binds prefix to namespaceURI on the element n, adding or
+// changing its xmlns attribute, and updates the NamespaceURI of n and of
+// the elements and attributes of its subtree that use prefix, up to those
+// redeclaring it. An empty prefix declares the default namespace, which an
+// empty namespaceURI undeclares. An empty namespaceURI with a non-empty
+// prefix removes the declaration of prefix from n, so that the prefix
+// refers again to the namespace it is bound to by the ancestors of n.
+func (n *Node) DeclareNamespace(prefix, namespaceURI string) {
+	key := "xmlns"
+	if prefix != "" {
+		key += ":" + prefix
+	}
+	if prefix != "" && namespaceURI == "" {
+		n.DelAttr(key)
+	} else {
+		n.SetAttr(key, namespaceURI)
+	}
+	uri := lookupNamespaceURI(n, prefix)
+	var update func(e *Node)
+	update = func(e *Node) {
+		if e.Prefix == prefix {
+			e.NamespaceURI = uri
+		}
+		if prefix != "" && len(e.attrURIs) == len(e.Attr) {
+			for i, attr := range e.Attr {
+				if attr.Name.Space == prefix {
+					e.attrURIs[i] = uri
+				}
+			}
+		}
+		for child := e.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode && !declaresPrefix(child, prefix) {
+				update(child)
+			}
+		}
+	}
+	update(n)
+}
+
+// Returns true if the element n has an xmlns attribute for prefix.
+func declaresPrefix(n *Node, prefix string) bool {
+	for _, attr := range n.Attr {
+		if (prefix == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns") ||
+			(prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a non-empty prefix bound to namespaceURI in scope at n, or an
+// empty string if there is none. Prefixes redeclared by a descendant of the
+// declaring element are skipped.
+func lookupPrefix(n *Node, namespaceURI string) string {
+	switch namespaceURI {
+	case xmlNamespaceURI:
+		return "xml"
+	case xmlnsNamespaceURI:
+		return "xmlns"
+	}
+	for p := n; p != nil; p = p.Parent {
+		if p.Type != ElementNode {
+			continue
+		}
+		for _, attr := range p.Attr {
+			if attr.Name.Space == "xmlns" && attr.Value == namespaceURI &&
+				lookupNamespaceURI(n, attr.Name.Local) == namespaceURI {
+				return attr.Name.Local
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the xmlns declarations to add to n for its subtree to be written
+// on its own: those of the prefixes used in the subtree but declared outside
+// of it, by the ancestors of n or, if it was detached, by its former
+// ancestors, as remembered by the namespace URIs of its nodes.
+func outOfScopeDecls(n *Node) []xml.Attr {
+	if n.Type != ElementNode {
+		return nil
+	}
+	var decls []xml.Attr
+	added := make(map[string]bool)
+	need := func(declared map[string]bool, prefix, uri string) {
+		if prefix == "xml" || prefix == "xmlns" || declared[prefix] || added[prefix] || uri == "" {
+			return
+		}
+		added[prefix] = true
+		if prefix == "" {
+			decls = append(decls, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: uri})
+		} else {
+			decls = append(decls, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: uri})
+		}
+	}
+	var walk func(e *Node, declared map[string]bool)
+	walk = func(e *Node, declared map[string]bool) {
+		var own []string
+		for _, attr := range e.Attr {
+			if attr.Name.Space == "xmlns" {
+				own = append(own, attr.Name.Local)
+			} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+				own = append(own, "")
+			}
+		}
+		if len(own) > 0 {
+			scope := make(map[string]bool, len(declared)+len(own))
+			for prefix := range declared {
+				scope[prefix] = true
+			}
+			for _, prefix := range own {
+				scope[prefix] = true
+			}
+			declared = scope
+		}
+		need(declared, e.Prefix, elementNamespaceURI(e))
+		for i, attr := range e.Attr {
+			if attr.Name.Space != "" && attr.Name.Space != "xmlns" {
+				need(declared, attr.Name.Space, e.AttrNamespaceURI(i))
+			}
+		}
+		for child := e.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode {
+				walk(child, declared)
+			}
+		}
+	}
+	walk(n, nil)
+	return decls
+}
+
+// Returns the namespace URI of the element n, resolving its prefix if the
+// node was not built by the parser.
+func elementNamespaceURI(n *Node) string {
+	if n.NamespaceURI != "" {
+		return n.NamespaceURI
+	}
+	return lookupNamespaceURI(n, n.Prefix)
+}
+
+// CreateXPathNavigatorNS creates a new xpath.NodeNavigator for top in which
+// prefixes in name tests refer to the namespace URIs given by namespaces,
+// which maps prefixes to URIs, rather than to the prefixes used by the
+// document. Nodes whose namespace is not in namespaces only match unprefixed
+// name tests. If several prefixes are mapped to the same URI, only the first
+// one in lexical order can be used.
+func CreateXPathNavigatorNS(top *Node, namespaces map[string]string) *NodeNavigator {
+	nav := CreateXPathNavigator(top)
+	nav.prefixes = queryPrefixes(namespaces)
+	return nav
+}
+
+// Inverts namespaces, which maps prefixes to URIs, keeping the first prefix
+// in lexical order for each URI.
+func queryPrefixes(namespaces map[string]string) map[string]string {
+	byURI := make(map[string]string, len(namespaces))
+	prefixes := make([]string, 0, len(namespaces))
+	for prefix := range namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(prefixes)))
+	for _, prefix := range prefixes {
+		byURI[namespaces[prefix]] = prefix
+	}
+	return byURI
+}
+
+// QueryAllNS is like QueryAll, but prefixes in expr are resolved through
+// namespaces, which maps them to namespace URIs, so that the expression
+// matches documents regardless of the prefixes their authors chose:
+//
+//	QueryAllNS(doc, "//a:item/@a:id", map[string]string{"a": "urn:example"})
+func QueryAllNS(top *Node, expr string, namespaces map[string]string) (elems []*Node, err error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(expr, &err)
+	t := exp.Select(CreateXPathNavigatorNS(top, namespaces))
+	for t.MoveNext() {
+		elems = append(elems, getCurrentNode(t))
+	}
+	return elems, nil
+}
+
+// QueryNS is like Query, with prefixes in expr resolved through namespaces as
+// in QueryAllNS.
+func QueryNS(top *Node, expr string, namespaces map[string]string) (elem *Node, err error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(expr, &err)
+	t := exp.Select(CreateXPathNavigatorNS(top, namespaces))
+	if t.MoveNext() {
+		return getCurrentNode(t), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,150 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetAttrNS(t *testing.T) {
+	doc := loadXML(`<root xmlns:a="urn:x" xmlns:b="urn:x"><e a:id="1"/><e b:id="2"/><e id="3"/></root>`)
+	want := []struct {
+		uri, val string
+	}{{"urn:x", "1"}, {"urn:x", "2"}, {"", "3"}}
+	for i, e := range Find(doc, "//e") {
+		val, ok := e.GetAttrNS(want[i].uri, "id")
+		if !ok || val != want[i].val {
+			t.Errorf("element %d: expected %q, got %q, %v", i, want[i].val, val, ok)
+		}
+		if _, ok := e.GetAttrNS("urn:y", "id"); ok {
+			t.Errorf("element %d: unexpected attribute in urn:y", i)
+		}
+	}
+}
+
+func TestSetAttrNS(t *testing.T) {
+	doc := loadXML(`<root xmlns:a="urn:x"><e a:id="1"/><f/></root>`)
+	e := FindOne(doc, "//e")
+	if !e.SetAttrNS("urn:x", "id", "2") {
+		t.Error("expected the existing attribute to be altered")
+	}
+	f := FindOne(doc, "//f")
+	if f.SetAttrNS("urn:x", "id", "3") {
+		t.Error("expected the attribute to be added")
+	}
+	if f.SetAttrNS("urn:y", "id", "4") {
+		t.Error("expected the attribute to be added")
+	}
+	expected := `<root xmlns:a="urn:x"><e a:id="2"/><f a:id="3" xmlns:ns0="urn:y" ns0:id="4"/></root>`
+	if got := FindOne(doc, "/root").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if val, _ := f.GetAttrNS("urn:y", "id"); val != "4" {
+		t.Errorf("expected 4, got %q", val)
+	}
+}
+
+func TestQueryAllNS(t *testing.T) {
+	doc := loadXML(`<root xmlns="urn:x" xmlns:p="urn:x" xmlns:q="urn:y">` +
+		`<item id="1"/><p:item id="2"/><q:item id="3"/></root>`)
+	ns := map[string]string{"x": "urn:x"}
+	list, err := QueryAllNS(doc, "//x:item", ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].SelectAttr("id") != "1" || list[1].SelectAttr("id") != "2" {
+		t.Errorf("unexpected matches: %v", list)
+	}
+	n, err := QueryNS(doc, "/x:root/x:item[2]", ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == nil || n.SelectAttr("id") != "2" {
+		t.Errorf("unexpected match: %v", n)
+	}
+	if _, err := QueryAllNS(doc, "//x:item[", ns); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+}
+
+func TestQueryAllNSAttr(t *testing.T) {
+	doc := loadXML(`<root xmlns:p="urn:x" xmlns:q="urn:x"><e p:id="1"/><e q:id="2"/><e id="3"/></root>`)
+	list, err := QueryAllNS(doc, "//e/@x:id", map[string]string{"x": "urn:x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].InnerText() != "1" || list[1].InnerText() != "2" {
+		t.Errorf("unexpected matches: %v", list)
+	}
+}
+
+func TestOutputDetachedNamespaces(t *testing.T) {
+	doc := loadXML(`<r xmlns="urn:d" xmlns:x="urn:x" xmlns:y="urn:y"><x:a y:k="v"><b/><c xmlns:x="urn:other"><x:d/></c></x:a></r>`)
+	a := FindOne(doc, "//x:a")
+	expected := `<x:a xmlns:x="urn:x" xmlns:y="urn:y" xmlns="urn:d" y:k="v"><b/><c xmlns:x="urn:other"><x:d/></c></x:a>`
+	if got := a.OutputXML(true); got != expected {
+		t.Errorf("attached:\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got := FindOne(doc, "//x:d").OutputXML(true); got != `<x:d xmlns:x="urn:other"/>` {
+		t.Errorf("unexpected output of a descendant: %s", got)
+	}
+	if got := doc.SelectElement("r").OutputXML(true); !strings.HasPrefix(got, `<r xmlns="urn:d" xmlns:x="urn:x" xmlns:y="urn:y"><x:a y:k="v">`) {
+		t.Errorf("unexpected output of the root element: %s", got)
+	}
+
+	removeFromTree(a)
+	if got := a.OutputXML(true); got != expected {
+		t.Errorf("detached:\nexpected: %s\ngot:      %s", expected, got)
+	}
+	reparsed, err := Parse(strings.NewReader(a.OutputXML(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(FindOne(reparsed, "/*"), a, CompareOptions{IgnorePrefixes: true}) {
+		t.Error("the detached subtree does not parse back to the same tree")
+	}
+
+	if expected, got := `<b xmlns="urn:d"/><c xmlns="urn:d" xmlns:x="urn:other"><x:d/></c>`, a.OutputXML(false); got != expected {
+		t.Errorf("children:\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestDeclareNamespace(t *testing.T) {
+	doc := loadXML(`<r xmlns:x="urn:x"><x:a x:k="1"><x:b/><c xmlns:x="urn:other"><x:d/></c></x:a><e/></r>`)
+	r, a := FindOne(doc, "/r"), FindOne(doc, "//x:a")
+	if got := a.LookupNamespaceURI("x"); got != "urn:x" {
+		t.Errorf("expected urn:x, got %q", got)
+	}
+	if prefix, ok := a.LookupPrefix("urn:x"); !ok || prefix != "x" {
+		t.Errorf("expected prefix x, got %q, %v", prefix, ok)
+	}
+	if _, ok := a.LookupPrefix("urn:none"); ok {
+		t.Error("expected no prefix for an undeclared namespace")
+	}
+
+	a.DeclareNamespace("x", "urn:new")
+	for expr, expected := range map[string]string{"//x:a": "urn:new", "//x:b": "urn:new", "//x:d": "urn:other"} {
+		if n := FindOne(doc, expr); n == nil || n.NamespaceURI != expected {
+			t.Errorf("%s: expected namespace %s, got %v", expr, expected, n)
+		}
+	}
+	if got := a.AttrNamespaceURI(0); got != "urn:new" {
+		t.Errorf("expected x:k in urn:new, got %q", got)
+	}
+	if expected, got := `<x:a x:k="1" xmlns:x="urn:new"><x:b/><c xmlns:x="urn:other"><x:d/></c></x:a>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	a.DeclareNamespace("x", "")
+	if n := FindOne(doc, "//x:b"); n.NamespaceURI != "urn:x" || a.NamespaceURI != "urn:x" {
+		t.Errorf("removing the declaration did not restore urn:x, got %q", n.NamespaceURI)
+	}
+
+	r.DeclareNamespace("", "urn:d")
+	if e := FindOne(doc, "//e"); e.NamespaceURI != "urn:d" {
+		t.Errorf("expected e in urn:d, got %q", e.NamespaceURI)
+	}
+	if prefix, ok := r.LookupPrefix("urn:d"); !ok || prefix != "" {
+		t.Errorf("expected the default namespace, got %q, %v", prefix, ok)
+	}
+	checkTreeInvariants(t, doc)
+}
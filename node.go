@@ -1,17 +1,20 @@
 package xmlquery
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 
-	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
 )
 
 // A NodeType is the type of a Node.
@@ -21,8 +24,8 @@ const (
 	// DocumentNode is a document object that, as the root of the document tree,
 	// provides access to the entire XML document.
 	DocumentNode NodeType = iota
-	// DeclarationNode is the document type declaration, indicated by the following
-	// tag (for example, <!DOCTYPE...> ).
+	// DeclarationNode is the XML declaration (for example,
+	// <?xml version="1.0"?> ).
 	DeclarationNode
 	// ElementNode is an element (for example, <item> ).
 	ElementNode
@@ -30,12 +33,26 @@ const (
 	TextNode
 	// CommentNode a comment (for example, <!-- my comment --> ).
 	CommentNode
-	// AttributeNode is an attribute of element.
+	// AttributeNode is an attribute of element. Attribute nodes are not
+	// linked into the tree: they are built on demand by queries such as
+	// "//item/@id" and by At, with Parent set to the owning element and a
+	// single text child holding the value.
 	AttributeNode
+	// ProcInstNode is a processing instruction other than the XML
+	// declaration (for example, <?xml-stylesheet href="a.xsl"?> ).
+	ProcInstNode
+	// DoctypeNode is a document type declaration, with Data holding its
+	// text between "<!" and ">" (for example, DOCTYPE note SYSTEM "note.dtd").
+	DoctypeNode
 )
 
 // A Node consists of a NodeType and some Data (tag name for
 // element nodes, content for text) and are part of a tree of Nodes.
+//
+// A tree may be read and queried by several goroutines at once as long as
+// none of them modifies it; any change needs exclusive access. Trees that
+// are read concurrently while being updated can be shared through a
+// SharedDocument.
 type Node struct {
 	Parent, FirstChild, LastChild, PrevSibling, NextSibling *Node
 
@@ -48,9 +65,101 @@ type Node struct {
 	// Application specific field that is never encoded to XML
 	Info interface{}
 
+	// The instruction of a ProcInstNode, as written after its target.
+	Inst string
+
+	// Set on text nodes read from, and to be written as, a CDATA section.
+	CDATA bool
+
+	// The position of the node in the document it was parsed from, counting
+	// lines and bytes within the line from 1, or zero if unknown.
+	Line, Column int
+
 	level int // node level in the tree
+
+	attrURIs []string // namespace URIs of Attr, by index, as resolved when parsed
+
+	doc *docInfo // data of the whole tree, only set on roots and documents
+}
+
+// docInfo holds the data kept for a tree as a whole rather than in each of
+// its nodes, so that nodes do not pay for what few of them use.
+type docInfo struct {
+	encoding  string                 // see DetectedEncoding
+	index     *docIndex              // lookup tables, see BuildIndex
+	sensitive map[*Node]*sensitivity // elements marked by MarkSensitive
+}
+
+// docMu guards the doc field of nodes, which lookups such as GetElementByID
+// may set while other readers use it, and the indexes of all trees.
+var docMu sync.Mutex
+
+// Returns the data of the tree rooted at root, or nil if it has none.
+func rootInfo(root *Node) *docInfo {
+	docMu.Lock()
+	defer docMu.Unlock()
+	return root.doc
+}
+
+// Returns the data of the tree rooted at root, adding it if needed.
+func addRootInfo(root *Node) *docInfo {
+	docMu.Lock()
+	defer docMu.Unlock()
+	if root.doc == nil {
+		root.doc = &docInfo{}
+	}
+	return root.doc
+}
+
+// DetectedEncoding returns the encoding the document n was read in (e.g.
+// "utf-8" or "utf-16le"), as detected by Parse from its byte order mark,
+// the byte pattern of its first character or its XML declaration, or as
+// given by the Content-Type header of LoadURLWithContext. It is empty for
+// nodes other than documents.
+func (n *Node) DetectedEncoding() string {
+	if n.Type != DocumentNode {
+		return ""
+	}
+	if info := rootInfo(n); info != nil {
+		return info.encoding
+	}
+	return ""
+}
+
+// Sets the encoding returned by DetectedEncoding.
+func (n *Node) setEncoding(enc string) {
+	addRootInfo(n).encoding = enc
 }
 
+// Called once n has been added below another node, n having possibly been
+// the root of a tree until then: the index of n is dropped, and its marks
+// are handed to the root of its new tree.
+func attachDoc(n *Node) {
+	if n.doc == nil || n.Parent == nil {
+		return
+	}
+	docMu.Lock()
+	marks := n.doc.sensitive
+	n.doc.index, n.doc.sensitive = nil, nil
+	docMu.Unlock()
+	if len(marks) == 0 {
+		return
+	}
+	info := addRootInfo(treeRoot(n))
+	if info.sensitive == nil {
+		info.sensitive = marks
+		return
+	}
+	for elem, s := range marks {
+		info.sensitive[elem] = s
+	}
+}
+
+const (
+	xmlNamespaceURI   = "http://www.w3.org/XML/1998/namespace"
+	xmlnsNamespaceURI = "http://www.w3.org/2000/xmlns/"
+)
+
 func xml_name2string(name xml.Name) string {
 	if name.Space == "" {
 		return name.Local
@@ -97,19 +206,28 @@ func (n *Node) String() string {
 	switch n.Type {
 	case ElementNode:
 		ans := "Node{<" + n.Data
+		marks := sensitiveMarks(n)[n]
 		for _, attr := range n.Attr {
+			name := xml_name2string(attr.Name)
 			ans += " "
-			ans += xml_name2string(attr.Name)
-			ans += fmt.Sprintf("=%q", attr.Value)
+			ans += name
+			ans += fmt.Sprintf("=%q", marks.maskAttr(name, attr.Value))
 		}
 		ans += ">}"
 		return ans
 	case TextNode:
+		if n.isMasked() {
+			return fmt.Sprintf("Node{%q}", maskedValue)
+		}
 		return fmt.Sprintf("Node{%q}", n.Data)
 	case CommentNode:
 		return fmt.Sprintf("Node{<!--%s-->}", n.Data)
 	case DeclarationNode:
 		return fmt.Sprintf("Node{<?%s?>}", n.Data)
+	case ProcInstNode:
+		return fmt.Sprintf("Node{<?%s %s?>}", n.Data, n.Inst)
+	case DoctypeNode:
+		return fmt.Sprintf("Node{<!%s>}", n.Data)
 	}
 	return fmt.Sprintf("Node{%q}", n.Data)
 }
@@ -135,6 +253,60 @@ func (n *Node) InnerText() string {
 	return buf.String()
 }
 
+// Returns true if n is an element with the given qualified name. The prefix
+// must match exactly, so a name without one only matches unprefixed elements.
+func (n *Node) hasName(name string) bool {
+	return n.Type == ElementNode && qualifiedName(n) == name
+}
+
+// At follows path from n, one child element name per step, and returns the
+// node found at its end. The last step may be "@name" to select an attribute.
+// It returns nil as soon as a step is missing, and is safe to call on a nil
+// Node, so optional elements can be navigated without checking every step:
+//
+//	title := doc.At("rss", "channel", "image", "title").Value()
+//
+// At, Ok and Value are the only methods that accept a nil Node; use Ok to
+// tell a missing node apart from an empty one before calling any other.
+func (n *Node) At(path ...string) *Node {
+	for _, name := range path {
+		if n == nil {
+			return nil
+		}
+		if strings.HasPrefix(name, "@") {
+			i := n.attrIndexByName(name[1:])
+			if i < 0 {
+				return nil
+			}
+			n = newAttributeNode(n, i)
+			continue
+		}
+		var found *Node
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.hasName(name) {
+				found = child
+				break
+			}
+		}
+		n = found
+	}
+	return n
+}
+
+// Ok reports whether n exists, that is whether it is not nil. It is meant to
+// be chained after At.
+func (n *Node) Ok() bool {
+	return n != nil
+}
+
+// Value returns the inner text of n, or an empty string if n is nil.
+func (n *Node) Value() string {
+	if n == nil {
+		return ""
+	}
+	return n.InnerText()
+}
+
 // Returns true if and only if the node is text consisting only of whitespaces
 func (n *Node) IsEmpty() bool {
 	if n.Type != TextNode {
@@ -192,86 +364,466 @@ func (n *Node) canHaveWhitespaceAfter() bool {
 	return unicode.IsSpace(char)
 }
 
-func print_indent(buf io.Writer, pretty bool, depth int, last_text_node **Node) {
-	if pretty && (*last_text_node == nil || (*last_text_node).canHaveWhitespaceAfter()) {
-		buf.Write([]byte("\n"))
-		for i := 0; i < depth; i++ {
-			buf.Write([]byte("\t"))
+// OutputOptions controls how OutputXMLWithOptions writes a tree.
+type OutputOptions struct {
+	// Pretty indents elements and collapses whitespace in text, except
+	// inside elements where xml:space="preserve" is in effect.
+	Pretty bool
+	// Indent is written once per level of depth by pretty output. It
+	// defaults to a tab.
+	Indent string
+	// Newline ends the lines of pretty output. It defaults to "\n".
+	Newline string
+	// PreserveWhitespace keeps pretty output from changing text: text nodes
+	// are written unchanged and no indentation is added inside elements
+	// that have text children, at any depth.
+	PreserveWhitespace bool
+	// MaxLineWidth, if positive, makes pretty output put each attribute of
+	// a start tag on its own line when the tag would otherwise make its
+	// line longer than this many characters.
+	MaxLineWidth int
+	// OmitDeclaration leaves out XML declarations.
+	OmitDeclaration bool
+	// Minify leaves out the text made only of whitespace found between the
+	// children of elements with element-only content, such as the
+	// indentation of a pretty document, except where xml:space="preserve"
+	// is in effect. Whitespace in mixed content and CDATA sections is kept.
+	Minify bool
+	// OmitComments leaves out comments.
+	OmitComments bool
+	// SingleQuote encloses attribute values in single quotes rather than
+	// double quotes.
+	SingleQuote bool
+	// MaskSensitive replaces the values marked with MarkSensitive.
+	MaskSensitive bool
+	// Encoding, if set, is the name of the encoding of the output, such as
+	// "ISO-8859-1" or "UTF-16", written to the encoding pseudo-attribute of
+	// the XML declaration. The output is transcoded from UTF-8, characters
+	// the encoding cannot represent being written as character references,
+	// and a document without declaration is given one. Such characters in
+	// comments, processing instructions, CDATA sections and DOCTYPEs, where
+	// references are not decoded, are an error. Names are those of the
+	// WHATWG Encoding Standard, except that ISO-8859-1 and US-ASCII are not
+	// taken for windows-1252.
+	Encoding string
+	// ElementHook, if set, is called for every element before it is
+	// written, with the writer the output goes to. If it returns true, the
+	// hook has written the element, or chosen to leave it out, and it is
+	// skipped along with its descendants; otherwise the element is written
+	// as usual. Errors returned by the hook stop the output.
+	ElementHook func(n *Node, w io.Writer) (handled bool, err error)
+}
+
+// xmlPrinter holds the state of the output of a tree.
+type xmlPrinter struct {
+	w        *errWriter
+	opts     OutputOptions
+	empty    bool                   // nothing was written yet
+	lastText *Node                  // the last text node written
+	marks    map[*Node]*sensitivity // the sensitive elements, with MaskSensitive
+	verbatim int                    // number of open elements whose content must not be indented
+	preserve bool                   // xml:space="preserve" is in effect
+
+	// The namespace declarations to add to the element declsOn, the root
+	// of the output of a detached subtree, for prefixes declared by its
+	// former ancestors.
+	declsOn *Node
+	decls   []xml.Attr
+
+	// encoder checks content written as is against the encoding of the
+	// output, if not UTF-8.
+	encoder *encoding.Encoder
+}
+
+// Reports whether the encoding of the output can represent the content of
+// n, if it is written without escaping, and sets the error of the output
+// otherwise.
+func (p *xmlPrinter) checkEncodable(n *Node) bool {
+	var raw string
+	switch {
+	case n.Type == CommentNode, n.Type == DoctypeNode:
+		raw = n.Data
+	case n.Type == ProcInstNode:
+		raw = n.Data + n.Inst
+	case n.Type == TextNode && n.CDATA:
+		raw = n.Data
+	default:
+		return true
+	}
+	if _, err := p.encoder.String(raw); err != nil {
+		if p.w.err == nil {
+			p.w.err = fmt.Errorf("xmlquery: %s has characters that %s cannot represent", describeNode(n), p.opts.Encoding)
 		}
+		return false
+	}
+	return true
+}
+
+// errWriter remembers the first error of its writer and ignores any later
+// write.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.err = err
+	return n, err
+}
+
+func (w *errWriter) WriteString(s string) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := io.WriteString(w.w, s)
+	w.err = err
+	return n, err
+}
+
+// Reports whether whitespace may be added or collapsed at this point.
+func (p *xmlPrinter) pretty() bool {
+	return p.opts.Pretty && p.verbatim == 0 && !p.preserve
+}
+
+func (p *xmlPrinter) indent(depth int) {
+	if p.pretty() && (p.lastText == nil || p.lastText.canHaveWhitespaceAfter()) {
+		p.newline(depth)
+	}
+}
+
+// Starts a new line of pretty output indented to depth.
+func (p *xmlPrinter) newline(depth int) {
+	newline, indent := p.opts.Newline, p.opts.Indent
+	if newline == "" {
+		newline = "\n"
+	}
+	if indent == "" {
+		indent = "\t"
+	}
+	p.w.WriteString(newline)
+	for i := 0; i < depth; i++ {
+		p.w.WriteString(indent)
+	}
+}
+
+// Writes the qualified name of n.
+func (p *xmlPrinter) writeName(n *Node) {
+	if n.Prefix != "" {
+		p.w.WriteString(n.Prefix)
+		p.w.WriteString(":")
+	}
+	p.w.WriteString(n.Data)
+}
+
+// Returns true if the attributes of n should be written one per line, n
+// being written at depth.
+func (p *xmlPrinter) wrapAttrs(n *Node, depth int) bool {
+	if !p.opts.Pretty || p.verbatim > 0 || p.opts.MaxLineWidth <= 0 || len(n.Attr) < 2 {
+		return false
+	}
+	indent := p.opts.Indent
+	if indent == "" {
+		indent = "\t"
+	}
+	width := depth*utf8.RuneCountInString(indent) + utf8.RuneCountInString(n.Prefix) + len(n.Data) + 3
+	for _, attr := range n.Attr {
+		width += utf8.RuneCountInString(xml_name2string(attr.Name)) + utf8.RuneCountInString(attr.Value) + 4
+	}
+	return width > p.opts.MaxLineWidth
+}
+
+// Returns true if n has a text child.
+func hasTextChild(n *Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == TextNode {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes the text of n, as a CDATA section if n was one. Since a CDATA
+// section cannot contain "]]>", such text is split into several sections,
+// between "]]" and ">".
+func writeText(buf io.Writer, n *Node, text string) {
+	if n.CDATA {
+		io.WriteString(buf, "<![CDATA[")
+		io.WriteString(buf, strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>"))
+		io.WriteString(buf, "]]>")
+		return
+	}
+	escapeText(buf, text)
+}
+
+// Writes text escaped exactly as xml.EscapeText does, without copying it
+// to a byte slice first.
+func escapeText(w io.Writer, text string) {
+	last := 0
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRuneInString(text[i:])
+		i += width
+		var esc string
+		switch r {
+		case '"':
+			esc = "&#34;"
+		case '\'':
+			esc = "&#39;"
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '\t':
+			esc = "&#x9;"
+		case '\n':
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			if isXMLChar(r) && !(r == utf8.RuneError && width == 1) {
+				continue
+			}
+			esc = "\uFFFD"
+		}
+		io.WriteString(w, text[last:i-width])
+		io.WriteString(w, esc)
+		last = i
+	}
+	io.WriteString(w, text[last:])
+}
+
+// Reports whether r is allowed in XML documents.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// EscapeAttrValue returns value escaped for use between the double quotes
+// of an attribute. Besides markup characters, tabs and line breaks are
+// escaped so that they survive the normalization of attribute values done
+// by XML parsers.
+func EscapeAttrValue(value string) string {
+	return escapeAttrValue(value, '"')
+}
+
+func escapeAttrValue(value string, quote byte) string {
+	var buf strings.Builder
+	last := 0
+	for i := 0; i < len(value); i++ {
+		var esc string
+		switch c := value[i]; c {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '\t':
+			esc = "&#x9;"
+		case '\n':
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		case '"', '\'':
+			if c != quote {
+				continue
+			}
+			if c == '"' {
+				esc = "&quot;"
+			} else {
+				esc = "&apos;"
+			}
+		default:
+			continue
+		}
+		buf.WriteString(value[last:i])
+		buf.WriteString(esc)
+		last = i + 1
+	}
+	if last == 0 {
+		return value
 	}
+	buf.WriteString(value[last:])
+	return buf.String()
 }
 
-func outputXML(buf io.Writer, buf_empty *bool, n *Node, last_text_node **Node, depth int, pretty bool) {
-	if n.Type == TextNode && pretty {
+func (p *xmlPrinter) output(n *Node, depth int) {
+	if p.w.err != nil {
+		return
+	}
+	buf := p.w
+	if n.Type == DeclarationNode && p.opts.OmitDeclaration {
+		return
+	}
+	if n.Type == CommentNode && p.opts.OmitComments {
+		return
+	}
+	if p.encoder != nil && !p.checkEncodable(n) {
+		return
+	}
+	if n.Type == TextNode && p.opts.Minify && !p.preserve && isInterElementSpace(n) {
+		return
+	}
+	if n.Type == TextNode && p.pretty() {
 		if !n.IsEmpty() {
 			if n.canhaveWhitespaceBefore() {
-				buf.Write([]byte("\n"))
-				for i := 0; i < depth; i++ {
-					buf.Write([]byte("\t"))
-				}
+				p.newline(depth)
 			}
-			xml.EscapeText(buf, []byte(n.TrimText()))
+			text := n.TrimText()
+			if n.CDATA {
+				// Whitespace matters in the scripts and markup usually found there.
+				text = n.Data
+			}
+			writeText(buf, n, text)
 		}
-		*last_text_node = n
+		p.lastText = n
 		return
 	}
 	if n.Type == TextNode {
-		xml.EscapeText(buf, []byte(n.Data))
+		writeText(buf, n, n.Data)
+		return
+	}
+	marks := p.marks[n]
+	if n.Type == ElementNode && marks != nil && marks.text && n.FirstChild != nil {
+		// Write the element as if it had a single text child, with the
+		// values of its attributes masked beforehand since the copy has no
+		// marks of its own.
+		masked := *n
+		text := &Node{Type: TextNode, Data: maskedValue, Parent: &masked}
+		masked.FirstChild, masked.LastChild = text, text
+		masked.Attr = marks.maskAttrs(n.Attr)
+		if p.declsOn == n {
+			p.declsOn = &masked
+		}
+		p.output(&masked, depth)
 		return
 	}
-	if !*buf_empty {
-		print_indent(buf, pretty, depth, last_text_node)
+	if !p.empty {
+		p.indent(depth)
+	}
+	p.empty = false
+	if n.Type == ElementNode && p.opts.ElementHook != nil {
+		handled, err := p.opts.ElementHook(n, buf)
+		if err != nil && buf.err == nil {
+			buf.err = err
+		}
+		if handled || err != nil {
+			return
+		}
 	}
-	*buf_empty = false
 	if n.Type == CommentNode {
-		buf.Write([]byte("<!--"))
-		buf.Write([]byte(n.Data))
-		buf.Write([]byte("-->"))
+		buf.WriteString("<!--")
+		buf.WriteString(n.Data)
+		buf.WriteString("-->")
+		return
+	}
+	if n.Type == DoctypeNode {
+		buf.WriteString("<!")
+		buf.WriteString(n.Data)
+		buf.WriteString(">")
+		return
+	}
+	if n.Type == ProcInstNode {
+		buf.WriteString("<?")
+		buf.WriteString(n.Data)
+		if n.Inst != "" {
+			buf.WriteString(" ")
+			buf.WriteString(n.Inst)
+		}
+		buf.WriteString("?>")
 		return
 	}
 	if n.Type == DeclarationNode {
-		buf.Write([]byte("<?" + n.Data))
+		buf.WriteString("<?")
+		buf.WriteString(n.Data)
 	} else {
-		if n.Prefix == "" {
-			buf.Write([]byte("<" + n.Data))
-		} else {
-			buf.Write([]byte("<" + n.Prefix + ":" + n.Data))
-		}
+		buf.WriteString("<")
+		p.writeName(n)
 	}
 
-	for _, attr := range n.Attr {
-		if attr.Name.Space != "" {
-			buf.Write([]byte(fmt.Sprintf(` %s:%s="%s"`, attr.Name.Space, attr.Name.Local, attr.Value)))
+	quote := `"`
+	if p.opts.SingleQuote {
+		quote = "'"
+	}
+	wrap := p.wrapAttrs(n, depth)
+	attrs := n.Attr
+	if n.Type == DeclarationNode && p.opts.Encoding != "" {
+		attrs = withEncodingAttr(attrs, p.opts.Encoding)
+	}
+	if n == p.declsOn && len(p.decls) > 0 {
+		attrs = append(p.decls[:len(p.decls):len(p.decls)], n.Attr...)
+	}
+	for _, attr := range attrs {
+		value := marks.maskAttr(xml_name2string(attr.Name), attr.Value)
+		if wrap {
+			p.newline(depth + 1)
 		} else {
-			buf.Write([]byte(fmt.Sprintf(` %s="%s"`, attr.Name.Local, attr.Value)))
+			buf.WriteString(" ")
+		}
+		if attr.Name.Space != "" {
+			buf.WriteString(attr.Name.Space)
+			buf.WriteString(":")
 		}
+		buf.WriteString(attr.Name.Local)
+		buf.WriteString("=")
+		buf.WriteString(quote)
+		buf.WriteString(escapeAttrValue(value, quote[0]))
+		buf.WriteString(quote)
 	}
 	if n.Type == DeclarationNode {
-		buf.Write([]byte("?>"))
+		buf.WriteString("?>")
 		return
 	} else if n.FirstChild == nil {
-		buf.Write([]byte("/>"))
+		buf.WriteString("/>")
 		return
 	} else {
-		buf.Write([]byte(">"))
+		buf.WriteString(">")
+	}
+	verbatim := p.opts.PreserveWhitespace && hasTextChild(n)
+	if verbatim {
+		p.verbatim++
+	}
+	preserve := p.preserve
+	if space, ok := n.xmlSpace(); ok {
+		p.preserve = space == "preserve"
 	}
 	depth++
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
-		outputXML(buf, buf_empty, child, last_text_node, depth, pretty)
+		p.output(child, depth)
 	}
 	depth--
-	print_indent(buf, pretty, depth, last_text_node)
+	p.indent(depth)
+	p.preserve = preserve
+	if verbatim {
+		p.verbatim--
+	}
 	if n.Type != DeclarationNode {
-		if n.Prefix == "" {
-			buf.Write([]byte(fmt.Sprintf("</%s>", n.Data)))
-		} else {
-			buf.Write([]byte(fmt.Sprintf("</%s:%s>", n.Prefix, n.Data)))
-		}
+		buf.WriteString("</")
+		p.writeName(n)
+		buf.WriteString(">")
 	}
 }
 
+// Reports whether n is a text node of whitespace between the children of
+// an element with element-only content, or of a document.
+func isInterElementSpace(n *Node) bool {
+	if n.CDATA || !n.IsEmpty() {
+		return false
+	}
+	return n.Parent == nil || n.Parent.Type == DocumentNode || hasElementContent(n.Parent)
+}
+
 // Dereference this node from others so GC can delete them. Also fixes pointers of other nodes.
 func (n *Node) DeleteMe() {
+	dropIndex(n)
+	detachMarks(n, true)
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
 		child.DeleteMe()
 		child.Parent = nil
@@ -291,7 +843,9 @@ func (n *Node) DeleteMe() {
 		n.NextSibling.PrevSibling = n.PrevSibling
 	}
 	n.Attr = nil
+	n.attrURIs = nil
 	n.Info = nil
+	n.doc = nil
 	n.FirstChild = nil
 	n.LastChild = nil
 	n.NextSibling = nil
@@ -299,35 +853,306 @@ func (n *Node) DeleteMe() {
 	n.Parent = nil
 }
 
+// Clone returns a copy of n, detached from any tree, along with copies of
+// all its descendants if deep is true. The copy has its own attribute slice,
+// so attributes can be changed without affecting n; Info is copied as is.
+func (n *Node) Clone(deep bool) *Node {
+	return n.CloneTo(heapAllocator{}, deep)
+}
+
+// CloneTo is like Clone, with the nodes of the copy provided by a.
+func (n *Node) CloneTo(a NodeAllocator, deep bool) *Node {
+	c := cloneNode(a, n, deep)
+	if enc := n.DetectedEncoding(); enc != "" {
+		c.setEncoding(enc)
+	}
+	copyMarks(n, c, sensitiveMarks(n), deep)
+	return c
+}
+
+func cloneNode(a NodeAllocator, n *Node, deep bool) *Node {
+	c := a.Alloc()
+	*c = Node{
+		Type:         n.Type,
+		Data:         n.Data,
+		Prefix:       n.Prefix,
+		NamespaceURI: n.NamespaceURI,
+		Info:         n.Info,
+		Inst:         n.Inst,
+		CDATA:        n.CDATA,
+		Line:         n.Line,
+		Column:       n.Column,
+		level:        n.level,
+	}
+	if n.Attr != nil {
+		c.Attr = append([]xml.Attr(nil), n.Attr...)
+	}
+	if n.attrURIs != nil {
+		c.attrURIs = append([]string(nil), n.attrURIs...)
+	}
+	if deep {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			addChild(c, cloneNode(a, child, true))
+		}
+	}
+	return c
+}
+
+// Detach unlinks n from its parent and siblings. Unlike DeleteMe, it leaves
+// the descendants of n untouched, so the subtree can be added elsewhere.
+// n becomes the root of its tree, at level 0.
+func (n *Node) Detach() {
+	removeFromTree(n)
+	setLevel(n, 0)
+}
+
+// RemoveChild detaches child, which must be a child of n, from n. It panics
+// otherwise.
+func (n *Node) RemoveChild(child *Node) {
+	if child.Parent != n {
+		panic("xmlquery: node is not a child of the node")
+	}
+	removeFromTree(child)
+	setLevel(child, 0)
+}
+
+// ReplaceChild puts newChild in place of oldChild, which must be a child of
+// n, and detaches oldChild. newChild is first detached from the tree it was
+// in. It panics if oldChild is not a child of n or if newChild is n or one
+// of its ancestors.
+func (n *Node) ReplaceChild(oldChild, newChild *Node) {
+	if oldChild == nil {
+		panic("xmlquery: node is not a child of the node")
+	}
+	checkInsert(n, newChild, oldChild)
+	if newChild == oldChild {
+		return
+	}
+	insertBefore(oldChild, newChild)
+	removeFromTree(oldChild)
+	setLevel(oldChild, 0)
+}
+
+// Unlinks n from its parent and siblings, leaving its descendants untouched.
+func removeFromTree(n *Node) {
+	dropIndex(n)
+	detachMarks(n, false)
+	if n.Parent != nil {
+		if n.Parent.FirstChild == n {
+			n.Parent.FirstChild = n.NextSibling
+		}
+		if n.Parent.LastChild == n {
+			n.Parent.LastChild = n.PrevSibling
+		}
+	}
+	if n.PrevSibling != nil {
+		n.PrevSibling.NextSibling = n.NextSibling
+	}
+	if n.NextSibling != nil {
+		n.NextSibling.PrevSibling = n.PrevSibling
+	}
+	n.Parent = nil
+	n.PrevSibling = nil
+	n.NextSibling = nil
+}
+
+// Inserts n right after ref, detaching it first and keeping the parent's
+// bookkeeping consistent.
+func insertAfter(ref, n *Node) {
+	if n == ref {
+		return
+	}
+	removeFromTree(n)
+	dropIndex(ref)
+	n.Parent = ref.Parent
+	n.PrevSibling = ref
+	n.NextSibling = ref.NextSibling
+	if ref.NextSibling != nil {
+		ref.NextSibling.PrevSibling = n
+	} else if ref.Parent != nil {
+		ref.Parent.LastChild = n
+	}
+	ref.NextSibling = n
+	setLevel(n, ref.level)
+	attachDoc(n)
+}
+
+// Inserts n right before ref, detaching it first and keeping the parent's
+// bookkeeping consistent.
+func insertBefore(ref, n *Node) {
+	if n == ref {
+		return
+	}
+	removeFromTree(n)
+	dropIndex(ref)
+	n.Parent = ref.Parent
+	n.NextSibling = ref
+	n.PrevSibling = ref.PrevSibling
+	if ref.PrevSibling != nil {
+		ref.PrevSibling.NextSibling = n
+	} else if ref.Parent != nil {
+		ref.Parent.FirstChild = n
+	}
+	ref.PrevSibling = n
+	setLevel(n, ref.level)
+	attachDoc(n)
+}
+
+// Level returns the depth of n in its tree: 0 for its root, usually the
+// document, 1 for the children of the root and so on. It is counted from
+// the ancestors of n, so it is right even for trees whose links were set
+// directly; the mutation methods of Node keep the level that parsing
+// functions such as ParseFragment rely on up to date as well.
+func (n *Node) Level() int {
+	level := 0
+	for p := n.Parent; p != nil; p = p.Parent {
+		level++
+	}
+	return level
+}
+
+// Sets the level of n and updates the levels of its descendants to match.
+func setLevel(n *Node, level int) {
+	n.level = level
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		setLevel(child, level+1)
+	}
+}
+
 // OutputXML returns the text that including tags name.
 func (n *Node) OutputXML(self bool) string {
-	var buf bytes.Buffer
-	n.OutputXMLToWriter(&buf, self, false)
+	var buf strings.Builder
+	n.writeTo(&buf, self, OutputOptions{})
 	return buf.String()
 }
 
 // Same as OutputXML, but pretty.
 func (n *Node) OutputPrettyXML(self bool) string {
-	var buf bytes.Buffer
-	n.OutputXMLToWriter(&buf, self, true)
+	var buf strings.Builder
+	n.writeTo(&buf, self, OutputOptions{Pretty: true})
+	return buf.String()
+}
+
+// OutputXMLMinified is like OutputXML, but leaves out the whitespace
+// between elements in element-only content, as OutputOptions.Minify does,
+// and comments if omitComments is true, for compact machine-to-machine
+// payloads.
+func (n *Node) OutputXMLMinified(self, omitComments bool) string {
+	var buf strings.Builder
+	n.writeTo(&buf, self, OutputOptions{Minify: true, OmitComments: omitComments})
 	return buf.String()
 }
 
 // Same as OutputXML, but different.
 func (n *Node) OutputXMLToWriter(output io.Writer, self bool, pretty bool) {
-	var last_text_node **Node
-	last_text_node = new(*Node)
-	*last_text_node = nil
-	buf_empty := new(bool)
-	*buf_empty = true
+	n.writeTo(output, self, OutputOptions{Pretty: pretty})
+}
+
+// WriteTo writes n as XML to w, implementing io.WriterTo: a document is
+// written as its children, any other node including its own tags. The
+// output is buffered, so w receives large writes even for trees of many
+// small nodes. It returns the number of bytes written to w and the first
+// error of w.
+func (n *Node) WriteTo(w io.Writer) (int64, error) {
+	return n.writeTo(w, n.Type != DocumentNode, OutputOptions{})
+}
+
+// Buffers for the output of writeTo, reused across calls.
+var outputBufPool = sync.Pool{
+	New: func() interface{} { return bufio.NewWriterSize(nil, 32<<10) },
+}
 
+// countWriter counts the bytes written to its writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countWriter) WriteString(s string) (int, error) {
+	n, err := io.WriteString(w.w, s)
+	w.n += int64(n)
+	return n, err
+}
+
+// Writes n like OutputXMLWithOptions, through a pooled buffer unless w
+// already keeps the output in memory, and returns the number of bytes
+// written to w.
+func (n *Node) writeTo(w io.Writer, self bool, opts OutputOptions) (int64, error) {
+	cw := &countWriter{w: w}
+	switch w.(type) {
+	case *strings.Builder, *bytes.Buffer:
+		err := n.OutputXMLWithOptions(cw, self, opts)
+		return cw.n, err
+	}
+	bw := outputBufPool.Get().(*bufio.Writer)
+	bw.Reset(cw)
+	err := n.OutputXMLWithOptions(bw, self, opts)
+	if flushErr := bw.Flush(); err == nil {
+		err = flushErr
+	}
+	bw.Reset(nil)
+	outputBufPool.Put(bw)
+	return cw.n, err
+}
+
+// OutputXMLWithOptions writes n, if self is true, or its children to w as
+// directed by opts. It returns the first error of w or of the element hook.
+//
+// Subtrees are written with the declarations of the namespaces they use
+// that were made by their ancestors, or by their former ancestors if they
+// were detached from their document, as clones are, so that the output is
+// well-formed on its own.
+func (n *Node) OutputXMLWithOptions(w io.Writer, self bool, opts OutputOptions) error {
+	var ew io.WriteCloser
+	var encoder *encoding.Encoder
+	if opts.Encoding != "" {
+		enc, name, err := lookupOutputEncoding(opts.Encoding)
+		if err != nil {
+			return err
+		}
+		if ew, err = newEncodingWriter(w, enc, name); err != nil {
+			return err
+		}
+		w, encoder = ew, enc.NewEncoder()
+	}
+	p := &xmlPrinter{w: &errWriter{w: w}, opts: opts, empty: true, encoder: encoder}
+	if opts.MaskSensitive {
+		p.marks = sensitiveMarks(n)
+	}
+	if opts.Encoding != "" && !opts.OmitDeclaration && n.Type == DocumentNode && !hasDeclaration(n) {
+		p.w.WriteString(`<?xml version="1.0" encoding="` + opts.Encoding + `"?>`)
+		p.empty = false
+	}
+	// Subtrees below the root element may use prefixes declared by their
+	// ancestors, and detached ones by their former ancestors.
+	detached := treeRoot(n).Type != DocumentNode
 	if self {
-		outputXML(output, buf_empty, n, last_text_node, 0, pretty)
+		p.preserve = inheritedSpace(n.Parent) == "preserve"
+		if detached || n.Parent != nil && n.Parent.Type != DocumentNode {
+			p.declsOn, p.decls = n, outOfScopeDecls(n)
+		}
+		p.output(n, 0)
 	} else {
-		for n := n.FirstChild; n != nil; n = n.NextSibling {
-			outputXML(output, buf_empty, n, last_text_node, 0, pretty)
+		p.preserve = inheritedSpace(n) == "preserve"
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if detached || n.Type != DocumentNode {
+				p.declsOn, p.decls = child, outOfScopeDecls(child)
+			}
+			p.output(child, 0)
 		}
 	}
+	if ew != nil {
+		if err := ew.Close(); p.w.err == nil {
+			p.w.err = err
+		}
+	}
+	return p.w.err
 }
 
 // Returns true if the attribute existed and was altered; false if it was added.
@@ -335,6 +1160,7 @@ func (n *Node) SetAttr(key, val string) bool {
 	for i, attr := range n.Attr {
 		if xml_name2string(attr.Name) == key {
 			n.Attr[i].Value = val
+			dropIndex(n)
 			return true
 		}
 	}
@@ -361,6 +1187,10 @@ func (n *Node) DelAttr(key string) bool {
 		}
 	}
 	if index >= 0 {
+		dropIndex(n)
+		if len(n.attrURIs) == len(n.Attr) {
+			n.attrURIs = append(n.attrURIs[:index], n.attrURIs[index+1:]...)
+		}
 		n.Attr = append(n.Attr[:index], n.Attr[index+1:]...)
 		return true
 	}
@@ -385,6 +1215,48 @@ func (n *Node) GetAttr(key string) (string, bool) {
 	return "", false
 }
 
+// attrIndexByName returns the index in n.Attr of the attribute named key,
+// as GetAttr matches it, or -1.
+func (n *Node) attrIndexByName(key string) int {
+	for i, attr := range n.Attr {
+		if xml_name2string(attr.Name) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// newAttributeNode materializes the i-th attribute of elem as an
+// AttributeNode owned by elem.
+func newAttributeNode(elem *Node, i int) *Node {
+	attr := elem.Attr[i]
+	n := &Node{
+		Type:         AttributeNode,
+		Data:         attr.Name.Local,
+		Prefix:       attr.Name.Space,
+		NamespaceURI: elem.AttrNamespaceURI(i),
+		Parent:       elem,
+		level:        elem.level + 1,
+	}
+	text := &Node{Type: TextNode, Data: attr.Value, Parent: n, level: n.level + 1}
+	n.FirstChild, n.LastChild = text, text
+	return n
+}
+
+// attrIndex returns the index in its owner's Attr of the attribute that the
+// AttributeNode a stands for, or -1 if a is not owned by an element.
+func attrIndex(a *Node) int {
+	if a.Type != AttributeNode || a.Parent == nil {
+		return -1
+	}
+	for i, attr := range a.Parent.Attr {
+		if attr.Name.Local == a.Data && attr.Name.Space == a.Prefix {
+			return i
+		}
+	}
+	return -1
+}
+
 func addAttr(n *Node, key, val string) {
 	var attr xml.Attr
 	if i := strings.Index(key, ":"); i > 0 {
@@ -399,44 +1271,127 @@ func addAttr(n *Node, key, val string) {
 		}
 	}
 
+	if len(n.attrURIs) == len(n.Attr) && len(n.Attr) > 0 {
+		n.attrURIs = append(n.attrURIs, "")
+	}
 	n.Attr = append(n.Attr, attr)
+	dropIndex(n)
 }
 
+// AttrNamespaceURI returns the namespace URI of the i-th attribute of n.
+// Attr only stores the prefix of attribute names, so the URI is the one the
+// prefix was bound to when the document was parsed or, for attributes added
+// later, the one it is bound to by the xmlns declarations in scope. Unprefixed
+// attributes are in no namespace.
+func (n *Node) AttrNamespaceURI(i int) string {
+	if len(n.attrURIs) == len(n.Attr) && n.attrURIs[i] != "" {
+		return n.attrURIs[i]
+	}
+	attr := n.Attr[i]
+	if attr.Name.Space == "" {
+		if attr.Name.Local == "xmlns" {
+			return xmlnsNamespaceURI
+		}
+		return ""
+	}
+	return lookupNamespaceURI(n, attr.Name.Space)
+}
+
+// Returns the namespace URI bound to prefix by the xmlns declarations of n
+// and its ancestors, or an empty string if prefix is not bound.
+func lookupNamespaceURI(n *Node, prefix string) string {
+	switch prefix {
+	case "xml":
+		return xmlNamespaceURI
+	case "xmlns":
+		return xmlnsNamespaceURI
+	}
+	for ; n != nil; n = n.Parent {
+		if n.Type != ElementNode {
+			continue
+		}
+		for _, attr := range n.Attr {
+			if (prefix == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns") ||
+				(prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix) {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// Removes the xmlns attributes of n that repeat a declaration in scope at
+// its parent.
+func dropRedundantNamespaces(n *Node) {
+	for i := 0; i < len(n.Attr); i++ {
+		attr := n.Attr[i]
+		prefix := ""
+		if attr.Name.Space == "xmlns" {
+			prefix = attr.Name.Local
+		} else if attr.Name.Space != "" || attr.Name.Local != "xmlns" {
+			continue
+		}
+		if lookupNamespaceURI(n.Parent, prefix) != attr.Value {
+			continue
+		}
+		if len(n.attrURIs) == len(n.Attr) {
+			n.attrURIs = append(n.attrURIs[:i], n.attrURIs[i+1:]...)
+		}
+		n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+		i--
+	}
+}
+
+// AddChild adds child as the last child of n. Unlike InsertBefore, it does
+// not detach child from the tree it was in.
 func (n *Node) AddChild(child *Node) {
 	addChild(n, child)
+	setLevel(child, n.level+1)
 }
 
-// Inserts a node between this and the old parent.
+// Inserts a node between this and the old parent. It is the same as Wrap,
+// which it predates, except that it does nothing if new_parent is nil.
 func (n *Node) Reparent(new_parent *Node) {
 	if new_parent == nil {
 		return
 	}
-	old_parent := n.Parent
-	if old_parent != nil {
-		if old_parent.FirstChild == n {
-			old_parent.FirstChild = new_parent
-		}
-		if old_parent.LastChild == n {
-			old_parent.LastChild = new_parent
+	n.Wrap(new_parent)
+}
+
+// Wrap inserts wrapper in place of n and moves n into it, as its last
+// child. wrapper is first detached from the tree it was in. It panics if
+// wrapper is n or one of its ancestors.
+func (n *Node) Wrap(wrapper *Node) {
+	for p := n; p != nil; p = p.Parent {
+		if p == wrapper {
+			panic("xmlquery: cannot wrap a node in itself or one of its ancestors")
 		}
 	}
-	new_parent.Parent = old_parent
-	new_parent.NextSibling = n.NextSibling
-	new_parent.PrevSibling = n.PrevSibling
-	if n.NextSibling != nil {
-		n.NextSibling.PrevSibling = new_parent
-	}
-	if n.PrevSibling != nil {
-		n.PrevSibling.NextSibling = new_parent
+	if n.Parent != nil {
+		insertBefore(n, wrapper)
+	} else {
+		removeFromTree(wrapper)
+		setLevel(wrapper, n.level)
 	}
-	addChild(new_parent, n)
+	wrapper.InsertBefore(n, nil)
+}
 
-	n.Parent = new_parent
-	n.NextSibling = nil
-	n.PrevSibling = nil
+// Unwrap replaces n with its children, which keep their order, and leaves
+// n detached and empty. It does nothing if n has no parent.
+func (n *Node) Unwrap() {
+	if n.Parent == nil {
+		return
+	}
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		insertBefore(n, child)
+		child = next
+	}
+	removeFromTree(n)
 }
 
 func addChild(parent, n *Node) {
+	dropIndex(parent)
 	n.Parent = parent
 	if parent.FirstChild == nil {
 		parent.FirstChild = n
@@ -446,13 +1401,17 @@ func addChild(parent, n *Node) {
 	}
 
 	parent.LastChild = n
+	attachDoc(n)
 }
 
+// AddSibling adds n as the last sibling of sibling.
 func (n *Node) AddSibling(sibling *Node) {
 	addSibling(sibling, n)
+	setLevel(n, sibling.level)
 }
 
 func addSibling(sibling, n *Node) {
+	dropIndex(sibling)
 	for t := sibling.NextSibling; t != nil; t = t.NextSibling {
 		sibling = t
 	}
@@ -462,30 +1421,62 @@ func addSibling(sibling, n *Node) {
 	if sibling.Parent != nil {
 		sibling.Parent.LastChild = n
 	}
+	attachDoc(n)
 }
 
+// AddBefore inserts sibling right before n, detaching it first from the
+// tree it was in.
 func (n *Node) AddBefore(sibling *Node) {
-	if n.Parent != nil && n.Parent.FirstChild == n {
-		n.Parent.FirstChild = sibling
+	insertBefore(n, sibling)
+}
+
+// AddAfter inserts sibling right after n, detaching it first from the tree
+// it was in.
+func (n *Node) AddAfter(sibling *Node) {
+	insertAfter(n, sibling)
+}
+
+// InsertBefore inserts newChild as a child of n, right before refChild, or
+// as the last child if refChild is nil. newChild is first detached from the
+// tree it was in. It panics if refChild is not a child of n or if newChild
+// is n or one of its ancestors.
+func (n *Node) InsertBefore(newChild, refChild *Node) {
+	checkInsert(n, newChild, refChild)
+	if refChild == nil {
+		removeFromTree(newChild)
+		addChild(n, newChild)
+		setLevel(newChild, n.level+1)
+		return
 	}
-	sibling.NextSibling = n
-	if n.PrevSibling != nil {
-		n.PrevSibling.NextSibling = sibling
+	insertBefore(refChild, newChild)
+}
+
+// InsertAfter inserts newChild as a child of n, right after refChild, or as
+// the first child if refChild is nil. It is otherwise like InsertBefore.
+func (n *Node) InsertAfter(newChild, refChild *Node) {
+	checkInsert(n, newChild, refChild)
+	if refChild == nil {
+		if n.FirstChild != nil {
+			insertBefore(n.FirstChild, newChild)
+			return
+		}
+		addChild(n, newChild)
+		setLevel(newChild, n.level+1)
+		return
 	}
-	sibling.PrevSibling = n.PrevSibling
-	n.PrevSibling = sibling
+	insertAfter(refChild, newChild)
 }
 
-func (n *Node) AddAfter(sibling *Node) {
-	if n.Parent != nil && n.Parent.LastChild == n {
-		n.Parent.LastChild = sibling
+// Panics if newChild cannot be inserted into n next to refChild.
+func checkInsert(n, newChild, refChild *Node) {
+	if refChild != nil && refChild.Parent != n {
+		panic("xmlquery: reference node is not a child of the node")
 	}
-	sibling.PrevSibling = n
-	if n.NextSibling != nil {
-		n.NextSibling.PrevSibling = sibling
+	for p := n; p != nil; p = p.Parent {
+		if p == newChild {
+			panic("xmlquery: node cannot be inserted into itself or a descendant")
+		}
 	}
-	sibling.NextSibling = n.NextSibling
-	n.NextSibling = sibling
 }
 
 // LoadURL loads the XML document from the specified URL.
@@ -495,68 +1486,259 @@ func LoadURL(url string) (*Node, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	return parse(resp.Body)
+	return parse(resp.Body, ParserOptions{})
+}
+
+func newNamespaceTable() map[string]string {
+	space2prefix := make(map[string]string)
+	// http://www.w3.org/XML/1998/namespace is bound by definition to the prefix xml.
+	space2prefix[xmlNamespaceURI] = "xml"
+	return space2prefix
 }
 
-func parse(r io.Reader) (*Node, error) {
+// Builds an element node from a start token, registering its namespace declarations.
+func newElementNode(alloc NodeAllocator, space2prefix map[string]string, tok xml.StartElement, level int) (*Node, error) {
+	// https://www.w3.org/TR/xml-names/#scoping-defaulting
+	for _, att := range tok.Attr {
+		if att.Name.Local == "xmlns" {
+			space2prefix[att.Value] = ""
+		} else if att.Name.Space == "xmlns" {
+			space2prefix[att.Value] = att.Name.Local
+		}
+	}
+
+	if tok.Name.Space != "" {
+		if _, found := space2prefix[tok.Name.Space]; !found {
+			return nil, errors.New("xmlquery: invalid XML document, namespace is missing")
+		}
+	}
+
+	var uris []string
+	if len(tok.Attr) > 0 {
+		uris = make([]string, len(tok.Attr))
+	}
+	for i := 0; i < len(tok.Attr); i++ {
+		att := &tok.Attr[i]
+		if prefix, ok := space2prefix[att.Name.Space]; ok {
+			uris[i] = att.Name.Space
+			att.Name.Space = prefix
+		} else if att.Name.Space == "xmlns" || (att.Name.Space == "" && att.Name.Local == "xmlns") {
+			uris[i] = xmlnsNamespaceURI
+		}
+	}
+
+	node := alloc.Alloc()
+	node.Type = ElementNode
+	node.Data = tok.Name.Local
+	node.Prefix = space2prefix[tok.Name.Space]
+	node.NamespaceURI = tok.Name.Space
+	node.Attr = tok.Attr
+	node.level = level
+	node.attrURIs = uris
+	return node, nil
+}
+
+func newDeclarationNode(alloc NodeAllocator, tok xml.ProcInst, level int) *Node {
+	node := newNode(alloc, DeclarationNode, tok.Target, level)
+	pairs := strings.Split(string(tok.Inst), " ")
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if i := strings.Index(pair, "="); i > 0 {
+			addAttr(node, pair[:i], strings.Trim(pair[i+1:], `"`))
+		}
+	}
+	return node
+}
+
+// Returns a node of the given type from alloc.
+func newNode(alloc NodeAllocator, typ NodeType, data string, level int) *Node {
+	node := alloc.Alloc()
+	node.Type = typ
+	node.Data = data
+	node.level = level
+	return node
+}
+
+// Returns the allocator providing the nodes parsed with opts.
+func (opts ParserOptions) allocator() NodeAllocator {
+	switch {
+	case opts.Allocator != nil:
+		return opts.Allocator
+	case opts.UseNodePool:
+		return nodePool
+	}
+	return heapAllocator{}
+}
+
+func parse(r io.Reader, opts ParserOptions) (*Node, error) {
+	return parseContext(context.Background(), r, opts)
+}
+
+// parseContext is parse, stopping with ctx.Err() once ctx is done.
+func parseContext(ctx context.Context, r io.Reader, opts ParserOptions) (*Node, error) {
+	done := ctx.Done()
+	alloc := opts.allocator()
+	decoder, rec, enc, err := newRecordingDecoder(r, opts.CharsetReader)
+	if err != nil {
+		return nil, err
+	}
+	rec.start(0)
+	rec.maxToken = int64(opts.MaxTokenSize)
+	rec.noLines = opts.DiscardPositions
+	entityRefs, totalNodes := 0, 0
+	decoder.Strict = !opts.Permissive
+	decoder.Entity = opts.Entity
+	decoder.AutoClose = opts.AutoClose
+	// After recovering from an error, the decoder is replaced by one reading
+	// from the next tag on, after synthetic start tags for the open elements:
+	// delta maps its offsets to offsets in the input.
+	var (
+		delta     int64
+		synthetic int // synthetic start tags left to skip
+	)
+	// The entities and expansion ratio in effect, extended by the DTD.
+	entities, ratio := opts.Entity, opts.MaxExpansionRatio
+	if ratio > 0 && len(entities) > 0 {
+		rec.limitExpansion(entities, ratio)
+	}
 	var (
-		decoder      = xml.NewDecoder(r)
-		doc          = &Node{Type: DocumentNode}
-		space2prefix = make(map[string]string)
+		doc          = newNode(alloc, DocumentNode, "", 0)
+		space2prefix = newNamespaceTable()
 		level        = 0
 	)
-	// http://www.w3.org/XML/1998/namespace is bound by definition to the prefix xml.
-	space2prefix["http://www.w3.org/XML/1998/namespace"] = "xml"
-	decoder.CharsetReader = charset.NewReaderLabel
+	doc.setEncoding(enc)
 	prev := doc
+	var expanded int64 // bytes of text and attribute values produced so far
 	for {
+		if done != nil {
+			select {
+			case <-done:
+				return nil, ctx.Err()
+			default:
+			}
+		}
+		offset := decoder.InputOffset() + delta
+		rec.start(offset)
+		var tokLine, tokColumn int
+		if !opts.DiscardPositions {
+			tokLine, tokColumn = rec.pos(offset)
+		}
+		line, column := tokLine, tokColumn // the position given to nodes
 		tok, err := decoder.Token()
+		if _, ok := tok.(xml.StartElement); ok && synthetic > 0 {
+			synthetic--
+			continue
+		}
 		switch {
 		case err == io.EOF:
 			goto quit
 		case err != nil:
-			return nil, err
+			var syntaxErr *xml.SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				return nil, err
+			}
+			end := decoder.InputOffset() + delta
+			errLine, errColumn := rec.pos(end)
+			if errLine == 0 {
+				errLine = syntaxErr.Line
+			}
+			parseErr := &ParseError{Line: errLine, Column: errColumn, ByteOffset: end, Path: openElementPath(prev, level), Err: err}
+			if opts.Recover == nil {
+				return nil, parseErr
+			}
+			opts.Recover(parseErr)
+			if end <= offset {
+				end = offset + 1
+			}
+			resume, ok := rec.skipToTag(end)
+			if !ok {
+				goto quit
+			}
+			tags := openElementTags(prev, level)
+			next := xml.NewDecoder(&resumeReader{pending: append([]byte(tags), rec.from(resume)...), rec: rec})
+			next.Strict, next.Entity, next.AutoClose = decoder.Strict, decoder.Entity, decoder.AutoClose
+			decoder, delta = next, resume-int64(len(tags))
+			synthetic = strings.Count(tags, "<")
+			continue
 		}
 
-		switch tok := tok.(type) {
-		case xml.StartElement:
-			if level == 0 {
-				// missing XML declaration
-				node := &Node{Type: DeclarationNode, Data: "xml", level: 1}
-				addChild(prev, node)
-				level = 1
-				prev = node
-			}
-			// https://www.w3.org/TR/xml-names/#scoping-defaulting
-			for _, att := range tok.Attr {
-				if att.Name.Local == "xmlns" {
-					space2prefix[att.Value] = ""
-				} else if att.Name.Space == "xmlns" {
-					space2prefix[att.Value] = att.Name.Local
+		if ratio > 0 {
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				for _, attr := range tok.Attr {
+					expanded += int64(len(attr.Value))
 				}
+			case xml.CharData:
+				expanded += int64(len(tok))
+			}
+			if input := decoder.InputOffset() + delta; float64(expanded) > ratio*float64(input) {
+				return nil, &ExpansionError{Input: input, Expanded: expanded, Limit: ratio}
 			}
+		}
 
-			if tok.Name.Space != "" {
-				if _, found := space2prefix[tok.Name.Space]; !found {
-					return nil, errors.New("xmlquery: invalid XML document, namespace is missing")
+		if opts.MaxEntityExpansions > 0 && len(entities) > 0 {
+			switch tok.(type) {
+			case xml.StartElement, xml.CharData:
+				raw := rec.from(offset)
+				if end := decoder.InputOffset() + delta - offset; end < int64(len(raw)) {
+					raw = raw[:end]
+				}
+				entityRefs += countEntityRefs(raw, entities)
+				if entityRefs > opts.MaxEntityExpansions {
+					return nil, &LimitError{Option: "MaxEntityExpansions", Limit: opts.MaxEntityExpansions, Line: line, Column: column}
 				}
 			}
+		}
 
-			for i := 0; i < len(tok.Attr); i++ {
-				att := &tok.Attr[i]
-				if prefix, ok := space2prefix[att.Name.Space]; ok {
-					att.Name.Space = prefix
+		if opts.MaxTotalNodes > 0 {
+			switch tok := tok.(type) {
+			case xml.StartElement, xml.ProcInst:
+				totalNodes++
+			case xml.CharData:
+				if !opts.DiscardWhitespace || len(bytes.TrimSpace(tok)) > 0 {
+					totalNodes++
+				}
+			case xml.Comment:
+				if !opts.DiscardComments {
+					totalNodes++
+				}
+			case xml.Directive:
+				if bytes.HasPrefix(tok, []byte("DOCTYPE")) {
+					totalNodes++
 				}
 			}
+			if totalNodes > opts.MaxTotalNodes {
+				return nil, &LimitError{Option: "MaxTotalNodes", Limit: opts.MaxTotalNodes, Line: line, Column: column}
+			}
+		}
 
-			node := &Node{
-				Type:         ElementNode,
-				Data:         tok.Name.Local,
-				Prefix:       space2prefix[tok.Name.Space],
-				NamespaceURI: tok.Name.Space,
-				Attr:         tok.Attr,
-				level:        level,
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if opts.MaxDepth > 0 && level > opts.MaxDepth {
+				return nil, &LimitError{Option: "MaxDepth", Limit: opts.MaxDepth, Line: line, Column: column}
+			}
+			if opts.MaxAttrCount > 0 && len(tok.Attr) > opts.MaxAttrCount {
+				return nil, &LimitError{Option: "MaxAttrCount", Limit: opts.MaxAttrCount, Line: line, Column: column}
+			}
+			if opts.MaxAttrValueLen > 0 {
+				for _, attr := range tok.Attr {
+					if len(attr.Value) > opts.MaxAttrValueLen {
+						return nil, &LimitError{Option: "MaxAttrValueLen", Limit: opts.MaxAttrValueLen, Line: line, Column: column}
+					}
+				}
 			}
+			if level == 0 {
+				// missing XML declaration
+				node := newNode(alloc, DeclarationNode, "xml", 1)
+				addChild(prev, node)
+				level = 1
+				prev = node
+			}
+			node, err := newElementNode(alloc, space2prefix, tok, level)
+			if err != nil {
+				return nil, &ParseError{Line: tokLine, Column: tokColumn, ByteOffset: offset, Path: openElementPath(prev, level), Err: err}
+			}
+			node.Line, node.Column = line, column
 
 			if level == prev.level {
 				addSibling(prev, node)
@@ -570,10 +1752,18 @@ func parse(r io.Reader) (*Node, error) {
 			}
 			prev = node
 			level++
+			if opts.DropRedundantNamespaces {
+				dropRedundantNamespaces(node)
+			}
 		case xml.EndElement:
 			level--
 		case xml.CharData:
-			node := &Node{Type: TextNode, Data: string(tok), level: level}
+			if opts.DiscardWhitespace && len(bytes.TrimSpace(tok)) == 0 {
+				break
+			}
+			node := newNode(alloc, TextNode, string(tok), level)
+			node.Line, node.Column = line, column
+			node.CDATA = bytes.HasPrefix(rec.from(offset), []byte("<![CDATA["))
 			if level == prev.level {
 				addSibling(prev, node)
 			} else if level > prev.level {
@@ -585,7 +1775,11 @@ func parse(r io.Reader) (*Node, error) {
 				addSibling(prev.Parent, node)
 			}
 		case xml.Comment:
-			node := &Node{Type: CommentNode, Data: string(tok), level: level}
+			if opts.DiscardComments {
+				break
+			}
+			node := newNode(alloc, CommentNode, string(tok), level)
+			node.Line, node.Column = line, column
 			if level == prev.level {
 				addSibling(prev, node)
 			} else if level > prev.level {
@@ -597,17 +1791,34 @@ func parse(r io.Reader) (*Node, error) {
 				addSibling(prev.Parent, node)
 			}
 		case xml.ProcInst: // Processing Instruction
+			if tok.Target != "xml" {
+				if level == 0 {
+					// missing XML declaration
+					node := newNode(alloc, DeclarationNode, "xml", 1)
+					addChild(prev, node)
+					level = 1
+					prev = node
+				}
+				node := newNode(alloc, ProcInstNode, tok.Target, level)
+				node.Inst = string(tok.Inst)
+				node.Line, node.Column = line, column
+				if level == prev.level {
+					addSibling(prev, node)
+				} else if level > prev.level {
+					addChild(prev, node)
+				} else if level < prev.level {
+					for i := prev.level - level; i > 1; i-- {
+						prev = prev.Parent
+					}
+					addSibling(prev.Parent, node)
+				}
+				break
+			}
 			if prev.Type != DeclarationNode {
 				level++
 			}
-			node := &Node{Type: DeclarationNode, Data: tok.Target, level: level}
-			pairs := strings.Split(string(tok.Inst), " ")
-			for _, pair := range pairs {
-				pair = strings.TrimSpace(pair)
-				if i := strings.Index(pair, "="); i > 0 {
-					addAttr(node, pair[:i], strings.Trim(pair[i+1:], `"`))
-				}
-			}
+			node := newDeclarationNode(alloc, tok, level)
+			node.Line, node.Column = line, column
 			if level == prev.level {
 				addSibling(prev, node)
 			} else if level > prev.level {
@@ -615,6 +1826,54 @@ func parse(r io.Reader) (*Node, error) {
 			}
 			prev = node
 		case xml.Directive:
+			if !bytes.HasPrefix(tok, []byte("DOCTYPE")) {
+				break
+			}
+			if opts.DisallowDoctype {
+				return nil, ErrDoctype
+			}
+			if level == 0 {
+				// missing XML declaration
+				node := newNode(alloc, DeclarationNode, "xml", 1)
+				addChild(prev, node)
+				level = 1
+				prev = node
+			}
+			node := newNode(alloc, DoctypeNode, string(tok), level)
+			node.Line, node.Column = line, column
+			if level == prev.level {
+				addSibling(prev, node)
+			} else if level > prev.level {
+				addChild(prev, node)
+			}
+			prev = node
+
+			declared, err := parseDoctypeEntities(node.Data)
+			if err != nil {
+				return nil, err
+			}
+			if len(declared) == 0 {
+				break
+			}
+			if ratio == 0 {
+				ratio = defaultEntityExpansionRatio
+			}
+			limit := int64(ratio * float64(len(tok)))
+			values, err := expandEntities(declared, opts.Entity, limit, ratio, len(tok))
+			if err != nil {
+				return nil, err
+			}
+			// Entities given in the options take precedence.
+			merged := make(map[string]string, len(values)+len(opts.Entity))
+			for name, value := range values {
+				merged[name] = value
+			}
+			for name, value := range opts.Entity {
+				merged[name] = value
+			}
+			entities = merged
+			decoder.Entity = merged
+			rec.limitExpansion(merged, ratio)
 		}
 
 	}
@@ -622,7 +1881,210 @@ quit:
 	return doc, nil
 }
 
+// ParserOptions controls how ParseWithOptions builds a tree. The zero value
+// parses documents exactly as Parse does.
+type ParserOptions struct {
+	// CharsetReader converts documents declaring an encoding other than
+	// UTF-8, as xml.Decoder.CharsetReader. By default the encodings known to
+	// golang.org/x/net/html/charset are supported.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+	// Permissive accepts documents that are not well-formed, such as ones
+	// with unknown entities or unquoted attribute values, by turning off
+	// xml.Decoder.Strict.
+	Permissive bool
+	// Entity maps entity names to their replacement text, in addition to
+	// the predefined XML entities, as xml.Decoder.Entity. Use
+	// xml.HTMLEntity for the entities of HTML.
+	Entity map[string]string
+	// AutoClose lists the elements that are closed right after they start,
+	// whether or not they have an end tag, when Permissive is set, as
+	// xml.Decoder.AutoClose. Use xml.HTMLAutoClose for HTML void elements
+	// such as <br>.
+	AutoClose []string
+	// Recover, if set, makes parsing go on after the errors that would make
+	// it fail with a *ParseError: Recover is called with the error and the
+	// malformed markup is skipped up to the next tag, keeping the elements
+	// that were open. At the end of a truncated document, the elements left
+	// open are closed. Other errors, such as those of limits, still stop
+	// parsing.
+	Recover func(err *ParseError)
+	// DiscardComments leaves comments out of the tree.
+	DiscardComments bool
+	// MaxExpansionRatio, if positive, makes parsing fail with an
+	// *ExpansionError once the text and attribute values read so far, or
+	// the replacement text of the entity references read so far, are more
+	// than this many times larger than the input consumed, which can only
+	// happen through the expansion of entities. References are counted as
+	// they are read, before the text holding them is expanded in memory. Documents declaring
+	// entities in their DTD are limited to a ratio of 100 when it is not set.
+	MaxExpansionRatio float64
+	// DisallowDoctype makes parsing fail with ErrDoctype on documents with a
+	// DOCTYPE declaration.
+	DisallowDoctype bool
+	// MaxEntityExpansions, if positive, limits the number of references to
+	// the entities of Entity and of the internal subset of the DTD.
+	MaxEntityExpansions int
+	// MaxDepth, if positive, limits the nesting of elements.
+	MaxDepth int
+	// MaxAttrCount, if positive, limits the number of attributes, namespace
+	// declarations included, of a single element.
+	MaxAttrCount int
+	// MaxAttrValueLen, if positive, limits the length in bytes of attribute
+	// values, after the expansion of entities.
+	MaxAttrValueLen int
+	// MaxTotalNodes, if positive, limits the number of elements, text
+	// nodes, comments, processing instructions and DOCTYPE declarations of
+	// the tree.
+	MaxTotalNodes int
+	// MaxTokenSize, if positive, limits the number of bytes of input making
+	// up a single tag, text, comment or directive. Parsing stops as soon as
+	// the limit is reached, before the token is held in memory.
+	MaxTokenSize int
+	// DropRedundantNamespaces leaves out the namespace declarations that
+	// bind a prefix to the namespace it is already bound to by an ancestor,
+	// as often repeated on every element by generators of SOAP messages.
+	DropRedundantNamespaces bool
+	// Allocator provides the nodes of the tree, instead of the heap.
+	Allocator NodeAllocator
+	// UseNodePool takes the nodes of the tree from a package-level pool,
+	// to which Release returns them, when Allocator is not set.
+	UseNodePool bool
+	// DiscardPositions leaves the Line and Column of nodes unset, and skips
+	// the tracking of lines they need. A *ParseError then only has the line
+	// reported by encoding/xml, and no column.
+	DiscardPositions bool
+	// DiscardWhitespace leaves out the text nodes made only of whitespace,
+	// such as the indentation between elements.
+	DiscardWhitespace bool
+}
+
+// ExpansionError is returned when a document exceeds the MaxExpansionRatio
+// of its ParserOptions, as in "billion laughs" attacks.
+type ExpansionError struct {
+	Input    int64   // bytes of input consumed
+	Expanded int64   // bytes of text and attribute values produced
+	Limit    float64 // the ratio that was exceeded
+}
+
+func (e *ExpansionError) Error() string {
+	return fmt.Sprintf("xmlquery: entity expansion ratio exceeds %g (%d bytes expanded from %d bytes of input)", e.Limit, e.Expanded, e.Input)
+}
+
+// ParseError is returned when a document is not well-formed. It wraps the
+// underlying error, usually an *xml.SyntaxError.
+type ParseError struct {
+	Line, Column int    // where the error was found; Column is 0 if unknown
+	ByteOffset   int64  // the offset in the input where the error was found
+	Path         string // the path of the element open at that point, e.g. "/catalog/book[2]"
+	Err          error
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Err.Error()
+	if syntaxErr, ok := e.Err.(*xml.SyntaxError); ok {
+		msg = syntaxErr.Msg
+	}
+	msg = strings.TrimPrefix(msg, "xmlquery: ")
+	where := fmt.Sprintf("line %d", e.Line)
+	if e.Column > 0 {
+		where += fmt.Sprintf(", column %d", e.Column)
+	}
+	if e.Path != "" && e.Path != "/" {
+		where += " in " + e.Path
+	}
+	return fmt.Sprintf("xmlquery: %s at %s", msg, where)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the element that the nodes at level are added to, while parsing,
+// prev being the last node added, or nil if they are added to the document.
+func openElement(prev *Node, level int) *Node {
+	n := prev
+	for n != nil && n.level >= level {
+		n = n.Parent
+	}
+	if n != nil && n.Type != ElementNode {
+		return nil
+	}
+	return n
+}
+
+// Returns the start tags of the elements open while parsing, with their
+// namespace declarations.
+func openElementTags(prev *Node, level int) string {
+	var tags []string
+	for n := openElement(prev, level); n != nil && n.Type == ElementNode; n = n.Parent {
+		tag := "<" + n.Data
+		if n.Prefix != "" {
+			tag = "<" + n.Prefix + ":" + n.Data
+		}
+		for _, attr := range n.Attr {
+			if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+				tag += " " + xml_name2string(attr.Name) + `="` + escapeAttrValue(attr.Value, '"') + `"`
+			}
+		}
+		tags = append(tags, tag+">")
+	}
+	var buf strings.Builder
+	for i := len(tags) - 1; i >= 0; i-- {
+		buf.WriteString(tags[i])
+	}
+	return buf.String()
+}
+
+// Returns the path of the element that the nodes at level are added to,
+// while parsing, prev being the last node added.
+func openElementPath(prev *Node, level int) string {
+	n := openElement(prev, level)
+	var steps []string
+	for ; n != nil && n.Type == ElementNode; n = n.Parent {
+		name := n.Data
+		if n.Prefix != "" {
+			name = n.Prefix + ":" + name
+		}
+		pos := 1
+		for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
+			if sib.Type == ElementNode && sib.Data == n.Data && sib.Prefix == n.Prefix {
+				pos++
+			}
+		}
+		if pos > 1 {
+			name += fmt.Sprintf("[%d]", pos)
+		}
+		steps = append(steps, name)
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return "/" + strings.Join(steps, "/")
+}
+
 // Parse returns the parse tree for the XML from the given Reader.
 func Parse(r io.Reader) (*Node, error) {
-	return parse(r)
+	return parse(r, ParserOptions{})
+}
+
+// ParseWithContext is like Parse but stops with the error of ctx once ctx is
+// done. The context is checked between tokens, so a read from r that blocks
+// is not interrupted; a reader bound to the same deadline should be used for
+// that.
+func ParseWithContext(ctx context.Context, r io.Reader) (*Node, error) {
+	return parseContext(ctx, r, ParserOptions{})
+}
+
+// ParseWithOptions is like Parse but with custom options.
+func ParseWithOptions(r io.Reader, opts ParserOptions) (*Node, error) {
+	return parse(r, opts)
+}
+
+// MustParse is like Parse but panics if the document cannot be parsed.
+func MustParse(r io.Reader) *Node {
+	doc, err := parse(r, ParserOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return doc
 }
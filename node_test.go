@@ -1,7 +1,13 @@
 package xmlquery
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -284,6 +290,28 @@ func TestSelectElement(t *testing.T) {
 	if len(ns) != 2 {
 		t.Fatalf("len(ns)!=2")
 	}
+	if n = aaa.SelectElement("DDD"); n != nil {
+		t.Fatalf("DDD is not a child of AAA, but got %v", n)
+	}
+	if ns = aaa.SelectElements("CCC[@id='3']/DDD"); len(ns) != 1 {
+		t.Fatalf("expected 1 node for an expression, but got %d", len(ns))
+	}
+}
+
+func TestSelectElementPrefix(t *testing.T) {
+	doc := loadXML(`<r xmlns:a="urn:a"><a:item>1</a:item><item>2</item></r>`)
+	r := doc.SelectElement("r")
+	if ns := r.SelectElements("item"); len(ns) != 1 || ns[0].InnerText() != "2" {
+		t.Fatalf("expected only the unprefixed item, but got %d items", len(ns))
+	}
+	if n := r.SelectElement("a:item"); n == nil || n.InnerText() != "1" {
+		t.Fatalf("unexpected a:item %v", n)
+	}
+	for name, plain := range map[string]bool{"a": true, "a:b": true, "x-1.y": true, "a:": false, ":a": false, "a:b:c": false, "1a": false, "a/b": false, "*": false, "": false} {
+		if isPlainName(name) != plain {
+			t.Errorf("isPlainName(%q) != %v", name, plain)
+		}
+	}
 }
 
 func TestEscapeOutputValue(t *testing.T) {
@@ -370,6 +398,48 @@ func TestReparent(t *testing.T) {
 	}
 }
 
+func TestWrapUnwrap(t *testing.T) {
+	doc := loadXML(`<r><a/><b><c/>text</b><d/></r>`)
+	b := FindOne(doc, "//b")
+	wrapper := FindOne(doc, "//d")
+	b.Wrap(wrapper)
+	if expected, got := `<r><a/><d><b><c/>text</b></d></r>`, FindOne(doc, "/r").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	b.Unwrap()
+	if expected, got := `<r><a/><d><c/>text</d></r>`, FindOne(doc, "/r").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if b.Parent != nil || b.FirstChild != nil {
+		t.Error("the unwrapped node should be detached and empty")
+	}
+	checkTreeInvariants(t, doc)
+
+	a := FindOne(doc, "//a")
+	a.Wrap(NewElement("first"))
+	wrapper.Unwrap()
+	if expected, got := `<r><first><a/></first><c/>text</r>`, FindOne(doc, "/r").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	detached := NewElement("x")
+	outer := NewElement("outer")
+	detached.Wrap(outer)
+	if detached.Parent != outer || outer.Parent != nil {
+		t.Error("wrapping a detached node should give it the wrapper as parent")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic when wrapping a node in its ancestor")
+		}
+	}()
+	FindOne(doc, "//a").Wrap(FindOne(doc, "/r"))
+}
+
 func TestAppendAttr(t *testing.T) {
 	s := `<?xml?><a/>`
 	doc, err := Parse(strings.NewReader(s))
@@ -466,6 +536,143 @@ func TestAddAfter2(t *testing.T) {
 	}
 }
 
+// Fails the test unless the links and levels of the tree rooted at n are
+// consistent.
+func checkTreeInvariants(t *testing.T, n *Node) {
+	t.Helper()
+	if n.FirstChild == nil != (n.LastChild == nil) {
+		t.Fatalf("%s: FirstChild and LastChild disagree", n.Data)
+	}
+	if n.FirstChild != nil && n.FirstChild.PrevSibling != nil {
+		t.Fatalf("%s: first child has a previous sibling", n.Data)
+	}
+	if n.LastChild != nil && n.LastChild.NextSibling != nil {
+		t.Fatalf("%s: last child has a next sibling", n.Data)
+	}
+	var prev *Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Parent != n {
+			t.Fatalf("%s: child %s has the wrong parent", n.Data, child.Data)
+		}
+		if child.PrevSibling != prev {
+			t.Fatalf("%s: child %s has the wrong previous sibling", n.Data, child.Data)
+		}
+		if child.level != n.level+1 {
+			t.Fatalf("%s: child %s is at level %d instead of %d", n.Data, child.Data, child.level, n.level+1)
+		}
+		checkTreeInvariants(t, child)
+		prev = child
+	}
+	if prev != n.LastChild {
+		t.Fatalf("%s: LastChild is not the last child", n.Data)
+	}
+}
+
+func TestInsertBeforeAfter(t *testing.T) {
+	s := `<root><a><a1/></a><b/><c/></root>`
+	tests := []struct {
+		name     string
+		insert   func(root *Node, n *Node)
+		expected string
+	}{
+		{"before first", func(root, n *Node) { root.InsertBefore(n, root.FirstChild) }, `<root><n><n1/></n><a><a1/></a><b/><c/></root>`},
+		{"before middle", func(root, n *Node) { root.InsertBefore(n, root.SelectElement("b")) }, `<root><a><a1/></a><n><n1/></n><b/><c/></root>`},
+		{"before nil", func(root, n *Node) { root.InsertBefore(n, nil) }, `<root><a><a1/></a><b/><c/><n><n1/></n></root>`},
+		{"after last", func(root, n *Node) { root.InsertAfter(n, root.LastChild) }, `<root><a><a1/></a><b/><c/><n><n1/></n></root>`},
+		{"after middle", func(root, n *Node) { root.InsertAfter(n, root.SelectElement("b")) }, `<root><a><a1/></a><b/><n><n1/></n><c/></root>`},
+		{"after nil", func(root, n *Node) { root.InsertAfter(n, nil) }, `<root><n><n1/></n><a><a1/></a><b/><c/></root>`},
+		{"into empty", func(root, n *Node) { root.SelectElement("c").InsertAfter(n, nil) }, `<root><a><a1/></a><b/><c><n><n1/></n></c></root>`},
+		{"deeper", func(root, n *Node) { root.SelectElement("a").InsertBefore(n, FindOne(root, "a/a1")) }, `<root><a><n><n1/></n><a1/></a><b/><c/></root>`},
+		{"AddBefore", func(root, n *Node) { root.SelectElement("c").AddBefore(n) }, `<root><a><a1/></a><b/><n><n1/></n><c/></root>`},
+		{"AddAfter", func(root, n *Node) { FindOne(root, "a/a1").AddAfter(n) }, `<root><a><a1/><n><n1/></n></a><b/><c/></root>`},
+	}
+	for _, test := range tests {
+		doc := loadXML(s)
+		root := FindOne(doc, "/root")
+		n := NewElement("n")
+		n.AppendElement("n1")
+		test.insert(root, n)
+		if got := root.OutputXML(true); got != test.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", test.name, test.expected, got)
+		}
+		checkTreeInvariants(t, doc)
+	}
+}
+
+func TestInsertMovesNode(t *testing.T) {
+	doc := loadXML(`<root><a><x><y/></x></a><b/></root>`)
+	root := FindOne(doc, "/root")
+	x := FindOne(doc, "//x")
+	root.InsertBefore(x, root.SelectElement("a"))
+	if expected, got := `<root><x><y/></x><a/><b/></root>`, root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	root.SelectElement("b").AddAfter(x)
+	if expected, got := `<root><a/><b/><x><y/></x></root>`, root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+}
+
+func TestInsertPanics(t *testing.T) {
+	doc := loadXML(`<root><a><b/></a><c/></root>`)
+	tests := []func(){
+		func() { FindOne(doc, "//a").InsertBefore(NewElement("n"), FindOne(doc, "//c")) },
+		func() { FindOne(doc, "//b").InsertBefore(FindOne(doc, "//a"), nil) },
+		func() { FindOne(doc, "//a").InsertAfter(FindOne(doc, "//a"), nil) },
+	}
+	for i, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("test %d: expected a panic", i)
+				}
+			}()
+			test()
+		}()
+	}
+	checkTreeInvariants(t, doc)
+}
+
+func TestDetachRemoveReplace(t *testing.T) {
+	doc := loadXML(`<root><a><x><y/></x></a><b/><c/></root>`)
+	root := FindOne(doc, "/root")
+	x := FindOne(doc, "//x")
+	x.Detach()
+	if x.Parent != nil || x.FirstChild == nil || x.FirstChild.Data != "y" {
+		t.Fatal("Detach should keep the descendants of the node")
+	}
+	root.ReplaceChild(root.SelectElement("b"), x)
+	if expected, got := `<root><a/><x><y/></x><c/></root>`, root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	root.ReplaceChild(root.SelectElement("a"), root.SelectElement("c"))
+	root.RemoveChild(x)
+	if expected, got := `<root><c/></root>`, root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	for i, test := range []func(){
+		func() { root.RemoveChild(x) },
+		func() { root.ReplaceChild(x, NewElement("n")) },
+		func() { root.SelectElement("c").ReplaceChild(nil, NewElement("n")) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("test %d: expected a panic", i)
+				}
+			}()
+			test()
+		}()
+	}
+}
+
 func TestSpaceEdgeCases1(t *testing.T) {
 	s := `<?xml?><a> Link</a>. `
 	doc, _ := Parse(strings.NewReader(s))
@@ -558,3 +765,678 @@ func TestTrimText5(t *testing.T) {
 		t.Fatalf("\nexpected: %q\ngot:      %q", expected, got)
 	}
 }
+
+func TestAt(t *testing.T) {
+	doc := loadXML(`<rss xmlns:dc="urn:dc"><channel><title>News</title><dc:creator id="7">Ann</dc:creator><image/></channel></rss>`)
+	if got := doc.At("rss", "channel", "title").Value(); got != "News" {
+		t.Fatalf("expected News, but got %q", got)
+	}
+	if got := doc.At("rss", "channel", "dc:creator", "@id").Value(); got != "7" {
+		t.Fatalf("expected 7, but got %q", got)
+	}
+	if doc.At("rss", "channel", "creator").Ok() {
+		t.Fatal("an unprefixed step matched dc:creator")
+	}
+	if n := doc.At("rss", "channel", "image"); !n.Ok() || n.Value() != "" {
+		t.Fatal("expected an existing, empty image")
+	}
+	missing := doc.At("rss", "missing", "title")
+	if missing.Ok() || missing.Value() != "" || missing.At("more", "@attr").Ok() {
+		t.Fatal("expected a missing node")
+	}
+	if doc.At("rss", "channel", "@missing").Ok() {
+		t.Fatal("expected a missing attribute")
+	}
+}
+
+func TestAttrNamespaceURI(t *testing.T) {
+	s := `<root xmlns:a="urn:first" xmlns:xl="http://www.w3.org/1999/xlink">
+	<item a:id="1" xl:href="#x" xml:lang="en" plain="p"/>
+	<inner xmlns:a="urn:second"><item a:id="2"/></inner>
+</root>`
+	doc := loadXML(s)
+	items := Find(doc, "//item")
+	uri := func(n *Node, key string) string {
+		for i, attr := range n.Attr {
+			if xml_name2string(attr.Name) == key {
+				return n.AttrNamespaceURI(i)
+			}
+		}
+		t.Fatalf("missing attribute %s", key)
+		return ""
+	}
+	if got := uri(items[0], "a:id"); got != "urn:first" {
+		t.Fatalf("expected urn:first, but got %q", got)
+	}
+	if got := uri(items[0], "xl:href"); got != "http://www.w3.org/1999/xlink" {
+		t.Fatalf("unexpected xlink namespace %q", got)
+	}
+	if got := uri(items[0], "xml:lang"); got != "http://www.w3.org/XML/1998/namespace" {
+		t.Fatalf("unexpected xml namespace %q", got)
+	}
+	if got := uri(items[0], "plain"); got != "" {
+		t.Fatalf("unprefixed attributes have no namespace, but got %q", got)
+	}
+	if got := uri(items[1], "a:id"); got != "urn:second" {
+		t.Fatalf("expected urn:second, but got %q", got)
+	}
+	if got := uri(doc.At("root"), "xmlns:a"); got != "http://www.w3.org/2000/xmlns/" {
+		t.Fatalf("unexpected xmlns namespace %q", got)
+	}
+
+	// Attributes added or removed later stay consistent.
+	items[0].DelAttr("a:id")
+	items[0].SetAttr("xl:title", "t")
+	if got := uri(items[0], "xl:href"); got != "http://www.w3.org/1999/xlink" {
+		t.Fatalf("unexpected xlink namespace %q after DelAttr", got)
+	}
+	if got := uri(items[0], "xl:title"); got != "http://www.w3.org/1999/xlink" {
+		t.Fatalf("unexpected xlink namespace %q for a new attribute", got)
+	}
+	if got := uri(items[0].CompactCopy(), "xml:lang"); got != "http://www.w3.org/XML/1998/namespace" {
+		t.Fatalf("unexpected xml namespace %q in a detached copy", got)
+	}
+}
+
+func TestParseWithOptions(t *testing.T) {
+	s := `<root>
+	<!-- comment -->
+	<a>&nbsp;x</a>
+	<b x=1/>
+</root>`
+	if _, err := Parse(strings.NewReader(s)); err == nil {
+		t.Fatal("expected an error for an unknown entity")
+	}
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{
+		Permissive:        true,
+		Entity:            map[string]string{"nbsp": " "},
+		DiscardComments:   true,
+		DiscardWhitespace: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := FindOne(doc, "/root")
+	if n := root.FirstChild; n.Data != "a" || n.InnerText() != " x" {
+		t.Fatalf("unexpected first child %v", n)
+	}
+	if n := root.LastChild; n.Data != "b" || n.SelectAttr("x") != "1" {
+		t.Fatalf("unexpected last child %v", n)
+	}
+	if root.FirstChild.NextSibling != root.LastChild {
+		t.Fatal("comments and whitespace should have been discarded")
+	}
+
+	charsetReader := func(label string, input io.Reader) (io.Reader, error) {
+		if label != "x-upper" {
+			return nil, fmt.Errorf("unsupported charset %s", label)
+		}
+		b, err := io.ReadAll(input)
+		return bytes.NewReader(bytes.ToUpper(b)), err
+	}
+	doc, err = ParseWithOptions(strings.NewReader(`<?xml version="1.0" encoding="x-upper"?><a>hi</a>`), ParserOptions{CharsetReader: charsetReader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := FindOne(doc, "/A"); n == nil || n.InnerText() != "HI" {
+		t.Fatal("document was not converted by the charset reader")
+	}
+}
+
+func TestParseMaxExpansionRatio(t *testing.T) {
+	opts := ParserOptions{
+		Entity:            map[string]string{"lol": strings.Repeat("lol", 1000)},
+		MaxExpansionRatio: 10,
+	}
+	_, err := ParseWithOptions(strings.NewReader(`<a>&lol;&lol;&lol;</a>`), opts)
+	experr, ok := err.(*ExpansionError)
+	if !ok {
+		t.Fatalf("expected an *ExpansionError, but got %v", err)
+	}
+	if experr.Limit != 10 || experr.Expanded <= 10*experr.Input {
+		t.Fatalf("unexpected error %+v", experr)
+	}
+
+	opts.MaxExpansionRatio = 1000
+	if _, err := ParseWithOptions(strings.NewReader(`<a x="&lol;">&amp;</a>`), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCDATA(t *testing.T) {
+	s := `<root><script><![CDATA[if (a < b && c) { go(); }]]></script><p>a &lt; b</p><m>x<![CDATA[<y/>]]>z</m></root>`
+	doc := loadXML(s)
+	script := FindOne(doc, "//script")
+	if !script.FirstChild.CDATA || script.InnerText() != "if (a < b && c) { go(); }" {
+		t.Fatalf("unexpected script %v (CDATA: %v)", script.FirstChild, script.FirstChild.CDATA)
+	}
+	if FindOne(doc, "//p").FirstChild.CDATA {
+		t.Fatal("escaped text should not be CDATA")
+	}
+	m := FindOne(doc, "//m")
+	if m.FirstChild.CDATA || !m.FirstChild.NextSibling.CDATA || m.LastChild.CDATA {
+		t.Fatal("only the middle text of <m> should be CDATA")
+	}
+	if got := FindOne(doc, "/root").OutputXML(true); got != s {
+		t.Fatalf("\nexpected: %s\ngot:      %s", s, got)
+	}
+	if !script.CompactCopy().FirstChild.CDATA {
+		t.Fatal("CompactCopy should keep CDATA")
+	}
+}
+
+func TestCDATAWithCharset(t *testing.T) {
+	s := "<?xml version=\"1.0\" encoding=\"iso-8859-1\"?><a>caf\xe9<![CDATA[<b>]]></a>"
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := FindOne(doc, "//a")
+	if a.InnerText() != "café<b>" || !a.LastChild.CDATA || a.FirstChild.CDATA {
+		t.Fatalf("unexpected content %q", a.InnerText())
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	s := "<?xml version=\"1.0\"?>\n<config>\n  <server port=\"80\"/>\n  <!-- note -->\n  <name>x</name></config>"
+	doc := loadXML(s)
+	tests := []struct {
+		n            *Node
+		line, column int
+	}{
+		{FindOne(doc, "/config"), 2, 1},
+		{FindOne(doc, "//server"), 3, 3},
+		{FindOne(doc, "//comment()"), 4, 3},
+		{FindOne(doc, "//name"), 5, 3},
+		{FindOne(doc, "//name/text()"), 5, 9},
+		{FindOne(doc, "/config").FirstChild, 2, 9},
+	}
+	for _, test := range tests {
+		if test.n.Line != test.line || test.n.Column != test.column {
+			t.Errorf("%v: expected %d:%d, but got %d:%d", test.n, test.line, test.column, test.n.Line, test.n.Column)
+		}
+	}
+
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{DiscardPositions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := FindOne(doc, "//server"); n.Line != 0 || n.Column != 0 {
+		t.Fatalf("expected no position, but got %d:%d", n.Line, n.Column)
+	}
+	_, err = ParseWithOptions(strings.NewReader("<a>\n<b>\n</a>"), ParserOptions{DiscardPositions: true})
+	if perr, ok := err.(*ParseError); !ok || perr.Line != 3 || perr.Column != 0 {
+		t.Fatalf("expected a *ParseError on line 3 without column, but got %#v", err)
+	}
+}
+
+func TestProcInstNode(t *testing.T) {
+	s := `<?xml version="1.0"?><?xml-stylesheet type="text/xsl" href="style.xsl"?><doc><?php echo 1; ?><a/><?empty?></doc>`
+	doc := loadXML(s)
+	decl := doc.FirstChild
+	if decl.Type != DeclarationNode || decl.SelectAttr("version") != "1.0" {
+		t.Fatalf("unexpected declaration %v", decl)
+	}
+	pi := decl.NextSibling
+	if pi.Type != ProcInstNode || pi.Data != "xml-stylesheet" || pi.Inst != `type="text/xsl" href="style.xsl"` {
+		t.Fatalf("unexpected processing instruction %v", pi)
+	}
+	root := FindOne(doc, "/doc")
+	if root == nil || pi.NextSibling != root || root.Parent != doc {
+		t.Fatal("<doc> should follow the processing instruction at the top level")
+	}
+	if php := root.FirstChild; php.Type != ProcInstNode || php.Data != "php" || php.Inst != "echo 1; " || php.Parent != root {
+		t.Fatalf("unexpected processing instruction %v", php)
+	}
+	if a := FindOne(doc, "/doc/a"); a == nil || a.Parent != root {
+		t.Fatal("<a> should be a child of <doc>")
+	}
+	if got := doc.OutputXML(false); got != s {
+		t.Fatalf("\nexpected: %s\ngot:      %s", s, got)
+	}
+	if got := NewFlatDoc(doc).Node().OutputXML(false); got != s {
+		t.Fatalf("\nexpected: %s\ngot:      %s", s, got)
+	}
+
+	doc = loadXML(`<?xml-stylesheet href="a.xsl"?><doc/>`)
+	if got, expected := doc.OutputXML(false), `<?xml?><?xml-stylesheet href="a.xsl"?><doc/>`; got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestCDATASplitting(t *testing.T) {
+	tests := []struct {
+		text, expected string
+	}{
+		{"a]]>b", "<![CDATA[a]]]]><![CDATA[>b]]>"},
+		{"]]>]]>", "<![CDATA[]]]]><![CDATA[>]]]]><![CDATA[>]]>"},
+		{"]]]>", "<![CDATA[]]]]]><![CDATA[>]]>"},
+		{"a]]b>", "<![CDATA[a]]b>]]>"},
+	}
+	for _, test := range tests {
+		root := &Node{Type: ElementNode, Data: "x"}
+		root.AddChild(&Node{Type: TextNode, Data: test.text, CDATA: true})
+		out := root.OutputXML(false)
+		if out != test.expected {
+			t.Errorf("%q:\nexpected: %s\ngot:      %s", test.text, test.expected, out)
+			continue
+		}
+		doc := loadXML("<x>" + out + "</x>")
+		if got := FindOne(doc, "/x").InnerText(); got != test.text {
+			t.Errorf("%q: read back as %q", test.text, got)
+		}
+	}
+}
+
+func TestOutputElementHook(t *testing.T) {
+	doc := loadXML(`<doc><raw id="1"/><keep>a</keep><drop>b</drop></doc>`)
+	opts := OutputOptions{
+		ElementHook: func(n *Node, w io.Writer) (bool, error) {
+			switch n.Data {
+			case "raw":
+				_, err := io.WriteString(w, "<stored>"+n.SelectAttr("id")+"</stored>")
+				return true, err
+			case "drop":
+				return true, nil
+			}
+			return false, nil
+		},
+	}
+	var buf bytes.Buffer
+	if err := FindOne(doc, "/doc").OutputXMLWithOptions(&buf, true, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := buf.String(), `<doc><stored>1</stored><keep>a</keep></doc>`; got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	errHook := fmt.Errorf("hook failed")
+	opts.ElementHook = func(n *Node, w io.Writer) (bool, error) {
+		if n.Data == "keep" {
+			return false, errHook
+		}
+		return false, nil
+	}
+	buf.Reset()
+	if err := doc.OutputXMLWithOptions(&buf, false, opts); err != errHook {
+		t.Fatalf("expected the error of the hook, but got %v", err)
+	}
+}
+
+func TestOutputFormatOptions(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?><doc><a x="1" y="2"><b>text</b></a><p>Some <em>  emphasized </em> text</p></doc>`)
+	tests := []struct {
+		opts     OutputOptions
+		expected string
+	}{
+		{
+			OutputOptions{Pretty: true, Indent: "  ", Newline: "\r\n", OmitDeclaration: true},
+			"<doc>\r\n  <a x=\"1\" y=\"2\">\r\n    <b>text</b></a><p>Some\r\n    <em>\r\n      emphasized\r\n    </em>\r\n    text</p></doc>",
+		},
+		{
+			OutputOptions{Pretty: true, Indent: "  ", PreserveWhitespace: true, OmitDeclaration: true},
+			"<doc>\n  <a x=\"1\" y=\"2\">\n    <b>text</b>\n  </a>\n  <p>Some <em>  emphasized </em> text</p>\n</doc>",
+		},
+		{
+			OutputOptions{Pretty: true, Indent: "  ", MaxLineWidth: 10, OmitDeclaration: true},
+			"<doc>\n  <a\n    x=\"1\"\n    y=\"2\">\n    <b>text</b></a><p>Some\n    <em>\n      emphasized\n    </em>\n    text</p></doc>",
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := doc.OutputXMLWithOptions(&buf, false, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.expected {
+			t.Errorf("\nexpected: %q\ngot:      %q", test.expected, got)
+		}
+	}
+}
+
+func TestOutputPrettyXMLSpace(t *testing.T) {
+	doc := loadXML(`<doc><pre xml:space="preserve">  <b> x </b>
+  <i xml:space="default"> y <u/></i></pre><c> z </c></doc>`)
+	opts := OutputOptions{Pretty: true, Indent: "  ", OmitDeclaration: true}
+	var buf bytes.Buffer
+	if err := doc.OutputXMLWithOptions(&buf, false, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<doc>\n  <pre xml:space=\"preserve\">  <b> x </b>&#xA;  <i xml:space=\"default\">\n      y\n      <u/>\n    </i></pre>\n  <c>\n    z\n  </c>\n</doc>"
+	if got := buf.String(); got != expected {
+		t.Errorf("\nexpected: %q\ngot:      %q", expected, got)
+	}
+
+	buf.Reset()
+	if err := FindOne(doc, "//b").OutputXMLWithOptions(&buf, true, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "<b> x </b>" {
+		t.Errorf("\nexpected: %q\ngot:      %q", "<b> x </b>", got)
+	}
+}
+
+func TestEscapeAttrValue(t *testing.T) {
+	tests := []struct {
+		value, expected string
+	}{
+		{"plain", "plain"},
+		{`a "quoted" & <tagged> 'value'`, `a &quot;quoted&quot; &amp; &lt;tagged&gt; 'value'`},
+		{"line\nbreak\ttab\r", "line&#xA;break&#x9;tab&#xD;"},
+	}
+	for _, test := range tests {
+		if got := EscapeAttrValue(test.value); got != test.expected {
+			t.Errorf("\nexpected: %s\ngot:      %s", test.expected, got)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	for _, text := range []string{"", "plain", `"quoted" & 'single' <tag>`, "tab\tline\ncr\r", "bad\x00\x1f\xffrune \uFFFD ok é"} {
+		var got, expected bytes.Buffer
+		escapeText(&got, text)
+		xml.EscapeText(&expected, []byte(text))
+		if got.String() != expected.String() {
+			t.Errorf("\nexpected: %q\ngot:      %q", expected.String(), got.String())
+		}
+	}
+}
+
+func TestOutputAttrEscaping(t *testing.T) {
+	value := "Tom & \"Jerry's\" <show>\n"
+	a := &Node{Type: ElementNode, Data: "a"}
+	a.SetAttr("title", value)
+
+	expected := `<a title="Tom &amp; &quot;Jerry's&quot; &lt;show&gt;&#xA;"/>`
+	if got := a.OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	var buf bytes.Buffer
+	a.OutputXMLWithOptions(&buf, true, OutputOptions{SingleQuote: true})
+	expected = `<a title='Tom &amp; "Jerry&apos;s" &lt;show&gt;&#xA;'/>`
+	if got := buf.String(); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	for _, s := range []string{a.OutputXML(true), buf.String()} {
+		if got := FindOne(loadXML(s), "/a").SelectAttr("title"); got != value {
+			t.Fatalf("%s: read back as %q", s, got)
+		}
+	}
+}
+
+func TestDropRedundantNamespaces(t *testing.T) {
+	s := `<s:Envelope xmlns:s="urn:soap" xmlns="urn:a"><s:Body xmlns:s="urn:soap"><m xmlns="urn:a" xmlns:x="urn:x" x:id="1"><n xmlns="urn:b" xmlns:x="urn:x"/></m></s:Body></s:Envelope>`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{DropRedundantNamespaces: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<s:Envelope xmlns:s="urn:soap" xmlns="urn:a"><s:Body><m xmlns:x="urn:x" x:id="1"><n xmlns="urn:b"/></m></s:Body></s:Envelope>`
+	if got := FindOne(doc, "/s:Envelope").OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	m := FindOne(doc, "//m")
+	if m.NamespaceURI != "urn:a" || m.AttrNamespaceURI(1) != "urn:x" {
+		t.Fatalf("unexpected namespaces %q and %q", m.NamespaceURI, m.AttrNamespaceURI(1))
+	}
+}
+
+func TestClone(t *testing.T) {
+	doc := loadXML(`<r xmlns:x="urn:x"><a id="1" x:k="v"><b>text</b><!-- c --></a><z/></r>`)
+	a := FindOne(doc, "//a")
+
+	shallow := a.Clone(false)
+	if shallow.Parent != nil || shallow.NextSibling != nil || shallow.FirstChild != nil {
+		t.Fatal("a shallow clone should be detached and have no children")
+	}
+	if shallow.Data != "a" || shallow.SelectAttr("id") != "1" {
+		t.Fatalf("unexpected clone %v", shallow)
+	}
+
+	deep := a.Clone(true)
+	// Being detached, the clone is written with the namespaces it uses.
+	if got, expected := deep.OutputXML(true), `<a xmlns:x="urn:x" id="1" x:k="v"><b>text</b><!-- c --></a>`; got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if deep.Parent != nil || deep.PrevSibling != nil || deep.NextSibling != nil {
+		t.Fatal("a deep clone should be detached")
+	}
+	b := deep.FirstChild
+	if b.Parent != deep || b.NextSibling != deep.LastChild || deep.LastChild.PrevSibling != b || b.FirstChild.Parent != b {
+		t.Fatal("the children of a deep clone are not linked correctly")
+	}
+	if b == a.FirstChild || b.FirstChild == a.FirstChild.FirstChild {
+		t.Fatal("a deep clone should not share nodes with the original")
+	}
+
+	deep.SetAttr("id", "2")
+	if a.SelectAttr("id") != "1" {
+		t.Fatal("changing the attributes of a clone should not change the original")
+	}
+	if deep.AttrNamespaceURI(1) != "urn:x" {
+		t.Fatalf("expected the clone to keep attribute namespaces, but got %q", deep.AttrNamespaceURI(1))
+	}
+}
+
+// cancelingReader cancels its context once it has been read from.
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(p)
+}
+
+func TestParseWithContext(t *testing.T) {
+	doc, err := ParseWithContext(context.Background(), strings.NewReader(`<a><b/></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FindOne(doc, "//b") == nil {
+		t.Fatal("//b not found")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ParseWithContext(ctx, strings.NewReader(`<a/>`)); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r := &cancelingReader{r: strings.NewReader(`<a>` + strings.Repeat(`<b/>`, 1000) + `</a>`), cancel: cancel}
+	if _, err := ParseWithContext(ctx, r); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+// benchmarkDocument returns a document with n items, each with attributes
+// and text needing escapes.
+func benchmarkDocument(n int) *Node {
+	var buf strings.Builder
+	buf.WriteString(`<?xml version="1.0"?><catalog xmlns:x="urn:x">`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `<item id="%d" x:kind="a &amp; b"><name>Item %d</name>
+	<description>Some   text &lt;with&gt; markup</description><!-- c --></item>`, i, i)
+	}
+	buf.WriteString(`</catalog>`)
+	return loadXML(buf.String())
+}
+
+func BenchmarkOutputXML(b *testing.B) {
+	doc := benchmarkDocument(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc.OutputXMLWithOptions(io.Discard, false, OutputOptions{})
+	}
+}
+
+func BenchmarkOutputPrettyXML(b *testing.B) {
+	doc := benchmarkDocument(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc.OutputXMLWithOptions(io.Discard, false, OutputOptions{Pretty: true})
+	}
+}
+
+// failingWriter fails once more than limit bytes have been written to it.
+type failingWriter struct {
+	limit, n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > w.limit {
+		return 0, errors.New("write failed")
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	doc := benchmarkDocument(500)
+	var _ io.WriterTo = doc
+	expected := doc.OutputXML(false)
+
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(bufio.NewWriter(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(expected)) {
+		t.Errorf("expected %d bytes written, got %d", len(expected), n)
+	}
+
+	// A writer that is not kept in memory goes through the buffer.
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := doc.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	got, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expected {
+		t.Error("WriteTo and OutputXML differ")
+	}
+
+	item := FindOne(doc, "//item[1]/name")
+	buf.Reset()
+	if _, err := item.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<name>Item 0</name>" {
+		t.Errorf("\nexpected: %s\ngot:      %s", "<name>Item 0</name>", buf.String())
+	}
+
+	if _, err := doc.WriteTo(&failingWriter{limit: 100}); err == nil {
+		t.Error("expected the error of the writer")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		s            string
+		line, column int
+		path         string
+	}{
+		{"<catalog>\n<book/>\n<book><title>x</titel></book></catalog>", 3, 23, "/catalog/book[2]/title"},
+		{"<a>\n  <b>&unknown;</b></a>", 2, 15, "/a/b"},
+		{"<a>", 1, 4, "/a"},
+		{"<a><p:b/></a>", 1, 4, "/a"},
+	}
+	for _, test := range tests {
+		_, err := Parse(strings.NewReader(test.s))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: expected a *ParseError, got %v", test.s, err)
+			continue
+		}
+		if parseErr.Line != test.line || parseErr.Column != test.column || parseErr.Path != test.path {
+			t.Errorf("%q: expected line %d, column %d in %s, got %d, %d in %s (%v)", test.s, test.line, test.column, test.path, parseErr.Line, parseErr.Column, parseErr.Path, err)
+		}
+	}
+
+	_, err := Parse(strings.NewReader("<a><b></c></a>"))
+	var syntaxErr *xml.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("expected the *xml.SyntaxError to be wrapped, got %v", err)
+	}
+	if expected := "xmlquery: element <b> closed by </c> at line 1, column 11 in /a/b"; err.Error() != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, err)
+	}
+}
+
+func TestParsePermissive(t *testing.T) {
+	opts := ParserOptions{Permissive: true, AutoClose: xml.HTMLAutoClose, Entity: xml.HTMLEntity}
+	doc, err := ParseWithOptions(strings.NewReader(`<p>Fish &amp; chips & peas&nbsp;<br>now</p>`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<p>Fish &amp; chips &amp; peas\u00a0<br/>now</p>"
+	if got := doc.SelectElement("p").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestParseRecover(t *testing.T) {
+	tests := []struct {
+		s, expected string
+		errors      int
+	}{
+		{`<a><b>x & y</b><c/></a>`, `<a><b/><c/></a>`, 1},
+		{`<a><b x=1>text</b><c/></a>`, `<a><c/></a>`, 2},
+		{`<a xmlns:p="urn:p"><p:b><p:c>1 < 2</p:c><p:d/></p:b></a>`, `<a xmlns:p="urn:p"><p:b><p:c>1 </p:c><p:d/></p:b></a>`, 1},
+		{`<a><b>truncated`, `<a><b>truncated</b></a>`, 1},
+		{`<a>x</b></a>`, `<a>x</a>`, 1},
+	}
+	for _, test := range tests {
+		var errs []*ParseError
+		opts := ParserOptions{Recover: func(err *ParseError) { errs = append(errs, err) }}
+		doc, err := ParseWithOptions(strings.NewReader(test.s), opts)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if got := doc.SelectElement("a").OutputXML(true); got != test.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", test.s, test.expected, got)
+		}
+		if len(errs) != test.errors {
+			t.Errorf("%s: expected %d errors, got %v", test.s, test.errors, errs)
+		}
+		checkTreeInvariants(t, doc)
+	}
+}
+
+func TestOutputXMLMinified(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?>
+<!-- books -->
+<books>
+	<book id="1">
+		<title> Go </title>
+		<!-- out of print -->
+		<code xml:space="preserve">
+	<x/> </code>
+		<empty>  </empty>
+		<raw><![CDATA[  ]]></raw>
+	</book>
+</books>
+`)
+	expected := `<?xml version="1.0"?><!-- books --><books><book id="1"><title> Go </title><!-- out of print --><code xml:space="preserve">&#xA;&#x9;<x/> </code><empty>  </empty><raw><![CDATA[  ]]></raw></book></books>`
+	if got := doc.OutputXMLMinified(false, false); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	expected = `<books><book id="1"><title> Go </title><code xml:space="preserve">&#xA;&#x9;<x/> </code><empty>  </empty><raw><![CDATA[  ]]></raw></book></books>`
+	if got := doc.SelectElement("books").OutputXMLMinified(true, true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	// Whitespace in mixed content is text.
+	doc = loadXML(`<p>Hello <b>big</b> <i>world</i></p>`)
+	expected = `<p>Hello <b>big</b> <i>world</i></p>`
+	if got := doc.SelectElement("p").OutputXMLMinified(true, false); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
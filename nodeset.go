@@ -0,0 +1,252 @@
+package xmlquery
+
+import (
+	"sort"
+)
+
+// Union returns the nodes found in any of the given slices, without
+// duplicates, in document order. Attribute nodes returned by different
+// queries for the same attribute count as the same node.
+func Union(sets ...[]*Node) []*Node {
+	seen := make(map[nodeKey]bool)
+	var nodes []*Node
+	for _, set := range sets {
+		for _, n := range set {
+			if k := keyOf(n); !seen[k] {
+				seen[k] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	sortDocumentOrder(nodes)
+	return nodes
+}
+
+// Intersect returns the nodes of a that are also in b, without duplicates,
+// in document order.
+func Intersect(a, b []*Node) []*Node {
+	return filterSet(a, b, true)
+}
+
+// Except returns the nodes of a that are not in b, without duplicates, in
+// document order.
+func Except(a, b []*Node) []*Node {
+	return filterSet(a, b, false)
+}
+
+func filterSet(a, b []*Node, keep bool) []*Node {
+	inB := make(map[nodeKey]bool, len(b))
+	for _, n := range b {
+		inB[keyOf(n)] = true
+	}
+	seen := make(map[nodeKey]bool)
+	var nodes []*Node
+	for _, n := range a {
+		if k := keyOf(n); inB[k] == keep && !seen[k] {
+			seen[k] = true
+			nodes = append(nodes, n)
+		}
+	}
+	sortDocumentOrder(nodes)
+	return nodes
+}
+
+// nodeKey identifies a node within sets of nodes. Queries return a new
+// AttributeNode each time they select an attribute, so attribute nodes are
+// identified by their owner element and the index of the attribute.
+type nodeKey struct {
+	n    *Node
+	attr int
+}
+
+func keyOf(n *Node) nodeKey {
+	if i := attrIndex(n); i >= 0 {
+		return nodeKey{n.Parent, i}
+	}
+	return nodeKey{n, -1}
+}
+
+// Sorts nodes in document order. Nodes of different trees are grouped by
+// tree, in the order the trees first appear.
+func sortDocumentOrder(nodes []*Node) {
+	trees := make(map[*Node]int)
+	rank := make([]int, len(nodes))
+	for i, n := range nodes {
+		root := n
+		for root.Parent != nil {
+			root = root.Parent
+		}
+		r, ok := trees[root]
+		if !ok {
+			r = len(trees)
+			trees[root] = r
+		}
+		rank[i] = r
+	}
+	sort.Sort(byDocumentOrder{nodes, rank})
+}
+
+type byDocumentOrder struct {
+	nodes []*Node
+	rank  []int
+}
+
+func (s byDocumentOrder) Len() int { return len(s.nodes) }
+
+func (s byDocumentOrder) Swap(i, j int) {
+	s.nodes[i], s.nodes[j] = s.nodes[j], s.nodes[i]
+	s.rank[i], s.rank[j] = s.rank[j], s.rank[i]
+}
+
+func (s byDocumentOrder) Less(i, j int) bool {
+	if s.rank[i] != s.rank[j] {
+		return s.rank[i] < s.rank[j]
+	}
+	return compareDocumentOrder(s.nodes[i], s.nodes[j]) < 0
+}
+
+// Returns -1, 0 or 1 depending on whether a comes before, is, or comes after
+// b in their document, which must be the same. Ancestors come before their
+// descendants.
+func compareDocumentOrder(a, b *Node) int {
+	if a == b {
+		return 0
+	}
+	depth := func(n *Node) int {
+		d := 0
+		for ; n.Parent != nil; n = n.Parent {
+			d++
+		}
+		return d
+	}
+	da, db := depth(a), depth(b)
+	pa, pb := a, b
+	for ; da > db; da-- {
+		pa = pa.Parent
+	}
+	for ; db > da; db-- {
+		pb = pb.Parent
+	}
+	if pa == pb {
+		// One is an ancestor of the other.
+		if pa == a {
+			return -1
+		}
+		return 1
+	}
+	for pa.Parent != pb.Parent {
+		pa, pb = pa.Parent, pb.Parent
+	}
+	// Attributes follow their element and precede its children.
+	if pa.Type == AttributeNode || pb.Type == AttributeNode {
+		if pa.Type != AttributeNode {
+			return 1
+		}
+		if pb.Type != AttributeNode {
+			return -1
+		}
+		ia, ib := attrIndex(pa), attrIndex(pb)
+		switch {
+		case ia < ib:
+			return -1
+		case ia > ib:
+			return 1
+		}
+		return 0
+	}
+	for n := pa.NextSibling; n != nil; n = n.NextSibling {
+		if n == pb {
+			return -1
+		}
+	}
+	return 1
+}
+
+// CompareDocumentOrder returns -1, 0 or 1 depending on whether n comes
+// before, is, or comes after other in document order. Ancestors come before
+// their descendants. Both nodes must belong to the same tree.
+func (n *Node) CompareDocumentOrder(other *Node) int {
+	return compareDocumentOrder(n, other)
+}
+
+// NodeList is a list of nodes with helpers for post-processing query
+// results, e.g.:
+//
+//	titles := xmlquery.NodeList(xmlquery.Find(doc, "//title")).Unique().Texts()
+type NodeList []*Node
+
+// SortInDocumentOrder sorts l in place in document order, grouping the
+// nodes of different trees by tree, and returns it.
+func (l NodeList) SortInDocumentOrder() NodeList {
+	sortDocumentOrder(l)
+	return l
+}
+
+// Unique returns the nodes of l without duplicates, keeping the first
+// occurrence of each. Attribute nodes standing for the same attribute are
+// duplicates.
+func (l NodeList) Unique() NodeList {
+	seen := make(map[nodeKey]bool, len(l))
+	var nodes NodeList
+	for _, n := range l {
+		if k := keyOf(n); !seen[k] {
+			seen[k] = true
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Texts returns the inner text of every node of l.
+func (l NodeList) Texts() []string {
+	texts := make([]string, len(l))
+	for i, n := range l {
+		texts[i] = n.InnerText()
+	}
+	return texts
+}
+
+// Attrs returns the values of the attribute key of the nodes of l that have
+// one, key being a name as accepted by GetAttr.
+func (l NodeList) Attrs(key string) []string {
+	var values []string
+	for _, n := range l {
+		if v, ok := n.GetAttr(key); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// First returns the first node of l, or nil if l is empty.
+func (l NodeList) First() *Node {
+	if len(l) == 0 {
+		return nil
+	}
+	return l[0]
+}
+
+// Filter returns the nodes of l for which keep returns true.
+func (l NodeList) Filter(keep func(*Node) bool) NodeList {
+	var nodes NodeList
+	for _, n := range l {
+		if keep(n) {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Map returns the nodes returned by fn for the nodes of l, leaving out nil
+// results, as in:
+//
+//	parents := list.Map(func(n *xmlquery.Node) *xmlquery.Node { return n.Parent }).Unique()
+func (l NodeList) Map(fn func(*Node) *Node) NodeList {
+	var nodes NodeList
+	for _, n := range l {
+		if m := fn(n); m != nil {
+			nodes = append(nodes, m)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,114 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeSets(t *testing.T) {
+	doc := loadXML(`<r><a id="1"><b id="2"/></a><a id="3"><b id="4"/><c id="5"/></a></r>`)
+	ids := func(nodes []*Node) string {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, n.SelectAttr("id"))
+		}
+		return strings.Join(s, ",")
+	}
+	as, bs := Find(doc, "//a"), Find(doc, "//b")
+	second := Find(doc, "//*[@id>=3]")
+	reversed := []*Node{bs[1], as[1], bs[0], as[0], bs[1]}
+
+	tests := []struct {
+		name     string
+		got      []*Node
+		expected string
+	}{
+		{"union", Union(bs, as, bs), "1,2,3,4"},
+		{"union of reversed", Union(reversed), "1,2,3,4"},
+		{"intersect", Intersect(reversed, second), "3,4"},
+		{"except", Except(reversed, second), "1,2"},
+		{"except nothing", Except(second, nil), "3,4,5"},
+	}
+	for _, test := range tests {
+		if got := ids(test.got); got != test.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", test.name, test.expected, got)
+		}
+	}
+
+	attrs := Find(doc, "//@id")
+	if n := len(Union(attrs, Find(doc, "//@id"))); n != 5 {
+		t.Errorf("expected 5 attributes in the union of two queries, got %d", n)
+	}
+	if n := len(Intersect(attrs, Find(doc, "//a/@id"))); n != 2 {
+		t.Errorf("expected 2 attributes in the intersection, got %d", n)
+	}
+	if n := len(Except(attrs, Find(doc, "//@id"))); n != 0 {
+		t.Errorf("expected no attributes left, got %d", n)
+	}
+	if n := len(NodeList(append(attrs, Find(doc, "//b/@id")...)).Unique()); n != 5 {
+		t.Errorf("expected 5 unique attributes, got %d", n)
+	}
+
+	other := loadXML(`<r><a id="x"/></r>`)
+	if got := ids(Union(Find(other, "//a"), bs, Find(other, "//r"))); got != ",x,2,4" {
+		t.Fatalf("expected nodes grouped by document, but got %s", got)
+	}
+}
+
+func TestCompareDocumentOrder(t *testing.T) {
+	doc := loadXML(`<a><b><c/></b><d/></a>`)
+	a, b, c, d := FindOne(doc, "//a"), FindOne(doc, "//b"), FindOne(doc, "//c"), FindOne(doc, "//d")
+	tests := []struct {
+		x, y     *Node
+		expected int
+	}{
+		{a, a, 0}, {a, c, -1}, {c, a, 1}, {b, d, -1}, {d, c, 1}, {c, d, -1},
+	}
+	doc = loadXML(`<a x="1" y="2"><b/></a>`)
+	a, b = FindOne(doc, "//a"), FindOne(doc, "//b")
+	x, y := FindOne(doc, "//@x"), FindOne(doc, "//@y")
+	tests = append(tests, []struct {
+		x, y     *Node
+		expected int
+	}{
+		{x, y, -1}, {y, x, 1}, {x, FindOne(doc, "//@x"), 0}, {a, x, -1}, {x, a, 1}, {y, b, -1}, {b, y, 1},
+	}...)
+	for _, test := range tests {
+		if got := test.x.CompareDocumentOrder(test.y); got != test.expected {
+			t.Errorf("%s vs %s: expected %d, got %d", test.x.Data, test.y.Data, test.expected, got)
+		}
+	}
+}
+
+func TestNodeList(t *testing.T) {
+	doc := loadXML(`<list><item id="1">a</item><item>b</item><item id="3">c</item></list>`)
+	items := NodeList(Find(doc, "//item"))
+	shuffled := NodeList{items[2], items[0], items[2], items[1], items[0]}
+
+	if got := strings.Join(shuffled.Unique().Texts(), ","); got != "c,a,b" {
+		t.Errorf("Unique: expected c,a,b, got %s", got)
+	}
+	if got := strings.Join(shuffled.Unique().SortInDocumentOrder().Texts(), ","); got != "a,b,c" {
+		t.Errorf("SortInDocumentOrder: expected a,b,c, got %s", got)
+	}
+	if got := strings.Join(items.Attrs("id"), ","); got != "1,3" {
+		t.Errorf("Attrs: expected 1,3, got %s", got)
+	}
+	if got := items.First(); got != items[0] {
+		t.Errorf("First: expected the first item, got %v", got)
+	}
+	if got := NodeList(nil).First(); got != nil {
+		t.Errorf("First: expected nil, got %v", got)
+	}
+	withID := items.Filter(func(n *Node) bool { return n.SelectAttr("id") != "" })
+	if got := strings.Join(withID.Texts(), ","); got != "a,c" {
+		t.Errorf("Filter: expected a,c, got %s", got)
+	}
+	parents := items.Map(func(n *Node) *Node { return n.Parent }).Unique()
+	if len(parents) != 1 || parents[0].Data != "list" {
+		t.Errorf("Map: expected the list element, got %v", parents)
+	}
+	if got := items.Map(func(n *Node) *Node { return n.PrevSibling }); len(got) != 2 {
+		t.Errorf("Map: expected nil results to be left out, got %d nodes", len(got))
+	}
+}
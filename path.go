@@ -0,0 +1,166 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path returns an XPath expression selecting n and only n in its tree, such
+// as "/root/items/item[3]/@id", for use in logs and reports. Steps have a
+// position only when siblings share the name of the node; text, comments
+// and processing instructions are selected with text(), comment() and
+// processing-instruction('target'). Element names keep their prefix, so
+// evaluating the path with namespaces needs the prefixes of the document.
+// The path of a document is "/"; in a tree without document node, the
+// first step is the root element. The XML declaration and the DOCTYPE,
+// which XPath does not see, have no path and yield an empty string.
+func (n *Node) Path() string {
+	if n.Type == DocumentNode {
+		return "/"
+	}
+	var steps []string
+	for ; n != nil && n.Type != DocumentNode; n = n.Parent {
+		step := pathStep(n)
+		if step == "" {
+			return ""
+		}
+		steps = append(steps, step)
+	}
+	var buf strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		buf.WriteByte('/')
+		buf.WriteString(steps[i])
+	}
+	return buf.String()
+}
+
+// Returns the step selecting n from its parent.
+func pathStep(n *Node) string {
+	var test string
+	switch n.Type {
+	case ElementNode:
+		test = qualifiedName(n)
+	case AttributeNode:
+		return "@" + qualifiedName(n)
+	case TextNode:
+		test = "text()"
+	case CommentNode:
+		test = "comment()"
+	case ProcInstNode:
+		test = "processing-instruction('" + n.Data + "')"
+	default:
+		return ""
+	}
+	pos, count := 1, 1
+	for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
+		if samePathTest(sib, n) {
+			pos++
+			count++
+		}
+	}
+	for sib := n.NextSibling; sib != nil && count == 1; sib = sib.NextSibling {
+		if samePathTest(sib, n) {
+			count++
+		}
+	}
+	if count > 1 {
+		test += "[" + strconv.Itoa(pos) + "]"
+	}
+	return test
+}
+
+// Reports whether the step selecting n also matches the sibling sib.
+func samePathTest(sib, n *Node) bool {
+	if sib.Type != n.Type {
+		return false
+	}
+	switch n.Type {
+	case ElementNode:
+		return sib.Data == n.Data && sib.Prefix == n.Prefix
+	case ProcInstNode:
+		return sib.Data == n.Data
+	}
+	return true
+}
+
+// ResolvePath returns the node of the tree of n selected by path, a path as
+// returned by Path, so that a place in a document can be found again after
+// it is saved and parsed anew. Steps without a position select the first
+// matching node. Only the forms of step written by Path are understood; use
+// Query for other XPath expressions.
+func (n *Node) ResolvePath(path string) (*Node, error) {
+	top := treeRoot(n)
+	if path == "/" && top.Type == DocumentNode {
+		return top, nil
+	}
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("xmlquery: invalid path %q", path)
+	}
+	steps := strings.Split(path[1:], "/")
+	cur := top
+	if top.Type != DocumentNode {
+		// The first step selects the root element itself.
+		cur = &Node{Type: DocumentNode, FirstChild: top, LastChild: top}
+	}
+	for i, step := range steps {
+		if strings.HasPrefix(step, "@") {
+			if i != len(steps)-1 || cur.Type != ElementNode {
+				return nil, fmt.Errorf("xmlquery: invalid path %q", path)
+			}
+			j := cur.attrIndexByName(step[1:])
+			if j < 0 {
+				return nil, fmt.Errorf("xmlquery: no node at step %q of path %q", step, path)
+			}
+			return newAttributeNode(cur, j), nil
+		}
+		typ, name, pos, ok := parsePathStep(step)
+		if !ok {
+			return nil, fmt.Errorf("xmlquery: invalid step %q in path %q", step, path)
+		}
+		var next *Node
+		for child := cur.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != typ || (typ == ElementNode && qualifiedName(child) != name) || (typ == ProcInstNode && name != "" && child.Data != name) {
+				continue
+			}
+			if pos--; pos == 0 {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("xmlquery: no node at step %q of path %q", step, path)
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// Splits a step written by Path into the type and name of the nodes it
+// selects and the position of the node among them.
+func parsePathStep(step string) (typ NodeType, name string, pos int, ok bool) {
+	pos = 1
+	if strings.HasSuffix(step, "]") {
+		i := strings.LastIndexByte(step, '[')
+		if i < 0 {
+			return 0, "", 0, false
+		}
+		n, err := strconv.Atoi(step[i+1 : len(step)-1])
+		if err != nil || n < 1 {
+			return 0, "", 0, false
+		}
+		step, pos = step[:i], n
+	}
+	switch {
+	case step == "text()":
+		return TextNode, "", pos, true
+	case step == "comment()":
+		return CommentNode, "", pos, true
+	case strings.HasPrefix(step, "processing-instruction(") && strings.HasSuffix(step, ")"):
+		name = strings.Trim(step[len("processing-instruction("):len(step)-1], `'"`)
+		return ProcInstNode, name, pos, true
+	case step == "" || strings.ContainsAny(step, "()[]'\" *"):
+		return 0, "", 0, false
+	}
+	return ElementNode, step, pos, true
+}
@@ -0,0 +1,91 @@
+package xmlquery
+
+import "testing"
+
+func TestPath(t *testing.T) {
+	doc := loadXML(`<root xmlns:x="urn:x"><items><item id="1"/><item id="2"/>text<item id="3">a<!--c-->b</item><x:other/></items><?pi data?></root>`)
+	for expr, expected := range map[string]string{
+		"/":                         "/",
+		"//items":                   "/root/items",
+		"//item[3]":                 "/root/items/item[3]",
+		"//item[3]/@id":             "/root/items/item[3]/@id",
+		"//item[3]/text()[2]":       "/root/items/item[3]/text()[2]",
+		"//item[3]/comment()":       "/root/items/item[3]/comment()",
+		"//items/text()":            "/root/items/text()",
+		"//*[local-name()='other']": "/root/items/x:other",
+	} {
+		n := FindOne(doc, expr)
+		if n == nil {
+			t.Fatalf("%s: no node", expr)
+		}
+		if got := n.Path(); got != expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", expr, expected, got)
+		}
+		if expected == "/" || n.Prefix != "" {
+			continue
+		}
+		if again := FindOne(doc, expected); again != n && (n.Type != AttributeNode || again.Parent != n.Parent) {
+			t.Errorf("%s does not select the node back", expected)
+		}
+	}
+	if got, expected := doc.SelectElement("root").LastChild.Path(), "/root/processing-instruction('pi')"; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got := doc.FirstChild.Path(); got != "" {
+		t.Errorf("expected no path for the declaration, got %q", got)
+	}
+
+	item := FindOne(doc, "//item[2]")
+	item.Detach()
+	if got, expected := item.Path(), "/item"; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got, expected := FindOne(doc, "//item[2]").Path(), "/root/items/item[2]"; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	const s = `<root xmlns:x="urn:x"><items><item id="1"/><item id="2"/>text<item id="3">a<!--c-->b</item><x:other/></items><?pi data?></root>`
+	doc := loadXML(s)
+	var paths []string
+	doc.Walk(func(n *Node) WalkDecision {
+		if p := n.Path(); p != "" {
+			paths = append(paths, p)
+		}
+		return Continue
+	})
+	paths = append(paths, "/root/items/item[3]/@id")
+
+	// The paths lead to the same places in a new copy of the document.
+	again := loadXML(s)
+	for _, p := range paths {
+		n, err := again.ResolvePath(p)
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+			continue
+		}
+		if got := n.Path(); got != p {
+			t.Errorf("\nexpected: %s\ngot:      %s", p, got)
+		}
+	}
+	if n, err := again.ResolvePath("/root/items/item[3]/@id"); err != nil || n.Type != AttributeNode || n.InnerText() != "3" {
+		t.Errorf("unexpected attribute %v, %v", n, err)
+	}
+	if n, err := FindOne(again, "//item").ResolvePath("/root/items/item[1]/@id"); err != nil || n.InnerText() != "1" {
+		t.Errorf("unexpected attribute %v, %v", n, err)
+	}
+
+	items := FindOne(again, "//items")
+	items.Detach()
+	if n, err := items.ResolvePath("/items/x:other"); err != nil || n.Data != "other" {
+		t.Errorf("unexpected node %v, %v", n, err)
+	}
+
+	for _, p := range []string{"", "root", "/root/missing", "/root/items/item[4]", "/root/items/item[0]",
+		"/root/@nope", "/root/@xmlns:x/a", "/root/items/item[x]", "/root//items", "/root/items/*"} {
+		if n, err := doc.ResolvePath(p); err == nil {
+			t.Errorf("%q: expected an error, got %v", p, n)
+		}
+	}
+}
@@ -5,25 +5,65 @@ package xmlquery
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/gjvnq/xpath"
 )
 
-// SelectElements finds child elements with the specified name.
+// SelectElements finds child elements with the specified name, whose prefix,
+// if any, must match exactly as in XPath name tests. Plain names are looked
+// up directly, without going through XPath; anything else is evaluated as an
+// expression, like Find does.
 func (n *Node) SelectElements(name string) []*Node {
-	return Find(n, name)
+	if !isPlainName(name) {
+		return Find(n, name)
+	}
+	var elems []*Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.hasName(name) {
+			elems = append(elems, child)
+		}
+	}
+	return elems
 }
 
-// SelectElement finds child elements with the specified name.
+// SelectElement finds the first child element with the specified name. It
+// accepts the same names as SelectElements.
 func (n *Node) SelectElement(name string) *Node {
-	return FindOne(n, name)
+	if !isPlainName(name) {
+		return FindOne(n, name)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.hasName(name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Returns true if name is an element name, with an optional prefix, rather
+// than an XPath expression.
+func isPlainName(name string) bool {
+	colon := false
+	for i, c := range name {
+		switch {
+		case unicode.IsLetter(c) || c == '_':
+		case i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.'):
+		case c == ':' && i > 0 && !colon && i < len(name)-1:
+			colon = true
+		default:
+			return false
+		}
+	}
+	return name != ""
 }
 
 // SelectAttr returns the attribute value with the specified name.
 func (n *Node) SelectAttr(name string) string {
 	if n.Type == AttributeNode {
-		if n.Data == name {
+		if n.Data == name || (n.Prefix != "" && n.Prefix+":"+n.Data == name) {
 			return n.InnerText()
 		}
 		return ""
@@ -45,52 +85,121 @@ func (n *Node) SelectAttr(name string) string {
 var _ xpath.NodeNavigator = &NodeNavigator{}
 
 // CreateXPathNavigator creates a new xpath.NodeNavigator for the specified html.Node.
+// An attribute node returned by a previous query is navigated as the
+// attribute of its owning element.
 func CreateXPathNavigator(top *Node) *NodeNavigator {
+	if i := attrIndex(top); i >= 0 {
+		return &NodeNavigator{curr: top.Parent, root: top.Parent, attr: i}
+	}
 	return &NodeNavigator{curr: top, root: top, attr: -1}
 }
 
 func getCurrentNode(it *xpath.NodeIterator) *Node {
 	n := it.Current().(*NodeNavigator)
-	if n.NodeType() == xpath.AttributeNode {
-		childNode := &Node{
-			Type: TextNode,
-			Data: n.Value(),
-		}
-		return &Node{
-			Type:       AttributeNode,
-			Data:       n.LocalName(),
-			FirstChild: childNode,
-			LastChild:  childNode,
-		}
+	if n.attr != -1 {
+		return newAttributeNode(n.curr, n.attr)
 	}
 	return n.curr
 }
 
 // Find searches the Node that matches by the specified XPath expr.
+// It panics if expr is not a valid XPath expression; use QueryAll to get an
+// error instead.
 func Find(top *Node, expr string) []*Node {
-	exp, err := xpath.Compile(expr)
+	elems, err := QueryAll(top, expr)
 	if err != nil {
 		panic(err)
 	}
-	t := exp.Select(CreateXPathNavigator(top))
-	var elems []*Node
-	for t.MoveNext() {
-		elems = append(elems, getCurrentNode(t))
-	}
 	return elems
 }
 
 // FindOne searches the Node that matches by the specified XPath expr,
-// and returns first element of matched.
+// and returns first element of matched. It panics if expr is not a valid
+// XPath expression; use Query to get an error instead.
 func FindOne(top *Node, expr string) *Node {
-	exp, err := xpath.Compile(expr)
+	elem, err := Query(top, expr)
 	if err != nil {
 		panic(err)
 	}
+	return elem
+}
+
+// QueryAll searches the Nodes that match the specified XPath expr, in
+// document order, returning an error if expr is not a valid XPath expression
+// or cannot be evaluated.
+func QueryAll(top *Node, expr string) (elems []*Node, err error) {
+	if nodes, ok := indexedQuery(top, expr); ok {
+		return nodes, nil
+	}
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(expr, &err)
+	return selectNodes(top, exp), nil
+}
+
+// Query searches the first Node that matches the specified XPath expr,
+// returning nil if nothing matches and an error if expr is not a valid
+// XPath expression or cannot be evaluated.
+func Query(top *Node, expr string) (elem *Node, err error) {
+	if nodes, ok := indexedQuery(top, expr); ok {
+		if len(nodes) == 0 {
+			return nil, nil
+		}
+		return nodes[0], nil
+	}
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(expr, &err)
 	t := exp.Select(CreateXPathNavigator(top))
-	var elem *Node
 	if t.MoveNext() {
-		elem = getCurrentNode(t)
+		return getCurrentNode(t), nil
+	}
+	return nil, nil
+}
+
+// Evaluate evaluates expr with top as the context node and returns its
+// value: a float64 for numbers, as returned by count() or sum(), a string
+// for strings, a bool for booleans, or a []*Node for node sets. It returns
+// an error if expr is not a valid XPath expression or cannot be evaluated.
+func Evaluate(top *Node, expr string) (value interface{}, err error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(expr, &err)
+	switch v := exp.Evaluate(CreateXPathNavigator(top)).(type) {
+	case *xpath.NodeIterator:
+		var nodes []*Node
+		for v.MoveNext() {
+			nodes = append(nodes, getCurrentNode(v))
+		}
+		return nodes, nil
+	default:
+		return v, nil
+	}
+}
+
+// recoverEval turns a panic of the xpath engine into an error stored in
+// *err; it must be deferred. The engine panics on some valid expressions,
+// for example when it compares text that is not a number with a number,
+// as in //a[. > 1].
+func recoverEval(expr string, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("xmlquery: evaluating %q: %v", expr, r)
+	}
+}
+
+// MustQuery returns the first node matched by expr, or nil if nothing
+// matches. It panics if expr is not a valid XPath expression or cannot be
+// evaluated.
+func (n *Node) MustQuery(expr string) *Node {
+	elem, err := Query(n, expr)
+	if err != nil {
+		panic(err)
 	}
 	return elem
 }
@@ -117,6 +226,15 @@ func FindEachWithBreak(top *Node, expr string, cb func(int, *Node) bool) {
 type NodeNavigator struct {
 	root, curr *Node
 	attr       int
+	prefixes   map[string]string // namespace URI to query prefix, see CreateXPathNavigatorNS
+
+	// The calls to registered functions of the expression evaluated, listed
+	// as attributes of the context node before its own (see funcCall); call
+	// is one plus the index of the call the navigator is on, or 0, and
+	// called is set once the calls were listed for the context node.
+	calls  []*funcCall
+	call   int
+	called bool
 }
 
 func (x *NodeNavigator) Current() *Node {
@@ -124,23 +242,31 @@ func (x *NodeNavigator) Current() *Node {
 }
 
 func (x *NodeNavigator) NodeType() xpath.NodeType {
+	if x.call > 0 {
+		return xpath.AttributeNode
+	}
 	switch x.curr.Type {
 	case CommentNode:
 		return xpath.CommentNode
 	case TextNode:
 		return xpath.TextNode
-	case DeclarationNode, DocumentNode:
+	case DeclarationNode, DocumentNode, ProcInstNode, DoctypeNode:
 		return xpath.RootNode
 	case ElementNode:
 		if x.attr != -1 {
 			return xpath.AttributeNode
 		}
 		return xpath.ElementNode
+	case AttributeNode:
+		return xpath.AttributeNode
 	}
 	panic(fmt.Sprintf("unknown XML node type: %v", x.curr.Type))
 }
 
 func (x *NodeNavigator) LocalName() string {
+	if x.call > 0 {
+		return funcAttrPrefix + strconv.Itoa(x.call-1)
+	}
 	if x.attr != -1 {
 		return x.curr.Attr[x.attr].Name.Local
 	}
@@ -149,16 +275,30 @@ func (x *NodeNavigator) LocalName() string {
 }
 
 func (x *NodeNavigator) Prefix() string {
+	if x.call > 0 {
+		return ""
+	}
 	if x.NodeType() == xpath.AttributeNode {
-		if x.attr != -1 {
-			return x.curr.Attr[x.attr].Name.Space
+		if x.attr == -1 {
+			return x.curr.Prefix
 		}
-		return ""
+		if x.prefixes != nil {
+			return x.prefixes[x.curr.AttrNamespaceURI(x.attr)]
+		}
+		return x.curr.Attr[x.attr].Name.Space
+	}
+	if x.prefixes != nil && x.curr.Type == ElementNode {
+		return x.prefixes[elementNamespaceURI(x.curr)]
 	}
 	return x.curr.Prefix
 }
 
 func (x *NodeNavigator) Value() string {
+	if x.call > 0 {
+		ctx := *x
+		ctx.call = 0
+		return x.calls[x.call-1].value(&ctx)
+	}
 	switch x.curr.Type {
 	case CommentNode:
 		return x.curr.Data
@@ -169,22 +309,35 @@ func (x *NodeNavigator) Value() string {
 		return x.curr.InnerText()
 	case TextNode:
 		return x.curr.Data
+	case AttributeNode:
+		return x.curr.InnerText()
 	}
 	return ""
 }
 
 func (x *NodeNavigator) Copy() xpath.NodeNavigator {
 	n := *x
+	if n.call == 0 {
+		// The copy is a new context node, whose calls are yet to be listed.
+		n.called = false
+	}
 	return &n
 }
 
 func (x *NodeNavigator) MoveToRoot() {
 	x.curr = x.root
+	x.attr = -1
+	x.call, x.called = 0, false
 }
 
 func (x *NodeNavigator) MoveToParent() bool {
+	if x.call > 0 {
+		x.call, x.called = 0, false
+		return true
+	}
 	if x.attr != -1 {
 		x.attr = -1
+		x.called = false
 		return true
 	} else if node := x.curr.Parent; node != nil {
 		x.curr = node
@@ -194,15 +347,20 @@ func (x *NodeNavigator) MoveToParent() bool {
 }
 
 func (x *NodeNavigator) MoveToNextAttribute() bool {
+	if !x.called && x.call < len(x.calls) {
+		x.call++
+		return true
+	}
 	if x.attr >= len(x.curr.Attr)-1 {
 		return false
 	}
 	x.attr++
+	x.call, x.called = 0, true
 	return true
 }
 
 func (x *NodeNavigator) MoveToChild() bool {
-	if x.attr != -1 {
+	if x.attr != -1 || x.call > 0 || x.curr.Type == AttributeNode {
 		return false
 	}
 	if node := x.curr.FirstChild; node != nil {
@@ -213,7 +371,7 @@ func (x *NodeNavigator) MoveToChild() bool {
 }
 
 func (x *NodeNavigator) MoveToFirst() bool {
-	if x.attr != -1 || x.curr.PrevSibling == nil {
+	if x.attr != -1 || x.call > 0 || x.curr.PrevSibling == nil {
 		return false
 	}
 	for {
@@ -231,7 +389,7 @@ func (x *NodeNavigator) String() string {
 }
 
 func (x *NodeNavigator) MoveToNext() bool {
-	if x.attr != -1 {
+	if x.attr != -1 || x.call > 0 {
 		return false
 	}
 	if node := x.curr.NextSibling; node != nil {
@@ -242,7 +400,7 @@ func (x *NodeNavigator) MoveToNext() bool {
 }
 
 func (x *NodeNavigator) MoveToPrevious() bool {
-	if x.attr != -1 {
+	if x.attr != -1 || x.call > 0 {
 		return false
 	}
 	if node := x.curr.PrevSibling; node != nil {
@@ -260,5 +418,6 @@ func (x *NodeNavigator) MoveTo(other xpath.NodeNavigator) bool {
 
 	x.curr = node.curr
 	x.attr = node.attr
+	x.call, x.called = node.call, node.called
 	return true
 }
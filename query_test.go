@@ -2,6 +2,7 @@ package xmlquery
 
 import (
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -90,6 +91,157 @@ func TestXPathCdUp(t *testing.T) {
 	}
 }
 
+func TestQuery(t *testing.T) {
+	list, err := QueryAll(doc, "//book/title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[2].InnerText() != "Maeve Ascendant" {
+		t.Fatal("//book/title did not return the titles in document order")
+	}
+	ids, err := QueryAll(doc, "//book/@id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[1].Type != AttributeNode || ids[1].InnerText() != "bk102" {
+		t.Fatal("//book/@id did not return attribute nodes")
+	}
+	node, err := Query(doc, "//book[genre='Fantasy']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node == nil || node.SelectAttr("id") != "bk102" {
+		t.Fatal("//book[genre='Fantasy'] is not bk102")
+	}
+	if node, err := Query(doc, "//magazine"); node != nil || err != nil {
+		t.Fatal("//magazine should match nothing")
+	}
+	if _, err := Query(doc, "//book["); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+	if _, err := QueryAll(doc, "//book["); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+}
+
+func TestQueryEvaluationError(t *testing.T) {
+	// The xpath engine panics comparing text that is not a number with one.
+	doc := loadXML(`<r><a>x</a></r>`)
+	const expr = "//a[. > 1]"
+	if _, err := QueryAll(doc, expr); err == nil {
+		t.Error("QueryAll: expected an error")
+	}
+	if _, err := Query(doc, expr); err == nil {
+		t.Error("Query: expected an error")
+	}
+	if _, err := Evaluate(doc, expr); err == nil {
+		t.Error("Evaluate: expected an error")
+	}
+	if _, err := QueryAs[string](doc, expr); err == nil {
+		t.Error("QueryAs: expected an error")
+	}
+	if _, err := QueryAllNS(doc, expr, nil); err == nil {
+		t.Error("QueryAllNS: expected an error")
+	}
+	if err := doc.MarkSensitive(expr); err == nil {
+		t.Error("MarkSensitive: expected an error")
+	}
+	if list, err := QueryAll(doc, "//a"); err != nil || len(list) != 1 {
+		t.Errorf("the document cannot be queried after an error: %v", err)
+	}
+}
+
+func TestAttributeNodes(t *testing.T) {
+	doc := loadXML(`<list xmlns:x="urn:x"><item id="1" x:ref="a"><name/></item><item id="2"/></list>`)
+	ids := Find(doc, "//item/@id")
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 attribute nodes, got %d", len(ids))
+	}
+	id := ids[0]
+	if id.Type != AttributeNode || id.Data != "id" || id.Value() != "1" {
+		t.Fatalf("unexpected attribute node %q=%q", id.Data, id.Value())
+	}
+	if id.Parent != FindOne(doc, "//item[1]") {
+		t.Fatal("attribute node is not owned by its element")
+	}
+	if n := FindOne(id, ".."); n != id.Parent {
+		t.Fatal("querying .. from an attribute node did not return its element")
+	}
+	if n := FindOne(id, "../name"); n == nil || n.Data != "name" {
+		t.Fatal("querying ../name from an attribute node failed")
+	}
+	ref := FindOne(doc, "//@x:ref")
+	if ref == nil || ref.Prefix != "x" || ref.NamespaceURI != "urn:x" || ref.SelectAttr("x:ref") != "a" {
+		t.Fatalf("unexpected namespaced attribute node %#v", ref)
+	}
+	if at := doc.At("list", "item", "@x:ref"); at == nil || at.Data != "ref" || at.NamespaceURI != "urn:x" {
+		t.Fatal("At did not return the namespaced attribute node")
+	}
+
+	all := Find(doc, "//item/@* | //name")
+	var got []string
+	for _, n := range all {
+		got = append(got, n.Data)
+	}
+	if s := strings.Join(got, ","); s != "id,ref,name,id" {
+		t.Fatalf("\nexpected: %s\ngot:      %s", "id,ref,name,id", s)
+	}
+	if id.CompareDocumentOrder(Find(doc, "//item/@id")[0]) != 0 {
+		t.Fatal("two attribute nodes for the same attribute do not compare equal")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"count(//book)", float64(3)},
+		{"sum(//book[genre='Fantasy']/price)", 5.95 * 2},
+		{"concat(//book[1]/genre, '/', //book[2]/genre)", "Computer/Fantasy"},
+		{"//book[1]/price > 40", true},
+		{"boolean(//magazine)", false},
+	}
+	for _, test := range tests {
+		got, err := Evaluate(doc, test.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		if got != test.expected {
+			t.Errorf("%s: expected %#v, got %#v", test.expr, test.expected, got)
+		}
+	}
+
+	got, err := Evaluate(doc, "//book[genre='Fantasy']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes, ok := got.([]*Node); !ok || len(nodes) != 2 || nodes[0].SelectAttr("id") != "bk102" {
+		t.Errorf("expected the two fantasy books, got %#v", got)
+	}
+	if _, err := Evaluate(doc, "count(//book"); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+}
+
+func TestEvaluateNodeSetConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				got, err := Evaluate(doc, "//book[price > 40]")
+				if nodes, ok := got.([]*Node); err != nil || !ok || len(nodes) != 1 {
+					t.Errorf("expected one book, got %#v (%v)", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestNavigator(t *testing.T) {
 	nav := &NodeNavigator{curr: doc, root: doc, attr: -1}
 	nav.MoveToChild() // New Line
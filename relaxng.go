@@ -0,0 +1,744 @@
+package xmlquery
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RelaxNG is a compiled RELAX NG schema. It is safe for concurrent use.
+//
+// Documents are validated with the derivative algorithm of James Clark, so
+// ambiguous and interleaved content models are handled as the
+// specification requires. The datatypes are those built into RELAX NG and
+// the common types of XML Schema, along with any type registered with
+// RegisterValueDecoder.
+type RelaxNG struct {
+	start rngPattern
+}
+
+// ValidationError reports a node of a document that does not match a
+// schema.
+type ValidationError struct {
+	Node *Node
+	Path string // the path of Node, as returned by Node.Path
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Node != nil && e.Node.Line > 0 {
+		return fmt.Sprintf("xmlquery: %s at line %d, column %d in %s", e.Msg, e.Node.Line, e.Node.Column, e.Path)
+	}
+	return fmt.Sprintf("xmlquery: %s in %s", e.Msg, e.Path)
+}
+
+// Validate checks doc, a document or an element, against the schema and
+// returns a *ValidationError for the first node that does not match it.
+// Comments, processing instructions and whitespace between elements are
+// ignored.
+func (s *RelaxNG) Validate(doc *Node) error {
+	root := doc
+	if doc.Type == DocumentNode {
+		root = nil
+		if elems := doc.ChildElements(); len(elems) > 0 {
+			root = elems[0]
+		}
+		if root == nil {
+			return &ValidationError{Node: doc, Path: "/", Msg: "missing root element"}
+		}
+	}
+	p, err := rngValidateElement(s.start, root)
+	if err != nil {
+		return err
+	}
+	if !rngNullable(p) {
+		return &ValidationError{Node: doc, Path: doc.Path(), Msg: "incomplete document"}
+	}
+	return nil
+}
+
+func rngError(n *Node, format string, args ...interface{}) error {
+	return &ValidationError{Node: n, Path: n.Path(), Msg: fmt.Sprintf(format, args...)}
+}
+
+// Returns the derivative of p with respect to the element n.
+func rngValidateElement(p rngPattern, n *Node) (rngPattern, error) {
+	uri := elementNamespaceURI(n)
+	if p = rngStartTagOpenDeriv(p, uri, n.Data); p == rngNotAllowedP {
+		return nil, rngError(n, "element %s not allowed here", qualifiedName(n))
+	}
+	for i, attr := range n.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		if p = rngAttDeriv(p, n.AttrNamespaceURI(i), attr.Name.Local, attr.Value); p == rngNotAllowedP {
+			return nil, rngError(n, "attribute %s not allowed or invalid", xml_name2string(attr.Name))
+		}
+	}
+	if p = rngStartTagCloseDeriv(p); p == rngNotAllowedP {
+		return nil, rngError(n, "missing attribute of element %s", qualifiedName(n))
+	}
+
+	// Adjacent text nodes, such as text and CDATA sections, are one text.
+	hasElements := false
+	for child := n.FirstChild; child != nil && !hasElements; child = child.NextSibling {
+		hasElements = child.Type == ElementNode
+	}
+	if !hasElements {
+		s := n.InnerText()
+		q := rngTextDeriv(p, s)
+		if isXMLSpace(s) {
+			q = rngChoice(p, q)
+		}
+		if q == rngNotAllowedP {
+			return nil, rngError(n, "invalid content %q in element %s", s, qualifiedName(n))
+		}
+		p = q
+	} else {
+		var text strings.Builder
+		var textNode *Node
+		flush := func() error {
+			s := text.String()
+			text.Reset()
+			if isXMLSpace(s) {
+				return nil
+			}
+			if p = rngTextDeriv(p, s); p == rngNotAllowedP {
+				return rngError(textNode, "text not allowed here")
+			}
+			return nil
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Type {
+			case TextNode:
+				if text.Len() == 0 {
+					textNode = child
+				}
+				text.WriteString(child.Data)
+			case ElementNode:
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				var err error
+				if p, err = rngValidateElement(p, child); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	if p = rngEndTagDeriv(p); p == rngNotAllowedP {
+		return nil, rngError(n, "missing content in element %s", qualifiedName(n))
+	}
+	return p, nil
+}
+
+func isXMLSpace(s string) bool {
+	return strings.Trim(s, " \t\r\n") == ""
+}
+
+// Patterns, as in the RELAX NG specification, and the after pattern of the
+// derivative algorithm.
+type rngPattern interface{}
+
+type (
+	rngEmpty       struct{}
+	rngNotAllowed  struct{}
+	rngText        struct{}
+	rngChoiceP     struct{ p1, p2 rngPattern }
+	rngInterleaveP struct{ p1, p2 rngPattern }
+	rngGroupP      struct{ p1, p2 rngPattern }
+	rngOneOrMoreP  struct{ p rngPattern }
+	rngList        struct{ p rngPattern }
+	rngData        struct {
+		dt     *rngDatatype
+		except rngPattern // nil if none
+	}
+	rngValue struct {
+		dt    *rngDatatype
+		value string
+	}
+	rngAttribute struct {
+		nc rngNameClass
+		p  rngPattern
+	}
+	rngElement struct {
+		nc rngNameClass
+		p  rngPattern
+	}
+	rngAfterP struct{ p1, p2 rngPattern }
+	rngRef    struct{ name string } // replaced when the schema is compiled
+)
+
+var (
+	rngEmptyP      = &rngEmpty{}
+	rngNotAllowedP = &rngNotAllowed{}
+	rngTextP       = &rngText{}
+)
+
+func rngChoice(p1, p2 rngPattern) rngPattern {
+	switch {
+	case p1 == rngNotAllowedP:
+		return p2
+	case p2 == rngNotAllowedP:
+		return p1
+	case rngChoiceHas(p1, p2):
+		return p1
+	}
+	return &rngChoiceP{p1, p2}
+}
+
+// Reports whether p is one of the alternatives of the choice c, to keep
+// derivatives from growing with repeated alternatives.
+func rngChoiceHas(c, p rngPattern) bool {
+	if rngEqual(c, p) {
+		return true
+	}
+	if c, ok := c.(*rngChoiceP); ok {
+		return rngChoiceHas(c.p1, p) || rngChoiceHas(c.p2, p)
+	}
+	return false
+}
+
+func rngEqual(a, b rngPattern) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *rngChoiceP:
+		b, ok := b.(*rngChoiceP)
+		return ok && rngEqual(a.p1, b.p1) && rngEqual(a.p2, b.p2)
+	case *rngGroupP:
+		b, ok := b.(*rngGroupP)
+		return ok && rngEqual(a.p1, b.p1) && rngEqual(a.p2, b.p2)
+	case *rngInterleaveP:
+		b, ok := b.(*rngInterleaveP)
+		return ok && rngEqual(a.p1, b.p1) && rngEqual(a.p2, b.p2)
+	case *rngAfterP:
+		b, ok := b.(*rngAfterP)
+		return ok && rngEqual(a.p1, b.p1) && rngEqual(a.p2, b.p2)
+	case *rngOneOrMoreP:
+		b, ok := b.(*rngOneOrMoreP)
+		return ok && rngEqual(a.p, b.p)
+	}
+	return false
+}
+
+func rngGroup(p1, p2 rngPattern) rngPattern {
+	switch {
+	case p1 == rngNotAllowedP || p2 == rngNotAllowedP:
+		return rngNotAllowedP
+	case p1 == rngEmptyP:
+		return p2
+	case p2 == rngEmptyP:
+		return p1
+	}
+	return &rngGroupP{p1, p2}
+}
+
+func rngInterleave(p1, p2 rngPattern) rngPattern {
+	switch {
+	case p1 == rngNotAllowedP || p2 == rngNotAllowedP:
+		return rngNotAllowedP
+	case p1 == rngEmptyP:
+		return p2
+	case p2 == rngEmptyP:
+		return p1
+	}
+	return &rngInterleaveP{p1, p2}
+}
+
+func rngAfter(p1, p2 rngPattern) rngPattern {
+	if p1 == rngNotAllowedP || p2 == rngNotAllowedP {
+		return rngNotAllowedP
+	}
+	return &rngAfterP{p1, p2}
+}
+
+func rngOneOrMore(p rngPattern) rngPattern {
+	if p == rngNotAllowedP {
+		return rngNotAllowedP
+	}
+	return &rngOneOrMoreP{p}
+}
+
+func rngNullable(p rngPattern) bool {
+	switch p := p.(type) {
+	case *rngEmpty, *rngText:
+		return true
+	case *rngChoiceP:
+		return rngNullable(p.p1) || rngNullable(p.p2)
+	case *rngGroupP:
+		return rngNullable(p.p1) && rngNullable(p.p2)
+	case *rngInterleaveP:
+		return rngNullable(p.p1) && rngNullable(p.p2)
+	case *rngOneOrMoreP:
+		return rngNullable(p.p)
+	}
+	return false
+}
+
+func rngTextDeriv(p rngPattern, s string) rngPattern {
+	switch p := p.(type) {
+	case *rngChoiceP:
+		return rngChoice(rngTextDeriv(p.p1, s), rngTextDeriv(p.p2, s))
+	case *rngInterleaveP:
+		return rngChoice(rngInterleave(rngTextDeriv(p.p1, s), p.p2), rngInterleave(p.p1, rngTextDeriv(p.p2, s)))
+	case *rngGroupP:
+		q := rngGroup(rngTextDeriv(p.p1, s), p.p2)
+		if rngNullable(p.p1) {
+			return rngChoice(q, rngTextDeriv(p.p2, s))
+		}
+		return q
+	case *rngAfterP:
+		return rngAfter(rngTextDeriv(p.p1, s), p.p2)
+	case *rngOneOrMoreP:
+		return rngGroup(rngTextDeriv(p.p, s), rngChoice(p, rngEmptyP))
+	case *rngText:
+		return p
+	case *rngValue:
+		if p.dt.equal(p.value, s) {
+			return rngEmptyP
+		}
+	case *rngData:
+		if p.dt.allows(s) && (p.except == nil || !rngNullable(rngTextDeriv(p.except, s))) {
+			return rngEmptyP
+		}
+	case *rngList:
+		q := p.p
+		for _, token := range strings.Fields(s) {
+			q = rngTextDeriv(q, token)
+		}
+		if rngNullable(q) {
+			return rngEmptyP
+		}
+	}
+	return rngNotAllowedP
+}
+
+// Applies f to the second patterns of the after patterns of p.
+func rngApplyAfter(p rngPattern, f func(rngPattern) rngPattern) rngPattern {
+	switch p := p.(type) {
+	case *rngAfterP:
+		return rngAfter(p.p1, f(p.p2))
+	case *rngChoiceP:
+		return rngChoice(rngApplyAfter(p.p1, f), rngApplyAfter(p.p2, f))
+	}
+	return rngNotAllowedP
+}
+
+func rngStartTagOpenDeriv(p rngPattern, uri, local string) rngPattern {
+	switch p := p.(type) {
+	case *rngChoiceP:
+		return rngChoice(rngStartTagOpenDeriv(p.p1, uri, local), rngStartTagOpenDeriv(p.p2, uri, local))
+	case *rngElement:
+		if p.nc.contains(uri, local) {
+			return rngAfter(p.p, rngEmptyP)
+		}
+	case *rngInterleaveP:
+		return rngChoice(
+			rngApplyAfter(rngStartTagOpenDeriv(p.p1, uri, local), func(q rngPattern) rngPattern { return rngInterleave(q, p.p2) }),
+			rngApplyAfter(rngStartTagOpenDeriv(p.p2, uri, local), func(q rngPattern) rngPattern { return rngInterleave(p.p1, q) }))
+	case *rngOneOrMoreP:
+		return rngApplyAfter(rngStartTagOpenDeriv(p.p, uri, local), func(q rngPattern) rngPattern {
+			return rngGroup(q, rngChoice(p, rngEmptyP))
+		})
+	case *rngGroupP:
+		q := rngApplyAfter(rngStartTagOpenDeriv(p.p1, uri, local), func(q rngPattern) rngPattern { return rngGroup(q, p.p2) })
+		if rngNullable(p.p1) {
+			return rngChoice(q, rngStartTagOpenDeriv(p.p2, uri, local))
+		}
+		return q
+	case *rngAfterP:
+		return rngApplyAfter(rngStartTagOpenDeriv(p.p1, uri, local), func(q rngPattern) rngPattern { return rngAfter(q, p.p2) })
+	}
+	return rngNotAllowedP
+}
+
+func rngAttDeriv(p rngPattern, uri, local, value string) rngPattern {
+	switch p := p.(type) {
+	case *rngAfterP:
+		return rngAfter(rngAttDeriv(p.p1, uri, local, value), p.p2)
+	case *rngChoiceP:
+		return rngChoice(rngAttDeriv(p.p1, uri, local, value), rngAttDeriv(p.p2, uri, local, value))
+	case *rngGroupP:
+		return rngChoice(rngGroup(rngAttDeriv(p.p1, uri, local, value), p.p2), rngGroup(p.p1, rngAttDeriv(p.p2, uri, local, value)))
+	case *rngInterleaveP:
+		return rngChoice(rngInterleave(rngAttDeriv(p.p1, uri, local, value), p.p2), rngInterleave(p.p1, rngAttDeriv(p.p2, uri, local, value)))
+	case *rngOneOrMoreP:
+		return rngGroup(rngAttDeriv(p.p, uri, local, value), rngChoice(p, rngEmptyP))
+	case *rngAttribute:
+		if p.nc.contains(uri, local) && (rngNullable(p.p) && isXMLSpace(value) || rngNullable(rngTextDeriv(p.p, value))) {
+			return rngEmptyP
+		}
+	}
+	return rngNotAllowedP
+}
+
+func rngStartTagCloseDeriv(p rngPattern) rngPattern {
+	switch p := p.(type) {
+	case *rngAfterP:
+		return rngAfter(rngStartTagCloseDeriv(p.p1), p.p2)
+	case *rngChoiceP:
+		return rngChoice(rngStartTagCloseDeriv(p.p1), rngStartTagCloseDeriv(p.p2))
+	case *rngGroupP:
+		return rngGroup(rngStartTagCloseDeriv(p.p1), rngStartTagCloseDeriv(p.p2))
+	case *rngInterleaveP:
+		return rngInterleave(rngStartTagCloseDeriv(p.p1), rngStartTagCloseDeriv(p.p2))
+	case *rngOneOrMoreP:
+		return rngOneOrMore(rngStartTagCloseDeriv(p.p))
+	case *rngAttribute:
+		return rngNotAllowedP
+	}
+	return p
+}
+
+func rngEndTagDeriv(p rngPattern) rngPattern {
+	switch p := p.(type) {
+	case *rngChoiceP:
+		return rngChoice(rngEndTagDeriv(p.p1), rngEndTagDeriv(p.p2))
+	case *rngAfterP:
+		if rngNullable(p.p1) {
+			return p.p2
+		}
+	}
+	return rngNotAllowedP
+}
+
+// rngNameClass is a set of expanded names.
+type rngNameClass interface {
+	contains(uri, local string) bool
+}
+
+type (
+	rngName    struct{ uri, local string }
+	rngAnyName struct{ except rngNameClass }
+	rngNsName  struct {
+		uri    string
+		except rngNameClass
+	}
+	rngNameChoice struct{ nc1, nc2 rngNameClass }
+)
+
+func (nc *rngName) contains(uri, local string) bool {
+	return nc.uri == uri && nc.local == local
+}
+
+func (nc *rngAnyName) contains(uri, local string) bool {
+	return nc.except == nil || !nc.except.contains(uri, local)
+}
+
+func (nc *rngNsName) contains(uri, local string) bool {
+	return nc.uri == uri && (nc.except == nil || !nc.except.contains(uri, local))
+}
+
+func (nc *rngNameChoice) contains(uri, local string) bool {
+	return nc.nc1.contains(uri, local) || nc.nc2.contains(uri, local)
+}
+
+// xsdDatatypesNamespace is the datatype library of XML Schema types.
+const xsdDatatypesNamespace = "http://www.w3.org/2001/XMLSchema-datatypes"
+
+// rngDatatype is a datatype with its parameters: "string" and "token" of
+// the built-in library, or an XML Schema type such as "xsd:integer".
+type rngDatatype struct {
+	name   string
+	params []rngParam
+}
+
+type rngParam struct {
+	name, value string
+	re          *regexp.Regexp // for the pattern parameter
+	num         float64        // for the bounds
+}
+
+var (
+	xsdDecimalRegexp  = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	xsdIntegerRegexp  = regexp.MustCompile(`^[+-]?\d+$`)
+	xsdLanguageRegexp = regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`)
+)
+
+// Lexical checks of the XML Schema types, which also accept the types with
+// a decoder registered with RegisterValueDecoder.
+var xsdLexical = map[string]func(string) bool{
+	"string":             func(string) bool { return true },
+	"normalizedString":   func(string) bool { return true },
+	"token":              func(string) bool { return true },
+	"anyURI":             func(string) bool { return true },
+	"NCName":             isNCName,
+	"ID":                 isNCName,
+	"IDREF":              isNCName,
+	"ENTITY":             isNCName,
+	"Name":               isXMLName,
+	"NMTOKEN":            isNmtoken,
+	"IDREFS":             xsdListOf(isNCName),
+	"ENTITIES":           xsdListOf(isNCName),
+	"NMTOKENS":           xsdListOf(isNmtoken),
+	"QName":              isQName,
+	"language":           xsdLanguageRegexp.MatchString,
+	"boolean":            func(s string) bool { return s == "true" || s == "false" || s == "1" || s == "0" },
+	"decimal":            xsdDecimalRegexp.MatchString,
+	"float":              isXSDFloat,
+	"double":             isXSDFloat,
+	"integer":            xsdIntegerRegexp.MatchString,
+	"nonNegativeInteger": xsdSignedInteger(0, 1),
+	"positiveInteger":    xsdSignedInteger(1, 1),
+	"nonPositiveInteger": xsdSignedInteger(0, -1),
+	"negativeInteger":    xsdSignedInteger(1, -1),
+	"long":               xsdSizedInteger(64, false),
+	"int":                xsdSizedInteger(32, false),
+	"short":              xsdSizedInteger(16, false),
+	"byte":               xsdSizedInteger(8, false),
+	"unsignedLong":       xsdSizedInteger(64, true),
+	"unsignedInt":        xsdSizedInteger(32, true),
+	"unsignedShort":      xsdSizedInteger(16, true),
+	"unsignedByte":       xsdSizedInteger(8, true),
+}
+
+func isNCName(s string) bool {
+	return isXMLName(s) && !strings.Contains(s, ":")
+}
+
+func isQName(s string) bool {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		return isNCName(s[:i]) && isNCName(s[i+1:])
+	}
+	return isNCName(s)
+}
+
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return isNameStartChar(r) && isNmtoken(s)
+}
+
+func isNmtoken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !isNameChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameStartChar(r rune) bool {
+	return r == ':' || r == '_' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || r >= 0xC0 && r != 0xD7 && r != 0xF7 && r != 0x37E && !(0x2000 <= r && r < 0x200C) && !(0x2190 <= r && r < 0x2C00)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStartChar(r) || r == '-' || r == '.' || '0' <= r && r <= '9' || r == 0xB7 || 0x300 <= r && r <= 0x36F || r == 0x203F || r == 0x2040
+}
+
+func xsdListOf(item func(string) bool) func(string) bool {
+	return func(s string) bool {
+		items := strings.Fields(s)
+		for _, it := range items {
+			if !item(it) {
+				return false
+			}
+		}
+		return len(items) > 0
+	}
+}
+
+func isXSDFloat(s string) bool {
+	switch s {
+	case "INF", "-INF", "NaN":
+		return true
+	case "+INF", "Infinity", "-Infinity", "+Infinity", "inf", "nan":
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil || errors.Is(err, strconv.ErrRange)
+}
+
+// Returns a check of integers that are positive (sign 1) or negative
+// (sign -1), zero being allowed if min is 0.
+func xsdSignedInteger(min, sign int) func(string) bool {
+	return func(s string) bool {
+		if !xsdIntegerRegexp.MatchString(s) {
+			return false
+		}
+		digits := strings.TrimLeft(strings.TrimLeft(s, "+-"), "0")
+		if digits == "" {
+			return min == 0
+		}
+		return strings.HasPrefix(s, "-") == (sign < 0)
+	}
+}
+
+func xsdSizedInteger(bits int, unsigned bool) func(string) bool {
+	return func(s string) bool {
+		if !xsdIntegerRegexp.MatchString(s) {
+			return false
+		}
+		var err error
+		if unsigned {
+			_, err = strconv.ParseUint(strings.TrimPrefix(s, "+"), 10, bits)
+			if strings.HasPrefix(s, "-") {
+				err = nil
+				if strings.TrimLeft(s[1:], "0") != "" {
+					err = strconv.ErrRange
+				}
+			}
+		} else {
+			_, err = strconv.ParseInt(s, 10, bits)
+		}
+		return err == nil
+	}
+}
+
+// Returns the datatype name of the XML Schema or built-in library, with its
+// parameters checked.
+func newRNGDatatype(library, name string, params [][2]string) (*rngDatatype, error) {
+	switch library {
+	case "":
+		if name != "string" && name != "token" {
+			return nil, fmt.Errorf("unknown datatype %s", name)
+		}
+		if len(params) > 0 {
+			return nil, fmt.Errorf("datatype %s has no parameters", name)
+		}
+		return &rngDatatype{name: name}, nil
+	case xsdDatatypesNamespace:
+	default:
+		return nil, fmt.Errorf("unsupported datatype library %q", library)
+	}
+	dt := &rngDatatype{name: "xs:" + name}
+	if _, ok := xsdLexical[name]; !ok {
+		valueDecodersMu.RLock()
+		_, ok = valueDecoders[dt.name]
+		valueDecodersMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unsupported datatype xsd:%s", name)
+		}
+	}
+	for _, kv := range params {
+		p := rngParam{name: kv[0], value: kv[1]}
+		var err error
+		switch p.name {
+		case "length", "minLength", "maxLength":
+			var n int
+			n, err = strconv.Atoi(p.value)
+			p.num = float64(n)
+		case "minInclusive", "maxInclusive", "minExclusive", "maxExclusive":
+			p.num, err = strconv.ParseFloat(p.value, 64)
+		case "pattern":
+			p.re, err = regexp.Compile(`^(?:` + p.value + `)$`)
+		default:
+			return nil, fmt.Errorf("unsupported parameter %s of xsd:%s", p.name, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %s of xsd:%s: %v", p.name, name, err)
+		}
+		dt.params = append(dt.params, p)
+	}
+	return dt, nil
+}
+
+// Returns s with the whitespace processing of the datatype applied.
+func (dt *rngDatatype) normalize(s string) string {
+	switch dt.name {
+	case "string", "xs:string":
+		return s
+	case "xs:normalizedString":
+		return strings.Map(func(r rune) rune {
+			if r == '\t' || r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, s)
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (dt *rngDatatype) allows(s string) bool {
+	if dt.name == "string" || dt.name == "token" {
+		return true
+	}
+	s = dt.normalize(s)
+	if check, ok := xsdLexical[strings.TrimPrefix(dt.name, "xs:")]; ok {
+		if !check(s) {
+			return false
+		}
+	} else if _, err := DecodeValue(dt.name, s); err != nil {
+		return false
+	}
+	for _, p := range dt.params {
+		switch p.name {
+		case "length":
+			if float64(utf8.RuneCountInString(s)) != p.num {
+				return false
+			}
+		case "minLength":
+			if float64(utf8.RuneCountInString(s)) < p.num {
+				return false
+			}
+		case "maxLength":
+			if float64(utf8.RuneCountInString(s)) > p.num {
+				return false
+			}
+		case "pattern":
+			if !p.re.MatchString(s) {
+				return false
+			}
+		default:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(f) {
+				return false
+			}
+			switch p.name {
+			case "minInclusive":
+				if f < p.num {
+					return false
+				}
+			case "maxInclusive":
+				if f > p.num {
+					return false
+				}
+			case "minExclusive":
+				if f <= p.num {
+					return false
+				}
+			case "maxExclusive":
+				if f >= p.num {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Reports whether s is the value v of the datatype. Numbers and booleans
+// are compared by value, so "01" is the integer 1.
+func (dt *rngDatatype) equal(v, s string) bool {
+	v, s = dt.normalize(v), dt.normalize(s)
+	if v == s {
+		return true
+	}
+	switch strings.TrimPrefix(dt.name, "xs:") {
+	case "boolean":
+		return dt.allows(s) && (v == "true" || v == "1") == (s == "true" || s == "1")
+	case "string", "token", "normalizedString", "anyURI", "NCName", "ID", "IDREF", "ENTITY", "Name", "NMTOKEN", "QName", "language",
+		"IDREFS", "ENTITIES", "NMTOKENS":
+		return false
+	}
+	if !dt.allows(s) {
+		return false
+	}
+	a, err1 := strconv.ParseFloat(v, 64)
+	b, err2 := strconv.ParseFloat(s, 64)
+	return err1 == nil && err2 == nil && a == b
+}
@@ -0,0 +1,109 @@
+package xmlquery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testRNC = `# A library.
+default namespace = "urn:books"
+namespace dc = "http://purl.org/dc/elements/1.1/"
+
+start = library
+
+library = element library {
+  attribute version { "1.0" | "2.0" }?,
+  book*
+}
+
+[ a:documentation [ "A book of the library." ] ]
+book = element book {
+  attribute id { xsd:ID },
+  attribute xml:lang { xsd:language }?,
+  (element dc:title { text } & element dc:creator { text }+),
+  element price { xsd:decimal { minInclusive = "0" } }?,
+  element tags { list { xsd:NCName* } }?,
+  element note { mixed { element em { text }* } }?,
+  element extra { anything }?
+}
+
+anything = element * { (attribute * { text } | text | anything)* }
+`
+
+func TestRelaxNG(t *testing.T) {
+	s, err := ParseRelaxNGCompact(strings.NewReader(testRNC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := []string{
+		`<library xmlns="urn:books"/>`,
+		`<library xmlns="urn:books" xmlns:dc="http://purl.org/dc/elements/1.1/" version="2.0">
+		  <book id="b1" xml:lang="en">
+		    <dc:creator>Ann</dc:creator><dc:title>One</dc:title><dc:creator>Bob</dc:creator>
+		    <price> 12.50 </price>
+		    <tags>a b  c</tags>
+		    <note>A <em>great</em> book<!-- really -->.</note>
+		    <extra><any x="1">text<more/></any></extra>
+		  </book>
+		  <book id="b2"><dc:title>Two</dc:title><dc:creator/></book>
+		</library>`,
+	}
+	for _, x := range valid {
+		if err := s.Validate(loadXML(x)); err != nil {
+			t.Errorf("%s: %v", x, err)
+		}
+	}
+
+	invalid := map[string]string{
+		`<library/>`: "element library not allowed here",
+		`<library xmlns="urn:books" version="3.0"/>`:                 "attribute version not allowed or invalid",
+		`<library xmlns="urn:books"><book id="b1"/></library>`:       "missing content in element book",
+		`<library xmlns="urn:books"><book><title/></book></library>`: "missing attribute of element book",
+		`<library xmlns="urn:books"><book id="1"/></library>`:        "attribute id not allowed or invalid",
+		`<library xmlns="urn:books"><book id="b" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title/><dc:creator/><price>-1</price></book></library>`: "invalid content \"-1\" in element price",
+		`<library xmlns="urn:books"><book id="b" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title/><dc:creator/><tags>a 1</tags></book></library>`:  "invalid content \"a 1\" in element tags",
+		`<library xmlns="urn:books"><book id="b" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title/><dc:title/></book></library>`:                    "element dc:title not allowed here",
+		`<library xmlns="urn:books">text</library>`: "invalid content \"text\" in element library",
+		`<library xmlns="urn:books"><book id="b" xmlns:dc="http://purl.org/dc/elements/1.1/">x<dc:title/><dc:creator/></book></library>`: "text not allowed here",
+	}
+	for x, msg := range invalid {
+		err := s.Validate(loadXML(x))
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: expected a *ValidationError, got %v", x, err)
+			continue
+		}
+		if verr.Msg != msg {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", x, msg, verr.Msg)
+		}
+	}
+
+	err = s.Validate(loadXML(`<library xmlns="urn:books">
+<book id="b1"/></library>`))
+	if expected := "xmlquery: missing content in element book at line 2, column 1 in /library/book"; err == nil || err.Error() != expected {
+		t.Errorf("\nexpected: %s\ngot:      %v", expected, err)
+	}
+}
+
+func TestRelaxNGPattern(t *testing.T) {
+	// A schema may be a single pattern, with interleave and values of types.
+	s, err := ParseRelaxNGCompact(strings.NewReader(`element point { attribute kind { xsd:integer "1" | xsd:boolean "true" }, (element x { xsd:double } & element y { xsd:double }) }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, ok := range map[string]bool{
+		`<point kind="01"><y>2</y><x>1e3</x></point>`:  true,
+		`<point kind="1"><x>INF</x><y>-0</y></point>`:  true,
+		`<point kind="true"><x>1</x><y>2</y></point>`:  true,
+		`<point kind="2"><x>1</x><y>2</y></point>`:     false,
+		`<point kind="1"><x>1</x></point>`:             false,
+		`<point kind="1"><x>1</x><y>2</y><x/></point>`: false,
+		`<point kind="1"><x>one</x><y>2</y></point>`:   false,
+		`<point><x>1</x><y>2</y></point>`:              false,
+	} {
+		if err := s.Validate(loadXML(x)); (err == nil) != ok {
+			t.Errorf("%s: unexpected result %v", x, err)
+		}
+	}
+}
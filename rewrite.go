@@ -0,0 +1,174 @@
+package xmlquery
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// A StreamRule describes a change made by RewriteStream.
+type StreamRule struct {
+	// Element is the qualified name of the elements the rule applies to, as
+	// written in the document (e.g. "atom:link"), or "*" for every element.
+	Element string
+	// Attr, if not empty, is the qualified name of the attribute of the
+	// matching elements the rule applies to, instead of the elements
+	// themselves.
+	Attr string
+	// Namespace, if not empty, makes the rule apply to the declarations of
+	// this namespace URI instead of elements and attributes, and Rename gives
+	// the URI replacing it.
+	Namespace string
+	// Rename is the new qualified name of the elements or attributes.
+	Rename string
+	// Remove removes the elements, along with their content, or attributes.
+	Remove bool
+}
+
+var charDataEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var encodingAttrRegexp = regexp.MustCompile(`\s+encoding\s*=\s*["'][^"']*["']`)
+
+// RewriteStream copies the XML document read from r to w, applying rules to
+// it token by token, so that documents too large to be parsed into a tree
+// can be transformed in constant memory. For every element and attribute,
+// the first rule matching its name, if any, is applied.
+//
+// Names are matched as written, prefix included, and namespace declarations
+// are only changed by rules with a Namespace, so renaming an element into
+// another namespace requires the new prefix to be declared. The output is
+// always encoded in UTF-8, and empty elements are written with an end tag.
+func RewriteStream(r io.Reader, w io.Writer, rules []StreamRule) error {
+	decoder, enc, err := newDecoder(r, nil)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+
+	var (
+		stack []xml.Name // names of the open elements, as found in the input
+		names []string   // names of the open elements, as written to w
+		skip  int        // depth inside a removed element
+	)
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, tok.Name)
+			if skip > 0 {
+				skip++
+				continue
+			}
+			elemName := xml_name2string(tok.Name)
+			name := elemName
+			rule := matchStreamRule(rules, elemName, "")
+			if rule != nil && rule.Remove {
+				skip = 1
+				continue
+			}
+			if rule != nil && rule.Rename != "" {
+				name = rule.Rename
+			}
+			names = append(names, name)
+			bw.WriteByte('<')
+			bw.WriteString(name)
+			for _, attr := range tok.Attr {
+				attrName, value := xml_name2string(attr.Name), attr.Value
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					if rule := matchNamespaceRule(rules, value); rule != nil {
+						value = rule.Rename
+					}
+				} else if rule := matchStreamRule(rules, elemName, attrName); rule != nil {
+					if rule.Remove {
+						continue
+					}
+					if rule.Rename != "" {
+						attrName = rule.Rename
+					}
+				}
+				bw.WriteByte(' ')
+				bw.WriteString(attrName)
+				bw.WriteString(`="`)
+				xml.EscapeText(bw, []byte(value))
+				bw.WriteByte('"')
+			}
+			bw.WriteByte('>')
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1] != tok.Name {
+				return fmt.Errorf("xmlquery: unexpected end element </%s>", xml_name2string(tok.Name))
+			}
+			stack = stack[:len(stack)-1]
+			if skip > 0 {
+				skip--
+				continue
+			}
+			bw.WriteString("</")
+			bw.WriteString(names[len(names)-1])
+			bw.WriteByte('>')
+			names = names[:len(names)-1]
+		default:
+			if skip > 0 {
+				continue
+			}
+			switch tok := tok.(type) {
+			case xml.CharData:
+				// Unlike xml.EscapeText, leave line breaks and tabs alone.
+				charDataEscaper.WriteString(bw, string(tok))
+			case xml.Comment:
+				bw.WriteString("<!--")
+				bw.Write(tok)
+				bw.WriteString("-->")
+			case xml.ProcInst:
+				inst := tok.Inst
+				if tok.Target == "xml" && enc != "utf-8" {
+					inst = encodingAttrRegexp.ReplaceAll(inst, nil)
+				}
+				bw.WriteString("<?")
+				bw.WriteString(tok.Target)
+				if len(inst) > 0 {
+					bw.WriteByte(' ')
+					bw.Write(inst)
+				}
+				bw.WriteString("?>")
+			case xml.Directive:
+				bw.WriteString("<!")
+				bw.Write(tok)
+				bw.WriteByte('>')
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("xmlquery: element <%s> is not closed", xml_name2string(stack[len(stack)-1]))
+	}
+	return bw.Flush()
+}
+
+// Returns the first element rule, or attribute rule if attr is not empty,
+// matching the given names.
+func matchStreamRule(rules []StreamRule, elem, attr string) *StreamRule {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Namespace == "" && rule.Attr == attr && (rule.Element == elem || rule.Element == "*") {
+			return rule
+		}
+	}
+	return nil
+}
+
+func matchNamespaceRule(rules []StreamRule, uri string) *StreamRule {
+	for i := range rules {
+		if rules[i].Namespace != "" && rules[i].Namespace == uri {
+			return &rules[i]
+		}
+	}
+	return nil
+}
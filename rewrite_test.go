@@ -0,0 +1,46 @@
+package xmlquery
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRewriteStream(t *testing.T) {
+	s := `<?xml version="1.0"?>
+<feed xmlns:old="urn:old">
+	<entry id="1" tmp="x"><title>A &amp; B</title><secret>hidden<b/></secret></entry>
+	<!-- end -->
+	<old:link href="a"/>
+</feed>`
+	rules := []StreamRule{
+		{Element: "entry", Rename: "item"},
+		{Element: "secret", Remove: true},
+		{Element: "*", Attr: "tmp", Remove: true},
+		{Element: "entry", Attr: "id", Rename: "key"},
+		{Namespace: "urn:old", Rename: "urn:new"},
+	}
+	var buf bytes.Buffer
+	if err := RewriteStream(strings.NewReader(s), &buf, rules); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0"?>
+<feed xmlns:old="urn:new">
+	<item key="1"><title>A &amp; B</title></item>
+	<!-- end -->
+	<old:link href="a"></old:link>
+</feed>`
+	if got := buf.String(); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestRewriteStreamErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RewriteStream(strings.NewReader(`<a><b></a>`), &buf, nil); err == nil {
+		t.Fatal("expected an error for mismatched end element")
+	}
+	if err := RewriteStream(strings.NewReader(`<a><b></b>`), &buf, nil); err == nil {
+		t.Fatal("expected an error for an unclosed element")
+	}
+}
@@ -0,0 +1,811 @@
+package xmlquery
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseRelaxNGCompact parses and compiles a RELAX NG schema written in the
+// compact syntax, as the .rnc files of DocBook or TEI:
+//
+//	default namespace = "urn:books"
+//	start = element books { book* }
+//	book = element book {
+//	  attribute id { xsd:ID },
+//	  element title { text },
+//	  element price { xsd:decimal { minInclusive = "0" } }?
+//	}
+//
+// Annotations are ignored. Schemas using include, external, parent or
+// nested grammars are rejected.
+func ParseRelaxNGCompact(r io.Reader) (*RelaxNG, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &rncParser{
+		lex:        rncLexer{src: string(src), line: 1},
+		namespaces: map[string]string{"xml": xmlNamespaceURI},
+		datatypes:  map[string]string{"xsd": xsdDatatypesNamespace},
+		defines:    make(map[string]rngPattern),
+		plain:      make(map[string]bool),
+		combine:    make(map[string]string),
+	}
+	start, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	c := &rngCompiler{defines: p.defines, resolved: make(map[string]rngPattern), done: make(map[*rngElement]bool)}
+	if start, err = c.resolve(start, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	return &RelaxNG{start: start}, nil
+}
+
+// rncError is a syntax error in a compact schema.
+func rncError(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("xmlquery: RELAX NG compact syntax: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+type rncTokenKind int
+
+const (
+	rncEOF     rncTokenKind = iota
+	rncIdent                // an identifier or keyword
+	rncEscaped              // an identifier written with a backslash
+	rncCName                // prefix:local
+	rncNsName               // prefix:*
+	rncLiteral              // a string literal
+	rncPunct                // an operator or bracket
+)
+
+type rncToken struct {
+	kind rncTokenKind
+	text string
+	line int
+}
+
+var rncKeywords = map[string]bool{
+	"attribute": true, "default": true, "datatypes": true, "div": true, "element": true,
+	"empty": true, "external": true, "grammar": true, "include": true, "inherit": true,
+	"list": true, "mixed": true, "namespace": true, "notAllowed": true, "parent": true,
+	"start": true, "string": true, "text": true, "token": true,
+}
+
+type rncLexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *rncLexer) next() (rncToken, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return rncToken{kind: rncEOF, line: l.line}, nil
+}
+
+func (l *rncLexer) token() (rncToken, error) {
+	line, s := l.line, l.src[l.pos:]
+	for _, op := range []string{"|=", "&=", "=", "{", "}", "(", ")", "[", "]", ",", "|", "&", "?", "*", "+", "-", "~"} {
+		if strings.HasPrefix(s, op) {
+			l.pos += len(op)
+			return rncToken{kind: rncPunct, text: op, line: line}, nil
+		}
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		quote := s[:1]
+		if strings.HasPrefix(s, strings.Repeat(quote, 3)) {
+			quote = s[:3]
+		}
+		end := strings.Index(s[len(quote):], quote)
+		if end < 0 {
+			return rncToken{}, rncError(line, "unterminated literal")
+		}
+		text := s[len(quote) : len(quote)+end]
+		if len(quote) == 1 && strings.Contains(text, "\n") {
+			return rncToken{}, rncError(line, "newline in literal")
+		}
+		l.line += strings.Count(text, "\n")
+		l.pos += len(quote)*2 + end
+		return rncToken{kind: rncLiteral, text: text, line: line}, nil
+	}
+	kind := rncIdent
+	if s[0] == '\\' {
+		kind = rncEscaped
+		s = s[1:]
+		l.pos++
+	}
+	n := rncNameLen(s)
+	if n == 0 {
+		r, _ := utf8.DecodeRuneInString(s)
+		return rncToken{}, rncError(line, "unexpected character %q", r)
+	}
+	name := s[:n]
+	l.pos += n
+	if kind == rncIdent && strings.HasPrefix(s[n:], ":") {
+		if strings.HasPrefix(s[n+1:], "*") {
+			l.pos += 2
+			return rncToken{kind: rncNsName, text: name, line: line}, nil
+		}
+		if m := rncNameLen(s[n+1:]); m > 0 {
+			l.pos += 1 + m
+			return rncToken{kind: rncCName, text: s[:n+1+m], line: line}, nil
+		}
+	}
+	return rncToken{kind: kind, text: name, line: line}, nil
+}
+
+// Returns the length of the NCName at the start of s.
+func rncNameLen(s string) int {
+	n := 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if r == ':' || !(n == 0 && isNameStartChar(r) || n > 0 && isNameChar(r)) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+type rncParser struct {
+	lex        rncLexer
+	tok        rncToken
+	peeked     []rncToken
+	namespaces map[string]string // prefix to URI
+	defaultNS  string
+	datatypes  map[string]string // prefix to datatype library
+	defines    map[string]rngPattern
+	plain      map[string]bool   // names defined with "="
+	combine    map[string]string // names defined with "|=" or "&=", to the operator
+}
+
+// Moves to the next token, skipping annotations.
+func (p *rncParser) advance() error {
+	for {
+		if len(p.peeked) > 0 {
+			p.tok, p.peeked = p.peeked[0], p.peeked[1:]
+		} else {
+			tok, err := p.lex.next()
+			if err != nil {
+				return err
+			}
+			p.tok = tok
+		}
+		if p.tok.kind == rncPunct && p.tok.text == "[" {
+			if err := p.skipAnnotation(); err != nil {
+				return err
+			}
+			continue
+		}
+		return nil
+	}
+}
+
+// Skips the annotation whose "[" is the current token.
+func (p *rncParser) skipAnnotation() error {
+	depth := 1
+	for depth > 0 {
+		tok, err := p.lex.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok.kind == rncEOF:
+			return rncError(tok.line, "unterminated annotation")
+		case tok.kind == rncPunct && tok.text == "[":
+			depth++
+		case tok.kind == rncPunct && tok.text == "]":
+			depth--
+		}
+	}
+	return nil
+}
+
+// Returns the token after the current one.
+func (p *rncParser) peek() (rncToken, error) {
+	if len(p.peeked) == 0 {
+		saved := p.tok
+		if err := p.advance(); err != nil {
+			return rncToken{}, err
+		}
+		p.peeked = append(p.peeked, p.tok)
+		p.tok = saved
+	}
+	return p.peeked[0], nil
+}
+
+func (p *rncParser) is(text string) bool {
+	return (p.tok.kind == rncPunct || p.tok.kind == rncIdent) && p.tok.text == text
+}
+
+func (p *rncParser) expect(text string) error {
+	if !p.is(text) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *rncParser) unexpected() error {
+	if p.tok.kind == rncEOF {
+		return rncError(p.tok.line, "unexpected end of schema")
+	}
+	return rncError(p.tok.line, "unexpected %q", p.tok.text)
+}
+
+func (p *rncParser) parse() (rngPattern, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for p.is("namespace") || p.is("default") || p.is("datatypes") {
+		if err := p.parseDecl(); err != nil {
+			return nil, err
+		}
+	}
+	next, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	isGrammar := p.is("start") || p.is("div") || p.is("include") ||
+		(p.tok.kind == rncIdent && !rncKeywords[p.tok.text] || p.tok.kind == rncEscaped) &&
+			next.kind == rncPunct && (next.text == "=" || next.text == "|=" || next.text == "&=")
+	if !isGrammar {
+		pattern, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != rncEOF {
+			return nil, p.unexpected()
+		}
+		return pattern, nil
+	}
+	if err := p.parseGrammar(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != rncEOF {
+		return nil, p.unexpected()
+	}
+	start, ok := p.defines[""]
+	if !ok {
+		return nil, rncError(p.tok.line, "missing start")
+	}
+	return start, nil
+}
+
+func (p *rncParser) parseDecl() error {
+	kw := p.tok.text
+	if err := p.advance(); err != nil {
+		return err
+	}
+	isDefault := false
+	if kw == "default" {
+		if err := p.expect("namespace"); err != nil {
+			return err
+		}
+		isDefault, kw = true, "namespace"
+	}
+	prefix := ""
+	if p.tok.kind == rncIdent || p.tok.kind == rncEscaped {
+		prefix = p.tok.text
+		if err := p.advance(); err != nil {
+			return err
+		}
+	} else if !isDefault {
+		return p.unexpected()
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	var uri string
+	if p.is("inherit") {
+		// The schema is not included, so the inherited namespace is none.
+	} else if p.tok.kind == rncLiteral {
+		uri = p.tok.text
+	} else {
+		return p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	if kw == "datatypes" {
+		p.datatypes[prefix] = uri
+		return nil
+	}
+	if prefix != "" {
+		p.namespaces[prefix] = uri
+	}
+	if isDefault {
+		p.defaultNS = uri
+	}
+	return nil
+}
+
+// Parses definitions until the end of the schema or a "}".
+func (p *rncParser) parseGrammar() error {
+	for p.tok.kind != rncEOF && !p.is("}") {
+		switch {
+		case p.is("div"):
+			if err := p.advance(); err != nil {
+				return err
+			}
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseGrammar(); err != nil {
+				return err
+			}
+			if err := p.expect("}"); err != nil {
+				return err
+			}
+		case p.is("include"):
+			return rncError(p.tok.line, "include is not supported")
+		case p.tok.kind == rncIdent && (p.tok.text == "start" || !rncKeywords[p.tok.text]) || p.tok.kind == rncEscaped:
+			name, line := p.tok.text, p.tok.line
+			if p.tok.kind == rncIdent && name == "start" {
+				name = ""
+			}
+			if err := p.advance(); err != nil {
+				return err
+			}
+			if !p.is("=") && !p.is("|=") && !p.is("&=") {
+				return p.unexpected()
+			}
+			assign := p.tok.text
+			if err := p.advance(); err != nil {
+				return err
+			}
+			pattern, err := p.parsePattern()
+			if err != nil {
+				return err
+			}
+			if err := p.define(name, assign, pattern, line); err != nil {
+				return err
+			}
+		default:
+			return p.unexpected()
+		}
+	}
+	return nil
+}
+
+// Adds a definition, combining it with the others of the same name by
+// choice ("|=") or interleave ("&="). Only one of them may use "=".
+func (p *rncParser) define(name, assign string, pattern rngPattern, line int) error {
+	display := name
+	if display == "" {
+		display = "start"
+	}
+	if assign == "=" {
+		if p.plain[name] {
+			return rncError(line, "%s is defined twice", display)
+		}
+		p.plain[name] = true
+	} else {
+		if m := p.combine[name]; m != "" && m != assign {
+			return rncError(line, "%s is combined with both |= and &=", display)
+		}
+		p.combine[name] = assign
+	}
+	prev, ok := p.defines[name]
+	switch {
+	case !ok:
+		p.defines[name] = pattern
+	case p.combine[name] == "|=":
+		p.defines[name] = &rngChoiceP{prev, pattern}
+	default:
+		p.defines[name] = &rngInterleaveP{prev, pattern}
+	}
+	return nil
+}
+
+func (p *rncParser) parsePattern() (rngPattern, error) {
+	pattern, err := p.parseParticle()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(",") && !p.is("|") && !p.is("&") {
+		return pattern, nil
+	}
+	op := p.tok.text
+	for p.is(op) {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		next, err := p.parseParticle()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case ",":
+			pattern = rngGroup(pattern, next)
+		case "|":
+			pattern = &rngChoiceP{pattern, next}
+		default:
+			pattern = rngInterleave(pattern, next)
+		}
+	}
+	if p.is(",") || p.is("|") || p.is("&") {
+		return nil, rncError(p.tok.line, "mixed operators %q and %q without parentheses", op, p.tok.text)
+	}
+	return pattern, nil
+}
+
+func (p *rncParser) parseParticle() (rngPattern, error) {
+	pattern, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.is("?"):
+		pattern = &rngChoiceP{pattern, rngEmptyP}
+	case p.is("*"):
+		pattern = &rngChoiceP{&rngOneOrMoreP{pattern}, rngEmptyP}
+	case p.is("+"):
+		pattern = &rngOneOrMoreP{pattern}
+	default:
+		return pattern, nil
+	}
+	return pattern, p.advance()
+}
+
+// Parses a pattern between braces.
+func (p *rncParser) parseBlock() (rngPattern, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	pattern, err := p.parsePattern()
+	if err != nil {
+		return nil, err
+	}
+	return pattern, p.expect("}")
+}
+
+func (p *rncParser) parsePrimary() (rngPattern, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == rncPunct && tok.text == "(":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		pattern, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+		return pattern, p.expect(")")
+	case tok.kind == rncLiteral:
+		return p.parseValue(&rngDatatype{name: "token"})
+	case tok.kind == rncCName:
+		return p.parseData()
+	case tok.kind == rncEscaped:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &rngRef{tok.text}, nil
+	case tok.kind != rncIdent:
+		return nil, p.unexpected()
+	}
+	switch tok.text {
+	case "element", "attribute":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		nc, err := p.parseNameClass(tok.text == "attribute")
+		if err != nil {
+			return nil, err
+		}
+		content, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "attribute" {
+			return &rngAttribute{nc: nc, p: content}, nil
+		}
+		return &rngElement{nc: nc, p: content}, nil
+	case "mixed", "list":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		content, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "list" {
+			return &rngList{content}, nil
+		}
+		return rngInterleave(rngTextP, content), nil
+	case "empty", "text", "notAllowed":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "empty":
+			return rngEmptyP, nil
+		case "text":
+			return rngTextP, nil
+		}
+		return rngNotAllowedP, nil
+	case "string", "token":
+		return p.parseData()
+	case "external", "parent", "grammar":
+		return nil, rncError(tok.line, "%s is not supported", tok.text)
+	}
+	if rncKeywords[tok.text] {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return &rngRef{tok.text}, nil
+}
+
+// Parses a datatype name followed by a literal, parameters or an except
+// pattern.
+func (p *rncParser) parseData() (rngPattern, error) {
+	tok := p.tok
+	library, name := "", tok.text
+	if tok.kind == rncCName {
+		i := strings.IndexByte(name, ':')
+		uri, ok := p.datatypes[name[:i]]
+		if !ok {
+			return nil, rncError(tok.line, "undeclared datatype prefix %s", name[:i])
+		}
+		library, name = uri, name[i+1:]
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var params [][2]string
+	if p.is("{") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.is("}") {
+			if p.tok.kind != rncIdent && p.tok.kind != rncEscaped {
+				return nil, p.unexpected()
+			}
+			param := p.tok.text
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, [2]string{param, value})
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	dt, err := newRNGDatatype(library, name, params)
+	if err != nil {
+		return nil, rncError(tok.line, "%v", err)
+	}
+	if p.tok.kind == rncLiteral && params == nil {
+		return p.parseValue(dt)
+	}
+	data := &rngData{dt: dt}
+	if p.is("-") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if data.except, err = p.parsePrimary(); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (p *rncParser) parseValue(dt *rngDatatype) (rngPattern, error) {
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return &rngValue{dt: dt, value: value}, nil
+}
+
+// Parses a literal, made of segments joined with "~".
+func (p *rncParser) parseLiteral() (string, error) {
+	var buf strings.Builder
+	for {
+		if p.tok.kind != rncLiteral {
+			return "", p.unexpected()
+		}
+		buf.WriteString(p.tok.text)
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+		if !p.is("~") {
+			return buf.String(), nil
+		}
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Parses a name class. Unprefixed names are in the default namespace for
+// elements and in no namespace for attributes.
+func (p *rncParser) parseNameClass(attr bool) (rngNameClass, error) {
+	nc, err := p.parseSimpleNameClass(attr)
+	if err != nil {
+		return nil, err
+	}
+	for p.is("|") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		next, err := p.parseSimpleNameClass(attr)
+		if err != nil {
+			return nil, err
+		}
+		nc = &rngNameChoice{nc, next}
+	}
+	return nc, nil
+}
+
+func (p *rncParser) parseSimpleNameClass(attr bool) (rngNameClass, error) {
+	tok := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	switch {
+	case tok.kind == rncIdent || tok.kind == rncEscaped:
+		uri := p.defaultNS
+		if attr {
+			uri = ""
+		}
+		return &rngName{uri: uri, local: tok.text}, nil
+	case tok.kind == rncCName:
+		i := strings.IndexByte(tok.text, ':')
+		uri, ok := p.namespaces[tok.text[:i]]
+		if !ok {
+			return nil, rncError(tok.line, "undeclared prefix %s", tok.text[:i])
+		}
+		return &rngName{uri: uri, local: tok.text[i+1:]}, nil
+	case tok.kind == rncNsName, tok.kind == rncPunct && tok.text == "*":
+		except, err := p.parseNameClassExcept(attr)
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == rncPunct {
+			return &rngAnyName{except: except}, nil
+		}
+		uri, ok := p.namespaces[tok.text]
+		if !ok {
+			return nil, rncError(tok.line, "undeclared prefix %s", tok.text)
+		}
+		return &rngNsName{uri: uri, except: except}, nil
+	case tok.kind == rncPunct && tok.text == "(":
+		nc, err := p.parseNameClass(attr)
+		if err != nil {
+			return nil, err
+		}
+		return nc, p.expect(")")
+	}
+	p.tok = tok
+	return nil, p.unexpected()
+}
+
+func (p *rncParser) parseNameClassExcept(attr bool) (rngNameClass, error) {
+	if !p.is("-") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.parseSimpleNameClass(attr)
+}
+
+// rngCompiler replaces references by the patterns they name.
+type rngCompiler struct {
+	defines  map[string]rngPattern
+	resolved map[string]rngPattern
+	done     map[*rngElement]bool
+}
+
+// Returns p with its references replaced. active holds the definitions
+// being resolved outside of any element, which must not refer to
+// themselves.
+func (c *rngCompiler) resolve(p rngPattern, active map[string]bool) (rngPattern, error) {
+	var err error
+	switch q := p.(type) {
+	case *rngRef:
+		if r, ok := c.resolved[q.name]; ok {
+			return r, nil
+		}
+		def, ok := c.defines[q.name]
+		if !ok {
+			return nil, fmt.Errorf("xmlquery: RELAX NG: undefined pattern %s", q.name)
+		}
+		if active[q.name] {
+			return nil, fmt.Errorf("xmlquery: RELAX NG: %s refers to itself outside of an element", q.name)
+		}
+		active[q.name] = true
+		r, err := c.resolve(def, active)
+		delete(active, q.name)
+		if err != nil {
+			return nil, err
+		}
+		c.resolved[q.name] = r
+		return r, nil
+	case *rngElement:
+		if !c.done[q] {
+			c.done[q] = true
+			if q.p, err = c.resolve(q.p, make(map[string]bool)); err != nil {
+				return nil, err
+			}
+		}
+		return q, nil
+	case *rngAttribute:
+		content, err := c.resolve(q.p, active)
+		if err != nil {
+			return nil, err
+		}
+		return &rngAttribute{nc: q.nc, p: content}, nil
+	case *rngChoiceP, *rngGroupP, *rngInterleaveP:
+		var p1, p2 rngPattern
+		switch q := q.(type) {
+		case *rngChoiceP:
+			p1, p2 = q.p1, q.p2
+		case *rngGroupP:
+			p1, p2 = q.p1, q.p2
+		case *rngInterleaveP:
+			p1, p2 = q.p1, q.p2
+		}
+		if p1, err = c.resolve(p1, active); err != nil {
+			return nil, err
+		}
+		if p2, err = c.resolve(p2, active); err != nil {
+			return nil, err
+		}
+		switch q.(type) {
+		case *rngChoiceP:
+			return rngChoice(p1, p2), nil
+		case *rngGroupP:
+			return rngGroup(p1, p2), nil
+		}
+		return rngInterleave(p1, p2), nil
+	case *rngOneOrMoreP:
+		content, err := c.resolve(q.p, active)
+		if err != nil {
+			return nil, err
+		}
+		return rngOneOrMore(content), nil
+	case *rngList:
+		content, err := c.resolve(q.p, active)
+		if err != nil {
+			return nil, err
+		}
+		return &rngList{content}, nil
+	case *rngData:
+		if q.except == nil {
+			return q, nil
+		}
+		except, err := c.resolve(q.except, active)
+		if err != nil {
+			return nil, err
+		}
+		return &rngData{dt: q.dt, except: except}, nil
+	}
+	return p, nil
+}
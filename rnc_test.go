@@ -0,0 +1,53 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRelaxNGCompactErrors(t *testing.T) {
+	for s, msg := range map[string]string{
+		`start = element a { text`:                       "line 1: unexpected end of schema",
+		"start = element a {\n b }":                      "undefined pattern b",
+		`start = a a = element a { empty }`:              "",
+		`start = element a { text } start = empty`:       "start is defined twice",
+		`start = element a { b } b = b`:                  "b refers to itself outside of an element",
+		`start = element x:a { text }`:                   "undeclared prefix x",
+		`start = element a { x:b }`:                      "undeclared datatype prefix x",
+		`start |= element a { text } start &= empty`:     "combined with both",
+		`start = element a { xsd:unknown }`:              "unsupported datatype xsd:unknown",
+		`start = element a { xsd:int { digits = "1" } }`: "unsupported parameter digits of xsd:int",
+		`start = element a { empty, text | empty }`:      "mixed operators",
+		`include "other.rnc"`:                            "include is not supported",
+		`start = element a { "x }`:                       "unterminated literal",
+		`start = element a { text } [ a:b [ "x" ]`:       "unterminated annotation",
+	} {
+		_, err := ParseRelaxNGCompact(strings.NewReader(s))
+		if msg == "" {
+			if err != nil {
+				t.Errorf("%s: %v", s, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: expected an error with %q, got %v", s, msg, err)
+		}
+	}
+
+	// Definitions may be combined, and keywords escaped.
+	s, err := ParseRelaxNGCompact(strings.NewReader(`
+start = element doc { attrs, \element* }
+attrs = attribute id { text }
+\element = element a { empty }
+\element |= element b { empty }
+div { attrs &= attribute n { text }? }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(loadXML(`<doc n="1" id="x"><b/><a/></doc>`)); err != nil {
+		t.Error(err)
+	}
+	if err := s.Validate(loadXML(`<doc><a/></doc>`)); err == nil {
+		t.Error("expected an error for the missing attribute")
+	}
+}
@@ -0,0 +1,401 @@
+package xmlquery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gjvnq/xpath"
+)
+
+// Namespace URIs of Schematron schemas: ISO Schematron and its predecessor,
+// Schematron 1.5.
+const (
+	SchematronNamespace   = "http://purl.oclc.org/dsdl/schematron"
+	Schematron15Namespace = "http://www.ascc.net/xml/schematron"
+)
+
+// Schematron is a compiled Schematron schema: rules made of XPath
+// assertions about the nodes selected by their context, for business rules
+// that grammars such as XSD cannot express. It is safe for concurrent use.
+//
+// The schema elements ns, pattern, rule, assert, report, name and value-of
+// are supported, with the diagnostics-free subset of their attributes.
+// Phases, abstract patterns and rules, variables (let) and inclusions are
+// not, and schemas using them are rejected. All patterns are active.
+type Schematron struct {
+	prefixes map[string]string // namespace URI to query prefix, nil without sch:ns
+	patterns []*schPattern
+}
+
+type schPattern struct {
+	id    string
+	rules []*schRule
+}
+
+type schRule struct {
+	context string
+	selects []*cachedExpr // one per alternative of context
+	checks  []*schCheck
+}
+
+type schCheck struct {
+	report bool
+	id     string
+	role   string
+	test   string
+	expr   *cachedExpr
+	body   *Node // the sch:assert or sch:report element
+}
+
+// SchematronResult is a failed assertion or a successful report found by
+// Schematron.Validate.
+type SchematronResult struct {
+	Report  bool   // true for a sch:report, false for a sch:assert
+	ID      string // the id attribute of the assertion, if any
+	Role    string // the role attribute of the assertion, such as "warning"
+	Pattern string // the id attribute of the pattern, if any
+	Context string // the context of the rule
+	Test    string
+	// Message is the text of the assertion, with sch:name and sch:value-of
+	// replaced and whitespace normalized.
+	Message string
+	// Node is the context node the assertion was tested on, and Path its
+	// path as returned by Node.Path.
+	Node *Node
+	Path string
+}
+
+func (r SchematronResult) String() string {
+	return fmt.Sprintf("%s: %s", r.Path, r.Message)
+}
+
+// ParseSchematron parses and compiles the Schematron schema read from r.
+func ParseSchematron(r io.Reader) (*Schematron, error) {
+	doc, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return CompileSchematron(doc)
+}
+
+// CompileSchematron compiles the Schematron schema held by doc, a document
+// or its root element. It returns an error if the schema uses elements that
+// are not supported or has invalid expressions.
+func CompileSchematron(doc *Node) (*Schematron, error) {
+	root := doc
+	if root.Type == DocumentNode {
+		root = nil
+		if elems := doc.ChildElements(); len(elems) > 0 {
+			root = elems[0]
+		}
+	}
+	if root == nil || !isSchematron(root, "schema") {
+		return nil, errors.New("xmlquery: not a Schematron schema")
+	}
+	s := &Schematron{}
+	namespaces := make(map[string]string)
+	for _, n := range root.ChildElements() {
+		if !isSchematron(n, n.Data) {
+			continue // foreign elements are ignored
+		}
+		switch n.Data {
+		case "ns":
+			namespaces[n.SelectAttr("prefix")] = n.SelectAttr("uri")
+		case "pattern":
+			p, err := compileSchPattern(n)
+			if err != nil {
+				return nil, err
+			}
+			s.patterns = append(s.patterns, p)
+		case "title", "p", "diagnostics":
+		default:
+			return nil, fmt.Errorf("xmlquery: unsupported Schematron element %s", n.Data)
+		}
+	}
+	if len(namespaces) > 0 {
+		s.prefixes = queryPrefixes(namespaces)
+	}
+	return s, nil
+}
+
+func isSchematron(n *Node, local string) bool {
+	uri := elementNamespaceURI(n)
+	return n.Type == ElementNode && n.Data == local && (uri == SchematronNamespace || uri == Schematron15Namespace)
+}
+
+func compileSchPattern(n *Node) (*schPattern, error) {
+	if n.SelectAttr("abstract") == "true" || n.SelectAttr("is-a") != "" {
+		return nil, errors.New("xmlquery: abstract Schematron patterns are not supported")
+	}
+	p := &schPattern{id: n.SelectAttr("id")}
+	for _, r := range n.ChildElements() {
+		switch {
+		case isSchematron(r, "rule"):
+			rule, err := compileSchRule(r)
+			if err != nil {
+				return nil, err
+			}
+			p.rules = append(p.rules, rule)
+		case isSchematron(r, "let"):
+			return nil, errors.New("xmlquery: Schematron variables are not supported")
+		}
+	}
+	return p, nil
+}
+
+func compileSchRule(n *Node) (*schRule, error) {
+	if n.SelectAttr("abstract") == "true" {
+		return nil, errors.New("xmlquery: abstract Schematron rules are not supported")
+	}
+	r := &schRule{context: n.SelectAttr("context")}
+	if r.context == "" {
+		return nil, errors.New("xmlquery: Schematron rule without a context")
+	}
+	for _, alt := range strings.Split(r.context, "|") {
+		// A context is a pattern: it matches the nodes it selects from any
+		// of their ancestors.
+		alt = strings.TrimSpace(alt)
+		if !strings.HasPrefix(alt, "/") {
+			alt = "//" + alt
+		}
+		exp, err := compile(alt)
+		if err != nil {
+			return nil, fmt.Errorf("xmlquery: invalid Schematron context %q: %v", r.context, err)
+		}
+		r.selects = append(r.selects, exp)
+	}
+	for _, c := range n.ChildElements() {
+		switch {
+		case isSchematron(c, "assert"), isSchematron(c, "report"):
+			check := &schCheck{report: c.Data == "report", id: c.SelectAttr("id"), role: c.SelectAttr("role"), test: c.SelectAttr("test"), body: c}
+			exp, err := compile(check.test)
+			if err != nil {
+				return nil, fmt.Errorf("xmlquery: invalid Schematron test %q: %v", check.test, err)
+			}
+			check.expr = exp
+			if err := checkSchMessage(c); err != nil {
+				return nil, err
+			}
+			r.checks = append(r.checks, check)
+		case isSchematron(c, "let"), isSchematron(c, "extends"):
+			return nil, fmt.Errorf("xmlquery: unsupported Schematron element %s", c.Data)
+		}
+	}
+	return r, nil
+}
+
+// Checks the expressions of the sch:name and sch:value-of elements of the
+// message of an assertion.
+func checkSchMessage(n *Node) error {
+	for _, c := range n.ChildElements() {
+		var expr string
+		switch {
+		case isSchematron(c, "value-of"):
+			expr = c.SelectAttr("select")
+		case isSchematron(c, "name"):
+			expr = c.SelectAttr("path")
+		}
+		if expr != "" {
+			if _, err := compile(expr); err != nil {
+				return fmt.Errorf("xmlquery: invalid Schematron expression %q: %v", expr, err)
+			}
+		}
+		if err := checkSchMessage(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks doc against the schema and returns the failed assertions
+// and the successful reports, in the order of the patterns and rules of the
+// schema and then in document order. As in Schematron, a node is only
+// checked by the first rule of each pattern whose context it matches.
+func (s *Schematron) Validate(doc *Node) []SchematronResult {
+	var results []SchematronResult
+	for _, p := range s.patterns {
+		fired := make(map[schNodeKey]bool)
+		for _, r := range p.rules {
+			for _, n := range s.contextNodes(doc, r) {
+				key := schNodeKey{n, attrIndex(n)}
+				if key.attr >= 0 {
+					key.n = n.Parent
+				}
+				if fired[key] {
+					continue
+				}
+				fired[key] = true
+				for _, c := range r.checks {
+					if s.evalBool(n, c.expr) != c.report {
+						continue
+					}
+					results = append(results, SchematronResult{
+						Report:  c.report,
+						ID:      c.id,
+						Role:    c.role,
+						Pattern: p.id,
+						Context: r.context,
+						Test:    c.test,
+						Message: strings.Join(strings.Fields(s.message(n, c.body)), " "),
+						Node:    n,
+						Path:    n.Path(),
+					})
+				}
+			}
+		}
+	}
+	return results
+}
+
+// schNodeKey identifies a node, attributes being built anew by each query.
+type schNodeKey struct {
+	n    *Node
+	attr int
+}
+
+// The XPath engine panics on some expressions it cannot evaluate, chiefly
+// comparisons of numbers with text that is not a number, which XPath
+// compares as NaN. The evaluations below recover and count them as false:
+// a failed predicate skips the node it was tested on, a failed test is
+// false and a failed value is empty.
+
+// Returns the nodes matched by the context of r, in document order.
+func (s *Schematron) contextNodes(doc *Node, r *schRule) []*Node {
+	var nodes []*Node
+	for _, exp := range r.selects {
+		t := exp.Select(s.navigator(treeRoot(doc)))
+		// Each failure moves past a candidate node, so there are at most as
+		// many as nodes in the tree; the limit guards against the engine
+		// failing again and again on the same one.
+		failures, limit := 0, -1
+		for {
+			more, ok := moveNext(t)
+			if ok && !more {
+				break
+			}
+			if !ok {
+				if limit < 0 {
+					limit = countTreeNodes(treeRoot(doc))
+				}
+				if failures++; failures > limit {
+					break
+				}
+				continue
+			}
+			nodes = append(nodes, getCurrentNode(t))
+		}
+	}
+	if len(r.selects) > 1 {
+		sortDocumentOrder(nodes)
+	}
+	return nodes
+}
+
+// Calls t.MoveNext, with ok false if the engine panicked.
+func moveNext(t *xpath.NodeIterator) (more, ok bool) {
+	defer func() {
+		if recover() != nil {
+			more, ok = false, false
+		}
+	}()
+	return t.MoveNext(), true
+}
+
+// Returns the number of nodes and attributes in the tree of root.
+func countTreeNodes(root *Node) int {
+	count := 1 + len(root.Attr)
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		count += countTreeNodes(c)
+	}
+	return count
+}
+
+// Returns a navigator at n in which absolute paths start from the root of
+// its tree.
+func (s *Schematron) navigator(n *Node) *NodeNavigator {
+	nav := CreateXPathNavigator(n)
+	nav.root = treeRoot(nav.curr)
+	nav.prefixes = s.prefixes
+	return nav
+}
+
+func (s *Schematron) evalBool(n *Node, exp *cachedExpr) (b bool) {
+	defer func() {
+		if recover() != nil {
+			b = false
+		}
+	}()
+	switch v := exp.Evaluate(s.navigator(n)).(type) {
+	case *xpath.NodeIterator:
+		return v.MoveNext()
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case bool:
+		return v
+	}
+	return false
+}
+
+func (s *Schematron) evalString(n *Node, expr string) (str string) {
+	defer func() {
+		if recover() != nil {
+			str = ""
+		}
+	}()
+	exp, _ := compile(expr) // checked by CompileSchematron
+	switch v := exp.Evaluate(s.navigator(n)).(type) {
+	case *xpath.NodeIterator:
+		if v.MoveNext() {
+			return v.Current().Value()
+		}
+		return ""
+	case float64:
+		// As the XPath string() function.
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 0):
+			if v > 0 {
+				return "Infinity"
+			}
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Returns the text of the assertion body, evaluated for the context node n.
+func (s *Schematron) message(n, body *Node) string {
+	var buf strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == TextNode:
+			buf.WriteString(c.Data)
+		case isSchematron(c, "value-of"):
+			buf.WriteString(s.evalString(n, c.SelectAttr("select")))
+		case isSchematron(c, "name"):
+			target := n
+			if path := c.SelectAttr("path"); path != "" {
+				exp, _ := compile(path)
+				t := exp.Select(s.navigator(n))
+				if !t.MoveNext() {
+					continue
+				}
+				target = getCurrentNode(t)
+			}
+			buf.WriteString(qualifiedName(target))
+		case c.Type == ElementNode:
+			// sch:emph, sch:dir, sch:span and foreign elements.
+			buf.WriteString(s.message(n, c))
+		}
+	}
+	return buf.String()
+}
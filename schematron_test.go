@@ -0,0 +1,107 @@
+package xmlquery
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testSchematron = `<schema xmlns="http://purl.oclc.org/dsdl/schematron">
+  <title>Invoices</title>
+  <ns prefix="i" uri="urn:invoice"/>
+  <pattern id="totals">
+    <rule context="i:invoice">
+      <assert test="i:line" id="has-lines">An invoice has lines.</assert>
+      <assert test="number(i:total) = sum(i:line/@amount)">The total of <name/> is <value-of select="i:total"/>, not
+        <value-of select="sum(i:line/@amount)"/>.</assert>
+    </rule>
+  </pattern>
+  <pattern id="lines">
+    <rule context="i:line[@amount &lt; 0]">
+      <report test="true()" role="warning">Credit line <value-of select="count(preceding-sibling::i:line) + 1"/> in <name path=".."/>.</report>
+    </rule>
+    <rule context="i:line">
+      <assert test="@amount &gt; 0">Line without amount.</assert>
+      <assert test="/i:invoice/i:currency">No currency for the line.</assert>
+    </rule>
+    <rule context="@amount">
+      <assert test="translate(., '0123456789-.', '') = ''">Invalid amount <value-of select="."/>.</assert>
+    </rule>
+  </pattern>
+</schema>`
+
+func TestSchematron(t *testing.T) {
+	s, err := ParseSchematron(strings.NewReader(testSchematron))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := loadXML(`<inv:invoice xmlns:inv="urn:invoice"><inv:line amount="10"/><inv:line amount="-2"/><inv:line/><inv:line amount="x"/><inv:total>9</inv:total></inv:invoice>`)
+	var got []string
+	for _, r := range s.Validate(doc) {
+		got = append(got, r.String())
+	}
+	// The engine's sum() skips values that are not numbers, and comparing
+	// them with numbers is false.
+	expected := []string{
+		"/inv:invoice: The total of inv:invoice is 9, not 8.",
+		"/inv:invoice/inv:line[2]: Credit line 2 in inv:invoice.",
+		"/inv:invoice/inv:line[1]: No currency for the line.",
+		"/inv:invoice/inv:line[3]: Line without amount.",
+		"/inv:invoice/inv:line[3]: No currency for the line.",
+		"/inv:invoice/inv:line[4]: Line without amount.",
+		"/inv:invoice/inv:line[4]: No currency for the line.",
+		"/inv:invoice/inv:line[4]/@amount: Invalid amount x.",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("\nexpected: %s\ngot:      %s", strings.Join(expected, "\n          "), strings.Join(got, "\n          "))
+	}
+
+	results := s.Validate(loadXML(`<invoice xmlns="urn:invoice"><currency>EUR</currency><line amount="4"/><total>4</total></invoice>`))
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+
+	results = s.Validate(loadXML(`<invoice xmlns="urn:invoice"><currency>EUR</currency><total>0</total></invoice>`))
+	if len(results) != 1 || results[0].ID != "has-lines" || results[0].Pattern != "totals" || results[0].Report || results[0].Node.Data != "invoice" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestSchematronConcurrently(t *testing.T) {
+	s, err := ParseSchematron(strings.NewReader(testSchematron))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := loadXML(`<invoice xmlns="urn:invoice"><currency>EUR</currency><line amount="x"/><total>0</total></invoice>`)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if results := s.Validate(doc); len(results) != 2 {
+					t.Errorf("expected 2 results, got %v", results)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSchematronErrors(t *testing.T) {
+	for _, s := range []string{
+		`<schema/>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><phase id="p"/></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule/></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a["/></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><assert test="b["/></rule></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><assert test="b"><value-of select="c["/></assert></rule></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><let name="x" value="1"/></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern abstract="true"/></schema>`,
+	} {
+		if _, err := ParseSchematron(strings.NewReader(s)); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package xmlquery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDoctype is returned when parsing a document with a DOCTYPE declaration
+// with ParserOptions.DisallowDoctype set.
+var ErrDoctype = errors.New("xmlquery: DOCTYPE declarations are not allowed")
+
+// LimitError is returned when a document exceeds one of the limits set in
+// its ParserOptions.
+type LimitError struct {
+	Option       string // the name of the exceeded option, e.g. "MaxDepth"
+	Limit        int
+	Line, Column int // where the limit was exceeded, if known
+}
+
+func (e *LimitError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("xmlquery: document exceeds %s (%d) at line %d, column %d", e.Option, e.Limit, e.Line, e.Column)
+	}
+	return fmt.Sprintf("xmlquery: document exceeds %s (%d)", e.Option, e.Limit)
+}
+
+// SecureParserOptions returns options suited to documents from untrusted
+// sources: DOCTYPE declarations are refused and the nesting of elements,
+// the size of tokens and the expansion of entities are limited.
+//
+// The parser never reads external entities, only the internal entities
+// declared by a DTD are expanded, so documents cannot make it read local
+// files or URLs (XXE) whatever the options.
+func SecureParserOptions() ParserOptions {
+	return ParserOptions{
+		DisallowDoctype:     true,
+		MaxEntityExpansions: 10000,
+		MaxExpansionRatio:   100,
+		MaxDepth:            1000,
+		MaxTokenSize:        10 << 20,
+	}
+}
+
+// SecureParse is like Parse but with the options of SecureParserOptions.
+func SecureParse(r io.Reader) (*Node, error) {
+	return parse(r, SecureParserOptions())
+}
+
+// Returns the number of references to the given entities in raw input.
+func countEntityRefs(raw []byte, entities map[string]string) int {
+	if bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+		return 0
+	}
+	count := 0
+	for {
+		i := bytes.IndexByte(raw, '&')
+		if i < 0 {
+			return count
+		}
+		raw = raw[i+1:]
+		j := bytes.IndexByte(raw, ';')
+		if j < 0 {
+			return count
+		}
+		if _, ok := entities[string(raw[:j])]; ok {
+			count++
+		}
+		raw = raw[j+1:]
+	}
+}
@@ -0,0 +1,59 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecureParse(t *testing.T) {
+	if _, err := SecureParse(strings.NewReader(`<?xml version="1.0"?><a><b>ok</b></a>`)); err != nil {
+		t.Fatal(err)
+	}
+	s := `<?xml version="1.0"?><!DOCTYPE a [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><a>&xxe;</a>`
+	if _, err := SecureParse(strings.NewReader(s)); err != ErrDoctype {
+		t.Fatalf("expected ErrDoctype, but got %v", err)
+	}
+}
+
+func TestParserLimits(t *testing.T) {
+	tests := []struct {
+		s      string
+		opts   ParserOptions
+		option string
+	}{
+		{`<a><b><c/></b></a>`, ParserOptions{MaxDepth: 2}, "MaxDepth"},
+		{`<a>` + strings.Repeat("x", 100) + `</a>`, ParserOptions{MaxTokenSize: 50}, "MaxTokenSize"},
+		{`<a x="` + strings.Repeat("x", 100) + `"/>`, ParserOptions{MaxTokenSize: 50}, "MaxTokenSize"},
+		{`<a x="&e;&e;">&e;&amp;&e;</a>`, ParserOptions{Entity: map[string]string{"e": "!"}, MaxEntityExpansions: 3}, "MaxEntityExpansions"},
+		{`<a x="1" y="2" z="3"/>`, ParserOptions{MaxAttrCount: 2}, "MaxAttrCount"},
+		{`<a xmlns:p="urn:p" p:x="1"/>`, ParserOptions{MaxAttrCount: 1}, "MaxAttrCount"},
+		{`<a><b x="` + strings.Repeat("x", 11) + `"/></a>`, ParserOptions{MaxAttrValueLen: 10}, "MaxAttrValueLen"},
+		{`<a x="&e;&e;"/>`, ParserOptions{Entity: map[string]string{"e": "xxxxxx"}, MaxAttrValueLen: 10}, "MaxAttrValueLen"},
+		{`<a><b/>text<!--c--></a>`, ParserOptions{MaxTotalNodes: 3}, "MaxTotalNodes"},
+	}
+	for _, test := range tests {
+		_, err := ParseWithOptions(strings.NewReader(test.s), test.opts)
+		limitErr, ok := err.(*LimitError)
+		if !ok || limitErr.Option != test.option {
+			t.Errorf("%s: expected a %s error, but got %v", test.s, test.option, err)
+		}
+	}
+
+	within := []struct {
+		s    string
+		opts ParserOptions
+	}{
+		{`<a><b><c/></b></a>`, ParserOptions{MaxDepth: 3}},
+		{`<?xml version="1.0"?><a><b/></a>`, ParserOptions{MaxDepth: 2}},
+		{`<a>` + strings.Repeat("x", 40) + `</a>`, ParserOptions{MaxTokenSize: 50}},
+		{`<a x="&e;"><![CDATA[&e;&e;&e;]]>&e;&amp;&e;</a>`, ParserOptions{Entity: map[string]string{"e": "!"}, MaxEntityExpansions: 3}},
+		{`<a x="1" y="2"/>`, ParserOptions{MaxAttrCount: 2}},
+		{`<a x="` + strings.Repeat("x", 10) + `"/>`, ParserOptions{MaxAttrValueLen: 10}},
+		{`<a> <b/> <!--c--></a>`, ParserOptions{MaxTotalNodes: 2, DiscardWhitespace: true, DiscardComments: true}},
+	}
+	for _, test := range within {
+		if _, err := ParseWithOptions(strings.NewReader(test.s), test.opts); err != nil {
+			t.Errorf("%s: %v", test.s, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package xmlquery
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sync/atomic"
+
+	"github.com/gjvnq/xpath"
+)
+
+// The text written in place of sensitive values.
+const maskedValue = "***"
+
+// sensitiveMarked is set once MarkSensitive has been called. Until then,
+// nodes detached from trees do not look for marks to take along.
+var sensitiveMarked int32
+
+// sensitivity holds the marks MarkSensitive set on an element. The marks of
+// the elements of a tree are held by its root, which hands them over to the
+// subtrees detached from it and takes those of the subtrees added to it.
+type sensitivity struct {
+	text  bool     // the content of the element is sensitive
+	attrs []string // the names of the sensitive attributes
+}
+
+// MarkSensitive marks the elements and attributes selected by expr, in the
+// tree of n, as holding sensitive values such as passwords. The values of
+// such attributes, and the content of such elements, are masked by String,
+// Dump, LogValue (with Go 1.21 or later) and the output functions given
+// OutputOptions.MaskSensitive, so that they do not end up in logs. Queries
+// and InnerText are not affected.
+func (n *Node) MarkSensitive(expr string) (err error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return err
+	}
+	info := addRootInfo(treeRoot(n))
+	if info.sensitive == nil {
+		info.sensitive = make(map[*Node]*sensitivity)
+	}
+	atomic.StoreInt32(&sensitiveMarked, 1)
+	defer recoverEval(expr, &err)
+	t := exp.Select(CreateXPathNavigator(n))
+	for t.MoveNext() {
+		nav := t.Current().(*NodeNavigator)
+		switch nav.NodeType() {
+		case xpath.AttributeNode:
+			elem := nav.curr
+			marks := markOf(info.sensitive, elem)
+			name := xml_name2string(elem.Attr[nav.attr].Name)
+			if !marks.hasAttr(name) {
+				marks.attrs = append(marks.attrs, name)
+			}
+		case xpath.ElementNode:
+			markOf(info.sensitive, nav.curr).text = true
+		}
+	}
+	return nil
+}
+
+// Dump returns n and its descendants as indented XML with the sensitive
+// values masked, for logging and debugging.
+func (n *Node) Dump() string {
+	var buf bytes.Buffer
+	n.OutputXMLWithOptions(&buf, true, OutputOptions{Pretty: true, MaskSensitive: true})
+	return buf.String()
+}
+
+// Returns the marks of elem in marks, adding them if needed.
+func markOf(marks map[*Node]*sensitivity, elem *Node) *sensitivity {
+	s := marks[elem]
+	if s == nil {
+		s = &sensitivity{}
+		marks[elem] = s
+	}
+	return s
+}
+
+// Returns the marks of the elements of the tree of n, by element.
+func sensitiveMarks(n *Node) map[*Node]*sensitivity {
+	if atomic.LoadInt32(&sensitiveMarked) == 0 {
+		return nil
+	}
+	if info := rootInfo(treeRoot(n)); info != nil {
+		return info.sensitive
+	}
+	return nil
+}
+
+func (s *sensitivity) hasAttr(name string) bool {
+	if s == nil {
+		return false
+	}
+	for _, masked := range s.attrs {
+		if masked == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns value, the value of the attribute with the given name, masked if
+// the attribute is sensitive. s may be nil.
+func (s *sensitivity) maskAttr(name, value string) string {
+	if s.hasAttr(name) {
+		return maskedValue
+	}
+	return value
+}
+
+// Returns attrs with the values of the sensitive attributes masked.
+func (s *sensitivity) maskAttrs(attrs []xml.Attr) []xml.Attr {
+	if len(s.attrs) == 0 {
+		return attrs
+	}
+	masked := make([]xml.Attr, len(attrs))
+	for i, attr := range attrs {
+		attr.Value = s.maskAttr(xml_name2string(attr.Name), attr.Value)
+		masked[i] = attr
+	}
+	return masked
+}
+
+// Returns true if n is inside a sensitive element.
+func (n *Node) isMasked() bool {
+	marks := sensitiveMarks(n)
+	if len(marks) == 0 {
+		return false
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if s := marks[p]; s != nil && s.text {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves the marks of the elements of the subtree of n, held by the root of
+// its tree, to n, which is about to be detached from the tree. The marks
+// are dropped instead if drop is set.
+func detachMarks(n *Node, drop bool) {
+	if n.Parent == nil || atomic.LoadInt32(&sensitiveMarked) == 0 {
+		return
+	}
+	info := rootInfo(treeRoot(n))
+	if info == nil || len(info.sensitive) == 0 {
+		return
+	}
+	for elem, s := range info.sensitive {
+		if !isAncestorOrSelf(n, elem) {
+			continue
+		}
+		delete(info.sensitive, elem)
+		if !drop {
+			own := addRootInfo(n)
+			if own.sensitive == nil {
+				own.sensitive = make(map[*Node]*sensitivity)
+			}
+			own.sensitive[elem] = s
+		}
+	}
+}
+
+// Gives the nodes of dst, a copy of src, the marks of their originals in
+// marks, held by the root of dst. Only src itself is copied unless deep.
+func copyMarks(src, dst *Node, marks map[*Node]*sensitivity, deep bool) {
+	if len(marks) == 0 {
+		return
+	}
+	var copied map[*Node]*sensitivity
+	var walk func(src, dst *Node)
+	walk = func(src, dst *Node) {
+		if s := marks[src]; s != nil {
+			if copied == nil {
+				copied = make(map[*Node]*sensitivity)
+			}
+			copied[dst] = &sensitivity{text: s.text, attrs: append([]string(nil), s.attrs...)}
+		}
+		if !deep {
+			return
+		}
+		for s, d := src.FirstChild, dst.FirstChild; s != nil && d != nil; s, d = s.NextSibling, d.NextSibling {
+			walk(s, d)
+		}
+	}
+	walk(src, dst)
+	if copied != nil {
+		addRootInfo(dst).sensitive = copied
+	}
+}
+
+// Reports whether n is elem or one of its ancestors.
+func isAncestorOrSelf(n, elem *Node) bool {
+	for ; elem != nil; elem = elem.Parent {
+		if elem == n {
+			return true
+		}
+	}
+	return false
+}
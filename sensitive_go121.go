@@ -0,0 +1,16 @@
+//go:build go1.21
+
+package xmlquery
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// LogValue implements slog.LogValuer, so that n is logged as its XML, on a
+// single line, with the sensitive values masked as by Dump.
+func (n *Node) LogValue() slog.Value {
+	var buf strings.Builder
+	n.OutputXMLWithOptions(&buf, true, OutputOptions{MaskSensitive: true})
+	return slog.StringValue(buf.String())
+}
@@ -0,0 +1,23 @@
+//go:build go1.21
+
+package xmlquery
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogValue(t *testing.T) {
+	doc := loadXML(`<config><db host="localhost" password="hunter2"/><token>abc</token></config>`)
+	if err := doc.MarkSensitive("//@password | //token"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("loaded", "config", FindOne(doc, "/config"))
+	got := buf.String()
+	if strings.Contains(got, "hunter2") || strings.Contains(got, "abc") || !strings.Contains(got, `password=\"***\"`) || !strings.Contains(got, "<token>***</token>") {
+		t.Errorf("unexpected log line %s", got)
+	}
+}
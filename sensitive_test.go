@@ -0,0 +1,90 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkSensitive(t *testing.T) {
+	doc := loadXML(`<config><db host="localhost" password="hunter2"/><token><value>abc</value></token><name>app</name></config>`)
+	if err := doc.MarkSensitive("//@password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.MarkSensitive("//token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.MarkSensitive("//["); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+
+	db := FindOne(doc, "//db")
+	if got, expected := db.String(), `Node{<db host="localhost" password="***">}`; got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got := FindOne(doc, "//value/text()").String(); got != `Node{"***"}` {
+		t.Fatalf("unexpected text %s", got)
+	}
+	dump := FindOne(doc, "/config").Dump()
+	if strings.Contains(dump, "hunter2") || strings.Contains(dump, "abc") || !strings.Contains(dump, "<token>***</token>") || !strings.Contains(dump, "app") {
+		t.Fatalf("unexpected dump %s", dump)
+	}
+
+	// Values stay available to the application.
+	if db.SelectAttr("password") != "hunter2" || FindOne(doc, "//token").InnerText() != "abc" {
+		t.Fatal("marking values as sensitive should not change them")
+	}
+	if !strings.Contains(doc.OutputXML(false), "hunter2") {
+		t.Fatal("OutputXML should only mask values when asked to")
+	}
+	if got := db.CompactCopy().String(); !strings.Contains(got, `password="***"`) {
+		t.Fatalf("CompactCopy should keep sensitive marks, but got %s", got)
+	}
+}
+
+func TestSensitiveMarksFollowNodes(t *testing.T) {
+	doc := loadXML(`<config><db password="hunter2"><secret key="k">abc</secret></db><other/></config>`)
+	if err := doc.MarkSensitive("//@password | //secret | //@key"); err != nil {
+		t.Fatal(err)
+	}
+	db := FindOne(doc, "//db")
+	if got := FindOne(doc, "//secret").OutputXML(true); got != `<secret key="k">abc</secret>` {
+		t.Fatalf("unexpected output %s", got)
+	}
+	if got := db.Dump(); !strings.Contains(got, `<db password="***">`) || !strings.Contains(got, `<secret key="***">***</secret>`) {
+		t.Fatalf("unexpected dump %s", got)
+	}
+
+	db.Detach()
+	if got := db.Clone(true).Dump(); strings.Contains(got, "hunter2") || strings.Contains(got, "abc") {
+		t.Fatalf("a clone of a detached subtree should keep its marks, but got %s", got)
+	}
+	other := loadXML(`<r/>`)
+	FindOne(other, "//r").AddChild(db)
+	if got := other.Dump(); strings.Contains(got, "hunter2") || strings.Contains(got, `"k"`) {
+		t.Fatalf("a subtree added to another tree should keep its marks, but got %s", got)
+	}
+	if len(sensitiveMarks(doc)) != 0 {
+		t.Fatalf("the former tree still holds %d marks", len(sensitiveMarks(doc)))
+	}
+
+	db.DeleteMe()
+	if marks := sensitiveMarks(other); len(marks) != 0 {
+		t.Fatalf("deleted elements still hold %d marks", len(marks))
+	}
+}
+
+func TestSensitiveMarksConcurrentReads(t *testing.T) {
+	doc := loadXML(`<config><db xml:id="db" password="hunter2"/></config>`)
+	if err := doc.MarkSensitive("//@password"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		doc.GetElementByID("db")
+		done <- true
+	}()
+	if strings.Contains(doc.Dump(), "hunter2") {
+		t.Error("the password should be masked")
+	}
+	<-done
+}
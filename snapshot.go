@@ -0,0 +1,96 @@
+package xmlquery
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only copy of a tree, made by Freeze, that any number of
+// goroutines may query at once. Its nodes must not be modified; use Thaw to
+// get a copy that can be.
+type Snapshot struct {
+	root *Node
+}
+
+// Freeze returns a snapshot of the subtree rooted at n. The snapshot is a
+// compact deep copy (see CompactCopy) with its indexes built in advance, so
+// that later changes to n do not affect it and queries on it never write to
+// the tree.
+func (n *Node) Freeze() *Snapshot {
+	root := n.CompactCopy()
+	root.BuildIndex()
+	docMu.Lock()
+	buildIDIndex(root)
+	docMu.Unlock()
+	return &Snapshot{root: root}
+}
+
+// Root returns the root of the snapshot. The nodes reachable from it are
+// shared by every reader of the snapshot and must not be modified.
+func (s *Snapshot) Root() *Node {
+	return s.root
+}
+
+// Find is like the package-level Find, evaluated from the root of s.
+func (s *Snapshot) Find(expr string) []*Node {
+	return Find(s.root, expr)
+}
+
+// FindOne is like the package-level FindOne, evaluated from the root of s.
+func (s *Snapshot) FindOne(expr string) *Node {
+	return FindOne(s.root, expr)
+}
+
+// QueryAll is like the package-level QueryAll, evaluated from the root of s.
+func (s *Snapshot) QueryAll(expr string) ([]*Node, error) {
+	return QueryAll(s.root, expr)
+}
+
+// Query is like the package-level Query, evaluated from the root of s.
+func (s *Snapshot) Query(expr string) (*Node, error) {
+	return Query(s.root, expr)
+}
+
+// Thaw returns a deep copy of the snapshot that can be modified freely, and
+// frozen again once the changes are done.
+func (s *Snapshot) Thaw() *Node {
+	return s.root.Clone(true)
+}
+
+// SharedDocument holds the current snapshot of a document that is read by
+// many goroutines and occasionally updated, as in a server caching parsed
+// XML. Readers get the current snapshot without locking; writers edit a copy
+// that replaces it when they are done, so readers never see a change half
+// made and keep a consistent view for as long as they hold a snapshot.
+type SharedDocument struct {
+	mu      sync.Mutex   // serializes writers
+	current atomic.Value // *Snapshot
+}
+
+// NewSharedDocument returns a SharedDocument whose first snapshot is a copy
+// of doc.
+func NewSharedDocument(doc *Node) *SharedDocument {
+	d := &SharedDocument{}
+	d.current.Store(doc.Freeze())
+	return d
+}
+
+// Snapshot returns the current snapshot of the document.
+func (d *SharedDocument) Snapshot() *Snapshot {
+	return d.current.Load().(*Snapshot)
+}
+
+// Update calls fn with a modifiable copy of the current snapshot and, if fn
+// returns nil, publishes the copy as the new snapshot. Updates run one at a
+// time, each seeing the changes of the previous ones; readers are not
+// blocked meanwhile. The error of fn is returned as is.
+func (d *SharedDocument) Update(fn func(doc *Node) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	doc := d.Snapshot().Thaw()
+	if err := fn(doc); err != nil {
+		return err
+	}
+	d.current.Store(doc.Freeze())
+	return nil
+}
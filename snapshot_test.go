@@ -0,0 +1,97 @@
+package xmlquery
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	doc := loadXML(`<list><item xml:id="a">1</item><item>2</item></list>`)
+	snap := doc.Freeze()
+	FindOne(doc, "/list").AddChild(NewElement("item"))
+	if n := len(snap.Find("//item")); n != 2 {
+		t.Errorf("expected the snapshot to keep 2 items, got %d", n)
+	}
+	if n := snap.Root().GetElementByID("a"); n == nil || n.InnerText() != "1" {
+		t.Errorf("expected the element with ID a, got %v", n)
+	}
+	if n, err := snap.Query("//item[2]"); err != nil || n.InnerText() != "2" {
+		t.Errorf("expected the second item, got %v, %v", n, err)
+	}
+
+	edit := snap.Thaw()
+	FindOne(edit, "//item").SetAttr("x", "1")
+	if snap.FindOne("//item[@x]") != nil {
+		t.Error("expected changes to the thawed copy not to affect the snapshot")
+	}
+}
+
+func TestFreezeConcurrentQueries(t *testing.T) {
+	doc := loadXML(`<list xmlns:p="urn:p"><item kind="a">1</item><p:item kind="a">2</p:item><item p:kind="a">3</item></list>`)
+	snap := doc.Freeze()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				// Indexed queries give the same nodes as XPath.
+				items := snap.Find("//item[@kind='a']")
+				if len(items) != 1 || items[0].InnerText() != "1" {
+					t.Errorf("expected the first item, got %v", NodeList(items).Texts())
+					return
+				}
+				if v, err := Evaluate(snap.Root(), "count(//p:item)"); err != nil || v != float64(1) {
+					t.Errorf("expected 1, got %v (%v)", v, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSharedDocument(t *testing.T) {
+	shared := NewSharedDocument(loadXML(`<list><item>0</item></list>`))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				snap := shared.Snapshot()
+				items := snap.Find("//item")
+				if n := len(snap.Find("/list/item")); n != len(items) {
+					t.Errorf("inconsistent snapshot: %d and %d items", len(items), n)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		err := shared.Update(func(doc *Node) error {
+			FindOne(doc, "/list").AddChild(NewElement("item"))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if n := len(shared.Snapshot().Find("//item")); n != 11 {
+		t.Errorf("expected 11 items, got %d", n)
+	}
+
+	errStop := errors.New("stop")
+	err := shared.Update(func(doc *Node) error {
+		FindOne(doc, "/list").AddChild(NewElement("item"))
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("expected the error of the update, got %v", err)
+	}
+	if n := len(shared.Snapshot().Find("//item")); n != 11 {
+		t.Errorf("expected the failed update to be dropped, got %d items", n)
+	}
+}
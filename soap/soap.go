@@ -0,0 +1,190 @@
+/*
+Package soap wraps and unwraps SOAP messages built from xmlquery Nodes.
+Envelopes are built in SOAP 1.1, the version most services still speak,
+and parsed in both SOAP 1.1 and 1.2.
+*/
+package soap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+// Namespace URIs of the SOAP envelope.
+const (
+	NamespaceSOAP11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	NamespaceSOAP12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// BuildEnvelope returns a SOAP 1.1 Envelope element whose Body holds body
+// and whose Header, written only if headers are given, holds the headers.
+// If body or a header is a document, its content other than the XML
+// declaration and DOCTYPE is used instead. The nodes are moved into the
+// envelope, so they are detached from their tree; Clone them to keep it
+// intact. body may be nil for an empty Body.
+func BuildEnvelope(body *xmlquery.Node, headers ...*xmlquery.Node) *xmlquery.Node {
+	env := newSOAPElement("Envelope").WithAttr("xmlns:soap", NamespaceSOAP11)
+	if len(headers) > 0 {
+		header := newSOAPElement("Header")
+		env.InsertBefore(header, nil)
+		for _, h := range headers {
+			moveContent(header, h)
+		}
+	}
+	b := newSOAPElement("Body")
+	env.InsertBefore(b, nil)
+	if body != nil {
+		moveContent(b, body)
+	}
+	return env
+}
+
+func newSOAPElement(name string) *xmlquery.Node {
+	n := xmlquery.NewElement("soap:" + name)
+	n.NamespaceURI = NamespaceSOAP11
+	return n
+}
+
+// Moves n, or its content if it is a document, to the end of parent.
+func moveContent(parent, n *xmlquery.Node) {
+	if n.Type != xmlquery.DocumentNode {
+		parent.InsertBefore(n, nil)
+		return
+	}
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type != xmlquery.DeclarationNode && child.Type != xmlquery.DoctypeNode {
+			parent.InsertBefore(child, nil)
+		}
+		child = next
+	}
+}
+
+// Envelope is a parsed SOAP message.
+type Envelope struct {
+	// Namespace is the namespace URI of the envelope, NamespaceSOAP11 or
+	// NamespaceSOAP12.
+	Namespace string
+	// Document is the parsed message.
+	Document *xmlquery.Node
+	// Header is the Header element, or nil if the message has none.
+	Header *xmlquery.Node
+	// Body is the Body element.
+	Body *xmlquery.Node
+	// Fault is the Fault element of the body, or nil if the message is not
+	// a fault.
+	Fault *xmlquery.Node
+}
+
+// ParseEnvelope parses the SOAP message read from r. It returns an error if
+// the message is not well-formed XML or is not a SOAP 1.1 or 1.2 envelope
+// with a Body.
+func ParseEnvelope(r io.Reader) (*Envelope, error) {
+	doc, err := xmlquery.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	root := firstElement(doc)
+	if root == nil || root.Data != "Envelope" || (root.NamespaceURI != NamespaceSOAP11 && root.NamespaceURI != NamespaceSOAP12) {
+		return nil, errors.New("soap: not a SOAP envelope")
+	}
+	env := &Envelope{Namespace: root.NamespaceURI, Document: doc}
+	for child := firstElement(root); child != nil; child = nextElement(child) {
+		if child.NamespaceURI != env.Namespace {
+			continue
+		}
+		switch child.Data {
+		case "Header":
+			if env.Header == nil {
+				env.Header = child
+			}
+		case "Body":
+			if env.Body == nil {
+				env.Body = child
+			}
+		}
+	}
+	if env.Body == nil {
+		return nil, errors.New("soap: envelope has no Body")
+	}
+	if f := firstElement(env.Body); f != nil && f.Data == "Fault" && f.NamespaceURI == env.Namespace {
+		env.Fault = f
+	}
+	return env, nil
+}
+
+// Content returns the first element of the body, which holds the request or
+// response of most messages, or nil if the body is empty.
+func (e *Envelope) Content() *xmlquery.Node {
+	return firstElement(e.Body)
+}
+
+// Err returns the fault of the message as a *FaultError, or nil if the
+// message is not a fault.
+func (e *Envelope) Err() error {
+	if e.Fault == nil {
+		return nil
+	}
+	err := &FaultError{Fault: e.Fault}
+	if e.Namespace == NamespaceSOAP12 {
+		err.Code = childText(e.Fault, e.Namespace, "Code", "Value")
+		err.Reason = childText(e.Fault, e.Namespace, "Reason", "Text")
+	} else {
+		// The children of a SOAP 1.1 fault are not namespace qualified.
+		err.Code = childText(e.Fault, "", "faultcode")
+		err.Reason = childText(e.Fault, "", "faultstring")
+	}
+	return err
+}
+
+// FaultError is the error returned by Envelope.Err for fault messages.
+type FaultError struct {
+	// Code is the fault code, such as soap:Server.
+	Code string
+	// Reason is the human readable explanation of the fault.
+	Reason string
+	// Fault is the Fault element, for access to its details.
+	Fault *xmlquery.Node
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("soap: fault %s: %s", e.Code, e.Reason)
+}
+
+// Returns the trimmed text of the element found by following path from n,
+// one child element name per step, or "" if there is none.
+func childText(n *xmlquery.Node, namespace string, path ...string) string {
+	for _, name := range path {
+		child := firstElement(n)
+		for child != nil && (child.Data != name || child.NamespaceURI != namespace) {
+			child = nextElement(child)
+		}
+		if child == nil {
+			return ""
+		}
+		n = child
+	}
+	return strings.TrimSpace(n.InnerText())
+}
+
+func firstElement(n *xmlquery.Node) *xmlquery.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+func nextElement(n *xmlquery.Node) *xmlquery.Node {
+	for n = n.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode {
+			return n
+		}
+	}
+	return nil
+}
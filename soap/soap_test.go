@@ -0,0 +1,77 @@
+package soap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+func TestBuildEnvelope(t *testing.T) {
+	body, err := xmlquery.Parse(strings.NewReader(`<?xml version="1.0"?><GetPrice xmlns="urn:shop"><Item>Apples</Item></GetPrice>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := BuildEnvelope(body, xmlquery.NewElement("Auth").WithAttr("token", "t"))
+	expected := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<soap:Header><Auth token="t"/></soap:Header>` +
+		`<soap:Body><GetPrice xmlns="urn:shop"><Item>Apples</Item></GetPrice></soap:Body></soap:Envelope>`
+	if got := env.OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	parsed, err := ParseEnvelope(strings.NewReader(env.OutputXML(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Namespace != NamespaceSOAP11 || parsed.Header == nil || parsed.Fault != nil || parsed.Err() != nil {
+		t.Errorf("unexpected envelope %+v", parsed)
+	}
+	if c := parsed.Content(); c == nil || c.Data != "GetPrice" || c.NamespaceURI != "urn:shop" {
+		t.Errorf("unexpected content %v", c)
+	}
+
+	if got := BuildEnvelope(nil).OutputXML(true); got != `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body/></soap:Envelope>` {
+		t.Errorf("unexpected empty envelope %s", got)
+	}
+}
+
+func TestParseEnvelopeFault(t *testing.T) {
+	tests := []struct {
+		msg          string
+		code, reason string
+	}{
+		{`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>` +
+			`<faultcode>s:Server</faultcode><faultstring> Out of apples </faultstring></s:Fault></s:Body></s:Envelope>`,
+			"s:Server", "Out of apples"},
+		{`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault>` +
+			`<env:Code><env:Value>env:Sender</env:Value></env:Code><env:Reason><env:Text xml:lang="en">Bad item</env:Text></env:Reason>` +
+			`</env:Fault></env:Body></env:Envelope>`,
+			"env:Sender", "Bad item"},
+	}
+	for _, test := range tests {
+		env, err := ParseEnvelope(strings.NewReader(test.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Header != nil || env.Fault == nil {
+			t.Errorf("unexpected envelope %+v", env)
+		}
+		fault, ok := env.Err().(*FaultError)
+		if !ok || fault.Code != test.code || fault.Reason != test.reason {
+			t.Errorf("unexpected fault %v", env.Err())
+		}
+	}
+}
+
+func TestParseEnvelopeErrors(t *testing.T) {
+	for _, msg := range []string{
+		`<Envelope><Body/></Envelope>`,
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Header/></s:Envelope>`,
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">`,
+	} {
+		if _, err := ParseEnvelope(strings.NewReader(msg)); err == nil {
+			t.Errorf("%s: expected an error", msg)
+		}
+	}
+}
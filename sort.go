@@ -0,0 +1,73 @@
+package xmlquery
+
+import (
+	"sort"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// SortNodes sorts nodes in place by the string returned by key for each of
+// them, compared using the rules of collator, so that names and titles are
+// ordered the way readers of the language expect ("Ärger" next to "Arger"
+// rather than after "Zorn"). The sort is stable.
+//
+// If key is nil the inner text of the nodes is used. If collator is nil one
+// is created for the xml:lang in scope at the first node, falling back to
+// the root language when there is none.
+func SortNodes(nodes []*Node, key func(*Node) string, collator *collate.Collator) {
+	if len(nodes) < 2 {
+		return
+	}
+	if key == nil {
+		key = (*Node).InnerText
+	}
+	if collator == nil {
+		tag, _ := language.Parse(nodes[0].ResolveXMLLang())
+		collator = collate.New(tag)
+	}
+	keys := make(map[*Node]string, len(nodes))
+	for _, n := range nodes {
+		keys[n] = key(n)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return collator.CompareString(keys[nodes[i]], keys[nodes[j]]) < 0
+	})
+}
+
+// SortChildren reorders the children of n so that they are sorted by less,
+// keeping the order of those that compare equal. If elementsOnly is set,
+// only the elements are reordered, among the places taken by elements, and
+// the text, comments and other nodes between them stay where they are.
+func (n *Node) SortChildren(less func(a, b *Node) bool, elementsOnly bool) {
+	dropIndex(n)
+	var all, sorted []*Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		all = append(all, child)
+		if !elementsOnly || child.Type == ElementNode {
+			sorted = append(sorted, child)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	next := 0
+	for i, child := range all {
+		if !elementsOnly || child.Type == ElementNode {
+			all[i] = sorted[next]
+			next++
+		}
+	}
+	var prev *Node
+	for _, child := range all {
+		child.PrevSibling = prev
+		if prev != nil {
+			prev.NextSibling = child
+		}
+		prev = child
+	}
+	if prev != nil {
+		prev.NextSibling = nil
+		n.FirstChild, n.LastChild = all[0], prev
+	}
+}
@@ -0,0 +1,72 @@
+package xmlquery
+
+import (
+	"testing"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func TestSortNodes(t *testing.T) {
+	doc := loadXML(`<names xml:lang="de"><n>Zorn</n><n>Ärger</n><n>apfel</n><n>Birne</n></names>`)
+	names := func(nodes []*Node) []string {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, n.InnerText())
+		}
+		return s
+	}
+
+	nodes := Find(doc, "//n")
+	SortNodes(nodes, nil, nil)
+	if got, expected := names(nodes), []string{"apfel", "Ärger", "Birne", "Zorn"}; !equalStrings(got, expected) {
+		t.Fatalf("\nexpected: %v\ngot:      %v", expected, got)
+	}
+
+	byLength := func(n *Node) string { return string(rune('0' + len(n.InnerText()))) }
+	SortNodes(nodes, byLength, collate.New(language.German))
+	if got, expected := names(nodes), []string{"Zorn", "apfel", "Birne", "Ärger"}; !equalStrings(got, expected) {
+		t.Fatalf("\nexpected: %v\ngot:      %v", expected, got)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSortChildren(t *testing.T) {
+	byName := func(a, b *Node) bool {
+		return a.SelectAttr("name") < b.SelectAttr("name")
+	}
+	doc := loadXML(`<deps><dep name="c"/><!-- pinned --><dep name="a"/> <dep name="b" v="1"/><dep name="b" v="2"/></deps>`)
+	deps := FindOne(doc, "/deps")
+	deps.SortChildren(byName, true)
+	expected := `<deps><dep name="a"/><!-- pinned --><dep name="b" v="1"/> <dep name="b" v="2"/><dep name="c"/></deps>`
+	if got := deps.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	deps.SortChildren(func(a, b *Node) bool {
+		return a.Type == ElementNode && b.Type != ElementNode
+	}, false)
+	expected = `<deps><dep name="a"/><dep name="b" v="1"/><dep name="b" v="2"/><dep name="c"/><!-- pinned --> </deps>`
+	if got := deps.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	empty := NewElement("empty")
+	empty.SortChildren(byName, false)
+	if empty.FirstChild != nil || empty.LastChild != nil {
+		t.Error("sorting no children should leave none")
+	}
+}
@@ -0,0 +1,335 @@
+package xmlquery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/gjvnq/xpath"
+)
+
+// streamer incrementally builds a partial tree from an xml.Decoder, keeping
+// only the ancestors of the current token plus the subtrees of elements that
+// matched one of its expressions.
+type streamer struct {
+	decoder      *xml.Decoder
+	doc          *Node
+	parent       *Node
+	space2prefix map[string]string
+	exprs        []*cachedExpr
+
+	// matches holds, for every open element, the indexes of the expressions
+	// it matched when it was started.
+	matches map[*Node][]int
+	// inMatch counts the open elements that matched at least one expression.
+	inMatch int
+	// done is the last emitted subtree, detached on the following call to next.
+	done *Node
+	// base is the offset in the input of the first byte read by the decoder,
+	// past the byte order mark, if any.
+	base int64
+}
+
+func newStreamer(r io.Reader, exprs []*cachedExpr) (*streamer, error) {
+	br := bufio.NewReader(r)
+	var base int64
+	if head, _ := br.Peek(3); bytes.Equal(head, []byte{0xEF, 0xBB, 0xBF}) {
+		base = 3
+	}
+	decoder, enc, err := newDecoder(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Node{Type: DocumentNode, doc: &docInfo{encoding: enc}}
+	return &streamer{
+		decoder:      decoder,
+		doc:          doc,
+		parent:       doc,
+		space2prefix: newNamespaceTable(),
+		exprs:        exprs,
+		matches:      make(map[*Node][]int),
+		base:         base,
+	}, nil
+}
+
+// Returns true if n is selected by expr when evaluated from the document root.
+func (s *streamer) selects(expr *cachedExpr, n *Node) (ok bool, err error) {
+	defer recoverEval(expr.String(), &err)
+	t := expr.Select(CreateXPathNavigator(s.doc))
+	for t.MoveNext() {
+		if getCurrentNode(t) == n {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// next returns the next element matched by at least one expression, along
+// with the indexes of the matching expressions. It returns io.EOF once the
+// input is exhausted.
+func (s *streamer) next() (*Node, []int, error) {
+	if s.done != nil {
+		if s.inMatch == 0 {
+			removeFromTree(s.done)
+		}
+		s.done = nil
+	}
+	for {
+		tok, err := s.decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			node, err := newElementNode(heapAllocator{}, s.space2prefix, tok, s.parent.level+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			addChild(s.parent, node)
+			s.parent = node
+			var which []int
+			for i, expr := range s.exprs {
+				ok, err := s.selects(expr, node)
+				if err != nil {
+					return nil, nil, err
+				}
+				if ok {
+					which = append(which, i)
+				}
+			}
+			if len(which) > 0 {
+				s.matches[node] = which
+				s.inMatch++
+			}
+		case xml.EndElement:
+			node := s.parent
+			s.parent = node.Parent
+			if which, ok := s.matches[node]; ok {
+				delete(s.matches, node)
+				s.inMatch--
+				s.done = node
+				return node, which, nil
+			}
+			if s.inMatch == 0 {
+				removeFromTree(node)
+			}
+		case xml.CharData:
+			if s.inMatch > 0 {
+				addChild(s.parent, &Node{Type: TextNode, Data: string(tok), level: s.parent.level + 1})
+			}
+		case xml.Comment:
+			if s.inMatch > 0 {
+				addChild(s.parent, &Node{Type: CommentNode, Data: string(tok), level: s.parent.level + 1})
+			}
+		case xml.ProcInst:
+			if tok.Target != "xml" && s.inMatch > 0 {
+				addChild(s.parent, &Node{Type: ProcInstNode, Data: tok.Target, Inst: string(tok.Inst), level: s.parent.level + 1})
+			} else if tok.Target == "xml" && s.parent == s.doc {
+				addChild(s.doc, newDeclarationNode(heapAllocator{}, tok, 1))
+			}
+		case xml.Directive:
+			if s.parent == s.doc && bytes.HasPrefix(tok, []byte("DOCTYPE")) {
+				addChild(s.doc, &Node{Type: DoctypeNode, Data: string(tok), level: 1})
+			}
+		}
+	}
+}
+
+// StreamParser reads an XML document one element at a time, returning the
+// elements selected by an expression as fully parsed subtrees. Unlike Parse,
+// it does not keep the whole document in memory, which makes it suitable for
+// feeds too large to be loaded at once.
+type StreamParser struct {
+	s      *streamer
+	filter *cachedExpr
+
+	expr, filterSrc string // the sources of the expressions, for checkpoints
+}
+
+// CreateStreamParser returns a parser reading from r that returns the
+// elements selected by expr, e.g. "/catalog/item". The restrictions on expr
+// are those of Subscribe.
+//
+// If a filter expression is given, as in antchfx/xmlquery, it is evaluated
+// against each selected element once it has been read in full, and only
+// the elements for which it selects a node or evaluates to true are
+// returned. Unlike expr, it can look at the children of the element:
+//
+//	CreateStreamParser(r, "/feed/entry", "./category/@term='go'")
+func CreateStreamParser(r io.Reader, expr string, filter ...string) (*StreamParser, error) {
+	exp, err := compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &StreamParser{expr: expr}
+	if len(filter) > 0 {
+		if p.filter, err = compile(filter[0]); err != nil {
+			return nil, err
+		}
+		p.filterSrc = filter[0]
+	}
+	if p.s, err = newStreamer(r, []*cachedExpr{exp}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Read returns the next element selected by the parser's expression, once
+// its end tag has been read. It returns io.EOF when the input is exhausted.
+//
+// The element is still attached to its ancestors, so it can be queried with
+// expressions going up the tree, but it is detached from them on the next
+// call to Read, together with the content read in the meantime.
+func (p *StreamParser) Read() (*Node, error) {
+	for {
+		n, _, err := p.s.next()
+		if err != nil || p.filter == nil {
+			return n, err
+		}
+		if ok, err := matchesFilter(p.filter, n); err != nil {
+			return nil, err
+		} else if ok {
+			return n, nil
+		}
+	}
+}
+
+// Checkpoint is the state of a StreamParser between two calls to Read, made
+// by StreamParser.Checkpoint, from which Resume carries on parsing without
+// going through the input read so far. Its fields can be saved, e.g. as
+// JSON, to recover from a crash of a long-running process.
+type Checkpoint struct {
+	// Offset is the offset in the input of the first byte not yet parsed.
+	Offset int64
+	// Open holds the start tags of the elements open at Offset, from the
+	// root element down, as read from the input: their namespace
+	// declarations are the namespace stack of the parser.
+	Open []string
+	// Expr and Filter are the expressions of the parser.
+	Expr, Filter string
+}
+
+// Checkpoint returns the state of p after the element last returned by
+// Read. It fails if that element is within another element selected by the
+// expression of p, whose partial subtree a checkpoint does not hold, or if
+// the input is not in UTF-8, for which offsets in the input are not known.
+func (p *StreamParser) Checkpoint() (Checkpoint, error) {
+	s := p.s
+	if s.inMatch > 0 {
+		return Checkpoint{}, errors.New("xmlquery: cannot checkpoint within a selected element")
+	}
+	if enc := s.doc.DetectedEncoding(); enc != "utf-8" && enc != "utf8" {
+		return Checkpoint{}, fmt.Errorf("xmlquery: cannot checkpoint input in %s", enc)
+	}
+	var open []string
+	for n := s.parent; n != s.doc; n = n.Parent {
+		open = append(open, startTag(n))
+	}
+	for i, j := 0, len(open)-1; i < j; i, j = i+1, j-1 {
+		open[i], open[j] = open[j], open[i]
+	}
+	return Checkpoint{
+		Offset: s.base + s.decoder.InputOffset(),
+		Open:   open,
+		Expr:   p.expr,
+		Filter: p.filterSrc,
+	}, nil
+}
+
+// Resume returns a parser carrying on from cp, reading from r the rest of
+// the input the checkpoint was made from. The elements it returns are
+// attached to copies of their open ancestors, but the content of the
+// document before cp, such as its DOCTYPE, is gone.
+func Resume(r io.ReaderAt, cp Checkpoint) (*StreamParser, error) {
+	var filter []string
+	if cp.Filter != "" {
+		filter = []string{cp.Filter}
+	}
+	// The start tags of the open elements are parsed again, followed by the
+	// rest of the input.
+	open := strings.Join(cp.Open, "")
+	rest := io.NewSectionReader(r, cp.Offset, math.MaxInt64-cp.Offset)
+	p, err := CreateStreamParser(io.MultiReader(strings.NewReader(open), rest), cp.Expr, filter...)
+	if err != nil {
+		return nil, err
+	}
+	p.s.base = cp.Offset - int64(len(open))
+	return p, nil
+}
+
+// Returns the start tag of the element n, with its attributes and
+// namespace declarations.
+func startTag(n *Node) string {
+	var buf strings.Builder
+	buf.WriteString("<")
+	buf.WriteString(qualifiedName(n))
+	for _, attr := range n.Attr {
+		buf.WriteString(" ")
+		buf.WriteString(xml_name2string(attr.Name))
+		buf.WriteString(`="`)
+		buf.WriteString(escapeAttrValue(attr.Value, '"'))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
+
+// Returns true if expr, evaluated with n as the context node, selects at
+// least one node or has a true, non-zero or non-empty value.
+func matchesFilter(expr *cachedExpr, n *Node) (ok bool, err error) {
+	defer recoverEval(expr.String(), &err)
+	switch res := expr.Evaluate(CreateXPathNavigator(n)).(type) {
+	case *xpath.NodeIterator:
+		return res.MoveNext(), nil
+	case bool:
+		return res, nil
+	case float64:
+		return res != 0, nil
+	case string:
+		return res != "", nil
+	}
+	return false, nil
+}
+
+// Subscribe reads the XML document from r in a single pass and calls fn for
+// every element selected by one of exprs, together with the expression that
+// selected it. An element selected by several expressions is dispatched once
+// per expression, in the order they were given.
+//
+// Only the ancestors of the current element and the subtrees being
+// dispatched are kept in memory, so expressions are tested as soon as an
+// element starts: predicates may look at its attributes and ancestors, but
+// not at its children or preceding siblings. The node passed to fn is
+// detached from its ancestors once fn returns, unless it is itself part of a
+// larger match.
+func Subscribe(r io.Reader, exprs []string, fn func(expr string, n *Node)) error {
+	compiled := make([]*cachedExpr, len(exprs))
+	for i, expr := range exprs {
+		exp, err := compile(expr)
+		if err != nil {
+			return err
+		}
+		compiled[i] = exp
+	}
+	s, err := newStreamer(r, compiled)
+	if err != nil {
+		return err
+	}
+	for {
+		node, which, err := s.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, i := range which {
+			fn(exprs[i], node)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package xmlquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	s := `<?xml version="1.0"?>
+<feed>
+	<entry id="1"><title>one</title><link href="a"/></entry>
+	<entry id="2"><title>two</title></entry>
+	<meta><link href="b"/></meta>
+</feed>`
+	var got []string
+	err := Subscribe(strings.NewReader(s), []string{"/feed/entry", "//link"}, func(expr string, n *Node) {
+		switch expr {
+		case "/feed/entry":
+			got = append(got, "entry:"+n.SelectAttr("id")+":"+n.InnerText())
+		case "//link":
+			got = append(got, "link:"+n.SelectAttr("href"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"link:a", "entry:1:one", "entry:2:two", "link:b"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("\nexpected: %v\ngot:      %v", expected, got)
+	}
+}
+
+func TestSubscribeDiscardsProcessedSubtrees(t *testing.T) {
+	s := `<feed><entry>1</entry><entry>2</entry><entry>3</entry></feed>`
+	count := 0
+	err := Subscribe(strings.NewReader(s), []string{"/feed/entry"}, func(expr string, n *Node) {
+		count++
+		if n.PrevSibling != nil {
+			t.Fatalf("previous entry was not discarded before %s", n.InnerText())
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 entries, but got %d", count)
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	if err := Subscribe(strings.NewReader(`<a/>`), []string{"//["}, func(string, *Node) {}); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+	if err := Subscribe(strings.NewReader(`<a><b></a>`), []string{"//b"}, func(string, *Node) {}); err == nil {
+		t.Fatal("expected an error for a malformed document")
+	}
+}
+
+func TestStreamParser(t *testing.T) {
+	s := `<catalog><title>Shop</title><item id="1"><name>pen</name></item><item id="2"><name>ink</name></item></catalog>`
+	p, err := CreateStreamParser(strings.NewReader(s), "/catalog/item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		n, err := p.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.PrevSibling != nil {
+			t.Fatalf("previous content was not discarded before item %s", n.SelectAttr("id"))
+		}
+		if n.Parent == nil || n.Parent.Data != "catalog" {
+			t.Fatal("item should be attached to its parent")
+		}
+		got = append(got, n.SelectAttr("id")+":"+FindOne(n, "name").InnerText())
+	}
+	if expected := "1:pen,2:ink"; strings.Join(got, ",") != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, strings.Join(got, ","))
+	}
+
+	if _, err := CreateStreamParser(strings.NewReader(s), "//["); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+}
+
+func TestStreamParserKeepsDoctype(t *testing.T) {
+	s := `<?xml version="1.0"?><!DOCTYPE catalog SYSTEM "catalog.dtd"><catalog><item/></catalog>`
+	p, err := CreateStreamParser(strings.NewReader(s), "/catalog/item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := n
+	for doc.Parent != nil {
+		doc = doc.Parent
+	}
+	expected := `<?xml version="1.0"?><!DOCTYPE catalog SYSTEM "catalog.dtd"><catalog><item/></catalog>`
+	if got := doc.OutputXML(false); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestStreamParserWithFilter(t *testing.T) {
+	s := `<feed><entry id="1"><category term="go"/></entry><entry id="2"><category term="rust"/></entry>` +
+		`<entry id="3"><category term="c"/><category term="go"/></entry></feed>`
+	for _, filter := range []string{"./category/@term='go'", "category[@term='go']"} {
+		p, err := CreateStreamParser(strings.NewReader(s), "/feed/entry", filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			n, err := p.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, n.SelectAttr("id"))
+		}
+		if expected := "1,3"; strings.Join(got, ",") != expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", filter, expected, strings.Join(got, ","))
+		}
+	}
+
+	if _, err := CreateStreamParser(strings.NewReader(s), "/feed/entry", "["); err == nil {
+		t.Fatal("expected an error for an invalid filter")
+	}
+}
+
+func TestStreamParserCheckpoint(t *testing.T) {
+	s := "\xEF\xBB\xBF" + `<?xml version="1.0"?>
+<feed xmlns:x="urn:x" lang="a&amp;b">
+	<entry id="1"><x:title>one</x:title></entry>
+	<group><entry id="2"><x:title>two</x:title></entry>
+	<entry id="3"><x:title>three</x:title></entry></group>
+	<entry id="4"><x:title>four</x:title></entry>
+</feed>`
+	read := func(p *StreamParser, count int) []string {
+		var ids []string
+		for i := 0; i != count; i++ {
+			n, err := p.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, n.SelectAttr("id")+":"+FindOne(n, "x:title").InnerText())
+		}
+		return ids
+	}
+	p, err := CreateStreamParser(strings.NewReader(s), "//entry", "x:title != 'three'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(read(p, 2), ","); got != "1:one,2:two" {
+		t.Fatalf("unexpected entries %s", got)
+	}
+	cp, err := p.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Open) != 2 || cp.Open[0] != `<feed xmlns:x="urn:x" lang="a&amp;b">` || cp.Open[1] != "<group>" {
+		t.Fatalf("unexpected open elements %q", cp.Open)
+	}
+
+	// The checkpoint survives being saved.
+	data, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.NewReader(s)
+	resumed, err := Resume(input, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := resumed.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.SelectAttr("id") != "4" || n.Parent.Data != "feed" || n.Parent.SelectAttr("lang") != "a&b" {
+		t.Fatalf("unexpected entry %s in %s", n.OutputXML(true), n.Parent.Data)
+	}
+
+	// Checkpoints of a resumed parser are offsets in the same input.
+	again, err := resumed.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s[again.Offset:], "\n</feed>") {
+		t.Errorf("unexpected offset %d before %q", again.Offset, s[again.Offset:])
+	}
+	if _, err := resumed.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestStreamParserCheckpointErrors(t *testing.T) {
+	p, err := CreateStreamParser(strings.NewReader(`<r><a id="1"><a id="2"/></a></r>`), "//a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Checkpoint(); err == nil {
+		t.Error("expected an error within a selected element")
+	}
+
+	p, err = CreateStreamParser(bytes.NewReader(utf16Bytes(`<r><a/></r>`, false, true)), "//a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Checkpoint(); err == nil {
+		t.Error("expected an error for UTF-16 input")
+	}
+}
@@ -0,0 +1,323 @@
+package xmlquery
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// TemplateNamespace is the namespace of the directives understood by
+// Template, usually bound to the "tpl" prefix.
+const TemplateNamespace = "urn:xmlquery:template"
+
+// Template is an XML document used to generate others, such as invoices or
+// reports, from Go values. Directives are given by attributes and elements
+// in TemplateNamespace:
+//
+//	tpl:repeat="path"      repeats the element once per item of a slice or array
+//	tpl:if="path"          keeps the element only if the value is not empty;
+//	                       "!path" keeps it only if the value is empty
+//	tpl:text="path"        replaces the content of the element with the value
+//	<tpl:value select="path"/>  is replaced by the value
+//
+// In addition, "{{path}}" is replaced by the value in attribute values and
+// text. Paths are dot-separated names of map keys, struct fields or slice
+// indexes, such as "customer.address.city" or "lines.0.price", and "."
+// refers to the current item of a tpl:repeat. Within a repeated element,
+// paths are looked up in the current item first and then in the enclosing
+// values, so the top-level data remains reachable.
+//
+// Values are written as text as in FromStruct; a *Node value is written as
+// its InnerText.
+//
+//	<invoice xmlns:tpl="urn:xmlquery:template" number="{{number}}">
+//	  <line tpl:repeat="lines"><item tpl:text="name"/><price>{{price}}</price></line>
+//	  <note tpl:if="note" tpl:text="note"/>
+//	</invoice>
+type Template struct {
+	doc *Node
+}
+
+// NewTemplate returns a template rendering copies of doc, which is left
+// unchanged.
+func NewTemplate(doc *Node) *Template {
+	return &Template{doc: doc}
+}
+
+// ParseTemplate parses a template from r.
+func ParseTemplate(r io.Reader) (*Template, error) {
+	doc, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewTemplate(doc), nil
+}
+
+// Render returns a new tree built from the template with the values taken
+// from data, a map with string keys, a struct or a pointer to one. The
+// result has no trace of the directives or of their namespace. Paths
+// missing from data are an error, except in tpl:if and tpl:repeat where
+// they count as empty.
+func (t *Template) Render(data interface{}) (*Node, error) {
+	root := t.doc.Clone(true)
+	if err := renderNode(root, &templateScope{value: reflect.ValueOf(data)}); err != nil {
+		return nil, err
+	}
+	dropTemplateNamespace(root)
+	return root, nil
+}
+
+// templateScope is a value paths are looked up in, with the scopes of the
+// enclosing tpl:repeat directives.
+type templateScope struct {
+	value  reflect.Value
+	parent *templateScope
+}
+
+// Returns the value at path, looking it up in s and then in the enclosing
+// scopes.
+func (s *templateScope) lookup(path string) (reflect.Value, bool) {
+	if path == "." {
+		return s.value, true
+	}
+	names := strings.Split(path, ".")
+	for ; s != nil; s = s.parent {
+		v, ok := templateField(s.value, names[0])
+		if !ok {
+			continue
+		}
+		for _, name := range names[1:] {
+			if v, ok = templateField(v, name); !ok {
+				return reflect.Value{}, false
+			}
+		}
+		return v, true
+	}
+	return reflect.Value{}, false
+}
+
+// Returns the map entry, struct field or slice item of v called name.
+func templateField(v reflect.Value, name string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		return v, v.IsValid()
+	case reflect.Struct:
+		f, ok := v.Type().FieldByName(name)
+		if !ok || f.PkgPath != "" {
+			return reflect.Value{}, false
+		}
+		return v.FieldByIndex(f.Index), true
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 || i >= v.Len() {
+			return reflect.Value{}, false
+		}
+		return v.Index(i), true
+	}
+	return reflect.Value{}, false
+}
+
+// Returns the text of the value at path.
+func (s *templateScope) text(path string) (string, error) {
+	v, ok := s.lookup(path)
+	if !ok {
+		return "", fmt.Errorf("xmlquery: template: no value for %q", path)
+	}
+	if n, ok := v.Interface().(*Node); ok {
+		if n == nil {
+			return "", nil
+		}
+		return n.InnerText(), nil
+	}
+	return textOf(v)
+}
+
+// Reports whether the value at path exists and is not empty.
+func (s *templateScope) truth(path string) bool {
+	v, ok := s.lookup(path)
+	if !ok {
+		return false
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	return !isEmptyValue(v)
+}
+
+// Replaces the {{path}} placeholders in text.
+func (s *templateScope) expand(text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	var buf strings.Builder
+	for {
+		i := strings.Index(text, "{{")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(text[i:], "}}")
+		if j < 0 {
+			return "", fmt.Errorf("xmlquery: template: unterminated placeholder in %q", text)
+		}
+		value, err := s.text(strings.TrimSpace(text[i+2 : i+j]))
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(text[:i])
+		buf.WriteString(value)
+		text = text[i+j+2:]
+	}
+	buf.WriteString(text)
+	return buf.String(), nil
+}
+
+func renderNode(n *Node, s *templateScope) error {
+	switch n.Type {
+	case TextNode:
+		text, err := s.expand(n.Data)
+		n.Data = text
+		return err
+	case ElementNode:
+		return renderElement(n, s)
+	case DocumentNode:
+		return renderChildren(n, s)
+	}
+	return nil
+}
+
+func renderChildren(n *Node, s *templateScope) error {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if err := renderNode(child, s); err != nil {
+			return err
+		}
+		child = next
+	}
+	return nil
+}
+
+func renderElement(n *Node, s *templateScope) error {
+	if elementNamespaceURI(n) == TemplateNamespace {
+		if n.Data != "value" {
+			return fmt.Errorf("xmlquery: template: unknown element %s", n.Data)
+		}
+		path, ok := n.GetAttr("select")
+		if !ok {
+			return fmt.Errorf("xmlquery: template: %s without a select attribute", qualifiedName(n))
+		}
+		text, err := s.text(path)
+		if err != nil {
+			return err
+		}
+		if text != "" {
+			insertBefore(n, NewText(text))
+		}
+		removeFromTree(n)
+		return nil
+	}
+
+	if i := templateAttr(n, "repeat"); i >= 0 {
+		path := n.Attr[i].Value
+		deleteAttrAt(n, i)
+		v, ok := s.lookup(path)
+		for ok && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+			ok = !v.IsNil()
+			if ok {
+				v = v.Elem()
+			}
+		}
+		if ok && v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Errorf("xmlquery: template: tpl:repeat of %q requires a slice, got %s", path, v.Type())
+		}
+		for i := 0; ok && i < v.Len(); i++ {
+			item := n.Clone(true)
+			insertBefore(n, item)
+			if err := renderElement(item, &templateScope{value: v.Index(i), parent: s}); err != nil {
+				return err
+			}
+		}
+		removeFromTree(n)
+		return nil
+	}
+
+	if i := templateAttr(n, "if"); i >= 0 {
+		path := n.Attr[i].Value
+		deleteAttrAt(n, i)
+		want := !strings.HasPrefix(path, "!")
+		if s.truth(strings.TrimPrefix(path, "!")) != want {
+			removeFromTree(n)
+			return nil
+		}
+	}
+
+	if i := templateAttr(n, "text"); i >= 0 {
+		text, err := s.text(n.Attr[i].Value)
+		if err != nil {
+			return err
+		}
+		deleteAttrAt(n, i)
+		n.removeChildren()
+		if text != "" {
+			n.appendNode(NewText(text))
+		}
+	}
+
+	for i := range n.Attr {
+		if n.AttrNamespaceURI(i) == TemplateNamespace {
+			return fmt.Errorf("xmlquery: template: unknown attribute %s", xml_name2string(n.Attr[i].Name))
+		}
+		value, err := s.expand(n.Attr[i].Value)
+		if err != nil {
+			return err
+		}
+		n.Attr[i].Value = value
+	}
+	return renderChildren(n, s)
+}
+
+// Returns the index of the directive attribute called local on n, or -1.
+func templateAttr(n *Node, local string) int {
+	for i, attr := range n.Attr {
+		if attr.Name.Local == local && attr.Name.Space != "" && n.AttrNamespaceURI(i) == TemplateNamespace {
+			return i
+		}
+	}
+	return -1
+}
+
+func deleteAttrAt(n *Node, i int) {
+	if len(n.attrURIs) == len(n.Attr) {
+		n.attrURIs = append(n.attrURIs[:i], n.attrURIs[i+1:]...)
+	}
+	n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+}
+
+// Removes the declarations of TemplateNamespace from the subtree of n.
+func dropTemplateNamespace(n *Node) {
+	if n.Type == ElementNode {
+		for i := 0; i < len(n.Attr); i++ {
+			if attr := n.Attr[i]; attr.Name.Space == "xmlns" && attr.Value == TemplateNamespace {
+				deleteAttrAt(n, i)
+				i--
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		dropTemplateNamespace(child)
+	}
+}
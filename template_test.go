@@ -0,0 +1,103 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testInvoiceLine struct {
+	Name  string
+	Price float64
+	Tags  []string
+}
+
+func TestTemplate(t *testing.T) {
+	tpl, err := ParseTemplate(strings.NewReader(`<invoice xmlns:tpl="urn:xmlquery:template" number="{{Number}}" currency="{{ currency }}">` +
+		`<customer tpl:text="customer.name">placeholder</customer>` +
+		`<line tpl:repeat="Lines" n="{{Name}}"><price tpl:text="Price"/><tag tpl:repeat="Tags" tpl:text="."/><cur>{{currency}}</cur></line>` +
+		`<note tpl:if="note">Note: <tpl:value select="note"/>.</note>` +
+		`<unpaid tpl:if="!paid"/>` +
+		`<date tpl:text="date"/>` +
+		`</invoice>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"Number":   42,
+		"currency": "EUR",
+		"customer": map[string]string{"name": "ACME & Co"},
+		"Lines": []testInvoiceLine{
+			{Name: "bolt", Price: 0.25, Tags: []string{"m3", "steel"}},
+			{Name: "nut", Price: 0.1},
+		},
+		"note": "thanks",
+		"paid": true,
+		"date": time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+	}
+	doc, err := tpl.Render(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<invoice number="42" currency="EUR"><customer>ACME &amp; Co</customer>` +
+		`<line n="bolt"><price>0.25</price><tag>m3</tag><tag>steel</tag><cur>EUR</cur></line>` +
+		`<line n="nut"><price>0.1</price><cur>EUR</cur></line>` +
+		`<note>Note: thanks.</note><date>2024-05-06T00:00:00Z</date></invoice>`
+	if got := doc.SelectElement("invoice").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	checkTreeInvariants(t, doc)
+
+	// The template is unchanged and can be rendered again.
+	data["note"] = ""
+	data["paid"] = false
+	data["Lines"] = nil
+	doc, err = tpl.Render(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `<invoice number="42" currency="EUR"><customer>ACME &amp; Co</customer><unpaid/><date>2024-05-06T00:00:00Z</date></invoice>`
+	if got := doc.SelectElement("invoice").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestTemplateStruct(t *testing.T) {
+	type order struct {
+		ID    string
+		Lines []*testInvoiceLine
+	}
+	doc := loadXML(`<order xmlns:t="urn:xmlquery:template" id="{{ID}}"><l t:repeat="Lines">{{Name}} for order {{ID}}</l></order>`)
+	out, err := NewTemplate(doc).Render(&order{ID: "A1", Lines: []*testInvoiceLine{{Name: "x"}, {Name: "y"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<order id="A1"><l>x for order A1</l><l>y for order A1</l></order>`
+	if got := out.SelectElement("order").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got := doc.SelectElement("order").OutputXML(true); !strings.Contains(got, "{{ID}}") {
+		t.Errorf("the template document was changed: %s", got)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, s := range []string{
+		`<a xmlns:tpl="urn:xmlquery:template">{{missing}}</a>`,
+		`<a xmlns:tpl="urn:xmlquery:template" x="{{name"/>`,
+		`<a xmlns:tpl="urn:xmlquery:template" tpl:text="missing"/>`,
+		`<a xmlns:tpl="urn:xmlquery:template" tpl:repeat="name"/>`,
+		`<a xmlns:tpl="urn:xmlquery:template" tpl:unknown="name"/>`,
+		`<a xmlns:tpl="urn:xmlquery:template"><tpl:loop/></a>`,
+		`<a xmlns:tpl="urn:xmlquery:template"><tpl:value/></a>`,
+		`<a xmlns:tpl="urn:xmlquery:template" x="{{m}}"/>`,
+	} {
+		tpl, err := ParseTemplate(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tpl.Render(map[string]interface{}{"name": "n", "m": map[string]int{}}); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package xmlquery
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TextMode tells InnerTextWithMode how to treat whitespace.
+type TextMode int
+
+const (
+	// TextRaw keeps the text as is, like InnerText.
+	TextRaw TextMode = iota
+	// TextCollapse replaces every run of whitespace with a single space and
+	// trims the result.
+	TextCollapse
+	// TextTrimEach trims the text of every text node, leaves out the ones
+	// left empty and separates the others with a single space.
+	TextTrimEach
+)
+
+// InnerTextNormalized returns the text of n with whitespace collapsed, as
+// InnerTextWithMode(TextCollapse) does: the text as a human would read it.
+func (n *Node) InnerTextNormalized() string {
+	return n.InnerTextWithMode(TextCollapse)
+}
+
+// InnerTextWithMode returns the text between the start and end tags of n,
+// with its whitespace treated as directed by mode. Text inside elements with
+// xml:space="preserve", or their descendants, is always kept as is.
+func (n *Node) InnerTextWithMode(mode TextMode) string {
+	if mode == TextRaw {
+		return n.InnerText()
+	}
+	var (
+		buf     strings.Builder
+		pending bool // whitespace was skipped since the last character written
+	)
+	var output func(n *Node, preserve bool)
+	output = func(n *Node, preserve bool) {
+		switch n.Type {
+		case CommentNode:
+			return
+		case ElementNode:
+			if space, ok := n.xmlSpace(); ok {
+				preserve = space == "preserve"
+			}
+		case TextNode:
+			text := n.Data
+			if mode == TextTrimEach && !preserve {
+				text = strings.TrimSpace(text)
+			}
+			if preserve || mode == TextTrimEach {
+				if text == "" {
+					return
+				}
+				if buf.Len() > 0 && (pending || mode == TextTrimEach) {
+					buf.WriteByte(' ')
+				}
+				buf.WriteString(text)
+				pending = false
+				return
+			}
+			for _, r := range text {
+				if unicode.IsSpace(r) {
+					pending = true
+					continue
+				}
+				if pending && buf.Len() > 0 {
+					buf.WriteByte(' ')
+				}
+				pending = false
+				buf.WriteRune(r)
+			}
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			output(child, preserve)
+		}
+	}
+	output(n, inheritedSpace(n.Parent) == "preserve")
+	return buf.String()
+}
+
+// Returns the value of the xml:space attribute of n, if it has one.
+func (n *Node) xmlSpace() (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Name.Space == "xml" && attr.Name.Local == "space" {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// ResolveXMLSpace returns the value of the xml:space attribute in effect at
+// n, found on n or its nearest ancestor that has one, or "default" if there
+// is none.
+func (n *Node) ResolveXMLSpace() string {
+	if space := inheritedSpace(n); space != "" {
+		return space
+	}
+	return "default"
+}
+
+// ResolveXMLLang returns the value of the xml:lang attribute in effect at
+// n, found on n or its nearest ancestor that has one, or an empty string if
+// there is none. An empty xml:lang also stops the search, as it declares the
+// language unknown.
+func (n *Node) ResolveXMLLang() string {
+	for ; n != nil; n = n.Parent {
+		for _, attr := range n.Attr {
+			if attr.Name.Space == "xml" && attr.Name.Local == "lang" {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the value of the xml:space attribute in effect at n, or an empty
+// string if there is none.
+func inheritedSpace(n *Node) string {
+	for ; n != nil; n = n.Parent {
+		if space, ok := n.xmlSpace(); ok {
+			return space
+		}
+	}
+	return ""
+}
+
+// Normalize merges the adjacent text nodes in the subtree of n and removes
+// the empty ones, like the normalize method of the DOM, so that trees
+// changed by hand are written and compared as if they had been parsed. Text
+// written as CDATA is only merged with adjacent CDATA text.
+func (n *Node) Normalize() {
+	n.normalize(false, inheritedSpace(n) == "preserve")
+}
+
+// NormalizeWhitespace is like Normalize but also removes the text nodes made
+// only of whitespace from elements that otherwise only contain elements,
+// such as indentation, except where xml:space="preserve" is in effect.
+// Whitespace in mixed content is kept.
+func (n *Node) NormalizeWhitespace() {
+	n.normalize(true, inheritedSpace(n) == "preserve")
+}
+
+func (n *Node) normalize(dropWhitespace, preserve bool) {
+	if space, ok := n.xmlSpace(); ok {
+		preserve = space == "preserve"
+	}
+	dropHere := dropWhitespace && !preserve && hasElementContent(n)
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type != TextNode {
+			child.normalize(dropWhitespace, preserve)
+			child = next
+			continue
+		}
+		for next != nil && next.Type == TextNode && next.CDATA == child.CDATA {
+			child.Data += next.Data
+			following := next.NextSibling
+			removeFromTree(next)
+			next = following
+		}
+		if child.Data == "" || dropHere && strings.TrimSpace(child.Data) == "" {
+			removeFromTree(child)
+		}
+		child = next
+	}
+}
+
+// Returns true if n has child elements and no text other than whitespace.
+func hasElementContent(n *Node) bool {
+	elements := false
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case ElementNode:
+			elements = true
+		case TextNode:
+			if strings.TrimSpace(child.Data) != "" {
+				return false
+			}
+		}
+	}
+	return elements
+}
@@ -0,0 +1,91 @@
+package xmlquery
+
+import "testing"
+
+func TestInnerTextWithMode(t *testing.T) {
+	doc := loadXML(`<doc>
+	<title>  Hello,
+		<b>big</b>   world!  </title>
+	<pre xml:space="preserve">  keep   this  </pre>
+	<p xml:space="preserve"><i xml:space="default">  not   this </i>  but  this</p>
+</doc>`)
+	tests := []struct {
+		expr     string
+		mode     TextMode
+		expected string
+	}{
+		{"//title", TextRaw, "  Hello,\n\t\tbig   world!  "},
+		{"//title", TextCollapse, "Hello, big world!"},
+		{"//title", TextTrimEach, "Hello, big world!"},
+		{"//pre", TextCollapse, "  keep   this  "},
+		{"//pre", TextTrimEach, "  keep   this  "},
+		{"//p", TextCollapse, "not this   but  this"},
+		{"//p/i", TextCollapse, "not this"},
+		{"/doc", TextCollapse, "Hello, big world!   keep   this   not this   but  this"},
+	}
+	for _, test := range tests {
+		if got := FindOne(doc, test.expr).InnerTextWithMode(test.mode); got != test.expected {
+			t.Errorf("%s (%d):\nexpected: %q\ngot:      %q", test.expr, test.mode, test.expected, got)
+		}
+	}
+	if got, expected := FindOne(doc, "//title").InnerTextNormalized(), "Hello, big world!"; got != expected {
+		t.Errorf("\nexpected: %q\ngot:      %q", expected, got)
+	}
+	if got, expected := FindOne(doc, "//pre/text()").InnerTextNormalized(), "  keep   this  "; got != expected {
+		t.Errorf("\nexpected: %q\ngot:      %q", expected, got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	doc := loadXML("<doc>\n  <p>a <b>x</b> <i>y</i></p>\n  <pre xml:space=\"preserve\">\n    <code/>\n  </pre>\n  <c><![CDATA[1]]><![CDATA[2]]></c>\n</doc>")
+	root := FindOne(doc, "/doc")
+	p := FindOne(doc, "//p")
+	p.InsertBefore(NewText(""), p.FirstChild)
+	p.InsertBefore(NewText("!"), nil)
+	p.InsertBefore(NewText("!"), nil)
+	b := FindOne(doc, "//b")
+	b.InsertBefore(NewText("z"), nil)
+	c := FindOne(doc, "//c")
+	c.InsertBefore(NewText("3"), nil)
+
+	root.Normalize()
+	checkTreeInvariants(t, doc)
+	expected := "<doc>&#xA;  <p>a <b>xz</b> <i>y</i>!!</p>&#xA;  <pre xml:space=\"preserve\">&#xA;    <code/>&#xA;  </pre>&#xA;  <c><![CDATA[12]]>3</c>&#xA;</doc>"
+	if got := root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if n := len(Find(doc, "//p/text()")); n != 3 {
+		t.Errorf("expected 3 text nodes in p, got %d", n)
+	}
+
+	root.NormalizeWhitespace()
+	checkTreeInvariants(t, doc)
+	expected = "<doc><p>a <b>xz</b> <i>y</i>!!</p><pre xml:space=\"preserve\">&#xA;    <code/>&#xA;  </pre><c><![CDATA[12]]>3</c></doc>"
+	if got := root.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestResolveXMLSpaceAndLang(t *testing.T) {
+	doc := loadXML(`<doc xml:lang="en"><pre xml:space="preserve"><code xml:lang="fr"><b/></code><p xml:space="default" xml:lang=""/></pre></doc>`)
+	tests := []struct {
+		expr, space, lang string
+	}{
+		{"//doc", "default", "en"},
+		{"//pre", "preserve", "en"},
+		{"//b", "preserve", "fr"},
+		{"//p", "default", ""},
+	}
+	for _, test := range tests {
+		n := FindOne(doc, test.expr)
+		if got := n.ResolveXMLSpace(); got != test.space {
+			t.Errorf("%s: expected xml:space %q, got %q", test.expr, test.space, got)
+		}
+		if got := n.ResolveXMLLang(); got != test.lang {
+			t.Errorf("%s: expected xml:lang %q, got %q", test.expr, test.lang, got)
+		}
+	}
+	if got := FindOne(doc, "//code/@xml:lang").ResolveXMLLang(); got != "fr" {
+		t.Errorf("attribute node: expected xml:lang %q, got %q", "fr", got)
+	}
+}
@@ -0,0 +1,85 @@
+package xmlquery
+
+import (
+	"regexp"
+)
+
+// A TextMatch is an occurrence of a regular expression in a text node.
+type TextMatch struct {
+	// Node is the text node containing the match.
+	Node *Node
+	// Start and End are the byte offsets of the match in Node.Data.
+	Start, End int
+	// Groups holds the text of the capturing groups of the expression.
+	Groups []string
+}
+
+// Text returns the matched text.
+func (m TextMatch) Text() string {
+	return m.Node.Data[m.Start:m.End]
+}
+
+// FindText returns every non-overlapping match of re in the text nodes of
+// the subtree rooted at root, in document order. Matches never span more
+// than one text node, so text split by markup (e.g. "a<b>b</b>") cannot be
+// matched as a whole.
+func FindText(root *Node, re *regexp.Regexp) []TextMatch {
+	var matches []TextMatch
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == TextNode {
+			for _, loc := range re.FindAllStringSubmatchIndex(n.Data, -1) {
+				m := TextMatch{Node: n, Start: loc[0], End: loc[1]}
+				for i := 2; i < len(loc); i += 2 {
+					if loc[i] >= 0 {
+						m.Groups = append(m.Groups, n.Data[loc[i]:loc[i+1]])
+					} else {
+						m.Groups = append(m.Groups, "")
+					}
+				}
+				matches = append(matches, m)
+			}
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+	return matches
+}
+
+// Wrap moves the matched text into a new element with the given name,
+// splitting the text node around it, and returns the new element. This is
+// how highlights and annotations are added to a document.
+//
+// Wrapping a match changes the text node the other matches in the same node
+// refer to, so when wrapping several matches, do it from the last to the
+// first, as WrapTextMatches does.
+func (m TextMatch) Wrap(name string) *Node {
+	n := m.Node
+	text := n.Data
+	elem := &Node{Type: ElementNode, Data: name}
+	insertAfter(n, elem)
+	addChild(elem, &Node{Type: TextNode, Data: text[m.Start:m.End], level: elem.level + 1})
+	if m.End < len(text) {
+		insertAfter(elem, &Node{Type: TextNode, Data: text[m.End:]})
+	}
+	if m.Start == 0 {
+		removeFromTree(n)
+	} else {
+		n.Data = text[:m.Start]
+	}
+	return elem
+}
+
+// WrapTextMatches wraps every match as Wrap does, processing them in reverse
+// order so that offsets stay valid, and returns the new elements in the
+// order of matches.
+func WrapTextMatches(matches []TextMatch, name string) []*Node {
+	elems := make([]*Node, len(matches))
+	for i := len(matches) - 1; i >= 0; i-- {
+		elems[i] = matches[i].Wrap(name)
+	}
+	return elems
+}
@@ -0,0 +1,44 @@
+package xmlquery
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFindText(t *testing.T) {
+	doc := loadXML(`<p>Call 555-1234 or 555-9876.<b>Fax: 555-0000</b><!-- 555-1111 --></p>`)
+	re := regexp.MustCompile(`555-(\d+)`)
+	matches := FindText(doc, re)
+	if len(matches) != 3 {
+		t.Fatalf("expected 3 matches, but got %d", len(matches))
+	}
+	if m := matches[1]; m.Text() != "555-9876" || m.Start != 17 || m.End != 25 || m.Groups[0] != "9876" {
+		t.Fatalf("unexpected match %+v", m)
+	}
+	if matches[2].Node.Parent.Data != "b" {
+		t.Fatal("third match should be inside <b>")
+	}
+
+	elems := WrapTextMatches(matches, "mark")
+	if len(elems) != 3 || elems[0].InnerText() != "555-1234" {
+		t.Fatalf("unexpected wrapped elements %v", elems)
+	}
+	expected := `<p>Call <mark>555-1234</mark> or <mark>555-9876</mark>.<b>Fax: <mark>555-0000</mark></b><!-- 555-1111 --></p>`
+	if got := FindOne(doc, "//p").OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestTextMatchWrapWholeNode(t *testing.T) {
+	doc := loadXML(`<p><i>word</i></p>`)
+	matches := FindText(doc, regexp.MustCompile(`word`))
+	matches[0].Wrap("em")
+	expected := `<p><i><em>word</em></i></p>`
+	if got := FindOne(doc, "//p").OutputXML(true); got != expected {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	i := FindOne(doc, "//i")
+	if i.FirstChild != i.LastChild || i.FirstChild.Parent != i {
+		t.Fatal("children of <i> are not linked correctly")
+	}
+}
@@ -0,0 +1,120 @@
+package xmlquery
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// TokenReader returns the subtree of a node as a stream of encoding/xml
+// tokens. It implements xml.TokenReader, so a tree can be fed to code that
+// reads tokens, including xml.NewTokenDecoder to decode it into structs.
+type TokenReader struct {
+	top     *Node
+	n       *Node // the next node to enter, or to leave if exit is set
+	exit    bool
+	encoder bool // names are written for an xml.Encoder
+}
+
+// Tokens returns the tokens of the subtree rooted at n, in the form
+// returned by xml.Decoder.Token: start and end elements, character data,
+// comments, processing instructions and directives, with names holding
+// namespace URIs rather than prefixes, and namespace declarations as
+// attributes. Documents produce the tokens of their children, XML
+// declarations a processing instruction and document type declarations a
+// directive.
+//
+// The tree must not be changed while its tokens are read.
+func (n *Node) Tokens() *TokenReader {
+	return &TokenReader{top: n, n: n}
+}
+
+// EncoderTokens is like Tokens, but names are written as qualified names,
+// with their prefix, and an empty Space, which is the form
+// xml.Encoder.EncodeToken writes unchanged: encoding the tokens writes the
+// tree with its prefixes and namespace declarations as they are.
+func (n *Node) EncoderTokens() *TokenReader {
+	return &TokenReader{top: n, n: n, encoder: true}
+}
+
+// Token returns the next token, or io.EOF once all the tokens have been
+// returned. The tokens do not share memory with the tree.
+func (r *TokenReader) Token() (xml.Token, error) {
+	for r.n != nil {
+		n := r.n
+		if r.exit {
+			r.advance(n)
+			if n.Type == ElementNode {
+				return xml.EndElement{Name: r.elementName(n)}, nil
+			}
+			continue
+		}
+		switch n.Type {
+		case DocumentNode, ElementNode:
+			if n.FirstChild != nil {
+				r.n = n.FirstChild
+			} else {
+				r.exit = true
+			}
+			if n.Type == ElementNode {
+				return xml.StartElement{Name: r.elementName(n), Attr: r.attrs(n)}, nil
+			}
+			continue
+		}
+		r.advance(n)
+		switch n.Type {
+		case TextNode:
+			return xml.CharData(n.Data), nil
+		case CommentNode:
+			return xml.Comment(n.Data), nil
+		case ProcInstNode:
+			return xml.ProcInst{Target: n.Data, Inst: []byte(n.Inst)}, nil
+		case DeclarationNode:
+			inst := ""
+			for i, attr := range n.Attr {
+				if i > 0 {
+					inst += " "
+				}
+				inst += xml_name2string(attr.Name) + `="` + attr.Value + `"`
+			}
+			return xml.ProcInst{Target: n.Data, Inst: []byte(inst)}, nil
+		case DoctypeNode:
+			return xml.Directive(n.Data), nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// Moves past n, which has been entered and, if it has children, left.
+func (r *TokenReader) advance(n *Node) {
+	switch {
+	case n == r.top:
+		r.n = nil
+	case n.NextSibling != nil:
+		r.n, r.exit = n.NextSibling, false
+	default:
+		r.n, r.exit = n.Parent, true
+	}
+}
+
+func (r *TokenReader) elementName(n *Node) xml.Name {
+	if r.encoder {
+		return xml.Name{Local: qualifiedName(n)}
+	}
+	return xml.Name{Space: elementNamespaceURI(n), Local: n.Data}
+}
+
+func (r *TokenReader) attrs(n *Node) []xml.Attr {
+	attrs := make([]xml.Attr, len(n.Attr))
+	for i, attr := range n.Attr {
+		switch {
+		case r.encoder:
+			attr.Name = xml.Name{Local: xml_name2string(attr.Name)}
+		case attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			// Declarations are left as is, as xml.Decoder does.
+		default:
+			attr.Name.Space = n.AttrNamespaceURI(i)
+		}
+		attrs[i] = attr
+	}
+	return attrs
+}
@@ -0,0 +1,79 @@
+package xmlquery
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	s := `<?xml version="1.0"?><!DOCTYPE doc><doc xmlns="urn:d" xmlns:p="urn:p" xml:lang="en"><p:item p:id="1" x="2">a &amp; b</p:item><!--c--><?pi data?><empty/></doc>`
+	doc := loadXML(s)
+
+	var expected []xml.Token
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, xml.CopyToken(tok))
+	}
+	var got []xml.Token
+	r := doc.Tokens()
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\nexpected: %v\ngot:      %v", expected, got)
+	}
+
+	// Tokens can be decoded into structs.
+	var item struct {
+		ID   string `xml:"urn:p id,attr"`
+		Text string `xml:",chardata"`
+	}
+	if err := xml.NewTokenDecoder(FindOne(doc, "//p:item").Tokens()).Decode(&item); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != "1" || item.Text != "a & b" {
+		t.Errorf("unexpected item %+v", item)
+	}
+}
+
+func TestEncoderTokens(t *testing.T) {
+	doc := loadXML(`<doc xmlns:p="urn:p"><p:item p:id="1">a &amp; b</p:item><!--c--><empty/></doc>`)
+	root := FindOne(doc, "/doc")
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	r := root.EncoderTokens()
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			break
+		}
+		if err := e.EncodeToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<doc xmlns:p="urn:p"><p:item p:id="1">a &amp; b</p:item><!--c--><empty></empty></doc>`
+	if buf.String() != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, buf.String())
+	}
+}
@@ -0,0 +1,104 @@
+package xmlquery
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Rule pairs the nodes selected by an expression with the change Transform
+// makes to them.
+type Rule struct {
+	// Match selects the nodes the rule applies to, as an XPath expression
+	// or, if CSS is set, a CSS selector (see QueryCSS). Expressions must not
+	// select attributes; select their elements instead.
+	Match string
+	CSS   bool
+	// Apply changes a selected node. It may change the tree in any way,
+	// including removing or replacing the node.
+	Apply func(n *Node) error
+}
+
+var (
+	transformingMu sync.Mutex
+	transforming   = make(map[*Node]bool) // the roots of the trees being transformed
+)
+
+// Transform applies rules to the tree of doc, for bulk fix-ups of documents:
+//
+//	err := xmlquery.Transform(doc,
+//		xmlquery.Rule{Match: "//font", Apply: func(n *xmlquery.Node) error {
+//			n.Data = "span"
+//			return nil
+//		}},
+//		xmlquery.Rule{Match: "//comment()", Apply: func(n *xmlquery.Node) error {
+//			n.Detach()
+//			return nil
+//		}},
+//	)
+//
+// All the nodes the rules select are found before any change is made, and
+// then visited in document order, each node getting the rules that selected
+// it in the order they are given. Changes cannot lead to rules being applied
+// again: the nodes added by a rule are not visited, and the nodes that were
+// removed from the tree by the time they are reached are skipped. Calling
+// Transform on the same tree from a rule returns an error.
+//
+// Transform stops at the first error returned by a rule and returns it,
+// leaving the changes made so far in place.
+func Transform(doc *Node, rules ...Rule) error {
+	root := treeRoot(doc)
+	transformingMu.Lock()
+	if transforming[root] {
+		transformingMu.Unlock()
+		return errors.New("xmlquery: Transform called on a tree it is already transforming")
+	}
+	transforming[root] = true
+	transformingMu.Unlock()
+	defer func() {
+		transformingMu.Lock()
+		delete(transforming, root)
+		transformingMu.Unlock()
+	}()
+
+	matched := make(map[*Node][]int) // indexes of the rules selecting each node
+	var nodes []*Node
+	for i, rule := range rules {
+		var (
+			selected []*Node
+			err      error
+		)
+		if rule.CSS {
+			selected, err = QueryCSS(doc, rule.Match)
+		} else {
+			selected, err = QueryAll(doc, rule.Match)
+		}
+		if err != nil {
+			return err
+		}
+		for _, n := range selected {
+			if n.Type == AttributeNode {
+				return fmt.Errorf("xmlquery: rule %q selects attributes", rule.Match)
+			}
+			if list := matched[n]; len(list) == 0 || list[len(list)-1] != i {
+				if len(list) == 0 {
+					nodes = append(nodes, n)
+				}
+				matched[n] = append(list, i)
+			}
+		}
+	}
+	sortDocumentOrder(nodes)
+
+	for _, n := range nodes {
+		for _, i := range matched[n] {
+			if treeRoot(n) != root {
+				break // removed by an earlier change
+			}
+			if err := rules[i].Apply(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
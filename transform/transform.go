@@ -0,0 +1,911 @@
+/*
+Package transform applies XSLT 1.0 stylesheets to xmlquery Node trees. It
+implements the subset of XSLT that simple stylesheets use:
+
+	xsl:template (match, name, mode, priority)
+	xsl:apply-templates (select, mode) and xsl:call-template
+	xsl:for-each and xsl:sort (select, order, data-type)
+	xsl:value-of, xsl:copy and xsl:copy-of
+	xsl:if, xsl:choose, xsl:when and xsl:otherwise
+	xsl:element, xsl:attribute, xsl:text and xsl:comment
+	xsl:output (method xml or text, indent, omit-xml-declaration)
+
+along with literal result elements, attribute value templates and the
+built-in template rules. Variables, parameters, keys, imports and extension
+functions are not supported, and stylesheets using them are rejected by
+Compile.
+
+Expressions are evaluated by xmlquery, with the context node as their
+root: expressions starting with "/" are evaluated from the root of the
+source document instead, and position() and last() are not related to the
+nodes being processed.
+*/
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+// NamespaceXSLT is the namespace URI of XSLT instructions.
+const NamespaceXSLT = "http://www.w3.org/1999/XSL/Transform"
+
+// maxDepth limits the nesting of template invocations, to stop stylesheets
+// that recurse forever.
+const maxDepth = 1000
+
+// Stylesheet is a compiled stylesheet. It is safe for concurrent use.
+type Stylesheet struct {
+	templates []*template // match rules, by decreasing precedence
+	named     map[string]*template
+
+	method          string // the output method, "xml" or "text"
+	indent          bool
+	omitDeclaration bool
+}
+
+type template struct {
+	match    string // one alternative of the match pattern
+	name     string
+	mode     string
+	priority float64
+	body     *xmlquery.Node // the xsl:template element
+}
+
+// ParseStylesheet parses and compiles the stylesheet read from r.
+func ParseStylesheet(r io.Reader) (*Stylesheet, error) {
+	doc, err := xmlquery.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(doc)
+}
+
+// Compile compiles the stylesheet held by doc, a document or its root
+// element. It returns an error if the stylesheet uses instructions that are
+// not supported or has invalid expressions.
+func Compile(doc *xmlquery.Node) (*Stylesheet, error) {
+	root := doc
+	if root.Type == xmlquery.DocumentNode {
+		root = firstElement(doc)
+	}
+	if root == nil || !isXSLT(root, "stylesheet") && !isXSLT(root, "transform") {
+		return nil, errors.New("transform: not an XSLT stylesheet")
+	}
+	s := &Stylesheet{named: make(map[string]*template), method: "xml"}
+	for n := firstElement(root); n != nil; n = nextElement(n) {
+		if n.NamespaceURI != NamespaceXSLT {
+			continue // top-level elements of other namespaces are ignored
+		}
+		switch n.Data {
+		case "template":
+			if err := s.addTemplate(n); err != nil {
+				return nil, err
+			}
+		case "output":
+			switch method := n.SelectAttr("method"); method {
+			case "":
+			case "xml", "text":
+				s.method = method
+			default:
+				return nil, fmt.Errorf("transform: unsupported output method %q", method)
+			}
+			s.indent = n.SelectAttr("indent") == "yes"
+			s.omitDeclaration = n.SelectAttr("omit-xml-declaration") == "yes"
+		default:
+			return nil, fmt.Errorf("transform: unsupported top-level element xsl:%s", n.Data)
+		}
+	}
+	// Rules of higher priority, then rules that come last, win.
+	sort.SliceStable(s.templates, func(i, j int) bool {
+		return s.templates[i].priority > s.templates[j].priority
+	})
+	return s, nil
+}
+
+func (s *Stylesheet) addTemplate(n *xmlquery.Node) error {
+	match, name := n.SelectAttr("match"), n.SelectAttr("name")
+	if match == "" && name == "" {
+		return errors.New("transform: xsl:template needs a match or name attribute")
+	}
+	if err := validate(n); err != nil {
+		return err
+	}
+	if name != "" {
+		s.named[name] = &template{name: name, body: n}
+	}
+	if match == "" {
+		return nil
+	}
+	var rules []*template
+	for _, alt := range splitUnion(match) {
+		if _, err := xmlquery.Compile(alt); err != nil && alt != "/" {
+			return fmt.Errorf("transform: invalid pattern %q: %v", match, err)
+		}
+		t := &template{match: alt, mode: n.SelectAttr("mode"), priority: defaultPriority(alt), body: n}
+		if p := n.SelectAttr("priority"); p != "" {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return fmt.Errorf("transform: invalid priority %q", p)
+			}
+			t.priority = f
+		}
+		rules = append(rules, t)
+	}
+	// Later rules are tried first among rules of the same priority.
+	s.templates = append(rules, s.templates...)
+	return nil
+}
+
+// Returns the alternatives of a union pattern.
+func splitUnion(pattern string) []string {
+	var alts []string
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			alts = append(alts, strings.TrimSpace(pattern[start:i]))
+			start = i + 1
+		}
+	}
+	return append(alts, strings.TrimSpace(pattern[start:]))
+}
+
+// Returns the default priority of a pattern without alternatives, as
+// defined by section 5.5 of the XSLT specification.
+func defaultPriority(pattern string) float64 {
+	p := strings.TrimPrefix(strings.TrimPrefix(pattern, "child::"), "@")
+	switch {
+	case p == "*" || p == "node()" || p == "text()" || p == "comment()" || p == "processing-instruction()":
+		return -0.5
+	case strings.HasSuffix(p, ":*") && isName(strings.TrimSuffix(p, ":*")):
+		return -0.25
+	case isName(p):
+		return 0
+	}
+	return 0.5
+}
+
+// Returns true if s is a name, with an optional prefix.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80:
+		case i > 0 && (c == '-' || c == '.' || c == ':' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return !strings.HasSuffix(s, ":") && strings.Count(s, ":") <= 1
+}
+
+// The attributes holding expressions and attribute value templates, by
+// instruction, along with the required attributes.
+var instructions = map[string]struct {
+	exprs, avts, required []string
+}{
+	"apply-templates": {exprs: []string{"select"}},
+	"call-template":   {required: []string{"name"}},
+	"for-each":        {exprs: []string{"select"}, required: []string{"select"}},
+	"sort":            {exprs: []string{"select"}},
+	"value-of":        {exprs: []string{"select"}, required: []string{"select"}},
+	"copy":            {},
+	"copy-of":         {exprs: []string{"select"}, required: []string{"select"}},
+	"if":              {exprs: []string{"test"}, required: []string{"test"}},
+	"choose":          {},
+	"when":            {exprs: []string{"test"}, required: []string{"test"}},
+	"otherwise":       {},
+	"element":         {avts: []string{"name"}, required: []string{"name"}},
+	"attribute":       {avts: []string{"name"}, required: []string{"name"}},
+	"text":            {},
+	"comment":         {},
+}
+
+// Checks the instructions in the body of a template.
+func validate(n *xmlquery.Node) error {
+	for child := firstElement(n); child != nil; child = nextElement(child) {
+		if child.NamespaceURI == NamespaceXSLT {
+			instr, ok := instructions[child.Data]
+			if !ok {
+				return fmt.Errorf("transform: unsupported instruction xsl:%s", child.Data)
+			}
+			for _, attr := range instr.required {
+				if child.SelectAttr(attr) == "" {
+					return fmt.Errorf("transform: xsl:%s needs a %s attribute", child.Data, attr)
+				}
+			}
+			for _, attr := range instr.exprs {
+				if expr := child.SelectAttr(attr); expr != "" {
+					if _, err := xmlquery.Compile(expr); err != nil {
+						return fmt.Errorf("transform: invalid expression %q in xsl:%s: %v", expr, child.Data, err)
+					}
+				}
+			}
+			for _, attr := range instr.avts {
+				if err := validateAVT(child.SelectAttr(attr)); err != nil {
+					return err
+				}
+			}
+		} else {
+			for _, attr := range child.Attr {
+				if err := validateAVT(attr.Value); err != nil {
+					return err
+				}
+			}
+		}
+		if err := validate(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAVT(s string) error {
+	_, err := expandAVT(s, func(expr string) (string, error) {
+		if _, err := xmlquery.Compile(expr); err != nil {
+			return "", fmt.Errorf("transform: invalid expression %q: %v", expr, err)
+		}
+		return "", nil
+	})
+	return err
+}
+
+// Returns the attribute value template s with its expressions replaced by
+// the values returned by eval.
+func expandAVT(s string, eval func(expr string) (string, error)) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' && i+1 < len(s) && s[i+1] == '{', c == '}' && i+1 < len(s) && s[i+1] == '}':
+			buf.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("transform: unterminated expression in %q", s)
+			}
+			v, err := eval(s[i+1 : i+end])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			i += end
+		case c == '}':
+			return "", fmt.Errorf("transform: unmatched } in %q", s)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), nil
+}
+
+// Apply transforms doc with the stylesheet and returns the result document.
+func (s *Stylesheet) Apply(doc *xmlquery.Node) (*xmlquery.Node, error) {
+	root := doc
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	p := &processor{s: s, root: root, selected: make(map[selection]map[*xmlquery.Node]bool)}
+	result := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	if err := p.applyTemplates(doc, "", result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Write transforms doc with the stylesheet and writes the result to w as
+// directed by the xsl:output element of the stylesheet.
+func (s *Stylesheet) Write(w io.Writer, doc *xmlquery.Node) error {
+	result, err := s.Apply(doc)
+	if err != nil {
+		return err
+	}
+	if s.method == "text" {
+		_, err := io.WriteString(w, result.InnerText())
+		return err
+	}
+	if !s.omitDeclaration {
+		decl := `<?xml version="1.0" encoding="UTF-8"?>`
+		if s.indent {
+			decl += "\n"
+		}
+		if _, err := io.WriteString(w, decl); err != nil {
+			return err
+		}
+	}
+	return result.OutputXMLWithOptions(w, false, xmlquery.OutputOptions{Pretty: s.indent})
+}
+
+type processor struct {
+	s     *Stylesheet
+	root  *xmlquery.Node // the root of the source document
+	depth int
+
+	// The nodes selected by patterns from their ancestors, to match
+	// patterns without evaluating them again for every node.
+	selected map[selection]map[*xmlquery.Node]bool
+}
+
+type selection struct {
+	pattern string
+	from    *xmlquery.Node
+}
+
+// Returns the value of expr with n as the context node.
+func (p *processor) eval(n *xmlquery.Node, expr string) (interface{}, error) {
+	if strings.HasPrefix(expr, "/") {
+		n = p.root
+	}
+	return xmlquery.Evaluate(n, expr)
+}
+
+func (p *processor) selectNodes(n *xmlquery.Node, expr string) ([]*xmlquery.Node, error) {
+	v, err := p.eval(n, expr)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]*xmlquery.Node)
+	if !ok {
+		return nil, fmt.Errorf("transform: %q does not select nodes", expr)
+	}
+	return nodes, nil
+}
+
+func (p *processor) evalString(n *xmlquery.Node, expr string) (string, error) {
+	v, err := p.eval(n, expr)
+	if err != nil {
+		return "", err
+	}
+	return stringValue(v), nil
+}
+
+func (p *processor) evalBool(n *xmlquery.Node, expr string) (bool, error) {
+	v, err := p.eval(n, expr)
+	if err != nil {
+		return false, err
+	}
+	switch v := v.(type) {
+	case []*xmlquery.Node:
+		return len(v) > 0, nil
+	case string:
+		return v != "", nil
+	case float64:
+		return v != 0 && !math.IsNaN(v), nil
+	case bool:
+		return v, nil
+	}
+	return false, nil
+}
+
+// Returns the string value of the result of an expression, as the XPath
+// string() function does.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case []*xmlquery.Node:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].InnerText()
+	case string:
+		return v
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// Returns the template rule for n in mode, or nil if only the built-in
+// rules apply.
+func (p *processor) findTemplate(n *xmlquery.Node, mode string) *template {
+	for _, t := range p.s.templates {
+		if t.mode == mode && p.matches(t.match, n) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Returns true if n matches the pattern, that is, if the pattern selects n
+// from one of its ancestors.
+func (p *processor) matches(pattern string, n *xmlquery.Node) bool {
+	switch {
+	case n.Type == xmlquery.AttributeNode:
+		// Attributes selected by expressions are copies, matched by name.
+		name := strings.TrimPrefix(pattern, "attribute::")
+		if name == pattern {
+			name = strings.TrimPrefix(pattern, "@")
+		}
+		return name != pattern && (name == "*" || name == n.Data)
+	case pattern == "/":
+		return n.Type == xmlquery.DocumentNode
+	case n.Type == xmlquery.DocumentNode:
+		return false
+	}
+	if matched, ok := matchSimple(pattern, n); ok {
+		return matched
+	}
+	if strings.HasPrefix(pattern, "/") {
+		return p.selects(p.root, pattern, n)
+	}
+	for a := n.Parent; a != nil; a = a.Parent {
+		if p.selects(a, pattern, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *processor) selects(from *xmlquery.Node, pattern string, n *xmlquery.Node) bool {
+	key := selection{pattern, from}
+	set, ok := p.selected[key]
+	if !ok {
+		set = make(map[*xmlquery.Node]bool)
+		nodes, _ := xmlquery.QueryAll(from, pattern) // patterns are checked by Compile
+		for _, m := range nodes {
+			set[m] = true
+		}
+		p.selected[key] = set
+	}
+	return set[n]
+}
+
+// Matches the patterns that test a single node without going through XPath.
+// ok is false for other patterns.
+func matchSimple(pattern string, n *xmlquery.Node) (matched, ok bool) {
+	switch pattern {
+	case "node()":
+		return true, true
+	case "*":
+		return n.Type == xmlquery.ElementNode, true
+	case "text()":
+		return n.Type == xmlquery.TextNode, true
+	case "comment()":
+		return n.Type == xmlquery.CommentNode, true
+	case "processing-instruction()":
+		return n.Type == xmlquery.ProcInstNode, true
+	}
+	if !isName(pattern) {
+		return false, false
+	}
+	if n.Type != xmlquery.ElementNode {
+		return false, true
+	}
+	if i := strings.IndexByte(pattern, ':'); i > 0 {
+		return n.Prefix == pattern[:i] && n.Data == pattern[i+1:], true
+	}
+	return n.Data == pattern, true
+}
+
+// Applies the template rule for n in mode, or the built-in one, adding the
+// result to out.
+func (p *processor) applyTemplates(n *xmlquery.Node, mode string, out *xmlquery.Node) error {
+	switch {
+	case n.Type == xmlquery.DeclarationNode, n.Type == xmlquery.DoctypeNode:
+		return nil
+	case n.Type == xmlquery.TextNode && n.Parent != nil && n.Parent.Type == xmlquery.DocumentNode:
+		// Whitespace around the root element is not part of the document.
+		return nil
+	}
+	if t := p.findTemplate(n, mode); t != nil {
+		return p.callTemplate(t, n, out)
+	}
+	switch n.Type {
+	case xmlquery.DocumentNode, xmlquery.ElementNode:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if err := p.applyTemplates(child, mode, out); err != nil {
+				return err
+			}
+		}
+	case xmlquery.TextNode, xmlquery.AttributeNode:
+		appendText(out, n.InnerText())
+	}
+	return nil
+}
+
+func (p *processor) callTemplate(t *template, n, out *xmlquery.Node) error {
+	if p.depth >= maxDepth {
+		return errors.New("transform: templates nested too deeply")
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	return p.execBody(t.body, n, out)
+}
+
+// Executes the children of instr, a template or instruction, with n as the
+// context node, adding the result to out.
+func (p *processor) execBody(instr, n, out *xmlquery.Node) error {
+	for child := instr.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case xmlquery.TextNode:
+			if strings.TrimSpace(child.Data) != "" {
+				appendText(out, child.Data)
+			}
+		case xmlquery.ElementNode:
+			var err error
+			if child.NamespaceURI == NamespaceXSLT {
+				err = p.exec(child, n, out)
+			} else {
+				err = p.literal(child, n, out)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Executes the XSLT instruction instr.
+func (p *processor) exec(instr, n, out *xmlquery.Node) error {
+	switch instr.Data {
+	case "apply-templates":
+		expr := instr.SelectAttr("select")
+		if expr == "" {
+			expr = "node()"
+		}
+		nodes, err := p.selectSorted(instr, n, expr)
+		if err != nil {
+			return err
+		}
+		for _, m := range nodes {
+			if err := p.applyTemplates(m, instr.SelectAttr("mode"), out); err != nil {
+				return err
+			}
+		}
+	case "call-template":
+		name := instr.SelectAttr("name")
+		t, ok := p.s.named[name]
+		if !ok {
+			return fmt.Errorf("transform: no template named %q", name)
+		}
+		return p.callTemplate(t, n, out)
+	case "for-each":
+		nodes, err := p.selectSorted(instr, n, instr.SelectAttr("select"))
+		if err != nil {
+			return err
+		}
+		for _, m := range nodes {
+			if err := p.execBody(instr, m, out); err != nil {
+				return err
+			}
+		}
+	case "sort":
+		// Handled by selectSorted.
+	case "value-of":
+		s, err := p.evalString(n, instr.SelectAttr("select"))
+		if err != nil {
+			return err
+		}
+		appendText(out, s)
+	case "if":
+		ok, err := p.evalBool(n, instr.SelectAttr("test"))
+		if err != nil || !ok {
+			return err
+		}
+		return p.execBody(instr, n, out)
+	case "choose":
+		for branch := firstElement(instr); branch != nil; branch = nextElement(branch) {
+			if branch.NamespaceURI != NamespaceXSLT {
+				continue
+			}
+			switch branch.Data {
+			case "when":
+				ok, err := p.evalBool(n, branch.SelectAttr("test"))
+				if err != nil {
+					return err
+				}
+				if ok {
+					return p.execBody(branch, n, out)
+				}
+			case "otherwise":
+				return p.execBody(branch, n, out)
+			}
+		}
+	case "element":
+		name, err := p.avt(instr.SelectAttr("name"), n)
+		if err != nil {
+			return err
+		}
+		elem := xmlquery.NewElement(name)
+		out.InsertBefore(elem, nil)
+		return p.execBody(instr, n, elem)
+	case "attribute":
+		name, err := p.avt(instr.SelectAttr("name"), n)
+		if err != nil {
+			return err
+		}
+		value, err := p.content(instr, n)
+		if err != nil {
+			return err
+		}
+		return setAttr(out, name, value)
+	case "text":
+		appendText(out, instr.InnerText())
+	case "comment":
+		text, err := p.content(instr, n)
+		if err != nil {
+			return err
+		}
+		out.InsertBefore(xmlquery.NewComment(text), nil)
+	case "copy":
+		switch n.Type {
+		case xmlquery.ElementNode:
+			elem := &xmlquery.Node{Type: xmlquery.ElementNode, Data: n.Data, Prefix: n.Prefix, NamespaceURI: n.NamespaceURI}
+			out.InsertBefore(elem, nil)
+			return p.execBody(instr, n, elem)
+		case xmlquery.DocumentNode:
+			return p.execBody(instr, n, out)
+		default:
+			return p.copyOf(n, out)
+		}
+	case "copy-of":
+		v, err := p.eval(n, instr.SelectAttr("select"))
+		if err != nil {
+			return err
+		}
+		nodes, ok := v.([]*xmlquery.Node)
+		if !ok {
+			appendText(out, stringValue(v))
+			return nil
+		}
+		for _, m := range nodes {
+			if err := p.copyOf(m, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Adds a deep copy of n to out.
+func (p *processor) copyOf(n, out *xmlquery.Node) error {
+	switch n.Type {
+	case xmlquery.AttributeNode:
+		return setAttr(out, n.Data, n.InnerText())
+	case xmlquery.TextNode:
+		appendText(out, n.Data)
+	case xmlquery.DocumentNode:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != xmlquery.DeclarationNode && child.Type != xmlquery.DoctypeNode {
+				if err := p.copyOf(child, out); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		out.InsertBefore(n.Clone(true), nil)
+	}
+	return nil
+}
+
+// Returns the nodes selected by expr, sorted as directed by the xsl:sort
+// children of instr.
+func (p *processor) selectSorted(instr, n *xmlquery.Node, expr string) ([]*xmlquery.Node, error) {
+	nodes, err := p.selectNodes(n, expr)
+	if err != nil {
+		return nil, err
+	}
+	for key := lastElement(instr); key != nil; key = prevElement(key) {
+		// Sorting by the last key first, stably, sorts by all keys.
+		if key.NamespaceURI != NamespaceXSLT || key.Data != "sort" {
+			continue
+		}
+		expr := key.SelectAttr("select")
+		if expr == "" {
+			expr = "."
+		}
+		values := make(map[*xmlquery.Node]string, len(nodes))
+		for _, m := range nodes {
+			if values[m], err = p.evalString(m, expr); err != nil {
+				return nil, err
+			}
+		}
+		numeric, descending := key.SelectAttr("data-type") == "number", key.SelectAttr("order") == "descending"
+		less := func(a, b string) bool {
+			if numeric {
+				x, errX := strconv.ParseFloat(strings.TrimSpace(a), 64)
+				y, errY := strconv.ParseFloat(strings.TrimSpace(b), 64)
+				// Values that are not numbers come first.
+				if errX != nil || errY != nil {
+					return errX != nil && errY == nil
+				}
+				return x < y
+			}
+			return a < b
+		}
+		sort.SliceStable(nodes, func(i, j int) bool {
+			if descending {
+				return less(values[nodes[j]], values[nodes[i]])
+			}
+			return less(values[nodes[i]], values[nodes[j]])
+		})
+	}
+	return nodes, nil
+}
+
+// Copies the literal result element lit to out.
+func (p *processor) literal(lit, n, out *xmlquery.Node) error {
+	elem := &xmlquery.Node{Type: xmlquery.ElementNode, Data: lit.Data, Prefix: lit.Prefix, NamespaceURI: lit.NamespaceURI}
+	out.InsertBefore(elem, nil)
+	for _, decl := range namespaceDecls(lit) {
+		if lookupNamespace(out, decl.prefix) != decl.uri {
+			name := "xmlns"
+			if decl.prefix != "" {
+				name += ":" + decl.prefix
+			}
+			elem.SetAttr(name, decl.uri)
+		}
+	}
+	for _, attr := range lit.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue // copied above
+		}
+		value, err := p.avt(attr.Value, n)
+		if err != nil {
+			return err
+		}
+		name := attr.Name.Local
+		if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + name
+		}
+		elem.SetAttr(name, value)
+	}
+	return p.execBody(lit, n, elem)
+}
+
+type namespaceDecl struct {
+	prefix, uri string
+}
+
+// Returns the namespaces in scope at the stylesheet element n, other than
+// the XSLT namespace, as literal result elements copy them.
+func namespaceDecls(n *xmlquery.Node) []namespaceDecl {
+	var decls []namespaceDecl
+	seen := make(map[string]bool)
+	for ; n != nil; n = n.Parent {
+		for _, attr := range n.Attr {
+			prefix, ok := "", false
+			if attr.Name.Space == "xmlns" {
+				prefix, ok = attr.Name.Local, true
+			} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+				ok = true
+			}
+			if ok && !seen[prefix] {
+				seen[prefix] = true
+				if attr.Value != NamespaceXSLT {
+					decls = append(decls, namespaceDecl{prefix, attr.Value})
+				}
+			}
+		}
+	}
+	// Declare the namespaces in a stable order.
+	sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+	return decls
+}
+
+// Returns the namespace URI bound to prefix at the result element n.
+func lookupNamespace(n *xmlquery.Node, prefix string) string {
+	name := "xmlns"
+	if prefix != "" {
+		name += ":" + prefix
+	}
+	for ; n != nil; n = n.Parent {
+		if n.Type != xmlquery.ElementNode {
+			continue
+		}
+		if v, ok := n.GetAttr(name); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// Returns the attribute value template s evaluated with n as the context
+// node.
+func (p *processor) avt(s string, n *xmlquery.Node) (string, error) {
+	return expandAVT(s, func(expr string) (string, error) {
+		return p.evalString(n, expr)
+	})
+}
+
+// Returns the text produced by the children of instr.
+func (p *processor) content(instr, n *xmlquery.Node) (string, error) {
+	tmp := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	if err := p.execBody(instr, n, tmp); err != nil {
+		return "", err
+	}
+	return tmp.InnerText(), nil
+}
+
+func setAttr(out *xmlquery.Node, name, value string) error {
+	if out.Type != xmlquery.ElementNode {
+		return fmt.Errorf("transform: attribute %s added outside of an element", name)
+	}
+	if out.FirstChild != nil {
+		return fmt.Errorf("transform: attribute %s added after the children of %s", name, out.Data)
+	}
+	out.SetAttr(name, value)
+	return nil
+}
+
+// Appends text to out, merging it with the last child of out if it is text.
+func appendText(out *xmlquery.Node, text string) {
+	if text == "" {
+		return
+	}
+	if last := out.LastChild; last != nil && last.Type == xmlquery.TextNode {
+		last.Data += text
+		return
+	}
+	out.InsertBefore(xmlquery.NewText(text), nil)
+}
+
+func isXSLT(n *xmlquery.Node, name string) bool {
+	return n.Type == xmlquery.ElementNode && n.NamespaceURI == NamespaceXSLT && n.Data == name
+}
+
+func firstElement(n *xmlquery.Node) *xmlquery.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+func lastElement(n *xmlquery.Node) *xmlquery.Node {
+	for child := n.LastChild; child != nil; child = child.PrevSibling {
+		if child.Type == xmlquery.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+func nextElement(n *xmlquery.Node) *xmlquery.Node {
+	for n = n.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode {
+			return n
+		}
+	}
+	return nil
+}
+
+func prevElement(n *xmlquery.Node) *xmlquery.Node {
+	for n = n.PrevSibling; n != nil; n = n.PrevSibling {
+		if n.Type == xmlquery.ElementNode {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package transform
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+const catalog = `<?xml version="1.0"?>
+<catalog>
+  <cd id="1"><title>Empire Burlesque</title><artist>Bob Dylan</artist><price>10.90</price></cd>
+  <cd id="2"><title>Hide your heart</title><artist>Bonnie Tyler</artist><price>9.90</price></cd>
+  <cd id="3"><title>Greatest Hits</title><artist>Dolly Parton</artist><price>11.50</price></cd>
+</catalog>`
+
+func apply(t *testing.T, stylesheet, input string) string {
+	t.Helper()
+	s, err := ParseStylesheet(strings.NewReader(stylesheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := xmlquery.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := s.Write(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	// Newlines in text are written as character references.
+	return strings.ReplaceAll(buf.String(), "&#xA;", "\n")
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, stylesheet, expected string
+	}{
+		{
+			"for-each, sort, value-of and attribute value templates",
+			`<xsl:template match="/">
+  <table>
+    <xsl:for-each select="catalog/cd">
+      <xsl:sort select="price" data-type="number" order="descending"/>
+      <tr id="cd-{@id}"><td><xsl:value-of select="title"/></td></tr>
+    </xsl:for-each>
+  </table>
+</xsl:template>`,
+			`<table><tr id="cd-3"><td>Greatest Hits</td></tr><tr id="cd-1"><td>Empire Burlesque</td></tr><tr id="cd-2"><td>Hide your heart</td></tr></table>`,
+		},
+		{
+			"apply-templates with priorities and built-in rules",
+			`<xsl:template match="catalog"><list><xsl:apply-templates select="cd"/></list></xsl:template>
+<xsl:template match="cd"><item><xsl:apply-templates/></item></xsl:template>
+<xsl:template match="cd[@id='2']"><special/></xsl:template>
+<xsl:template match="price"/>
+<xsl:template match="artist"><by><xsl:apply-templates/></by></xsl:template>`,
+			`<list><item>Empire Burlesque<by>Bob Dylan</by></item><special/><item>Greatest Hits<by>Dolly Parton</by></item></list>`,
+		},
+		{
+			"if, choose, element, attribute, text, comment and call-template",
+			`<xsl:template match="/"><out><xsl:apply-templates select="//cd"/><xsl:call-template name="footer"/></out></xsl:template>
+<xsl:template match="cd">
+  <xsl:element name="cd-{@id}">
+    <xsl:attribute name="band"><xsl:if test="starts-with(artist, 'Bo')">yes</xsl:if></xsl:attribute>
+    <xsl:choose>
+      <xsl:when test="price &gt; 11">expensive</xsl:when>
+      <xsl:when test="price &gt; 10">fair</xsl:when>
+      <xsl:otherwise>cheap</xsl:otherwise>
+    </xsl:choose>
+  </xsl:element>
+</xsl:template>
+<xsl:template name="footer"><xsl:comment>total <xsl:value-of select="count(/catalog/cd)"/></xsl:comment><xsl:text> end </xsl:text></xsl:template>`,
+			`<out><cd-1 band="yes">fair</cd-1><cd-2 band="yes">cheap</cd-2><cd-3 band="">expensive</cd-3><!--total 3--> end </out>`,
+		},
+		{
+			"copy and copy-of",
+			`<xsl:template match="@*|node()"><xsl:copy><xsl:apply-templates select="@*|node()"/></xsl:copy></xsl:template>
+<xsl:template match="price"/>
+<xsl:template match="cd[@id='3']"><xsl:copy-of select="."/></xsl:template>`,
+			`<catalog>
+  <cd id="1"><title>Empire Burlesque</title><artist>Bob Dylan</artist></cd>
+  <cd id="2"><title>Hide your heart</title><artist>Bonnie Tyler</artist></cd>
+  <cd id="3"><title>Greatest Hits</title><artist>Dolly Parton</artist><price>11.50</price></cd>
+</catalog>`,
+		},
+		{
+			"modes",
+			`<xsl:template match="/"><r><xsl:apply-templates select="//title" mode="toc"/><xsl:apply-templates select="//title"/></r></xsl:template>
+<xsl:template match="title" mode="toc"><toc><xsl:value-of select="."/></toc></xsl:template>
+<xsl:template match="title"><t/></xsl:template>`,
+			`<r><toc>Empire Burlesque</toc><toc>Hide your heart</toc><toc>Greatest Hits</toc><t/><t/><t/></r>`,
+		},
+	}
+	for _, test := range tests {
+		stylesheet := `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+<xsl:output omit-xml-declaration="yes"/>` + test.stylesheet + `</xsl:stylesheet>`
+		if got := apply(t, stylesheet, catalog); got != test.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	text := `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+<xsl:output method="text"/>
+<xsl:template match="cd"><xsl:value-of select="artist"/>;</xsl:template>
+<xsl:template match="text()"/>
+</xsl:stylesheet>`
+	if got, expected := apply(t, text, catalog), "Bob Dylan;Bonnie Tyler;Dolly Parton;"; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+
+	xml := `<xsl:transform version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform" xmlns:h="urn:h">
+<xsl:template match="/"><h:p><xsl:value-of select="count(//cd)"/></h:p></xsl:template>
+</xsl:transform>`
+	if got, expected := apply(t, xml, catalog), `<?xml version="1.0" encoding="UTF-8"?><h:p xmlns:h="urn:h">3</h:p>`; got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestApplyConcurrently(t *testing.T) {
+	s, err := ParseStylesheet(strings.NewReader(`<xsl:transform version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+<xsl:output method="text"/>
+<xsl:template match="/"><xsl:value-of select="sum(//price)"/></xsl:template>
+</xsl:transform>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := xmlquery.Parse(strings.NewReader(catalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var buf bytes.Buffer
+				if err := s.Write(&buf, doc); err != nil || buf.String() != "32.3" {
+					t.Errorf("expected 32.3, got %q (%v)", buf.String(), err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, body := range []string{
+		`<xsl:template match="/"><xsl:variable name="x" select="1"/></xsl:template>`,
+		`<xsl:key name="k" match="cd" use="@id"/>`,
+		`<xsl:template match="/"><xsl:value-of select="count(("/></xsl:template>`,
+		`<xsl:template match="/"><a href="{@x"/></xsl:template>`,
+		`<xsl:template match="/"><xsl:if/></xsl:template>`,
+		`<xsl:template/>`,
+		`<xsl:output method="html"/>`,
+	} {
+		s := `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">` + body + `</xsl:stylesheet>`
+		if _, err := ParseStylesheet(strings.NewReader(s)); err == nil {
+			t.Errorf("%s: expected an error", body)
+		}
+	}
+	if _, err := ParseStylesheet(strings.NewReader(`<html/>`)); err == nil {
+		t.Error("expected an error for a document that is not a stylesheet")
+	}
+}
+
+func TestApplyEvaluationError(t *testing.T) {
+	s, err := ParseStylesheet(strings.NewReader(`<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+<xsl:template match="/"><xsl:value-of select="count(//artist[. > 1])"/></xsl:template>
+</xsl:stylesheet>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := xmlquery.Parse(strings.NewReader(catalog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Apply(doc); err == nil {
+		t.Fatal("expected an error comparing artists with a number")
+	}
+}
+
+func TestInfiniteRecursion(t *testing.T) {
+	s, err := ParseStylesheet(strings.NewReader(`<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+<xsl:template match="/"><xsl:apply-templates select="."/></xsl:template>
+</xsl:stylesheet>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := xmlquery.Parse(strings.NewReader(catalog))
+	if _, err := s.Apply(doc); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,79 @@
+package xmlquery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	doc := loadXML(`<doc><font>a</font><!--x--><p class="old">b<font>c</font></p><drop><font>d</font></drop></doc>`)
+	var visited []string
+	err := Transform(doc,
+		Rule{Match: "//font", Apply: func(n *Node) error {
+			visited = append(visited, "font:"+n.InnerText())
+			n.Data = "span"
+			// Added nodes are not visited.
+			n.InsertBefore(NewElement("font"), nil)
+			return nil
+		}},
+		Rule{Match: "p.old", CSS: true, Apply: func(n *Node) error {
+			visited = append(visited, "p")
+			n.SetAttr("class", "new")
+			return nil
+		}},
+		Rule{Match: "//drop | //comment()", Apply: func(n *Node) error {
+			visited = append(visited, "drop")
+			n.Detach()
+			return nil
+		}},
+		Rule{Match: "//p", Apply: func(n *Node) error {
+			visited = append(visited, "p2")
+			return nil
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<doc><span>a<font/></span><p class="new">b<span>c<font/></span></p></doc>`
+	if got := FindOne(doc, "/doc").OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if got := strings.Join(visited, ","); got != "font:a,drop,p,p2,font:c,drop" {
+		t.Errorf("unexpected visits %s", got)
+	}
+	checkTreeInvariants(t, doc)
+}
+
+func TestTransformErrors(t *testing.T) {
+	doc := loadXML(`<doc><a/><b/></doc>`)
+	errStop := errors.New("stop")
+	calls := 0
+	err := Transform(doc, Rule{Match: "//*", Apply: func(n *Node) error {
+		calls++
+		return errStop
+	}})
+	if err != errStop || calls != 1 {
+		t.Errorf("expected the first error to stop the transformation, got %v after %d calls", err, calls)
+	}
+
+	err = Transform(doc, Rule{Match: "//a", Apply: func(n *Node) error {
+		return Transform(n, Rule{Match: ".", Apply: func(*Node) error { return nil }})
+	}})
+	if err == nil {
+		t.Error("expected an error for a nested transformation")
+	}
+	if err := Transform(doc, Rule{Match: "//a", Apply: func(*Node) error { return nil }}); err != nil {
+		t.Errorf("expected the tree to be released, got %v", err)
+	}
+
+	if err := Transform(doc, Rule{Match: "//@id"}); err != nil {
+		t.Errorf("expected no error without attributes to select, got %v", err)
+	}
+	if err := Transform(loadXML(`<a id="1"/>`), Rule{Match: "//@id"}); err == nil {
+		t.Error("expected an error for attributes")
+	}
+	if err := Transform(doc, Rule{Match: "//["}); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+}
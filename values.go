@@ -0,0 +1,211 @@
+package xmlquery
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	valueDecodersMu sync.RWMutex
+	valueDecoders   = map[string]func(string) (interface{}, error){
+		"xs:string":   func(s string) (interface{}, error) { return s, nil },
+		"xs:anyURI":   func(s string) (interface{}, error) { return s, nil },
+		"xs:boolean":  decodeBoolean,
+		"xs:int":      decodeInteger,
+		"xs:integer":  decodeInteger,
+		"xs:long":     decodeInteger,
+		"xs:short":    decodeInteger,
+		"xs:byte":     decodeInteger,
+		"xs:decimal":  decodeDouble,
+		"xs:float":    decodeDouble,
+		"xs:double":   decodeDouble,
+		"xs:dateTime": decodeTime("2006-01-02T15:04:05.999999999"),
+		"xs:date":     decodeTime("2006-01-02"),
+		"xs:time":     decodeTime("15:04:05.999999999"),
+		"xs:duration": decodeDuration,
+	}
+)
+
+// RegisterValueDecoder registers fn as the decoder of values of the given
+// type, replacing any previous decoder for it. Types are usually XML Schema
+// type names such as "xs:dateTime" ("xsd:" prefixes are treated as "xs:"),
+// but any name may be used for application specific types.
+//
+// Decoders are used by TypedValue and by Extract for fields with an
+// `xsd:"..."` tag, and receive the text of the node with surrounding
+// whitespace removed, except for "xs:string". It is safe to register
+// decoders while other goroutines decode values.
+func RegisterValueDecoder(xsdType string, fn func(string) (interface{}, error)) {
+	valueDecodersMu.Lock()
+	defer valueDecodersMu.Unlock()
+	valueDecoders[normalizeXSDType(xsdType)] = fn
+}
+
+func normalizeXSDType(xsdType string) string {
+	if strings.HasPrefix(xsdType, "xsd:") {
+		return "xs:" + xsdType[4:]
+	}
+	return xsdType
+}
+
+// DecodeValue decodes s as a value of the given type using the registered decoders.
+func DecodeValue(xsdType, s string) (interface{}, error) {
+	xsdType = normalizeXSDType(xsdType)
+	valueDecodersMu.RLock()
+	fn, ok := valueDecoders[xsdType]
+	valueDecodersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("xmlquery: no decoder registered for type %q", xsdType)
+	}
+	if xsdType != "xs:string" {
+		s = strings.TrimSpace(s)
+	}
+	v, err := fn(s)
+	if err != nil {
+		return nil, fmt.Errorf("xmlquery: cannot decode %q as %s: %v", s, xsdType, err)
+	}
+	return v, nil
+}
+
+// TypedValue decodes the inner text of n as a value of the given type. If
+// xsdType is empty, the type named by the xsi:type attribute of n is used,
+// defaulting to "xs:string".
+func (n *Node) TypedValue(xsdType string) (interface{}, error) {
+	if xsdType == "" {
+		xsdType = n.GetAttrWithDefault("xsi:type", "xs:string")
+	}
+	return DecodeValue(xsdType, n.InnerText())
+}
+
+// Decodes s with the decoder of xsdType and stores the result into v.
+func decodeTyped(s, xsdType string, v reflect.Value) error {
+	val, err := DecodeValue(xsdType, s)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(val)
+	switch {
+	case val == nil:
+		v.Set(reflect.Zero(v.Type()))
+	case rv.Type().AssignableTo(v.Type()):
+		v.Set(rv)
+	case rv.Type().ConvertibleTo(v.Type()) && (v.Kind() == reflect.String) == (rv.Kind() == reflect.String):
+		// Numbers are convertible to strings, but as runes.
+		if !fits(rv, v) {
+			return fmt.Errorf("xmlquery: %s value %v does not fit in %s", xsdType, val, v.Type())
+		}
+		v.Set(rv.Convert(v.Type()))
+	default:
+		return fmt.Errorf("xmlquery: cannot store %s value of type %s into %s", xsdType, rv.Type(), v.Type())
+	}
+	return nil
+}
+
+// Returns true if the number rv can be converted to the type of v without
+// losing its value. Values of other kinds always fit.
+func fits(rv, v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return !v.OverflowInt(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return rv.Uint() <= math.MaxInt64 && !v.OverflowInt(int64(rv.Uint()))
+		case reflect.Float32, reflect.Float64:
+			f := rv.Float()
+			return f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !v.OverflowInt(int64(f))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int() >= 0 && !v.OverflowUint(uint64(rv.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return !v.OverflowUint(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			f := rv.Float()
+			return f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 && !v.OverflowUint(uint64(f))
+		}
+	case reflect.Float32, reflect.Float64:
+		if k := rv.Kind(); k == reflect.Float32 || k == reflect.Float64 {
+			return !v.OverflowFloat(rv.Float())
+		}
+	}
+	return true
+}
+
+func decodeBoolean(s string) (interface{}, error) {
+	switch s {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return nil, errors.New("not a boolean")
+}
+
+func decodeInteger(s string) (interface{}, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func decodeDouble(s string) (interface{}, error) {
+	switch s {
+	case "INF":
+		s = "+Inf"
+	case "-INF":
+		s = "-Inf"
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func decodeTime(layout string) func(string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		// Timezones are optional in XML Schema dates and times.
+		if t, err := time.Parse(layout+"Z07:00", s); err == nil {
+			return t, nil
+		}
+		return time.Parse(layout, s)
+	}
+}
+
+var durationRegexp = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Decodes an xs:duration into a time.Duration. Years and months have no
+// fixed length, so durations using them are rejected.
+func decodeDuration(s string) (interface{}, error) {
+	m := durationRegexp.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return nil, errors.New("not a duration")
+	}
+	if m[2] != "" && m[2] != "0" || m[3] != "" && m[3] != "0" {
+		return nil, errors.New("durations with years or months are not supported")
+	}
+	var d time.Duration
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute}
+	for i, unit := range units {
+		if m[4+i] != "" {
+			n, err := strconv.ParseInt(m[4+i], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[7] != "" {
+		secs, err := strconv.ParseFloat(m[7], 64)
+		if err != nil {
+			return nil, err
+		}
+		d += time.Duration(secs * float64(time.Second))
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
@@ -0,0 +1,105 @@
+package xmlquery
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type money struct {
+	Cents    int64
+	Currency string
+}
+
+func TestTypedValue(t *testing.T) {
+	doc := loadXML(`<r xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+	<a xsi:type="xs:int"> 42 </a>
+	<b>2019-03-01T10:00:00Z</b>
+	<c>PT1H30M</c>
+	<d>-P1DT0.5S</d>
+	<e> text </e>
+</r>`)
+	if v, err := FindOne(doc, "//a").TypedValue(""); err != nil || v != int64(42) {
+		t.Fatalf("expected 42, but got %v (%v)", v, err)
+	}
+	if v, err := FindOne(doc, "//b").TypedValue("xsd:dateTime"); err != nil || v.(time.Time).Hour() != 10 {
+		t.Fatalf("unexpected dateTime %v (%v)", v, err)
+	}
+	if v, err := FindOne(doc, "//c").TypedValue("xs:duration"); err != nil || v != 90*time.Minute {
+		t.Fatalf("unexpected duration %v (%v)", v, err)
+	}
+	if v, err := FindOne(doc, "//d").TypedValue("xs:duration"); err != nil || v != -(24*time.Hour+500*time.Millisecond) {
+		t.Fatalf("unexpected duration %v (%v)", v, err)
+	}
+	if v, err := FindOne(doc, "//e").TypedValue(""); err != nil || v != " text " {
+		t.Fatalf("unexpected string %q (%v)", v, err)
+	}
+	if _, err := FindOne(doc, "//e").TypedValue("xs:boolean"); err == nil {
+		t.Fatal("expected an error for an invalid boolean")
+	}
+	if _, err := FindOne(doc, "//e").TypedValue("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown type")
+	}
+}
+
+func TestRegisterValueDecoder(t *testing.T) {
+	RegisterValueDecoder("money", func(s string) (interface{}, error) {
+		parts := strings.Fields(s)
+		if len(parts) != 2 {
+			return nil, errors.New("expected an amount and a currency")
+		}
+		f, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		return money{int64(f*100 + 0.5), parts[1]}, nil
+	})
+	doc := loadXML(`<order><total>10.25 EUR</total><item>1 EUR</item><item>2.5 USD</item><wait>PT2S</wait></order>`)
+	var order struct {
+		Total money         `xpath:"//total" xsd:"money"`
+		Items []*money      `xpath:"//item" xsd:"money"`
+		Wait  time.Duration `xpath:"//wait" xsd:"xs:duration"`
+		Count int           `xpath:"count(//item)" xsd:"xs:integer"`
+	}
+	if err := Extract(doc, &order); err != nil {
+		t.Fatal(err)
+	}
+	if order.Total != (money{1025, "EUR"}) || len(order.Items) != 2 || *order.Items[1] != (money{250, "USD"}) {
+		t.Fatalf("unexpected order: %+v", order)
+	}
+	if order.Wait != 2*time.Second || order.Count != 2 {
+		t.Fatalf("unexpected order: %+v", order)
+	}
+}
+
+func TestDecodeTypedOverflow(t *testing.T) {
+	doc := loadXML(`<v><big>300</big><neg>-1</neg><frac>1.5</frac><small>100</small><huge>1e300</huge></v>`)
+	var ok struct {
+		Small int8    `xpath:"//small" xsd:"xs:integer"`
+		Whole uint8   `xpath:"//small" xsd:"xs:double"`
+		Float float32 `xpath:"//frac" xsd:"xs:double"`
+	}
+	if err := Extract(doc, &ok); err != nil || ok.Small != 100 || ok.Whole != 100 || ok.Float != 1.5 {
+		t.Fatalf("unexpected values %+v (%v)", ok, err)
+	}
+	for name, dest := range map[string]interface{}{
+		"int8 300": &struct {
+			V int8 `xpath:"//big" xsd:"xs:integer"`
+		}{},
+		"uint -1": &struct {
+			V uint `xpath:"//neg" xsd:"xs:integer"`
+		}{},
+		"int 1.5": &struct {
+			V int `xpath:"//frac" xsd:"xs:double"`
+		}{},
+		"float32 1e300": &struct {
+			V float32 `xpath:"//huge" xsd:"xs:double"`
+		}{},
+	} {
+		if err := Extract(doc, dest); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
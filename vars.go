@@ -0,0 +1,149 @@
+package xmlquery
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FindWithVars is like Find, but references to variables in expr, such as
+// $id, are replaced by the values given in vars, so that user input can be
+// used in queries without being interpreted as XPath:
+//
+//	FindWithVars(doc, "//item[@id=$id]", map[string]interface{}{"id": 42})
+//
+// It panics if expr is not a valid XPath expression or uses a variable
+// missing from vars; use QueryAllWithVars to get an error instead.
+func FindWithVars(top *Node, expr string, vars map[string]interface{}) []*Node {
+	elems, err := QueryAllWithVars(top, expr, vars)
+	if err != nil {
+		panic(err)
+	}
+	return elems
+}
+
+// QueryAllWithVars is like QueryAll, with variables in expr bound to the
+// values given in vars as in FindWithVars. Values may be strings, booleans
+// or numbers of any Go numeric type.
+//
+// The values are bound by substitution, so the expression is compiled anew
+// for every call and left out of the cache of compiled expressions, which
+// it would otherwise fill with one entry per distinct value.
+func QueryAllWithVars(top *Node, expr string, vars map[string]interface{}) (elems []*Node, err error) {
+	bound, err := bindVars(expr, vars)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := compileExpr(bound)
+	if err != nil {
+		return nil, err
+	}
+	defer recoverEval(bound, &err)
+	return selectNodes(top, exp), nil
+}
+
+// Returns expr with its variable references replaced by XPath literals for
+// the values in vars.
+func bindVars(expr string, vars map[string]interface{}) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; c {
+		case '\'', '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				// Let the compiler report the unterminated literal.
+				buf.WriteString(expr[i:])
+				return buf.String(), nil
+			}
+			buf.WriteString(expr[i : i+end+2])
+			i += end + 2
+		case '$':
+			j := i + 1
+			for j < len(expr) && (isCSSNameChar(expr[j]) || expr[j] == '.' || expr[j] == ':') {
+				j++
+			}
+			name := expr[i+1 : j]
+			value, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("xmlquery: undefined variable $%s in %q", name, expr)
+			}
+			literal, err := xpathLiteral(value)
+			if err != nil {
+				return "", fmt.Errorf("xmlquery: variable $%s: %v", name, err)
+			}
+			buf.WriteString(literal)
+			i = j
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String(), nil
+}
+
+// Returns an XPath expression evaluating to value.
+func xpathLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteXPathString(v), nil
+	case bool:
+		if v {
+			return "true()", nil
+		}
+		return "false()", nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return formatXPathNumber(float64(v)), nil
+	case float64:
+		return formatXPathNumber(v), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", value)
+}
+
+func formatXPathNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "number('NaN')"
+	case math.IsInf(f, 1):
+		return "(1 div 0)"
+	case math.IsInf(f, -1):
+		return "(-1 div 0)"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Returns s as an XPath string literal. XPath 1.0 has no escapes, so
+// strings with both kinds of quotes are built with concat.
+func quoteXPathString(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`
+	}
+	parts := strings.Split(s, "'")
+	for i, part := range parts {
+		parts[i] = "'" + part + "'"
+	}
+	return "concat(" + strings.Join(parts, `, "'", `) + ")"
+}
@@ -0,0 +1,61 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindWithVars(t *testing.T) {
+	doc := loadXML(`<list>` +
+		`<item id="42" name="plain">a</item>` +
+		`<item id="7" name="it's">b</item>` +
+		`<item id="8" name="say &quot;it's&quot;">c</item>` +
+		`<item id="1.5" name="$name">d</item>` +
+		`</list>`)
+	tests := []struct {
+		expr     string
+		vars     map[string]interface{}
+		expected string
+	}{
+		{"//item[@id=$id]", map[string]interface{}{"id": 42}, "a"},
+		{"//item[@id=$id]", map[string]interface{}{"id": 1.5}, "d"},
+		{"//item[@id > $min]", map[string]interface{}{"min": uint8(7)}, "a,c"},
+		{"//item[@name=$name]", map[string]interface{}{"name": "it's"}, "b"},
+		{"//item[@name=$name]", map[string]interface{}{"name": `say "it's"`}, "c"},
+		{"//item[@name='$name']", nil, "d"},
+		{"//item[@name=$name or $all]", map[string]interface{}{"name": "' or '1'='1", "all": false}, ""},
+		{"//item[$all]", map[string]interface{}{"all": true}, "a,b,c,d"},
+	}
+	for _, test := range tests {
+		list, err := QueryAllWithVars(doc, test.expr, test.vars)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := strings.Join(NodeList(list).Texts(), ","); got != test.expected {
+			t.Errorf("%s with %v:\nexpected: %s\ngot:      %s", test.expr, test.vars, test.expected, got)
+		}
+	}
+	exprCache.Lock()
+	cached := exprCache.order.Len()
+	exprCache.Unlock()
+	for i := 0; i < 10; i++ {
+		FindWithVars(doc, "//item[@id=$id]", map[string]interface{}{"id": i})
+	}
+	exprCache.Lock()
+	n := exprCache.order.Len()
+	exprCache.Unlock()
+	if n != cached {
+		t.Errorf("expected bound expressions to stay out of the cache, got %d more entries", n-cached)
+	}
+	if got := FindWithVars(doc, "//item[@id=$id]", map[string]interface{}{"id": 7}); len(got) != 1 {
+		t.Errorf("expected 1 item, got %d", len(got))
+	}
+
+	if _, err := QueryAllWithVars(doc, "//item[@id=$id]", nil); err == nil {
+		t.Error("expected an error for an undefined variable")
+	}
+	if _, err := QueryAllWithVars(doc, "//item[@id=$id]", map[string]interface{}{"id": []int{1}}); err == nil {
+		t.Error("expected an error for an unsupported value")
+	}
+}
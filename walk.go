@@ -0,0 +1,131 @@
+package xmlquery
+
+// WalkDecision tells Walk how to go on after visiting a node.
+type WalkDecision int
+
+const (
+	// Continue visits the children of the node, then the rest of the tree.
+	Continue WalkDecision = iota
+	// SkipChildren goes on with the next sibling of the node, leaving its
+	// descendants out.
+	SkipChildren
+	// Stop ends the walk.
+	Stop
+)
+
+// Walk calls fn for n and each of its descendants, depth-first, in document
+// order. The next sibling of a node is looked up before fn is called for it,
+// so fn may detach the node it is given from the tree.
+func (n *Node) Walk(fn func(*Node) WalkDecision) {
+	n.WalkWithDepth(func(n *Node, depth int) WalkDecision {
+		return fn(n)
+	})
+}
+
+// WalkWithDepth is like Walk, but also passes fn the depth of each node
+// relative to n, which is at depth 0.
+func (n *Node) WalkWithDepth(fn func(n *Node, depth int) WalkDecision) {
+	walk(n, 0, fn)
+}
+
+// Returns false if the walk was stopped.
+func walk(n *Node, depth int, fn func(*Node, int) WalkDecision) bool {
+	switch fn(n, depth) {
+	case Stop:
+		return false
+	case SkipChildren:
+		return true
+	}
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if !walk(child, depth+1, fn) {
+			return false
+		}
+		child = next
+	}
+	return true
+}
+
+// WalkPostOrder calls fn for the descendants of n and then for n itself,
+// visiting the children of every node before the node. Since the children
+// have already been visited, SkipChildren is the same as Continue; Stop ends
+// the walk. Nodes may be detached from the tree by fn.
+func (n *Node) WalkPostOrder(fn func(*Node) WalkDecision) {
+	walkPostOrder(n, fn)
+}
+
+// Returns false if the walk was stopped.
+func walkPostOrder(n *Node, fn func(*Node) WalkDecision) bool {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if !walkPostOrder(child, fn) {
+			return false
+		}
+		child = next
+	}
+	return fn(n) != Stop
+}
+
+// ChildNodes returns the children of n, in document order.
+func (n *Node) ChildNodes() []*Node {
+	var nodes []*Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		nodes = append(nodes, child)
+	}
+	return nodes
+}
+
+// ChildElements returns the children of n that are elements, in document
+// order.
+func (n *Node) ChildElements() []*Node {
+	var nodes []*Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// Ancestors returns the parent of n, its parent and so on up to the root of
+// the tree, the nearest first.
+func (n *Node) Ancestors() []*Node {
+	var nodes []*Node
+	for p := n.Parent; p != nil; p = p.Parent {
+		nodes = append(nodes, p)
+	}
+	return nodes
+}
+
+// Descendants returns the descendants of n, not including n, in document
+// order.
+func (n *Node) Descendants() []*Node {
+	var nodes []*Node
+	n.Walk(func(d *Node) WalkDecision {
+		if d != n {
+			nodes = append(nodes, d)
+		}
+		return Continue
+	})
+	return nodes
+}
+
+// PrecedingSiblings returns the siblings of n that come before it, the
+// nearest first, as the preceding-sibling axis of XPath.
+func (n *Node) PrecedingSiblings() []*Node {
+	var nodes []*Node
+	for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
+		nodes = append(nodes, sib)
+	}
+	return nodes
+}
+
+// FollowingSiblings returns the siblings of n that come after it, in
+// document order.
+func (n *Node) FollowingSiblings() []*Node {
+	var nodes []*Node
+	for sib := n.NextSibling; sib != nil; sib = sib.NextSibling {
+		nodes = append(nodes, sib)
+	}
+	return nodes
+}
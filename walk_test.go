@@ -0,0 +1,93 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	doc := loadXML(`<a><b><c/></b><skip><d/></skip><e/><stop/><f/></a>`)
+	var got []string
+	FindOne(doc, "/a").WalkWithDepth(func(n *Node, depth int) WalkDecision {
+		got = append(got, fmt.Sprintf("%s%d", n.Data, depth))
+		switch n.Data {
+		case "skip":
+			return SkipChildren
+		case "stop":
+			return Stop
+		}
+		return Continue
+	})
+	if expected := "a0 b1 c2 skip1 e1 stop1"; strings.Join(got, " ") != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, strings.Join(got, " "))
+	}
+}
+
+func TestWalkDetach(t *testing.T) {
+	doc := loadXML(`<a><x/><b/><x/><x/><c/></a>`)
+	a := FindOne(doc, "/a")
+	a.Walk(func(n *Node) WalkDecision {
+		if n.Data == "x" {
+			removeFromTree(n)
+		}
+		return Continue
+	})
+	if expected, got := `<a><b/><c/></a>`, a.OutputXML(true); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+}
+
+func TestWalkPostOrder(t *testing.T) {
+	doc := loadXML(`<a><b><c/><d/></b><e/><f/></a>`)
+	var got []string
+	FindOne(doc, "/a").WalkPostOrder(func(n *Node) WalkDecision {
+		got = append(got, n.Data)
+		if n.Data == "e" {
+			return Stop
+		}
+		return Continue
+	})
+	if expected := "c d b e"; strings.Join(got, " ") != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, strings.Join(got, " "))
+	}
+}
+
+func TestAxes(t *testing.T) {
+	doc := loadXML(`<a><b>x<c/><!-- y --><d><e/></d><f/></b></a>`)
+	names := func(nodes []*Node) string {
+		var s []string
+		for _, n := range nodes {
+			switch n.Type {
+			case TextNode:
+				s = append(s, "#text")
+			case CommentNode:
+				s = append(s, "#comment")
+			case DocumentNode:
+				s = append(s, "#document")
+			default:
+				s = append(s, n.Data)
+			}
+		}
+		return strings.Join(s, ",")
+	}
+	b, d := FindOne(doc, "//b"), FindOne(doc, "//d")
+	tests := []struct {
+		name     string
+		nodes    []*Node
+		expected string
+	}{
+		{"ChildNodes", b.ChildNodes(), "#text,c,#comment,d,f"},
+		{"ChildElements", b.ChildElements(), "c,d,f"},
+		{"Ancestors", FindOne(doc, "//e").Ancestors(), "d,b,a,#document"},
+		{"Descendants", b.Descendants(), "#text,c,#comment,d,e,f"},
+		{"PrecedingSiblings", d.PrecedingSiblings(), "#comment,c,#text"},
+		{"FollowingSiblings", d.FollowingSiblings(), "f"},
+		{"ChildElements of a leaf", FindOne(doc, "//e").ChildElements(), ""},
+	}
+	for _, test := range tests {
+		if got := names(test.nodes); got != test.expected {
+			t.Errorf("%s:\nexpected: %s\ngot:      %s", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+/*
+Package xmlrpc converts between Go values and XML-RPC messages built from
+xmlquery Nodes, for writing lightweight XML-RPC clients and servers.
+
+Go values map to XML-RPC types as follows, in both directions except where
+noted:
+
+	nil                       <nil/>
+	bool                      <boolean>
+	int and other integers    <int> (<i4> and <i8> are also read)
+	float32, float64          <double>
+	string                    <string> (or a <value> without a type)
+	time.Time                 <dateTime.iso8601>
+	[]byte                    <base64>
+	slices and arrays         <array>, read as []interface{}
+	maps with string keys     <struct>, read as map[string]interface{}
+	structs                   <struct>, written only
+
+Struct fields are written under their name, or the name given by an xmlrpc
+tag; fields tagged "-" and unexported fields are left out.
+*/
+package xmlrpc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gjvnq/xmlquery"
+)
+
+// The layout of dateTime.iso8601 values. XML-RPC leaves out the time zone.
+const dateTimeLayout = "20060102T15:04:05"
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// EncodeValue returns a <value> element holding v.
+func EncodeValue(v interface{}) (*xmlquery.Node, error) {
+	value := xmlquery.NewElement("value")
+	if err := encodeValue(value, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func encodeValue(value *xmlquery.Node, v reflect.Value) error {
+	if !v.IsValid() {
+		value.AppendElement("nil")
+		return nil
+	}
+	switch v.Type() {
+	case timeType:
+		value.AppendElement("dateTime.iso8601").AppendText(v.Interface().(time.Time).Format(dateTimeLayout))
+		return nil
+	case bytesType:
+		value.AppendElement("base64").AppendText(base64.StdEncoding.EncodeToString(v.Bytes()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			value.AppendElement("nil")
+			return nil
+		}
+		return encodeValue(value, v.Elem())
+	case reflect.Bool:
+		b := "0"
+		if v.Bool() {
+			b = "1"
+		}
+		value.AppendElement("boolean").AppendText(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return fmt.Errorf("xmlrpc: %d does not fit in a 32-bit int", i)
+		}
+		value.AppendElement("int").AppendText(strconv.FormatInt(i, 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt32 {
+			return fmt.Errorf("xmlrpc: %d does not fit in a 32-bit int", u)
+		}
+		value.AppendElement("int").AppendText(strconv.FormatUint(u, 10))
+	case reflect.Float32, reflect.Float64:
+		value.AppendElement("double").AppendText(strconv.FormatFloat(v.Float(), 'f', -1, 64))
+	case reflect.String:
+		value.AppendElement("string").AppendText(v.String())
+	case reflect.Slice, reflect.Array:
+		data := value.AppendElement("array").AppendElement("data")
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(data.AppendElement("value"), v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("xmlrpc: unsupported map key type %s", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		st := value.AppendElement("struct")
+		for _, key := range keys {
+			if err := encodeMember(st, key.String(), v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		st := value.AppendElement("struct")
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Name
+			if tag := f.Tag.Get("xmlrpc"); tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			if err := encodeMember(st, name, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("xmlrpc: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func encodeMember(st *xmlquery.Node, name string, v reflect.Value) error {
+	member := st.AppendElement("member")
+	member.AppendElement("name").AppendText(name)
+	return encodeValue(member.AppendElement("value"), v)
+}
+
+// DecodeValue returns the Go value held by the <value> element n.
+func DecodeValue(n *xmlquery.Node) (interface{}, error) {
+	if n == nil || n.Type != xmlquery.ElementNode || n.Data != "value" {
+		return nil, errors.New("xmlrpc: expected a value element")
+	}
+	typ := firstElement(n)
+	if typ == nil {
+		return n.InnerText(), nil
+	}
+	text := strings.TrimSpace(typ.InnerText())
+	switch typ.Data {
+	case "nil":
+		return nil, nil
+	case "boolean":
+		switch text {
+		case "1":
+			return true, nil
+		case "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("xmlrpc: invalid boolean %q", text)
+	case "int", "i4", "i8":
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("xmlrpc: invalid int %q", text)
+		}
+		return int(i), nil
+	case "double":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("xmlrpc: invalid double %q", text)
+		}
+		return f, nil
+	case "string":
+		// Strings keep their whitespace.
+		return typ.InnerText(), nil
+	case "dateTime.iso8601":
+		for _, layout := range []string{dateTimeLayout, "2006-01-02T15:04:05", time.RFC3339} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("xmlrpc: invalid dateTime.iso8601 %q", text)
+	case "base64":
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("xmlrpc: invalid base64: %v", err)
+		}
+		return b, nil
+	case "array":
+		values := []interface{}{}
+		data := childElement(typ, "data")
+		if data == nil {
+			return values, nil
+		}
+		for child := firstElement(data); child != nil; child = nextElement(child) {
+			v, err := DecodeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case "struct":
+		members := make(map[string]interface{})
+		for member := firstElement(typ); member != nil; member = nextElement(member) {
+			name := childElement(member, "name")
+			if member.Data != "member" || name == nil {
+				return nil, errors.New("xmlrpc: invalid struct member")
+			}
+			v, err := DecodeValue(childElement(member, "value"))
+			if err != nil {
+				return nil, err
+			}
+			members[name.InnerText()] = v
+		}
+		return members, nil
+	}
+	return nil, fmt.Errorf("xmlrpc: unknown type %s", typ.Data)
+}
+
+// BuildMethodCall returns a methodCall document calling method with params.
+func BuildMethodCall(method string, params ...interface{}) (*xmlquery.Node, error) {
+	call := xmlquery.NewElement("methodCall")
+	call.AppendElement("methodName").AppendText(method)
+	if err := appendParams(call, params); err != nil {
+		return nil, err
+	}
+	return newDocument(call), nil
+}
+
+// ParseMethodCall parses the methodCall read from r and returns the name of
+// the method and its decoded parameters.
+func ParseMethodCall(r io.Reader) (method string, params []interface{}, err error) {
+	root, err := parseRoot(r, "methodCall")
+	if err != nil {
+		return "", nil, err
+	}
+	name := childElement(root, "methodName")
+	if name == nil {
+		return "", nil, errors.New("xmlrpc: methodCall has no methodName")
+	}
+	params, err = decodeParams(root)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(name.InnerText()), params, nil
+}
+
+// BuildMethodResponse returns a methodResponse document holding result.
+func BuildMethodResponse(result interface{}) (*xmlquery.Node, error) {
+	resp := xmlquery.NewElement("methodResponse")
+	if err := appendParams(resp, []interface{}{result}); err != nil {
+		return nil, err
+	}
+	return newDocument(resp), nil
+}
+
+// BuildFaultResponse returns a methodResponse document reporting a fault.
+func BuildFaultResponse(code int, message string) *xmlquery.Node {
+	resp := xmlquery.NewElement("methodResponse")
+	value := resp.AppendElement("fault").AppendElement("value")
+	// Cannot fail: a map of an int and a string is always encodable.
+	encodeValue(value, reflect.ValueOf(map[string]interface{}{"faultCode": code, "faultString": message}))
+	return newDocument(resp)
+}
+
+// ParseMethodResponse parses the methodResponse read from r and returns its
+// decoded result, or a *Fault error if it reports a fault.
+func ParseMethodResponse(r io.Reader) (interface{}, error) {
+	root, err := parseRoot(r, "methodResponse")
+	if err != nil {
+		return nil, err
+	}
+	if fault := childElement(root, "fault"); fault != nil {
+		v, err := DecodeValue(childElement(fault, "value"))
+		if err != nil {
+			return nil, err
+		}
+		members, _ := v.(map[string]interface{})
+		f := &Fault{}
+		f.Code, _ = members["faultCode"].(int)
+		f.String, _ = members["faultString"].(string)
+		return nil, f
+	}
+	params, err := decodeParams(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) != 1 {
+		return nil, fmt.Errorf("xmlrpc: expected one result, got %d", len(params))
+	}
+	return params[0], nil
+}
+
+// Fault is the error reported by a methodResponse fault.
+type Fault struct {
+	Code   int
+	String string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("xmlrpc: fault %d: %s", f.Code, f.String)
+}
+
+func appendParams(parent *xmlquery.Node, params []interface{}) error {
+	ps := parent.AppendElement("params")
+	for _, p := range params {
+		if err := encodeValue(ps.AppendElement("param").AppendElement("value"), reflect.ValueOf(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeParams(parent *xmlquery.Node) ([]interface{}, error) {
+	var params []interface{}
+	ps := childElement(parent, "params")
+	if ps == nil {
+		return params, nil
+	}
+	for param := firstElement(ps); param != nil; param = nextElement(param) {
+		v, err := DecodeValue(childElement(param, "value"))
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, v)
+	}
+	return params, nil
+}
+
+func parseRoot(r io.Reader, name string) (*xmlquery.Node, error) {
+	doc, err := xmlquery.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	root := firstElement(doc)
+	if root == nil || root.Data != name {
+		return nil, fmt.Errorf("xmlrpc: expected a %s", name)
+	}
+	return root, nil
+}
+
+// Returns a document with an XML declaration and root as its element.
+func newDocument(root *xmlquery.Node) *xmlquery.Node {
+	doc := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	decl := &xmlquery.Node{Type: xmlquery.DeclarationNode, Data: "xml"}
+	decl.SetAttr("version", "1.0")
+	doc.InsertBefore(decl, nil)
+	doc.InsertBefore(root, nil)
+	return doc
+}
+
+func childElement(n *xmlquery.Node, name string) *xmlquery.Node {
+	for child := firstElement(n); child != nil; child = nextElement(child) {
+		if child.Data == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func firstElement(n *xmlquery.Node) *xmlquery.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+func nextElement(n *xmlquery.Node) *xmlquery.Node {
+	for n = n.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == xmlquery.ElementNode {
+			return n
+		}
+	}
+	return nil
+}
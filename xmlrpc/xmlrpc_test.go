@@ -0,0 +1,93 @@
+package xmlrpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeValue(t *testing.T) {
+	type point struct {
+		X, Y   int
+		Label  string `xmlrpc:"label"`
+		hidden int
+		Skip   bool `xmlrpc:"-"`
+	}
+	tests := []struct {
+		v        interface{}
+		expected string
+	}{
+		{nil, `<value><nil/></value>`},
+		{true, `<value><boolean>1</boolean></value>`},
+		{uint8(7), `<value><int>7</int></value>`},
+		{-1.5, `<value><double>-1.5</double></value>`},
+		{"a<b", `<value><string>a&lt;b</string></value>`},
+		{time.Date(1998, 7, 17, 14, 8, 55, 0, time.UTC), `<value><dateTime.iso8601>19980717T14:08:55</dateTime.iso8601></value>`},
+		{[]byte("hi"), `<value><base64>aGk=</base64></value>`},
+		{[]interface{}{1, "a"}, `<value><array><data><value><int>1</int></value><value><string>a</string></value></data></array></value>`},
+		{map[string]int{"b": 2, "a": 1}, `<value><struct><member><name>a</name><value><int>1</int></value></member><member><name>b</name><value><int>2</int></value></member></struct></value>`},
+		{&point{X: 1, Y: 2, Label: "p"}, `<value><struct><member><name>X</name><value><int>1</int></value></member><member><name>Y</name><value><int>2</int></value></member><member><name>label</name><value><string>p</string></value></member></struct></value>`},
+	}
+	for _, test := range tests {
+		n, err := EncodeValue(test.v)
+		if err != nil {
+			t.Errorf("%v: %v", test.v, err)
+			continue
+		}
+		if got := n.OutputXML(true); got != test.expected {
+			t.Errorf("%v:\nexpected: %s\ngot:      %s", test.v, test.expected, got)
+		}
+	}
+	for _, v := range []interface{}{int64(1) << 40, map[int]string{1: "a"}, make(chan int)} {
+		if _, err := EncodeValue(v); err == nil {
+			t.Errorf("%v: expected an error", v)
+		}
+	}
+}
+
+func TestMethodCall(t *testing.T) {
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	call, err := BuildMethodCall("examples.getStateName", 41, "x", []interface{}{true, 2.5}, map[string]interface{}{"when": date, "data": []byte{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, params, err := ParseMethodCall(strings.NewReader(call.OutputXML(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{41, "x", []interface{}{true, 2.5}, map[string]interface{}{"when": date, "data": []byte{1, 2}}}
+	if method != "examples.getStateName" || !reflect.DeepEqual(params, expected) {
+		t.Errorf("unexpected call %s%v", method, params)
+	}
+
+	// Values without a type are strings, and i4 is read as int.
+	s := `<?xml version="1.0"?><methodCall><methodName>m</methodName><params>` +
+		`<param><value> raw </value></param><param><value><i4>-3</i4></value></param></params></methodCall>`
+	if _, params, err := ParseMethodCall(strings.NewReader(s)); err != nil || !reflect.DeepEqual(params, []interface{}{" raw ", -3}) {
+		t.Errorf("unexpected params %v, %v", params, err)
+	}
+	if _, _, err := ParseMethodCall(strings.NewReader(`<methodResponse/>`)); err == nil {
+		t.Error("expected an error for a response")
+	}
+}
+
+func TestMethodResponse(t *testing.T) {
+	resp, err := BuildMethodResponse("South Dakota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0"?><methodResponse><params><param><value><string>South Dakota</string></value></param></params></methodResponse>`
+	if got := resp.OutputXML(false); got != expected {
+		t.Errorf("\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if v, err := ParseMethodResponse(strings.NewReader(resp.OutputXML(false))); err != nil || v != "South Dakota" {
+		t.Errorf("unexpected result %v, %v", v, err)
+	}
+
+	fault := BuildFaultResponse(4, "Too many parameters.")
+	_, err = ParseMethodResponse(strings.NewReader(fault.OutputXML(false)))
+	if f, ok := err.(*Fault); !ok || f.Code != 4 || f.String != "Too many parameters." {
+		t.Errorf("unexpected fault %v", err)
+	}
+}